@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/rexxDigital/clmail/internal/db"
+	"github.com/rexxDigital/clmail/internal/secrets"
+	"golang.org/x/term"
+	"os"
+)
+
+// runEncrypt is `clmail encrypt`, it encrypts the local store and rotates the key of one that
+// already is
+func runEncrypt(dbClient *db.Client, args []string) error {
+	flags := flag.NewFlagSet("encrypt", flag.ContinueOnError)
+	keyring := flags.Bool("keyring", false, "keep the key in the os keyring instead of asking for a passphrase on every start")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: clmail encrypt [flags]")
+		fmt.Fprintln(flags.Output(), "Encrypts mail in the local database. On an encrypted database it rotates the key, and can switch between passphrase and keyring.")
+		flags.PrintDefaults()
+	}
+
+	if err := flags.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return nil
+		}
+		return err
+	}
+
+	backend := secrets.DatabasePassphrase
+	var passphrase string
+	if *keyring {
+		backend = secrets.DatabaseKeyring
+	} else {
+		var err error
+		if passphrase, err = newPassphrase(); err != nil {
+			return err
+		}
+	}
+
+	if dbClient.Encrypted() {
+		fmt.Fprintln(os.Stderr, "Rotating the database key, this rewrites all mail...")
+	} else {
+		fmt.Fprintln(os.Stderr, "Encrypting the database, this rewrites all mail...")
+	}
+
+	removed, err := secrets.EncryptDatabase(context.Background(), dbClient, backend, passphrase)
+	if err != nil {
+		return err
+	}
+
+	for _, backup := range removed {
+		fmt.Fprintf(os.Stderr, "Removed unencrypted backup %s\n", backup)
+	}
+	fmt.Fprintln(os.Stderr, "Done.")
+	return nil
+}
+
+// newPassphrase asks for the passphrase twice, a typo would lock the mail away for good
+func newPassphrase() (string, error) {
+	fmt.Fprint(os.Stderr, "New database passphrase: ")
+	first, err := term.ReadPassword(int(os.Stdin.Fd()))
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return "", err
+	}
+	if len(first) == 0 {
+		return "", errors.New("the passphrase can't be empty")
+	}
+
+	fmt.Fprint(os.Stderr, "Repeat the passphrase: ")
+	second, err := term.ReadPassword(int(os.Stdin.Fd()))
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return "", err
+	}
+	if string(first) != string(second) {
+		return "", errors.New("the passphrases don't match")
+	}
+
+	return string(first), nil
+}
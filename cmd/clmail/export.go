@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/rexxDigital/clmail/internal/archive"
+	"github.com/rexxDigital/clmail/internal/db"
+	"github.com/rexxDigital/clmail/internal/imap"
+	"github.com/rexxDigital/clmail/internal/search"
+	"os"
+	"strings"
+)
+
+// runExport is `clmail export`, it dumps mail from the local store without starting the tui
+func runExport(dbClient *db.Client, args []string) error {
+	flags := flag.NewFlagSet("export", flag.ContinueOnError)
+	accountName := flags.String("account", "", "name or address of the account, the default account if empty")
+	folder := flags.String("folder", "", "export only this folder")
+	thread := flags.Int64("thread", 0, "export only this thread")
+	query := flags.String("search", "", "export what this search finds, e.g. 'from:bob after:2024-01-01'")
+	formatName := flags.String("format", "mbox", "mbox or maildir")
+	out := flags.String("o", "", "where to write the archive, a directory with one archive per folder when exporting the whole account")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: clmail export [flags] -o PATH")
+		fmt.Fprintln(flags.Output(), "Without -folder, -thread or -search the whole account is exported.")
+		flags.PrintDefaults()
+	}
+
+	if err := flags.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return nil
+		}
+		return err
+	}
+	if *out == "" {
+		flags.Usage()
+		return fmt.Errorf("-o is required")
+	}
+
+	format, err := archive.ParseFormat(*formatName)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	account, err := findAccount(ctx, dbClient, *accountName)
+	if err != nil {
+		return err
+	}
+
+	var ids []int64
+	wholeAccount := false
+	switch {
+	case *folder != "":
+		dbFolder, err := dbClient.GetFolderByName(ctx, db.GetFolderByNameParams{AccountID: account.ID, Name: *folder})
+		if err != nil {
+			return fmt.Errorf("no folder %q in %s", *folder, account.Email)
+		}
+		if ids, err = dbClient.ListFolderEmailIDs(ctx, dbFolder.ID); err != nil {
+			return err
+		}
+	case *thread != 0:
+		if ids, err = archive.ThreadEmailIDs(ctx, dbClient, *thread); err != nil {
+			return err
+		}
+	case *query != "":
+		parsed, err := search.Parse(*query)
+		if err != nil {
+			return err
+		}
+		if ids, err = search.EmailIDs(ctx, dbClient, account.ID, parsed); err != nil {
+			return err
+		}
+	default:
+		wholeAccount = true
+	}
+
+	fetch, closeFetch := sourceFetcher(account, dbClient)
+	defer closeFetch()
+
+	if wholeAccount {
+		exported, rebuilt, err := archive.ExportAccount(ctx, dbClient, account.ID, format, *out, fetch)
+		if err != nil {
+			return err
+		}
+		printExported(exported, rebuilt, *out)
+		return nil
+	}
+
+	rebuilt, err := archive.Export(ctx, dbClient, ids, format, *out, fetch)
+	if err != nil {
+		return err
+	}
+	printExported(len(ids), rebuilt, *out)
+	return nil
+}
+
+// sourceFetcher gets the source of mail we don't have it for from the server, like saving a
+// message in the tui does. the password is only asked for once a message needs it, and a server
+// we can't reach isn't asked again for every message after that.
+func sourceFetcher(account db.Account, dbClient *db.Client) (archive.FetchSource, func()) {
+	var fetcher *imap.BodyFetcher
+	var unavailable error
+
+	fetch := func(ctx context.Context, email db.Email) ([]byte, error) {
+		if unavailable != nil {
+			return nil, unavailable
+		}
+		if fetcher == nil {
+			password, err := accountPassword(account)
+			if err != nil {
+				unavailable = fmt.Errorf("no password for %s: %w", account.Email, err)
+				return nil, unavailable
+			}
+			fetcher = imap.NewBodyFetcher(account, password, dbClient)
+		}
+
+		raw, err := fetcher.FetchSource(ctx, email.ID, false)
+		if errors.Is(err, imap.ErrOffline) {
+			unavailable = err
+		}
+		return raw, err
+	}
+
+	return fetch, func() {
+		if fetcher != nil {
+			imap.ClosePool(account.ID)
+		}
+	}
+}
+
+// findAccount picks the account by name or address, an empty one is the default account
+func findAccount(ctx context.Context, dbClient *db.Client, name string) (db.Account, error) {
+	if name == "" {
+		account, err := dbClient.GetDefaultAccount(ctx)
+		if err != nil {
+			return db.Account{}, fmt.Errorf("no default account, pick one with -account")
+		}
+		return account, nil
+	}
+
+	accounts, err := dbClient.ListAccounts(ctx)
+	if err != nil {
+		return db.Account{}, err
+	}
+	for _, account := range accounts {
+		if strings.EqualFold(account.Name, name) || strings.EqualFold(account.Email, name) {
+			return account, nil
+		}
+	}
+	return db.Account{}, fmt.Errorf("no account %q", name)
+}
+
+func printExported(exported int, rebuilt []archive.Rebuilt, out string) {
+	fmt.Fprintf(os.Stderr, "Exported %d messages to %s\n", exported, out)
+	if len(rebuilt) == 0 {
+		return
+	}
+
+	fmt.Fprintf(os.Stderr, "%d of them couldn't be downloaded whole and were rebuilt from what is stored, their content may be missing:\n", len(rebuilt))
+	for _, email := range rebuilt {
+		messageID := email.MessageID
+		if messageID == "" {
+			messageID = fmt.Sprintf("email %d", email.EmailID)
+		}
+		fmt.Fprintf(os.Stderr, "  %s: %v\n", messageID, email.Reason)
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/rexxDigital/clmail/internal/accounts"
+	"github.com/rexxDigital/clmail/internal/archive"
+	"github.com/rexxDigital/clmail/internal/db"
+	"github.com/rexxDigital/clmail/internal/imap"
+	"github.com/rexxDigital/clmail/internal/secrets"
+	"io"
+	"os"
+)
+
+// runImport is `clmail import`, it reads mbox files, maildirs and .eml files into a folder
+func runImport(dbClient *db.Client, args []string) error {
+	flags := flag.NewFlagSet("import", flag.ContinueOnError)
+	accountName := flags.String("account", "", "name or address of the account, the default account if empty")
+	folder := flags.String("folder", "", "the folder to import into, a local one is created if it doesn't exist")
+	upload := flags.Bool("upload", false, "upload to the folder on the server instead of keeping the mail local")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: clmail import [flags] -folder NAME PATH...")
+		fmt.Fprintln(flags.Output(), "PATH is an mbox file, a maildir or an .eml file.")
+		flags.PrintDefaults()
+	}
+
+	if err := flags.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return nil
+		}
+		return err
+	}
+	if *folder == "" || flags.NArg() == 0 {
+		flags.Usage()
+		return fmt.Errorf("-folder and at least one path are required")
+	}
+
+	ctx := context.Background()
+	account, err := findAccount(ctx, dbClient, *accountName)
+	if err != nil {
+		return err
+	}
+
+	var importer *imap.Importer
+	if *upload {
+		password, err := accountPassword(account)
+		if err != nil {
+			return err
+		}
+		importer, err = imap.NewUploadImporter(account, password, *folder, dbClient)
+		if err != nil {
+			return err
+		}
+		defer imap.ClosePool(account.ID)
+	} else {
+		importer, err = imap.NewLocalImporter(account, *folder, dbClient)
+		if err != nil {
+			return err
+		}
+	}
+
+	var imported, skipped, failed int
+	for _, path := range flags.Args() {
+		reader, err := archive.Open(path)
+		if err != nil {
+			return err
+		}
+
+		for {
+			msg, err := reader.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				reader.Close()
+				return err
+			}
+
+			err = importer.Import(ctx, msg)
+			switch {
+			case errors.Is(err, imap.ErrNotImported):
+				skipped++
+			case err != nil:
+				// one broken message shouldn't stop years of archive
+				fmt.Fprintf(os.Stderr, "Failed to import a message of %s: %v\n", path, err)
+				failed++
+			default:
+				imported++
+			}
+
+			if total := imported + skipped + failed; total%100 == 0 {
+				fmt.Fprintf(os.Stderr, "%d messages...\n", total)
+			}
+		}
+		reader.Close()
+	}
+
+	fmt.Fprintf(os.Stderr, "Imported %d messages into %s, %d were already there or had no sender or recipient", imported, *folder, skipped)
+	if failed > 0 {
+		fmt.Fprintf(os.Stderr, ", %d failed", failed)
+	}
+	fmt.Fprintln(os.Stderr)
+	if *upload {
+		fmt.Fprintln(os.Stderr, "They show up after the next sync of the folder.")
+	}
+	return nil
+}
+
+// accountPassword unlocks the secrets file when there is one and gets the password of the account
+func accountPassword(account db.Account) (string, error) {
+	if secrets.FileExists() {
+		if err := unlockSecrets(); err != nil {
+			return "", err
+		}
+	}
+	return accounts.PasswordFor(account)
+}
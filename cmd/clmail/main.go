@@ -1,9 +1,16 @@
 package main
 
 import (
+	"context"
 	_ "embed"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/rexxDigital/clmail/internal/config"
 	"github.com/rexxDigital/clmail/internal/db"
+	"github.com/rexxDigital/clmail/internal/secrets"
 	"github.com/rexxDigital/clmail/internal/tui"
+	"golang.org/x/term"
 	"log"
 	_ "modernc.org/sqlite"
 	"os"
@@ -12,12 +19,71 @@ import (
 )
 
 func main() {
+	configFile := flag.String("config", "", "config file to read instead of config.json in the config directory")
+	dataDir := flag.String("data-dir", "", "directory of the database, overrides the config file")
+	profile := flag.String("profile", "", "separate set of accounts and mail, defaults to $CLMAIL_PROFILE")
+	flag.Usage = func() {
+		fmt.Fprintln(flag.CommandLine.Output(), "Usage: clmail [flags] [encrypt|export|import|paths] [command flags]")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	args := flag.Args()
+
+	paths, err := config.Load(config.Options{ConfigFile: *configFile, DataDir: *dataDir, Profile: *profile})
+	if err != nil {
+		log.Fatalf("Failed to load config: %v", err)
+	}
+
+	if len(args) > 0 {
+		switch args[0] {
+		case "paths":
+			printPaths(paths)
+			return
+		case "encrypt", "export", "import":
+		default:
+			flag.Usage()
+			os.Exit(2)
+		}
+	}
+
 	dbClient, err := db.NewClient()
 	if err != nil {
 		log.Fatalf("Failed to init database: %v", err)
 	}
 	defer dbClient.Close()
 
+	if dbClient.Encrypted() {
+		if err := unlockDatabase(dbClient); err != nil {
+			log.Fatalf("Failed to unlock database: %v", err)
+		}
+	}
+
+	if len(args) > 0 {
+		switch args[0] {
+		case "encrypt":
+			if err := runEncrypt(dbClient, args[1:]); err != nil {
+				log.Fatalf("Failed to encrypt: %v", err)
+			}
+			return
+		case "export":
+			if err := runExport(dbClient, args[1:]); err != nil {
+				log.Fatalf("Failed to export: %v", err)
+			}
+			return
+		case "import":
+			if err := runImport(dbClient, args[1:]); err != nil {
+				log.Fatalf("Failed to import: %v", err)
+			}
+			return
+		}
+	}
+
+	if secrets.FileExists() {
+		if err := unlockSecrets(); err != nil {
+			log.Fatalf("Failed to unlock secrets: %v", err)
+		}
+	}
+
 	baseModel := tui.NewBaseModel(dbClient)
 
 	defer baseModel.Close()
@@ -26,3 +92,57 @@ func main() {
 		os.Exit(1)
 	}
 }
+
+// printPaths is `clmail paths`, where the files of the profile are
+func printPaths(paths config.Paths) {
+	if paths.Profile != "" {
+		fmt.Printf("profile  %s\n", paths.Profile)
+	}
+	fmt.Printf("config   %s\n", paths.Config)
+	fmt.Printf("data     %s\n", paths.Data)
+	fmt.Printf("cache    %s\n", paths.Cache)
+}
+
+// unlockDatabase asks for the passphrase of an encrypted database, a key in the keyring needs none
+func unlockDatabase(dbClient *db.Client) error {
+	for attempt := 0; attempt < 3; attempt++ {
+		err := secrets.UnlockDatabase(context.Background(), dbClient, func() (string, error) {
+			fmt.Fprint(os.Stderr, "Database passphrase: ")
+			passphrase, err := term.ReadPassword(int(os.Stdin.Fd()))
+			fmt.Fprintln(os.Stderr)
+			return string(passphrase), err
+		})
+		if err == nil {
+			return nil
+		}
+		if !errors.Is(err, secrets.ErrWrongPassphrase) {
+			return err
+		}
+		fmt.Fprintln(os.Stderr, "Wrong passphrase, try again.")
+	}
+
+	return secrets.ErrWrongPassphrase
+}
+
+// unlockSecrets asks for the master passphrase of the secrets file before the accounts connect
+func unlockSecrets() error {
+	for attempt := 0; attempt < 3; attempt++ {
+		fmt.Fprint(os.Stderr, "Master passphrase: ")
+		passphrase, err := term.ReadPassword(int(os.Stdin.Fd()))
+		fmt.Fprintln(os.Stderr)
+		if err != nil {
+			return err
+		}
+
+		err = secrets.UnlockFile(string(passphrase))
+		if err == nil {
+			return nil
+		}
+		if !errors.Is(err, secrets.ErrWrongPassphrase) {
+			return err
+		}
+		fmt.Fprintln(os.Stderr, "Wrong passphrase, try again.")
+	}
+
+	return secrets.ErrWrongPassphrase
+}
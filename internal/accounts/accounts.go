@@ -2,32 +2,45 @@ package accounts
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"github.com/rexxDigital/clmail/internal/db"
 	"github.com/rexxDigital/clmail/internal/imap"
-	"github.com/zalando/go-keyring"
+	"github.com/rexxDigital/clmail/internal/oauth"
+	"github.com/rexxDigital/clmail/internal/secrets"
+	"github.com/rexxDigital/clmail/internal/services/events"
 	"log"
+	"strings"
 )
 
-const serviceName = "clmail"
-
+// CreateAccount tests the login and creates the account. with a password command the password
+// argument is ignored and the command is asked instead.
 func CreateAccount(account db.CreateAccountParams, password string, dbClient *db.Client) error {
-	folders, err := imap.TestLoginAndGetFolders(db.Account{
-		Email:        account.ImapUsername,
-		ImapUsername: account.ImapUsername,
-		ImapServer:   account.ImapServer,
-		ImapPort:     account.ImapPort,
-		ImapUseSsl:   true,
-	}, password, dbClient)
-
-	if err != nil {
-		folders, err = imap.TestLoginAndGetFolders(db.Account{
-			Email:        account.Email,
-			ImapUsername: account.Email,
-			ImapServer:   account.ImapServer,
-			ImapPort:     account.ImapPort,
-			ImapUseSsl:   true,
-		}, password, dbClient)
+	if err := imap.ValidateSecurity(loginAccount(account, account.Email)); err != nil {
+		return err
+	}
+
+	store, err := secrets.For(account.SecretStore, account.PasswordCommand.String)
+	if err != nil {
+		return err
+	}
+
+	// ask for the master passphrase before the slow login test, not after it
+	if account.SecretStore == secrets.BackendFile && !secrets.FileUnlocked() {
+		return secrets.ErrLocked
+	}
+
+	if account.SecretStore == secrets.BackendCommand {
+		password, err = store.Get(account.Email)
+		if err != nil {
+			return err
+		}
+	}
+
+	folders, err := imap.TestLoginAndGetFolders(loginAccount(account, account.ImapUsername), password, dbClient)
+
+	if err != nil {
+		folders, err = imap.TestLoginAndGetFolders(loginAccount(account, account.Email), password, dbClient)
 		account.ImapUsername = account.Email
 
 		if err != nil {
@@ -36,39 +49,316 @@ func CreateAccount(account db.CreateAccountParams, password string, dbClient *db
 		}
 	}
 
-	err = keyring.Set(serviceName, account.Email, password)
+	if err = setSecret(store, account.Email, password); err != nil {
+		return err
+	}
+
+	if err = storeAccount(account, folders, dbClient); err != nil {
+		deleteSecret(store, account.Email)
+		return err
+	}
+
+	return nil
+}
+
+// CreateOAuthAccount runs the oauth sign in in the browser, tests the token against the server and
+// creates the account. the auth methods of the params are set to the mechanism of the provider.
+func CreateOAuthAccount(ctx context.Context, account db.CreateAccountParams, dbClient *db.Client) error {
+	if err := imap.ValidateSecurity(loginAccount(account, account.Email)); err != nil {
+		return err
+	}
+
+	// the token goes to the secret store, ask for the master passphrase before the browser opens
+	if account.SecretStore == secrets.BackendFile && !secrets.FileUnlocked() {
+		return secrets.ErrLocked
+	}
+
+	provider, err := oauth.ProviderFor(account.Email, account.ImapServer)
+	if err != nil {
+		return err
+	}
+
+	token, err := oauth.NewClient().Authorize(ctx, provider, account.Email)
+	if err != nil {
+		log.Printf("[ACCOUNTS::CreateOAuthAccount] Failed to authorize: %v", err)
+		return fmt.Errorf("sign in failed: %w", err)
+	}
+
+	// the imap login reads the token from the secret store, so it has to be stored before testing it
+	if err = oauth.SaveToken(loginAccount(account, account.Email), token); err != nil {
+		return err
+	}
+
+	account.ImapAuthMethod = provider.Mechanism
+	account.SmtpAuthMethod = provider.Mechanism
+	// oauth logins always use the full address
+	account.ImapUsername = account.Email
+	account.SmtpUsername = account.Email
+
+	folders, err := imap.TestLoginAndGetFolders(loginAccount(account, account.Email), "", dbClient)
+	if err != nil {
+		oauth.DeleteToken(loginAccount(account, account.Email))
+		log.Printf("[ACCOUNTS::CreateOAuthAccount] Failed to login: %v", err)
+		return fmt.Errorf("the server did not accept the token")
+	}
+
+	if err = storeAccount(account, folders, dbClient); err != nil {
+		oauth.DeleteToken(loginAccount(account, account.Email))
+		return err
+	}
+
+	return nil
+}
+
+// ReauthorizeOAuth signs in again, for when the refresh token was revoked or expired
+func ReauthorizeOAuth(ctx context.Context, account db.Account, dbClient *db.Client) error {
+	provider, err := oauth.ProviderFor(account.Email, account.ImapServer)
+	if err != nil {
+		return err
+	}
+
+	token, err := oauth.NewClient().Authorize(ctx, provider, account.Email)
 	if err != nil {
+		return fmt.Errorf("sign in failed: %w", err)
+	}
+
+	if err = oauth.SaveToken(account, token); err != nil {
 		return err
 	}
 
+	if _, err = imap.TestLoginAndGetFolders(account, "", dbClient); err != nil {
+		log.Printf("[ACCOUNTS::ReauthorizeOAuth] Failed to login: %v", err)
+		return fmt.Errorf("the server did not accept the token")
+	}
+
+	return nil
+}
+
+// loginAccount is the account as far as the login test needs it, before it exists in the db
+func loginAccount(account db.CreateAccountParams, username string) db.Account {
+	return db.Account{
+		Email:              username,
+		ImapUsername:       username,
+		ImapServer:         account.ImapServer,
+		ImapPort:           account.ImapPort,
+		ImapUseSsl:         account.ImapUseSsl,
+		ImapAuthMethod:     account.ImapAuthMethod,
+		ImapSecurity:       account.ImapSecurity,
+		ImapCaFile:         account.ImapCaFile,
+		ImapTlsFingerprint: account.ImapTlsFingerprint,
+		SecretStore:        account.SecretStore,
+		PasswordCommand:    account.PasswordCommand,
+	}
+}
+
+// storeAccount creates the account row with its folders
+func storeAccount(account db.CreateAccountParams, folders []string, dbClient *db.Client) error {
 	newAccount, err := dbClient.CreateAccount(context.Background(), account)
 	if err != nil {
-		keyring.Delete(serviceName, account.Email)
 		return err
 	}
 
+	if newAccount.IsDefault {
+		if err = dbClient.ClearDefaultAccount(context.Background(), newAccount.ID); err != nil {
+			log.Printf("[ACCOUNTS::storeAccount] Failed to clear default account: %v", err)
+		}
+	}
+
 	for _, folder := range folders {
 		_, err = dbClient.CreateFolder(context.Background(), db.CreateFolderParams{
 			AccountID: newAccount.ID,
 			Name:      folder,
+			// new mail in the inbox is what people wait for, the rest can be changed in the accounts view
+			Push: strings.EqualFold(folder, "INBOX"),
 		})
 
 		if err != nil {
-			err = keyring.Delete(serviceName, account.Email)
-			if err != nil {
-				return err
-			}
-			err = dbClient.DeleteAccount(context.Background(), newAccount.ID)
-			if err != nil {
-				return err
+			if deleteErr := dbClient.DeleteAccount(context.Background(), newAccount.ID); deleteErr != nil {
+				return deleteErr
 			}
 			return err
 		}
 	}
 
+	events.Publish(events.FoldersChanged{AccountID: newAccount.ID})
+	return nil
+}
+
+// PushFolders lists the folders of the account that get push updates
+func PushFolders(accountID int64, dbClient *db.Client) ([]string, error) {
+	folders, err := dbClient.ListPushFolders(context.Background(), accountID)
+	if err != nil {
+		return nil, fmt.Errorf("[ACCOUNTS::PushFolders] failed to list push folders: %w", err)
+	}
+	return folders, nil
+}
+
+// SetPushFolders replaces the push folders of the account, every name has to be a folder we know
+func SetPushFolders(accountID int64, folders []string, dbClient *db.Client) error {
+	tx, err := dbClient.DB.BeginTx(context.Background(), nil)
+	if err != nil {
+		return fmt.Errorf("[ACCOUNTS::SetPushFolders] failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	queries := dbClient.WithTx(tx)
+	if err = queries.ClearFolderPush(context.Background(), accountID); err != nil {
+		return fmt.Errorf("[ACCOUNTS::SetPushFolders] failed to clear push folders: %w", err)
+	}
+
+	for _, folder := range folders {
+		updated, err := queries.SetFolderPush(context.Background(), db.SetFolderPushParams{
+			AccountID: accountID,
+			Name:      folder,
+		})
+		if err != nil {
+			return fmt.Errorf("[ACCOUNTS::SetPushFolders] failed to set push on %s: %w", folder, err)
+		}
+		if updated == 0 {
+			return fmt.Errorf("unknown folder %q", folder)
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("[ACCOUNTS::SetPushFolders] failed to commit: %w", err)
+	}
+	return nil
+}
+
+// setSecret stores the password, a password command already has it so there is nothing to do
+func setSecret(store secrets.Store, email, password string) error {
+	if err := store.Set(email, password); err != nil && !errors.Is(err, secrets.ErrReadOnly) {
+		return fmt.Errorf("[ACCOUNTS::setSecret] failed to store password: %w", err)
+	}
+	return nil
+}
+
+func deleteSecret(store secrets.Store, email string) {
+	err := store.Delete(email)
+	if err != nil && !errors.Is(err, secrets.ErrReadOnly) && !errors.Is(err, secrets.ErrNotFound) {
+		log.Printf("[ACCOUNTS::deleteSecret] Failed to remove password: %v", err)
+	}
+}
+
+// PasswordFor returns the password of the account from its secret store, oauth accounts don't
+// have one and get an empty string
+func PasswordFor(account db.Account) (string, error) {
+	if oauth.IsOAuth(account.ImapAuthMethod) && oauth.IsOAuth(account.SmtpAuthMethod) {
+		return "", nil
+	}
+
+	store, err := secrets.ForAccount(account)
+	if err != nil {
+		return "", err
+	}
+
+	return store.Get(account.Email)
+}
+
+// UpdateAccount saves the edited account, moving the password along when the email or the secret
+// store changes
+func UpdateAccount(account db.UpdateAccountParams, dbClient *db.Client) (db.Account, error) {
+	oldAccount, err := dbClient.GetAccount(context.Background(), account.ID)
+	if err != nil {
+		return db.Account{}, fmt.Errorf("[ACCOUNTS::UpdateAccount] failed to get account: %w", err)
+	}
+
+	oldStore, err := secrets.ForAccount(oldAccount)
+	if err != nil {
+		return db.Account{}, err
+	}
+	newStore, err := secrets.For(account.SecretStore, account.PasswordCommand.String)
+	if err != nil {
+		return db.Account{}, err
+	}
+
+	backend := func(backend string) string {
+		if backend == "" {
+			return secrets.BackendKeyring
+		}
+		return backend
+	}
+
+	usesPassword := !oauth.IsOAuth(oldAccount.ImapAuthMethod) || !oauth.IsOAuth(oldAccount.SmtpAuthMethod)
+	moved := usesPassword &&
+		(oldAccount.Email != account.Email || backend(oldAccount.SecretStore) != backend(account.SecretStore))
+
+	if moved {
+		password, err := oldStore.Get(oldAccount.Email)
+		if err != nil {
+			return db.Account{}, fmt.Errorf("[ACCOUNTS::UpdateAccount] failed to get password: %w", err)
+		}
+
+		if err = newStore.Set(account.Email, password); err != nil && !errors.Is(err, secrets.ErrReadOnly) {
+			return db.Account{}, fmt.Errorf("[ACCOUNTS::UpdateAccount] failed to store password: %w", err)
+		}
+	}
+
+	updated, err := dbClient.UpdateAccount(context.Background(), account)
+	if err != nil {
+		if moved {
+			deleteSecret(newStore, account.Email)
+		}
+		return db.Account{}, fmt.Errorf("[ACCOUNTS::UpdateAccount] failed to update account: %w", err)
+	}
+
+	if moved {
+		deleteSecret(oldStore, oldAccount.Email)
+	}
+
+	// the token lives in the secret store too
+	if oauth.IsOAuth(oldAccount.ImapAuthMethod) || oauth.IsOAuth(oldAccount.SmtpAuthMethod) {
+		if err = oauth.MoveToken(oldAccount, updated); err != nil {
+			return updated, fmt.Errorf("[ACCOUNTS::UpdateAccount] failed to move token: %w", err)
+		}
+	}
+
+	// there can only be one default account
+	if updated.IsDefault {
+		if err = dbClient.ClearDefaultAccount(context.Background(), updated.ID); err != nil {
+			return updated, fmt.Errorf("[ACCOUNTS::UpdateAccount] failed to clear default account: %w", err)
+		}
+	}
+
+	return updated, nil
+}
+
+// UpdatePassword tests the new password against the server before replacing the stored one
+func UpdatePassword(account db.Account, password string, dbClient *db.Client) error {
+	store, err := secrets.ForAccount(account)
+	if err != nil {
+		return err
+	}
+
+	if account.SecretStore == secrets.BackendCommand {
+		return fmt.Errorf("the password comes from the password command, change it there")
+	}
+
+	if _, err := imap.TestLoginAndGetFolders(account, password, dbClient); err != nil {
+		log.Printf("[ACCOUNTS::UpdatePassword] Failed to login: %v", err)
+		return fmt.Errorf("invalid credentials")
+	}
+
+	if err := store.Set(account.Email, password); err != nil {
+		return fmt.Errorf("[ACCOUNTS::UpdatePassword] failed to store password: %w", err)
+	}
+
 	return nil
 }
 
-func GetPassword(email string) (string, error) {
-	return keyring.Get(serviceName, email)
+// DeleteAccount removes the account with all its folders, threads and emails and its secrets
+func DeleteAccount(account db.Account, dbClient *db.Client) error {
+	if err := dbClient.DeleteAccount(context.Background(), account.ID); err != nil {
+		return fmt.Errorf("[ACCOUNTS::DeleteAccount] failed to delete account: %w", err)
+	}
+
+	if store, err := secrets.ForAccount(account); err == nil {
+		deleteSecret(store, account.Email)
+	}
+
+	if err := oauth.DeleteToken(account); err != nil {
+		return fmt.Errorf("[ACCOUNTS::DeleteAccount] failed to remove token: %w", err)
+	}
+
+	return nil
 }
@@ -0,0 +1,266 @@
+package archive
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/emersion/go-message/mail"
+	"github.com/rexxDigital/clmail/internal/db"
+)
+
+type Format string
+
+const (
+	// FormatMbox is one file with every message, mboxrd flavour
+	FormatMbox Format = "mbox"
+	// FormatMaildir is a directory with a file per message
+	FormatMaildir Format = "maildir"
+)
+
+func ParseFormat(name string) (Format, error) {
+	switch format := Format(strings.ToLower(name)); format {
+	case FormatMbox, FormatMaildir:
+		return format, nil
+	default:
+		return "", fmt.Errorf("unknown format %q, use mbox or maildir", name)
+	}
+}
+
+// Message is one message of an archive with what the formats keep besides its source
+type Message struct {
+	// Raw is the RFC 5322 message
+	Raw     []byte
+	From    string
+	Date    time.Time
+	Seen    bool
+	Flagged bool
+	Draft   bool
+}
+
+type Writer interface {
+	Write(msg Message) error
+	Close() error
+}
+
+// Create opens an archive for writing, an mbox file must not exist yet
+func Create(format Format, path string) (Writer, error) {
+	switch format {
+	case FormatMbox:
+		return newMboxWriter(path)
+	case FormatMaildir:
+		return newMaildirWriter(path)
+	default:
+		return nil, fmt.Errorf("[ARCHIVE::Create] unknown format %q", format)
+	}
+}
+
+// FetchSource gets the source of an email we don't have it for from the server
+type FetchSource func(ctx context.Context, email db.Email) ([]byte, error)
+
+// Rebuilt is an email that went into the archive rebuilt from what we stored, because its source
+// was neither in the database nor to be had from the server
+type Rebuilt struct {
+	EmailID   int64
+	MessageID string
+	// Reason is why fetching the source failed, nil when there was nothing to fetch it with
+	Reason error
+}
+
+// Export writes the emails to a new archive at path in the given order. fetch is asked for the
+// source of mail we never downloaded whole or whose body retention took, it may be nil. the
+// emails rebuilt without their source are returned.
+func Export(ctx context.Context, dbClient *db.Client, emailIDs []int64, format Format, path string, fetch FetchSource) (rebuilt []Rebuilt, err error) {
+	writer, err := Create(format, path)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if closeErr := writer.Close(); err == nil {
+			err = closeErr
+		}
+	}()
+
+	for _, id := range emailIDs {
+		email, err := dbClient.GetEmail(ctx, id)
+		if err != nil {
+			return rebuilt, fmt.Errorf("[ARCHIVE::Export] failed to get email %d: %w", id, err)
+		}
+
+		msg, emailRebuilt, err := ToMessage(ctx, dbClient, email, fetch)
+		if err != nil {
+			return rebuilt, err
+		}
+		if emailRebuilt != nil {
+			rebuilt = append(rebuilt, *emailRebuilt)
+		}
+
+		if err = writer.Write(msg); err != nil {
+			return rebuilt, err
+		}
+	}
+
+	return rebuilt, nil
+}
+
+// ExportAccount writes every folder of the account to its own archive in dir, named after the
+// folder. mail in more than one folder is in each of their archives.
+func ExportAccount(ctx context.Context, dbClient *db.Client, accountID int64, format Format, dir string, fetch FetchSource) (exported int, rebuilt []Rebuilt, err error) {
+	folders, err := dbClient.ListFolders(ctx, accountID)
+	if err != nil {
+		return 0, nil, fmt.Errorf("[ARCHIVE::ExportAccount] failed to get folders: %w", err)
+	}
+
+	if err = os.MkdirAll(dir, 0o700); err != nil {
+		return 0, nil, fmt.Errorf("[ARCHIVE::ExportAccount] failed to create %s: %w", dir, err)
+	}
+
+	// "A/B" and "A_B" end up with the same file name, and a file system may not tell case apart
+	taken := make(map[string]bool)
+	for _, folder := range folders {
+		ids, err := dbClient.ListFolderEmailIDs(ctx, folder.ID)
+		if err != nil {
+			return exported, rebuilt, fmt.Errorf("[ARCHIVE::ExportAccount] failed to list %s: %w", folder.Name, err)
+		}
+		if len(ids) == 0 {
+			continue
+		}
+
+		name := FileName(folder.Name, format)
+		for n := 2; taken[strings.ToLower(name)]; n++ {
+			name = FileName(fmt.Sprintf("%s-%d", folder.Name, n), format)
+		}
+		taken[strings.ToLower(name)] = true
+
+		folderRebuilt, err := Export(ctx, dbClient, ids, format, filepath.Join(dir, name), fetch)
+		if err != nil {
+			return exported, rebuilt, err
+		}
+		exported += len(ids)
+		rebuilt = append(rebuilt, folderRebuilt...)
+	}
+
+	return exported, rebuilt, nil
+}
+
+// ThreadEmailIDs returns the emails of the thread oldest first
+func ThreadEmailIDs(ctx context.Context, dbClient *db.Client, threadID int64) ([]int64, error) {
+	emails, err := dbClient.ListEmailsByThread(ctx, threadID)
+	if err != nil {
+		return nil, fmt.Errorf("[ARCHIVE::ThreadEmailIDs] failed to list emails: %w", err)
+	}
+
+	ids := make([]int64, 0, len(emails))
+	for _, email := range slices.Backward(emails) {
+		ids = append(ids, email.ID)
+	}
+	return ids, nil
+}
+
+// FileName turns a folder name into the name of its archive, the hierarchy separator and
+// anything else a file system may not like become '_'
+func FileName(folder string, format Format) string {
+	name := strings.Map(func(r rune) rune {
+		if strings.ContainsRune(`/\:*?"<>|`, r) || r < ' ' {
+			return '_'
+		}
+		return r
+	}, folder)
+	name = strings.TrimLeft(name, ".")
+	if name == "" {
+		name = "folder"
+	}
+
+	if format == FormatMbox {
+		return name + ".mbox"
+	}
+	return name
+}
+
+// ToMessage returns the email as it goes into an archive, with the source the server sent when
+// we have it or fetch gets it. otherwise it is rebuilt from what we stored and rebuilt says why.
+func ToMessage(ctx context.Context, dbClient *db.Client, email db.Email, fetch FetchSource) (msg Message, rebuilt *Rebuilt, err error) {
+	msg = Message{
+		From:    email.FromAddress,
+		Date:    email.ReceivedDate,
+		Seen:    email.IsRead,
+		Flagged: email.IsStarred,
+		Draft:   email.IsDraft,
+	}
+
+	raw, err := dbClient.Source(ctx, email.ID)
+	if err == nil {
+		msg.Raw = raw
+		return msg, nil, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return msg, nil, fmt.Errorf("[ARCHIVE::ToMessage] failed to get source of email %d: %w", email.ID, err)
+	}
+
+	// never downloaded whole, or retention took the body and its source with it
+	rebuilt = &Rebuilt{EmailID: email.ID, MessageID: email.MessageID}
+	if fetch != nil {
+		if raw, rebuilt.Reason = fetch(ctx, email); rebuilt.Reason == nil {
+			msg.Raw = raw
+			return msg, nil, nil
+		}
+	}
+
+	if msg.Raw, err = reconstruct(email); err != nil {
+		return msg, nil, err
+	}
+	return msg, rebuilt, nil
+}
+
+// reconstruct writes a plain text message from the parsed columns. what we didn't keep is lost:
+// html, attachments and any header besides the ones below.
+func reconstruct(email db.Email) ([]byte, error) {
+	var header mail.Header
+	header.SetDate(email.ReceivedDate)
+	header.SetSubject(email.Subject)
+	header.SetAddressList("From", []*mail.Address{{Name: email.FromName.String, Address: email.FromAddress}})
+	header.SetAddressList("To", addressList(email.ToAddresses))
+	if email.CcAddresses.Valid {
+		header.SetAddressList("Cc", addressList(email.CcAddresses.String))
+	}
+	if email.MessageID != "" {
+		header.SetMessageID(email.MessageID)
+	}
+	if email.ReferenceID.Valid {
+		header.SetMsgIDList("References", strings.Split(email.ReferenceID.String, ","))
+	}
+	header.SetContentType("text/plain", map[string]string{"charset": "utf-8"})
+	header.Set("X-Clmail-Reconstructed", "the original source was not downloaded")
+
+	var buf bytes.Buffer
+	body, err := mail.CreateSingleInlineWriter(&buf, header)
+	if err != nil {
+		return nil, fmt.Errorf("[ARCHIVE::reconstruct] failed to write header: %w", err)
+	}
+	if _, err = body.Write([]byte(email.BodyText.String)); err != nil {
+		return nil, fmt.Errorf("[ARCHIVE::reconstruct] failed to write body: %w", err)
+	}
+	if err = body.Close(); err != nil {
+		return nil, fmt.Errorf("[ARCHIVE::reconstruct] failed to write body: %w", err)
+	}
+
+	return buf.Bytes(), nil
+}
+
+// addressList splits a comma separated column back into addresses
+func addressList(addresses string) []*mail.Address {
+	var list []*mail.Address
+	for _, address := range strings.Split(addresses, ",") {
+		if address = strings.TrimSpace(address); address != "" {
+			list = append(list, &mail.Address{Address: address})
+		}
+	}
+	return list
+}
@@ -0,0 +1,78 @@
+package archive
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// maildirWriter puts every message in its own file. they are written to tmp and renamed into
+// cur, so a reader never sees half a message, with the flags in the name after ":2,".
+type maildirWriter struct {
+	dir      string
+	hostname string
+}
+
+// deliveries keeps the names unique when several messages are written in the same second
+var deliveries atomic.Int64
+
+// newMaildirWriter creates the maildir, messages are added to one that exists already
+func newMaildirWriter(dir string) (*maildirWriter, error) {
+	for _, sub := range []string{"tmp", "new", "cur"} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0o700); err != nil {
+			return nil, fmt.Errorf("[ARCHIVE::newMaildirWriter] failed to create %s: %w", dir, err)
+		}
+	}
+
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "localhost"
+	}
+	// '/' and ':' have a meaning in maildir names
+	hostname = strings.NewReplacer("/", `\057`, ":", `\072`).Replace(hostname)
+
+	return &maildirWriter{dir: dir, hostname: hostname}, nil
+}
+
+func (w *maildirWriter) Write(msg Message) error {
+	name := fmt.Sprintf("%d.P%dQ%d.%s", time.Now().Unix(), os.Getpid(), deliveries.Add(1), w.hostname)
+
+	tmpPath := filepath.Join(w.dir, "tmp", name)
+	if err := os.WriteFile(tmpPath, msg.Raw, 0o600); err != nil {
+		return fmt.Errorf("[ARCHIVE::maildirWriter] failed to write message: %w", err)
+	}
+
+	// the file time is what most readers sort by
+	if !msg.Date.IsZero() {
+		os.Chtimes(tmpPath, msg.Date, msg.Date)
+	}
+
+	curPath := filepath.Join(w.dir, "cur", name+":2,"+maildirFlags(msg))
+	if err := os.Rename(tmpPath, curPath); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("[ARCHIVE::maildirWriter] failed to move message to cur: %w", err)
+	}
+	return nil
+}
+
+func (w *maildirWriter) Close() error {
+	return nil
+}
+
+// maildirFlags are the info flags of the message, they have to be in ASCII order
+func maildirFlags(msg Message) string {
+	var flags string
+	if msg.Draft {
+		flags += "D"
+	}
+	if msg.Flagged {
+		flags += "F"
+	}
+	if msg.Seen {
+		flags += "S"
+	}
+	return flags
+}
@@ -0,0 +1,71 @@
+package archive
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"time"
+)
+
+// mboxWriter appends messages to an mboxrd file: every message starts with a "From " line, and
+// lines in the message that look like one, quoted or not, get one more '>' so they can be told
+// apart and restored exactly.
+type mboxWriter struct {
+	file   *os.File
+	writer *bufio.Writer
+}
+
+// newMboxWriter creates the file, an existing one is never overwritten
+func newMboxWriter(path string) (*mboxWriter, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("[ARCHIVE::newMboxWriter] failed to create %s: %w", path, err)
+	}
+	return &mboxWriter{file: file, writer: bufio.NewWriter(file)}, nil
+}
+
+func (w *mboxWriter) Write(msg Message) error {
+	sender := msg.From
+	if sender == "" {
+		sender = "MAILER-DAEMON"
+	}
+	// the date of the From line is always in asctime format and UTC
+	if _, err := fmt.Fprintf(w.writer, "From %s %s\n", sender, msg.Date.UTC().Format(time.ANSIC)); err != nil {
+		return fmt.Errorf("[ARCHIVE::mboxWriter] failed to write: %w", err)
+	}
+
+	// mbox files use plain newlines, the CRLF from the wire goes
+	raw := bytes.ReplaceAll(msg.Raw, []byte("\r\n"), []byte("\n"))
+	for len(raw) > 0 {
+		line, rest, found := bytes.Cut(raw, []byte("\n"))
+		raw = rest
+
+		if isFromLine(line) {
+			w.writer.WriteByte('>')
+		}
+		w.writer.Write(line)
+		if found || len(line) > 0 {
+			w.writer.WriteByte('\n')
+		}
+	}
+
+	// an empty line separates the messages
+	if err := w.writer.WriteByte('\n'); err != nil {
+		return fmt.Errorf("[ARCHIVE::mboxWriter] failed to write: %w", err)
+	}
+	return nil
+}
+
+func (w *mboxWriter) Close() error {
+	if err := w.writer.Flush(); err != nil {
+		w.file.Close()
+		return fmt.Errorf("[ARCHIVE::mboxWriter] failed to write: %w", err)
+	}
+	return w.file.Close()
+}
+
+// isFromLine matches ">*From ", the lines mboxrd quotes
+func isFromLine(line []byte) bool {
+	return bytes.HasPrefix(bytes.TrimLeft(line, ">"), []byte("From "))
+}
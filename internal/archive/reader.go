@@ -0,0 +1,267 @@
+package archive
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/emersion/go-message/textproto"
+)
+
+// Reader returns the messages of an archive one by one, io.EOF after the last
+type Reader interface {
+	Next() (Message, error)
+	Close() error
+}
+
+// Open reads a Maildir, an mbox file or a single .eml file, whatever is at path
+func Open(path string) (Reader, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("[ARCHIVE::Open] failed to open %s: %w", path, err)
+	}
+
+	if info.IsDir() {
+		return openMaildir(path)
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("[ARCHIVE::Open] failed to open %s: %w", path, err)
+	}
+
+	reader := bufio.NewReader(file)
+	start, _ := reader.Peek(5)
+	if string(start) == "From " {
+		return &mboxReader{file: file, reader: reader}, nil
+	}
+
+	return &emlReader{file: file, reader: reader, date: info.ModTime()}, nil
+}
+
+// mboxReader splits an mbox at its "From " lines. it takes mboxrd and the older mboxo, which only
+// quotes unquoted From lines, alike: one '>' is taken off every quoted one.
+type mboxReader struct {
+	file   *os.File
+	reader *bufio.Reader
+	// the From line of the next message, already read while looking for the end of this one
+	next []byte
+}
+
+func (r *mboxReader) Next() (Message, error) {
+	fromLine := r.next
+	r.next = nil
+	if fromLine == nil {
+		line, err := r.reader.ReadBytes('\n')
+		if len(line) == 0 && err != nil {
+			return Message{}, err
+		}
+		fromLine = line
+	}
+
+	var raw bytes.Buffer
+	for {
+		line, err := r.reader.ReadBytes('\n')
+		if bytes.HasPrefix(line, []byte("From ")) {
+			r.next = line
+			break
+		}
+
+		if isFromLine(line) {
+			line = line[1:]
+		}
+		writeCRLF(&raw, line)
+
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return Message{}, fmt.Errorf("[ARCHIVE::mboxReader] failed to read: %w", err)
+		}
+	}
+
+	// the empty line in front of the next From line belongs to the mbox, not to the message
+	content := bytes.TrimSuffix(raw.Bytes(), []byte("\r\n\r\n"))
+	if len(content) < raw.Len() {
+		content = append(content, "\r\n"...)
+	}
+
+	msg := headerMessage(content)
+	if msg.Date.IsZero() {
+		msg.Date = fromLineDate(fromLine)
+	}
+	return msg, nil
+}
+
+func (r *mboxReader) Close() error {
+	return r.file.Close()
+}
+
+// emlReader is a file with a single message
+type emlReader struct {
+	file   *os.File
+	reader *bufio.Reader
+	date   time.Time
+	done   bool
+}
+
+func (r *emlReader) Next() (Message, error) {
+	if r.done {
+		return Message{}, io.EOF
+	}
+	r.done = true
+
+	content, err := io.ReadAll(r.reader)
+	if err != nil {
+		return Message{}, fmt.Errorf("[ARCHIVE::emlReader] failed to read: %w", err)
+	}
+
+	msg := headerMessage(toCRLF(content))
+	if msg.Date.IsZero() {
+		msg.Date = r.date
+	}
+	return msg, nil
+}
+
+func (r *emlReader) Close() error {
+	return r.file.Close()
+}
+
+// maildirReader goes through new and cur in the order of the file names, which start with the
+// time of delivery
+type maildirReader struct {
+	paths []string
+}
+
+func openMaildir(dir string) (*maildirReader, error) {
+	var paths []string
+	for _, sub := range []string{"new", "cur"} {
+		entries, err := os.ReadDir(filepath.Join(dir, sub))
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("[ARCHIVE::openMaildir] failed to read %s: %w", dir, err)
+		}
+		for _, entry := range entries {
+			if !entry.IsDir() && !strings.HasPrefix(entry.Name(), ".") {
+				paths = append(paths, filepath.Join(dir, sub, entry.Name()))
+			}
+		}
+	}
+
+	if paths == nil {
+		if _, err := os.Stat(filepath.Join(dir, "cur")); err != nil {
+			return nil, fmt.Errorf("[ARCHIVE::openMaildir] %s is not a maildir", dir)
+		}
+	}
+
+	sort.Slice(paths, func(i, j int) bool { return filepath.Base(paths[i]) < filepath.Base(paths[j]) })
+	return &maildirReader{paths: paths}, nil
+}
+
+func (r *maildirReader) Next() (Message, error) {
+	if len(r.paths) == 0 {
+		return Message{}, io.EOF
+	}
+	path := r.paths[0]
+	r.paths = r.paths[1:]
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return Message{}, fmt.Errorf("[ARCHIVE::maildirReader] failed to read %s: %w", path, err)
+	}
+
+	msg := headerMessage(toCRLF(content))
+	if info, err := os.Stat(path); err == nil && msg.Date.IsZero() {
+		msg.Date = info.ModTime()
+	}
+
+	// the name has the flags, they win over whatever the headers say
+	if _, info, ok := strings.Cut(filepath.Base(path), ":2,"); ok {
+		msg.Seen = strings.Contains(info, "S")
+		msg.Flagged = strings.Contains(info, "F")
+		msg.Draft = strings.Contains(info, "D")
+	} else {
+		msg.Seen, msg.Flagged, msg.Draft = false, false, false
+	}
+	return msg, nil
+}
+
+func (r *maildirReader) Close() error {
+	return nil
+}
+
+// headerMessage fills in what the headers of the message say about it: the date and
+// the flags other clients leave in Status, X-Status and X-Mozilla-Status
+func headerMessage(raw []byte) Message {
+	msg := Message{Raw: raw}
+
+	header, err := textproto.ReadHeader(bufio.NewReader(bytes.NewReader(raw)))
+	if err != nil {
+		return msg
+	}
+
+	if date, err := mail.ParseDate(header.Get("Date")); err == nil {
+		msg.Date = date
+	}
+
+	status := header.Get("Status") + header.Get("X-Status")
+	msg.Seen = strings.Contains(status, "R")
+	msg.Flagged = strings.Contains(status, "F")
+	msg.Draft = strings.Contains(status, "T")
+
+	// thunderbird keeps them as a hex bit field, 0x1 is read and 0x4 is flagged
+	if mozilla, err := strconv.ParseUint(strings.TrimSpace(header.Get("X-Mozilla-Status")), 16, 32); err == nil {
+		msg.Seen = msg.Seen || mozilla&0x1 != 0
+		msg.Flagged = msg.Flagged || mozilla&0x4 != 0
+	}
+
+	return msg
+}
+
+// fromLineDate reads the date off "From sender Mon Jan  2 15:04:05 2006"
+func fromLineDate(line []byte) time.Time {
+	fields := strings.Fields(string(line))
+	if len(fields) < 7 {
+		return time.Time{}
+	}
+
+	date, err := time.Parse("Mon Jan 2 15:04:05 2006", strings.Join(fields[len(fields)-5:], " "))
+	if err != nil {
+		return time.Time{}
+	}
+	return date
+}
+
+// writeCRLF writes the line with a CRLF ending, IMAP servers don't take bare newlines
+func writeCRLF(buf *bytes.Buffer, line []byte) {
+	if len(line) == 0 {
+		return
+	}
+	line = bytes.TrimSuffix(bytes.TrimSuffix(line, []byte("\n")), []byte("\r"))
+	buf.Write(line)
+	buf.WriteString("\r\n")
+}
+
+func toCRLF(content []byte) []byte {
+	var buf bytes.Buffer
+	for len(content) > 0 {
+		line, rest, found := bytes.Cut(content, []byte("\n"))
+		content = rest
+		if found {
+			line = append(line, '\n')
+		}
+		writeCRLF(&buf, line)
+	}
+	return buf.Bytes()
+}
@@ -0,0 +1,265 @@
+package autoconfig
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// socket types as used by the thunderbird autoconfig format
+const (
+	SocketSSL      = "SSL"
+	SocketSTARTTLS = "STARTTLS"
+	SocketPlain    = "plain"
+)
+
+var ErrNotFound = errors.New("no configuration found")
+
+type Server struct {
+	Hostname   string
+	Port       int64
+	SocketType string
+	Username   string
+}
+
+// Config is what we found out about the mail servers of an email address
+type Config struct {
+	Imap Server
+	Smtp Server
+	// Source tells where the config came from, shown in the setup view
+	Source string
+}
+
+// Resolver is the part of net.Resolver we need, swap it out to test without the network
+type Resolver interface {
+	LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error)
+	LookupMX(ctx context.Context, name string) ([]*net.MX, error)
+	LookupHost(ctx context.Context, host string) ([]string, error)
+}
+
+// Fetcher downloads the autoconfig xml, swap it out to test without the network
+type Fetcher interface {
+	Fetch(ctx context.Context, url string) ([]byte, error)
+}
+
+type httpFetcher struct {
+	client *http.Client
+}
+
+func (f httpFetcher) Fetch(ctx context.Context, url string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	// nobody needs a config file bigger than this
+	return io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+}
+
+type Discoverer struct {
+	Resolver Resolver
+	Fetcher  Fetcher
+	// ISPDB maps a domain to its config, defaults to the bundled snapshot
+	ISPDB map[string]clientConfig
+}
+
+func NewDiscoverer() *Discoverer {
+	return &Discoverer{
+		Resolver: net.DefaultResolver,
+		Fetcher:  httpFetcher{client: &http.Client{Timeout: 5 * time.Second}},
+		ISPDB:    bundledISPDB(),
+	}
+}
+
+// Discover tries, in order, the autoconfig file hosted by the domain, the bundled ISPDB, RFC 6186
+// SRV records and finally guesses based on the MX records of the domain.
+func (d *Discoverer) Discover(ctx context.Context, email string) (*Config, error) {
+	localPart, domain, ok := strings.Cut(strings.TrimSpace(email), "@")
+	if !ok || localPart == "" || domain == "" {
+		return nil, fmt.Errorf("[AUTOCONFIG::Discover] invalid email address: %s", email)
+	}
+	domain = strings.ToLower(domain)
+
+	strategies := []struct {
+		name string
+		find func(ctx context.Context, email, domain string) (*Config, error)
+	}{
+		{"autoconfig", d.fromISP},
+		{"ispdb", d.fromISPDB},
+		{"srv", d.fromSRV},
+		{"mx", d.fromMX},
+	}
+
+	for _, strategy := range strategies {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+
+		config, err := strategy.find(ctx, email, domain)
+		if err != nil {
+			if !errors.Is(err, ErrNotFound) {
+				log.Printf("[AUTOCONFIG::Discover] %s lookup for %s failed: %v", strategy.name, domain, err)
+			}
+			continue
+		}
+
+		return config, nil
+	}
+
+	return nil, ErrNotFound
+}
+
+// fromISP fetches the config file the provider hosts itself
+func (d *Discoverer) fromISP(ctx context.Context, email, domain string) (*Config, error) {
+	query := url.QueryEscape(email)
+	urls := []string{
+		fmt.Sprintf("https://autoconfig.%s/mail/config-v1.1.xml?emailaddress=%s", domain, query),
+		fmt.Sprintf("https://%s/.well-known/autoconfig/mail/config-v1.1.xml?emailaddress=%s", domain, query),
+	}
+
+	for _, configURL := range urls {
+		data, err := d.Fetcher.Fetch(ctx, configURL)
+		if err != nil {
+			continue
+		}
+
+		config, err := parseClientConfig(data)
+		if err != nil {
+			log.Printf("[AUTOCONFIG::fromISP] Failed to parse %s: %v", configURL, err)
+			continue
+		}
+
+		if result, ok := config.resolve(email, "autoconfig from "+domain); ok {
+			return result, nil
+		}
+	}
+
+	return nil, ErrNotFound
+}
+
+func (d *Discoverer) fromISPDB(_ context.Context, email, domain string) (*Config, error) {
+	config, ok := d.ISPDB[domain]
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	if result, ok := config.resolve(email, "ISPDB"); ok {
+		return result, nil
+	}
+
+	return nil, ErrNotFound
+}
+
+// fromSRV looks up the RFC 6186 records, implicit tls is preferred over starttls
+func (d *Discoverer) fromSRV(ctx context.Context, email, domain string) (*Config, error) {
+	imapServer, imapFound := d.lookupSRV(ctx, domain, []srvService{
+		{"imaps", SocketSSL},
+		{"imap", SocketSTARTTLS},
+	})
+	smtpServer, smtpFound := d.lookupSRV(ctx, domain, []srvService{
+		{"submissions", SocketSSL},
+		{"submission", SocketSTARTTLS},
+	})
+
+	if !imapFound || !smtpFound {
+		return nil, ErrNotFound
+	}
+
+	imapServer.Username = email
+	smtpServer.Username = email
+
+	return &Config{Imap: imapServer, Smtp: smtpServer, Source: "SRV records"}, nil
+}
+
+type srvService struct {
+	name       string
+	socketType string
+}
+
+func (d *Discoverer) lookupSRV(ctx context.Context, domain string, services []srvService) (Server, bool) {
+	for _, service := range services {
+		_, records, err := d.Resolver.LookupSRV(ctx, service.name, "tcp", domain)
+		if err != nil || len(records) == 0 {
+			continue
+		}
+
+		// records come sorted by priority, a target of "." means the service is not offered
+		record := records[0]
+		target := strings.TrimSuffix(record.Target, ".")
+		if target == "" {
+			continue
+		}
+
+		return Server{Hostname: target, Port: int64(record.Port), SocketType: service.socketType}, true
+	}
+
+	return Server{}, false
+}
+
+// fromMX figures out who hosts the mail of the domain. custom domains at google, microsoft and co
+// are found in the ISPDB through the MX host, otherwise we guess the usual host names.
+func (d *Discoverer) fromMX(ctx context.Context, email, domain string) (*Config, error) {
+	records, err := d.Resolver.LookupMX(ctx, domain)
+	if err == nil {
+		for _, record := range records {
+			host := strings.ToLower(strings.TrimSuffix(record.Host, "."))
+
+			for _, suffix := range domainSuffixes(host) {
+				if config, ok := d.ISPDB[suffix]; ok {
+					if result, ok := config.resolve(email, "ISPDB via MX "+host); ok {
+						return result, nil
+					}
+				}
+			}
+		}
+	}
+
+	for _, prefix := range []string{"imap.", "mail."} {
+		host := prefix + domain
+		if _, err := d.Resolver.LookupHost(ctx, host); err != nil {
+			continue
+		}
+
+		smtpHost := "smtp." + domain
+		if _, err := d.Resolver.LookupHost(ctx, smtpHost); err != nil {
+			smtpHost = host
+		}
+
+		return &Config{
+			Imap:   Server{Hostname: host, Port: 993, SocketType: SocketSSL, Username: email},
+			Smtp:   Server{Hostname: smtpHost, Port: 587, SocketType: SocketSTARTTLS, Username: email},
+			Source: "guessed",
+		}, nil
+	}
+
+	return nil, ErrNotFound
+}
+
+// domainSuffixes returns mx1.mail.example.com as mail.example.com and example.com, the bare tld is skipped
+func domainSuffixes(host string) []string {
+	labels := strings.Split(host, ".")
+
+	var suffixes []string
+	for i := 1; i < len(labels)-1; i++ {
+		suffixes = append(suffixes, strings.Join(labels[i:], "."))
+	}
+
+	return suffixes
+}
@@ -0,0 +1,275 @@
+package autoconfig
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+)
+
+// fakeResolver answers from maps, everything else doesn't exist
+type fakeResolver struct {
+	srv   map[string][]*net.SRV
+	mx    map[string][]*net.MX
+	hosts map[string]bool
+}
+
+var errNoSuchHost = errors.New("no such host")
+
+func (r fakeResolver) LookupSRV(_ context.Context, service, proto, name string) (string, []*net.SRV, error) {
+	records, ok := r.srv["_"+service+"._"+proto+"."+name]
+	if !ok {
+		return "", nil, errNoSuchHost
+	}
+	return "", records, nil
+}
+
+func (r fakeResolver) LookupMX(_ context.Context, name string) ([]*net.MX, error) {
+	records, ok := r.mx[name]
+	if !ok {
+		return nil, errNoSuchHost
+	}
+	return records, nil
+}
+
+func (r fakeResolver) LookupHost(_ context.Context, host string) ([]string, error) {
+	if !r.hosts[host] {
+		return nil, errNoSuchHost
+	}
+	return []string{"192.0.2.1"}, nil
+}
+
+const ispConfig = `<?xml version="1.0" encoding="UTF-8"?>
+<clientConfig version="1.1">
+  <emailProvider id="example.org">
+    <domain>example.org</domain>
+    <incomingServer type="pop3">
+      <hostname>pop.example.org</hostname>
+      <port>995</port>
+      <socketType>SSL</socketType>
+    </incomingServer>
+    <incomingServer type="imap">
+      <hostname>imap.example.org</hostname>
+      <port>143</port>
+      <socketType>STARTTLS</socketType>
+      <username>%EMAILLOCALPART%</username>
+    </incomingServer>
+    <outgoingServer type="smtp">
+      <hostname>smtp.%EMAILDOMAIN%</hostname>
+      <port>587</port>
+      <socketType>STARTTLS</socketType>
+      <username>%EMAILADDRESS%</username>
+    </outgoingServer>
+  </emailProvider>
+</clientConfig>`
+
+// ispServer serves config files by host and path, every https request of the fetcher goes to it
+func ispServer(t *testing.T, files map[string]string) Fetcher {
+	t.Helper()
+
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		file, ok := files[r.Host+r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		if r.URL.Query().Get("emailaddress") == "" {
+			http.Error(w, "no address", http.StatusBadRequest)
+			return
+		}
+		w.Write([]byte(file))
+	}))
+	t.Cleanup(server.Close)
+
+	transport := server.Client().Transport.(*http.Transport).Clone()
+	transport.DialContext = func(ctx context.Context, network, _ string) (net.Conn, error) {
+		return (&net.Dialer{}).DialContext(ctx, network, server.Listener.Addr().String())
+	}
+	transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+
+	return httpFetcher{client: &http.Client{Transport: transport}}
+}
+
+func TestDiscover(t *testing.T) {
+	tests := []struct {
+		name     string
+		email    string
+		files    map[string]string
+		resolver fakeResolver
+		want     *Config
+	}{
+		{
+			name:  "isp autoconfig subdomain",
+			email: "jane@example.org",
+			files: map[string]string{"autoconfig.example.org/mail/config-v1.1.xml": ispConfig},
+			want: &Config{
+				Imap:   Server{Hostname: "imap.example.org", Port: 143, SocketType: SocketSTARTTLS, Username: "jane"},
+				Smtp:   Server{Hostname: "smtp.example.org", Port: 587, SocketType: SocketSTARTTLS, Username: "jane@example.org"},
+				Source: "autoconfig from example.org",
+			},
+		},
+		{
+			name:  "isp well-known path",
+			email: "jane@example.org",
+			files: map[string]string{"example.org/.well-known/autoconfig/mail/config-v1.1.xml": ispConfig},
+			want: &Config{
+				Imap:   Server{Hostname: "imap.example.org", Port: 143, SocketType: SocketSTARTTLS, Username: "jane"},
+				Smtp:   Server{Hostname: "smtp.example.org", Port: 587, SocketType: SocketSTARTTLS, Username: "jane@example.org"},
+				Source: "autoconfig from example.org",
+			},
+		},
+		{
+			name:  "broken isp file falls through to srv",
+			email: "jane@example.org",
+			files: map[string]string{"autoconfig.example.org/mail/config-v1.1.xml": "<clientConfig"},
+			resolver: fakeResolver{srv: map[string][]*net.SRV{
+				"_imaps._tcp.example.org":      {{Target: "mail.example.org.", Port: 993}},
+				"_submission._tcp.example.org": {{Target: "mail.example.org.", Port: 587}},
+			}},
+			want: &Config{
+				Imap:   Server{Hostname: "mail.example.org", Port: 993, SocketType: SocketSSL, Username: "jane@example.org"},
+				Smtp:   Server{Hostname: "mail.example.org", Port: 587, SocketType: SocketSTARTTLS, Username: "jane@example.org"},
+				Source: "SRV records",
+			},
+		},
+		{
+			name:  "bundled ispdb",
+			email: "Jane@GoogleMail.com",
+			want: &Config{
+				Imap:   Server{Hostname: "imap.gmail.com", Port: 993, SocketType: SocketSSL, Username: "Jane@GoogleMail.com"},
+				Smtp:   Server{Hostname: "smtp.gmail.com", Port: 465, SocketType: SocketSSL, Username: "Jane@GoogleMail.com"},
+				Source: "ISPDB",
+			},
+		},
+		{
+			name:  "srv prefers implicit tls and skips services that aren't offered",
+			email: "jane@example.net",
+			resolver: fakeResolver{srv: map[string][]*net.SRV{
+				"_imaps._tcp.example.net":       {{Target: ".", Port: 0}},
+				"_imap._tcp.example.net":        {{Target: "imap.example.net.", Port: 143}},
+				"_submissions._tcp.example.net": {{Target: "smtp.example.net.", Port: 465}},
+				"_submission._tcp.example.net":  {{Target: "smtp.example.net.", Port: 587}},
+			}},
+			want: &Config{
+				Imap:   Server{Hostname: "imap.example.net", Port: 143, SocketType: SocketSTARTTLS, Username: "jane@example.net"},
+				Smtp:   Server{Hostname: "smtp.example.net", Port: 465, SocketType: SocketSSL, Username: "jane@example.net"},
+				Source: "SRV records",
+			},
+		},
+		{
+			name:  "srv without smtp falls through to mx",
+			email: "jane@example.net",
+			resolver: fakeResolver{
+				srv: map[string][]*net.SRV{"_imaps._tcp.example.net": {{Target: "imap.example.net.", Port: 993}}},
+				hosts: map[string]bool{
+					"imap.example.net": true,
+					"smtp.example.net": true,
+				},
+			},
+			want: &Config{
+				Imap:   Server{Hostname: "imap.example.net", Port: 993, SocketType: SocketSSL, Username: "jane@example.net"},
+				Smtp:   Server{Hostname: "smtp.example.net", Port: 587, SocketType: SocketSTARTTLS, Username: "jane@example.net"},
+				Source: "guessed",
+			},
+		},
+		{
+			name:  "mx host in the ispdb",
+			email: "jane@custom.example",
+			resolver: fakeResolver{mx: map[string][]*net.MX{
+				"custom.example": {{Host: "ASPMX.L.GOOGLE.COM.", Pref: 1}},
+			}},
+			want: &Config{
+				Imap:   Server{Hostname: "imap.gmail.com", Port: 993, SocketType: SocketSSL, Username: "jane@custom.example"},
+				Smtp:   Server{Hostname: "smtp.gmail.com", Port: 465, SocketType: SocketSSL, Username: "jane@custom.example"},
+				Source: "ISPDB via MX aspmx.l.google.com",
+			},
+		},
+		{
+			name:  "guessed mail host without an smtp host",
+			email: "jane@small.example",
+			resolver: fakeResolver{
+				mx:    map[string][]*net.MX{"small.example": {{Host: "mx.small.example.", Pref: 10}}},
+				hosts: map[string]bool{"mail.small.example": true},
+			},
+			want: &Config{
+				Imap:   Server{Hostname: "mail.small.example", Port: 993, SocketType: SocketSSL, Username: "jane@small.example"},
+				Smtp:   Server{Hostname: "mail.small.example", Port: 587, SocketType: SocketSTARTTLS, Username: "jane@small.example"},
+				Source: "guessed",
+			},
+		},
+		{
+			name:  "nothing found",
+			email: "jane@nowhere.example",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			discoverer := &Discoverer{
+				Resolver: test.resolver,
+				Fetcher:  ispServer(t, test.files),
+				ISPDB:    bundledISPDB(),
+			}
+
+			got, err := discoverer.Discover(context.Background(), test.email)
+			if test.want == nil {
+				if !errors.Is(err, ErrNotFound) {
+					t.Fatalf("Discover(%s) = %+v, %v, want ErrNotFound", test.email, got, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Discover(%s) failed: %v", test.email, err)
+			}
+			if *got != *test.want {
+				t.Errorf("Discover(%s) = %+v, want %+v", test.email, *got, *test.want)
+			}
+		})
+	}
+}
+
+func TestDiscoverInvalidAddress(t *testing.T) {
+	discoverer := &Discoverer{Resolver: fakeResolver{}, Fetcher: ispServer(t, nil), ISPDB: bundledISPDB()}
+
+	for _, email := range []string{"", "jane", "@example.org", "jane@"} {
+		if _, err := discoverer.Discover(context.Background(), email); err == nil || errors.Is(err, ErrNotFound) {
+			t.Errorf("Discover(%q) = %v, want an invalid address error", email, err)
+		}
+	}
+}
+
+func TestBundledISPDB(t *testing.T) {
+	ispdb := bundledISPDB()
+
+	for _, domain := range []string{"gmail.com", "googlemail.com", "outlook.com", "hotmail.com", "yahoo.com", "icloud.com", "fastmail.com", "gmx.net", "aol.com", "zoho.com"} {
+		config, ok := ispdb[domain]
+		if !ok {
+			t.Errorf("%s is missing from the bundled ISPDB", domain)
+			continue
+		}
+		if _, ok := config.resolve("jane@"+domain, "ISPDB"); !ok {
+			t.Errorf("the config of %s has no imap or smtp server", domain)
+		}
+	}
+}
+
+func TestDomainSuffixes(t *testing.T) {
+	tests := []struct {
+		host string
+		want []string
+	}{
+		{"mx1.mail.example.com", []string{"mail.example.com", "example.com"}},
+		{"example.com", nil},
+		{"com", nil},
+	}
+
+	for _, test := range tests {
+		if got := domainSuffixes(test.host); !slices.Equal(got, test.want) {
+			t.Errorf("domainSuffixes(%s) = %q, want %q", test.host, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package autoconfig
+
+import (
+	"embed"
+	"encoding/xml"
+	"fmt"
+	"log"
+	"strings"
+)
+
+// a small snapshot of the thunderbird ISPDB for the big providers, so setup works without
+// hitting the network for the common cases
+//
+//go:embed ispdb/*.xml
+var ispdbFiles embed.FS
+
+type clientConfig struct {
+	Provider struct {
+		Domains  []string       `xml:"domain"`
+		Incoming []serverConfig `xml:"incomingServer"`
+		Outgoing []serverConfig `xml:"outgoingServer"`
+	} `xml:"emailProvider"`
+}
+
+type serverConfig struct {
+	Type       string `xml:"type,attr"`
+	Hostname   string `xml:"hostname"`
+	Port       int64  `xml:"port"`
+	SocketType string `xml:"socketType"`
+	Username   string `xml:"username"`
+}
+
+func parseClientConfig(data []byte) (clientConfig, error) {
+	var config clientConfig
+	if err := xml.Unmarshal(data, &config); err != nil {
+		return config, fmt.Errorf("[AUTOCONFIG::parseClientConfig] failed to parse: %w", err)
+	}
+	return config, nil
+}
+
+// resolve picks the first imap and smtp server and fills in the username placeholders
+func (c clientConfig) resolve(email, source string) (*Config, bool) {
+	imapServer, imapFound := firstServer(c.Provider.Incoming, "imap", email)
+	smtpServer, smtpFound := firstServer(c.Provider.Outgoing, "smtp", email)
+
+	if !imapFound || !smtpFound {
+		return nil, false
+	}
+
+	return &Config{Imap: imapServer, Smtp: smtpServer, Source: source}, true
+}
+
+func firstServer(servers []serverConfig, serverType, email string) (Server, bool) {
+	localPart, domain, _ := strings.Cut(email, "@")
+	placeholders := strings.NewReplacer(
+		"%EMAILADDRESS%", email,
+		"%EMAILLOCALPART%", localPart,
+		"%EMAILDOMAIN%", domain,
+	)
+
+	for _, server := range servers {
+		if server.Type != serverType || server.Hostname == "" {
+			continue
+		}
+
+		socketType := SocketPlain
+		switch strings.ToUpper(server.SocketType) {
+		case "SSL":
+			socketType = SocketSSL
+		case "STARTTLS":
+			socketType = SocketSTARTTLS
+		}
+
+		return Server{
+			Hostname:   placeholders.Replace(server.Hostname),
+			Port:       server.Port,
+			SocketType: socketType,
+			Username:   placeholders.Replace(server.Username),
+		}, true
+	}
+
+	return Server{}, false
+}
+
+// bundledISPDB indexes the embedded configs by every domain they list
+func bundledISPDB() map[string]clientConfig {
+	configs := make(map[string]clientConfig)
+
+	entries, err := ispdbFiles.ReadDir("ispdb")
+	if err != nil {
+		log.Printf("[AUTOCONFIG::bundledISPDB] Failed to read ispdb: %v", err)
+		return configs
+	}
+
+	for _, entry := range entries {
+		data, err := ispdbFiles.ReadFile("ispdb/" + entry.Name())
+		if err != nil {
+			log.Printf("[AUTOCONFIG::bundledISPDB] Failed to read %s: %v", entry.Name(), err)
+			continue
+		}
+
+		config, err := parseClientConfig(data)
+		if err != nil {
+			log.Printf("[AUTOCONFIG::bundledISPDB] Failed to parse %s: %v", entry.Name(), err)
+			continue
+		}
+
+		for _, domain := range config.Provider.Domains {
+			configs[strings.ToLower(domain)] = config
+		}
+	}
+
+	return configs
+}
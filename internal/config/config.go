@@ -1,26 +1,250 @@
 package config
 
 import (
+	"encoding/json"
+	"errors"
+	"fmt"
 	"os"
 	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
 )
 
-func GetConfigDir() (string, error) {
-	var configDir string
+// ConfigFile is the name of the config file in the config directory
+const ConfigFile = "config.json"
 
-	if homeDir, err := os.UserHomeDir(); err == nil {
-		if _, err := os.Stat(filepath.Join(homeDir, "Library/Application Support")); err == nil {
-			configDir = filepath.Join(homeDir, "Library/Application Support", "clmail")
-		} else {
-			configDir = filepath.Join(homeDir, ".config", "clmail")
+// Options is what the command line and the environment say about where clmail keeps its files,
+// the zero value is the default profile in the default directories
+type Options struct {
+	// ConfigFile is read instead of config.json in the config directory, its directory becomes
+	// the config directory
+	ConfigFile string
+	// DataDir overrides everything else about where the database goes
+	DataDir string
+	// Profile keeps a separate set of accounts, mail and secrets, empty for the default one
+	Profile string
+}
+
+// File is config.json. every path in it may start with ~/
+type File struct {
+	DataDir  string `json:"data_dir,omitempty"`
+	CacheDir string `json:"cache_dir,omitempty"`
+}
+
+// Paths are the directories clmail keeps its files in
+type Paths struct {
+	// Config has config.json, oauth.json and secrets.age
+	Config string
+	// Data has the database, its backups and exports
+	Data string
+	// Cache has what can be downloaded again
+	Cache   string
+	Profile string
+}
+
+var (
+	mu     sync.Mutex
+	loaded *Paths
+)
+
+// Load works out the directories for opts, the getters below use them from then on. CLMAIL_HOME
+// puts all of them in one directory, otherwise they follow XDG_CONFIG_HOME, XDG_DATA_HOME and
+// XDG_CACHE_HOME. config.json can move the data and cache directories, opts.DataDir beats it.
+// CLMAIL_PROFILE picks the profile when opts doesn't.
+func Load(opts Options) (Paths, error) {
+	if opts.Profile == "" {
+		opts.Profile = os.Getenv("CLMAIL_PROFILE")
+	}
+	if err := checkProfile(opts.Profile); err != nil {
+		return Paths{}, err
+	}
+
+	paths, err := defaultPaths()
+	if err != nil {
+		return Paths{}, err
+	}
+	if opts.Profile != "" {
+		paths.Config = filepath.Join(paths.Config, "profiles", opts.Profile)
+		paths.Data = filepath.Join(paths.Data, "profiles", opts.Profile)
+		paths.Cache = filepath.Join(paths.Cache, "profiles", opts.Profile)
+	}
+	paths.Profile = opts.Profile
+
+	configFile := filepath.Join(paths.Config, ConfigFile)
+	if opts.ConfigFile != "" {
+		if configFile, err = expand(opts.ConfigFile); err != nil {
+			return Paths{}, err
 		}
-	} else {
+		paths.Config = filepath.Dir(configFile)
+	}
+
+	file, err := readFile(configFile, opts.ConfigFile != "")
+	if err != nil {
+		return Paths{}, err
+	}
+
+	explicitData := file.DataDir != "" || opts.DataDir != ""
+	if file.DataDir != "" {
+		if paths.Data, err = expand(file.DataDir); err != nil {
+			return Paths{}, err
+		}
+	}
+	if file.CacheDir != "" {
+		if paths.Cache, err = expand(file.CacheDir); err != nil {
+			return Paths{}, err
+		}
+	}
+	if opts.DataDir != "" {
+		if paths.Data, err = expand(opts.DataDir); err != nil {
+			return Paths{}, err
+		}
+	}
+
+	// the database used to live in the config directory, it stays there until it is moved
+	if !explicitData && !exists(filepath.Join(paths.Data, "db.sqlite")) && exists(filepath.Join(paths.Config, "db.sqlite")) {
+		paths.Data = paths.Config
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	loaded = &paths
+	return paths, nil
+}
+
+// Profile is the profile Load picked, empty for the default one
+func Profile() string {
+	paths, err := current()
+	if err != nil {
+		return ""
+	}
+	return paths.Profile
+}
+
+// ConfigDir is the config directory, created when missing
+func ConfigDir() (string, error) {
+	return dir(func(paths Paths) string { return paths.Config })
+}
+
+// DataDir is the directory of the database, created when missing
+func DataDir() (string, error) {
+	return dir(func(paths Paths) string { return paths.Data })
+}
+
+// CacheDir is the directory for what can be downloaded again, created when missing
+func CacheDir() (string, error) {
+	return dir(func(paths Paths) string { return paths.Cache })
+}
+
+func dir(pick func(paths Paths) string) (string, error) {
+	paths, err := current()
+	if err != nil {
 		return "", err
 	}
 
-	if err := os.MkdirAll(configDir, 0700); err != nil {
+	dir := pick(paths)
+	if err := os.MkdirAll(dir, 0700); err != nil {
 		return "", err
 	}
+	return dir, nil
+}
+
+// current is what Load picked, the defaults when nothing called it
+func current() (Paths, error) {
+	mu.Lock()
+	paths := loaded
+	mu.Unlock()
+	if paths != nil {
+		return *paths, nil
+	}
+	return Load(Options{})
+}
+
+func defaultPaths() (Paths, error) {
+	if home := os.Getenv("CLMAIL_HOME"); home != "" {
+		home, err := expand(home)
+		if err != nil {
+			return Paths{}, err
+		}
+		return Paths{Config: home, Data: home, Cache: filepath.Join(home, "cache")}, nil
+	}
+
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return Paths{}, err
+	}
+
+	// macOS keeps config and data together, the XDG variables still win when they are set
+	var paths Paths
+	if _, err := os.Stat(filepath.Join(homeDir, "Library/Application Support")); err == nil {
+		paths.Config = filepath.Join(homeDir, "Library/Application Support", "clmail")
+		paths.Data = paths.Config
+		paths.Cache = filepath.Join(homeDir, "Library/Caches", "clmail")
+	} else {
+		paths.Config = filepath.Join(homeDir, ".config", "clmail")
+		paths.Data = filepath.Join(homeDir, ".local", "share", "clmail")
+		paths.Cache = filepath.Join(homeDir, ".cache", "clmail")
+	}
+
+	for variable, target := range map[string]*string{
+		"XDG_CONFIG_HOME": &paths.Config,
+		"XDG_DATA_HOME":   &paths.Data,
+		"XDG_CACHE_HOME":  &paths.Cache,
+	} {
+		// relative ones are invalid by the spec and ignored
+		if value := os.Getenv(variable); filepath.IsAbs(value) {
+			*target = filepath.Join(value, "clmail")
+		}
+	}
+
+	return paths, nil
+}
+
+// readFile reads config.json, a missing one is only an error when it was asked for by name
+func readFile(path string, required bool) (File, error) {
+	var file File
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) && !required {
+		return file, nil
+	}
+	if err != nil {
+		return file, fmt.Errorf("[CONFIG::readFile] failed to read %s: %w", path, err)
+	}
+
+	if err := json.Unmarshal(data, &file); err != nil {
+		return file, fmt.Errorf("[CONFIG::readFile] failed to parse %s: %w", path, err)
+	}
+	return file, nil
+}
+
+// reservedProfiles can't be used, their keyring service clmail-NAME is taken
+var reservedProfiles = []string{"oauth"}
+
+func checkProfile(profile string) error {
+	if profile == "." || profile == ".." || strings.ContainsAny(profile, `/\`) {
+		return fmt.Errorf("invalid profile name %q", profile)
+	}
+	if slices.Contains(reservedProfiles, strings.ToLower(profile)) {
+		return fmt.Errorf("the profile name %q is reserved", profile)
+	}
+	return nil
+}
+
+// expand resolves ~/ and makes the path absolute, a relative path would change with the working
+// directory
+func expand(path string) (string, error) {
+	if path == "~" || strings.HasPrefix(path, "~/") {
+		homeDir, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		path = filepath.Join(homeDir, path[1:])
+	}
+	return filepath.Abs(path)
+}
 
-	return configDir, nil
+func exists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
 }
@@ -3,68 +3,67 @@ package db
 import (
 	"context"
 	"database/sql"
-	_ "embed"
+	"fmt"
 	"github.com/rexxDigital/clmail/internal/config"
-	"log"
-	_ "modernc.org/sqlite"
 	"path/filepath"
 	"time"
 )
 
-//go:embed schema.sql
-var ddl string
-
 type Client struct {
 	DB *sql.DB
 	*Queries
+	path      string
+	connector *connector
+	encrypted bool
 }
 
+// NewClient opens the database in the data directory and brings it up to the newest migration,
+// an encrypted one still needs its keys before mail can be read
 func NewClient() (*Client, error) {
 	ctx := context.Background()
 
-	configDir, err := config.GetConfigDir()
+	dataDir, err := config.DataDir()
 	if err != nil {
-		log.Fatalf("failed to get config dir: %v", err)
+		return nil, fmt.Errorf("[DB::NewClient] failed to get data dir: %w", err)
 	}
 
-	dbPath := filepath.Join(configDir, "db.sqlite")
+	dbPath := filepath.Join(dataDir, "db.sqlite")
 
-	dsn := dbPath + "?_journal_mode=WAL&_synchronous=NORMAL&_timeout=10000&_busy_timeout=10000"
+	// everything that only holds for one connection, database/sql replaces them
+	dsn := dbPath + "?_pragma=journal_mode(WAL)&_pragma=synchronous(NORMAL)&_pragma=busy_timeout(10000)" +
+		"&_pragma=cache_size(1000)&_pragma=foreign_keys(1)"
 
-	dbConn, err := sql.Open("sqlite", dsn)
-	if err != nil {
-		log.Fatalf("failed to open db: %v", err)
-	}
+	conn := &connector{dsn: dsn}
+	dbConn := sql.OpenDB(conn)
 
 	dbConn.SetMaxOpenConns(1)
 	dbConn.SetMaxIdleConns(1)
 	dbConn.SetConnMaxLifetime(time.Hour)
 
-	pragmas := []string{
-		"PRAGMA journal_mode=WAL;",
-		"PRAGMA synchronous=NORMAL;",
-		"PRAGMA cache_size=1000;",
-		"PRAGMA foreign_keys=ON;",
-		"PRAGMA busy_timeout=10000;",
+	// only takes effect on a new database, older ones switch with Vacuum
+	if _, err := dbConn.ExecContext(ctx, "PRAGMA auto_vacuum=INCREMENTAL;"); err != nil {
+		_ = dbConn.Close()
+		return nil, fmt.Errorf("[DB::NewClient] failed to set auto_vacuum: %w", err)
+	}
+
+	if err := migrate(ctx, dbConn, dbPath); err != nil {
+		_ = dbConn.Close()
+		return nil, fmt.Errorf("[DB::NewClient] failed to migrate %s: %w", dbPath, err)
 	}
 
-	for _, pragma := range pragmas {
-		if _, err := dbConn.ExecContext(ctx, pragma); err != nil {
-			_ = dbConn.Close()
-			log.Fatalf("failed to set pragma %s: %v", pragma, err)
-		}
+	client := &Client{
+		DB:        dbConn,
+		Queries:   New(dbConn),
+		path:      dbPath,
+		connector: conn,
 	}
 
-	// create tables
-	if _, err := dbConn.ExecContext(ctx, ddl); err != nil {
+	if err := client.ensureSealed(ctx); err != nil {
 		_ = dbConn.Close()
-		log.Fatalf("failed to create tables: %v", err)
+		return nil, err
 	}
 
-	return &Client{
-		DB:      dbConn,
-		Queries: New(dbConn),
-	}, nil
+	return client, nil
 }
 
 func (c *Client) Close() error {
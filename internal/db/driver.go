@@ -0,0 +1,152 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	_ "modernc.org/sqlite"
+	"sync/atomic"
+)
+
+// the parts of the modernc connection and statement database/sql uses
+type sqliteConn interface {
+	driver.Conn
+	driver.ConnBeginTx
+	driver.ConnPrepareContext
+	driver.ExecerContext
+	driver.QueryerContext
+	driver.Pinger
+	driver.SessionResetter
+	driver.Validator
+}
+
+type sqliteStmt interface {
+	driver.Stmt
+	driver.StmtExecContext
+	driver.StmtQueryContext
+}
+
+// sqliteDriver is the sqlite driver with every value read opened, so sqlc and the raw queries get
+// plaintext from an encrypted database without knowing about it. writes are sealed by the
+// triggers Reseal creates.
+var sqliteDriver openingDriver
+
+func init() {
+	// sql.Open doesn't connect, it only hands back the registered driver
+	pool, err := sql.Open("sqlite", "")
+	if err != nil {
+		panic(fmt.Sprintf("[DB::init] failed to get the sqlite driver: %v", err))
+	}
+	sqliteDriver = openingDriver{pool.Driver()}
+	_ = pool.Close()
+}
+
+// connector opens the connections of a client. the pragmas in the dsn are set on every one of
+// them, one set with PRAGMA would be gone once database/sql replaces the connection.
+type connector struct {
+	dsn string
+	// turned on for an encrypted database, see sealedPragmas
+	sealed atomic.Bool
+}
+
+// sealedPragmas keep the plaintext the seal triggers overwrite off the disk. overwritten values
+// are zeroed instead of only marked free, and pages stay in memory until the transaction commits,
+// so the WAL only ever gets them sealed.
+var sealedPragmas = []string{"secure_delete = ON", "cache_spill = OFF"}
+
+func (c *connector) Connect(context.Context) (driver.Conn, error) {
+	dsn := c.dsn
+	if c.sealed.Load() {
+		dsn += "&_pragma=secure_delete(1)&_pragma=cache_spill(0)"
+	}
+	return sqliteDriver.Open(dsn)
+}
+
+func (c *connector) Driver() driver.Driver {
+	return sqliteDriver
+}
+
+type openingDriver struct {
+	driver.Driver
+}
+
+func (d openingDriver) Open(name string) (driver.Conn, error) {
+	c, err := d.Driver.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	conn, ok := c.(sqliteConn)
+	if !ok {
+		_ = c.Close()
+		return nil, fmt.Errorf("[DB::Open] the sqlite driver returned a %T", c)
+	}
+	return openingConn{conn}, nil
+}
+
+type openingConn struct {
+	sqliteConn
+}
+
+func (c openingConn) Prepare(query string) (driver.Stmt, error) {
+	return c.PrepareContext(context.Background(), query)
+}
+
+func (c openingConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	s, err := c.sqliteConn.PrepareContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	stmt, ok := s.(sqliteStmt)
+	if !ok {
+		_ = s.Close()
+		return nil, fmt.Errorf("[DB::PrepareContext] the sqlite driver returned a %T", s)
+	}
+	return openingStmt{stmt}, nil
+}
+
+func (c openingConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	rows, err := c.sqliteConn.QueryContext(ctx, query, args)
+	if err != nil {
+		return nil, err
+	}
+	return openingRows{rows}, nil
+}
+
+type openingStmt struct {
+	sqliteStmt
+}
+
+func (s openingStmt) Query(args []driver.Value) (driver.Rows, error) {
+	rows, err := s.sqliteStmt.Query(args)
+	if err != nil {
+		return nil, err
+	}
+	return openingRows{rows}, nil
+}
+
+func (s openingStmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	rows, err := s.sqliteStmt.QueryContext(ctx, args)
+	if err != nil {
+		return nil, err
+	}
+	return openingRows{rows}, nil
+}
+
+type openingRows struct {
+	driver.Rows
+}
+
+func (r openingRows) Next(dest []driver.Value) error {
+	if err := r.Rows.Next(dest); err != nil {
+		return err
+	}
+	for i, value := range dest {
+		opened, err := open(value, "")
+		if err != nil {
+			return err
+		}
+		dest[i] = opened
+	}
+	return nil
+}
@@ -0,0 +1,235 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// sealedColumns is everything an encrypted database keeps sealed: the mail itself, what the
+// thread list shows of it, attachments and what is queued for the server. ids, flags, dates and
+// folder names stay readable, the queries filter and sort by them.
+var sealedColumns = []struct {
+	// name of the seal triggers, seal_<name>_insert and seal_<name>_update
+	name    string
+	table   string
+	columns []string
+	// the key of the row the values are sealed for. not the rowid where it isn't the primary key,
+	// VACUUM renumbers those
+	row string
+	// only rows matching it are sealed, empty for all
+	when string
+}{
+	{"emails", "emails", []string{"subject", "from_address", "from_name", "to_addresses", "cc_addresses", "bcc_addresses", "body_text", "body_html"}, "new.id", ""},
+	{"threads", "threads", []string{"subject", "snippet"}, "new.id", ""},
+	{"folder_threads", "folder_threads", []string{"latest_sender", "latest_sender_name"}, "new.folder_id || ':' || new.thread_id", ""},
+	{"attachments", "attachments", []string{"filename", "content"}, "new.id", ""},
+	{"email_sources", "email_sources", []string{"raw"}, "new.email_id", ""},
+	{"email_headers", "email_headers", []string{"raw"}, "new.email_id", ""},
+	{"pending_actions", "pending_actions", []string{"summary", "payload"}, "new.id", ""},
+	// the recipients of a send, which keeps them when it turns into an append. the target of the
+	// other kinds is a flag or a folder, and DeleteSupersededFlagActions compares it
+	{"pending_sends", "pending_actions", []string{"target"}, "new.id", "new.kind IN ('send', 'sending', 'append')"},
+}
+
+// place is the sql for where a value of column is kept, what it is sealed for. row is the key of
+// the row as in sealedColumns, with or without new.
+func place(table, column, row string) string {
+	return fmt.Sprintf("'%s.%s.' || %s", table, column, row)
+}
+
+// the fts index holds the plaintext of everything it indexes, an encrypted database goes without
+// it and search opens the values instead
+var ftsTriggers = []string{"emails_fts_insert", "emails_fts_update", "emails_fts_delete", "attachments_fts_insert", "attachments_fts_delete"}
+
+// Encrypted is whether the database seals mail, it needs its keys before anything can be read
+func (c *Client) Encrypted() bool {
+	return c.encrypted
+}
+
+// Reseal stores a new data key and seals everything with it, both to encrypt a database for the
+// first time and to rotate the key of an encrypted one. wrapped is how the key is kept in the
+// database, the caller wraps it for its backend. the keys it replaces are deleted once nothing is
+// sealed with them anymore, and the file is rebuilt so no page with plaintext or an old key stays
+// behind. the migration backups next to it are plaintext, they are removed and returned.
+func (c *Client) Reseal(ctx context.Context, backend string, wrapped, key []byte) ([]string, error) {
+	if err := c.sealConnections(ctx); err != nil {
+		return nil, fmt.Errorf("[DB::Reseal] failed to set the pragmas: %w", err)
+	}
+
+	tx, err := c.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("[DB::Reseal] failed to begin: %w", err)
+	}
+	defer tx.Rollback()
+
+	// the key row and everything sealed with it commit together, a failed run leaves the
+	// database as it was
+	row, err := c.WithTx(tx).CreateEncryptionKey(ctx, CreateEncryptionKeyParams{
+		Backend:   backend,
+		Wrapped:   wrapped,
+		CreatedAt: time.Now(),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("[DB::Reseal] failed to store the key: %w", err)
+	}
+	if err = c.WithTx(tx).SetCurrentEncryptionKey(ctx, row.ID); err != nil {
+		return nil, fmt.Errorf("[DB::Reseal] failed to set the current key: %w", err)
+	}
+
+	restore, err := addKey(row.ID, key)
+	if err != nil {
+		return nil, err
+	}
+	committed := false
+	defer func() {
+		if !committed {
+			restore()
+		}
+	}()
+
+	if err = createSealTriggers(ctx, tx); err != nil {
+		return nil, err
+	}
+
+	for _, sealed := range sealedColumns {
+		row := strings.ReplaceAll(sealed.row, "new.", "")
+		assignments := make([]string, len(sealed.columns))
+		for i, column := range sealed.columns {
+			// open first, a value sealed with the old key is left alone by clmail_seal. opening
+			// also checks every value is where it was sealed for.
+			at := place(sealed.table, column, row)
+			assignments[i] = fmt.Sprintf("%s = clmail_seal(clmail_open(%s, %s), %s)", column, column, at, at)
+		}
+		query := fmt.Sprintf("UPDATE %s SET %s", sealed.table, strings.Join(assignments, ", "))
+		if sealed.when != "" {
+			query += " WHERE " + strings.ReplaceAll(sealed.when, "new.", "")
+		}
+
+		if _, err = tx.ExecContext(ctx, query); err != nil {
+			return nil, fmt.Errorf("[DB::Reseal] failed to seal %s: %w", sealed.name, err)
+		}
+	}
+
+	if err = c.WithTx(tx).DeleteOtherEncryptionKeys(ctx); err != nil {
+		return nil, fmt.Errorf("[DB::Reseal] failed to delete the old keys: %w", err)
+	}
+	if err = tx.Commit(); err != nil {
+		return nil, fmt.Errorf("[DB::Reseal] failed to commit: %w", err)
+	}
+
+	committed = true
+	c.encrypted = true
+	forgetOtherKeys()
+
+	// the old pages are zeroed, but the WAL and the free pages of the file still have copies
+	if _, err = c.DB.ExecContext(ctx, "VACUUM"); err != nil {
+		return nil, fmt.Errorf("[DB::Reseal] failed to vacuum: %w", err)
+	}
+	if _, err = c.DB.ExecContext(ctx, "PRAGMA wal_checkpoint(TRUNCATE)"); err != nil {
+		return nil, fmt.Errorf("[DB::Reseal] failed to checkpoint: %w", err)
+	}
+
+	backups, err := filepath.Glob(c.path + ".v*.bak")
+	if err != nil {
+		return nil, fmt.Errorf("[DB::Reseal] failed to look for backups: %w", err)
+	}
+	for _, backup := range backups {
+		if err = os.Remove(backup); err != nil {
+			return nil, fmt.Errorf("[DB::Reseal] failed to remove backup %s: %w", backup, err)
+		}
+		log.Printf("[DB::Reseal] Removed unencrypted backup %s", backup)
+	}
+
+	return backups, nil
+}
+
+// sealConnections sets the sealedPragmas on the open connection and every one opened after it
+func (c *Client) sealConnections(ctx context.Context) error {
+	c.connector.sealed.Store(true)
+	for _, pragma := range sealedPragmas {
+		if _, err := c.DB.ExecContext(ctx, "PRAGMA "+pragma); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// createSealTriggers seals every value as it is written, and drops the fts index. they aren't in
+// a migration, sqlc reads those and can't know clmail_seal. a migration that rebuilds a table
+// loses its triggers, NewClient puts them back on every start. the triggers run after the write,
+// sealedPragmas keep the plaintext it wrote from reaching the disk.
+func createSealTriggers(ctx context.Context, tx *sql.Tx) error {
+	for _, sealed := range sealedColumns {
+		assignments := make([]string, len(sealed.columns))
+		for i, column := range sealed.columns {
+			assignments[i] = fmt.Sprintf("%s = clmail_seal(%s, %s)", column, column, place(sealed.table, column, sealed.row))
+		}
+		when := ""
+		if sealed.when != "" {
+			when = "WHEN " + sealed.when
+		}
+		// recursive_triggers is off, the update inside doesn't set them off again
+		body := fmt.Sprintf("BEGIN UPDATE %s SET %s WHERE rowid = new.rowid; END", sealed.table, strings.Join(assignments, ", "))
+
+		// replaced every time, an older version may have created them differently
+		triggers := []string{
+			fmt.Sprintf("DROP TRIGGER IF EXISTS seal_%s_insert", sealed.name),
+			fmt.Sprintf("DROP TRIGGER IF EXISTS seal_%s_update", sealed.name),
+			fmt.Sprintf("CREATE TRIGGER seal_%s_insert AFTER INSERT ON %s %s %s", sealed.name, sealed.table, when, body),
+			fmt.Sprintf("CREATE TRIGGER seal_%s_update AFTER UPDATE OF %s ON %s %s %s",
+				sealed.name, strings.Join(sealed.columns, ", "), sealed.table, when, body),
+		}
+		for _, trigger := range triggers {
+			if _, err := tx.ExecContext(ctx, trigger); err != nil {
+				return fmt.Errorf("[DB::createSealTriggers] failed to create the triggers of %s: %w", sealed.name, err)
+			}
+		}
+	}
+
+	for _, trigger := range ftsTriggers {
+		if _, err := tx.ExecContext(ctx, "DROP TRIGGER IF EXISTS "+trigger); err != nil {
+			return fmt.Errorf("[DB::createSealTriggers] failed to drop %s: %w", trigger, err)
+		}
+	}
+	// a delete only adds tombstones to the index, the rebuild writes it anew from the empty table
+	for _, statement := range []string{"DELETE FROM emails_fts", "INSERT INTO emails_fts (emails_fts) VALUES ('rebuild')"} {
+		if _, err := tx.ExecContext(ctx, statement); err != nil {
+			return fmt.Errorf("[DB::createSealTriggers] failed to empty the fts index: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// ensureSealed puts back what an encrypted database needs after a migration may have changed it
+func (c *Client) ensureSealed(ctx context.Context) error {
+	keys, err := c.ListEncryptionKeys(ctx)
+	if err != nil {
+		return fmt.Errorf("[DB::ensureSealed] failed to list keys: %w", err)
+	}
+	c.encrypted = len(keys) > 0
+	if !c.encrypted {
+		return nil
+	}
+
+	if err = c.sealConnections(ctx); err != nil {
+		return fmt.Errorf("[DB::ensureSealed] failed to set the pragmas: %w", err)
+	}
+
+	tx, err := c.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("[DB::ensureSealed] failed to begin: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err = createSealTriggers(ctx, tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
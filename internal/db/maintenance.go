@@ -0,0 +1,66 @@
+package db
+
+import (
+	"context"
+	"fmt"
+)
+
+// DatabaseStorage is how much of the database file is in use
+type DatabaseStorage struct {
+	FileBytes int64
+	FreeBytes int64
+	// IncrementalVacuum is false for databases created before we turned it on, until a Vacuum
+	IncrementalVacuum bool
+}
+
+func (c *Client) DatabaseStorage(ctx context.Context) (DatabaseStorage, error) {
+	var pageSize, pageCount, freePages, autoVacuum int64
+
+	for pragma, value := range map[string]*int64{
+		"page_size":      &pageSize,
+		"page_count":     &pageCount,
+		"freelist_count": &freePages,
+		"auto_vacuum":    &autoVacuum,
+	} {
+		if err := c.DB.QueryRowContext(ctx, "PRAGMA "+pragma).Scan(value); err != nil {
+			return DatabaseStorage{}, fmt.Errorf("[DB::DatabaseStorage] failed to read %s: %w", pragma, err)
+		}
+	}
+
+	return DatabaseStorage{
+		FileBytes: pageSize * pageCount,
+		FreeBytes: pageSize * freePages,
+		// 2 is INCREMENTAL
+		IncrementalVacuum: autoVacuum == 2,
+	}, nil
+}
+
+// IncrementalVacuum hands the pages freed by deletes back to the file system. it does nothing on
+// databases that don't have incremental auto vacuum yet, sqlite reuses their free pages instead.
+func (c *Client) IncrementalVacuum(ctx context.Context) error {
+	// it frees one page per step, exec would only run the first
+	rows, err := c.DB.QueryContext(ctx, "PRAGMA incremental_vacuum")
+	if err != nil {
+		return fmt.Errorf("[DB::IncrementalVacuum] failed to vacuum: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+	}
+	if err = rows.Err(); err != nil {
+		return fmt.Errorf("[DB::IncrementalVacuum] failed to vacuum: %w", err)
+	}
+	return nil
+}
+
+// Vacuum rebuilds the whole database file, which also turns on incremental auto vacuum for one
+// created before it was the default. every other query waits until it is done.
+func (c *Client) Vacuum(ctx context.Context) error {
+	if _, err := c.DB.ExecContext(ctx, "PRAGMA auto_vacuum = INCREMENTAL"); err != nil {
+		return fmt.Errorf("[DB::Vacuum] failed to set auto_vacuum: %w", err)
+	}
+	if _, err := c.DB.ExecContext(ctx, "VACUUM"); err != nil {
+		return fmt.Errorf("[DB::Vacuum] failed to vacuum: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,236 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// migrations/NNNN_name.sql, applied in order of their number. a released migration is never
+// edited, changes to the schema always go into a new file. sqlc reads the same directory, so the
+// generated code always matches what the last migration leaves behind.
+//
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+type migration struct {
+	version int
+	name    string
+	sql     string
+}
+
+func loadMigrations() ([]migration, error) {
+	entries, err := fs.ReadDir(migrationFiles, "migrations")
+	if err != nil {
+		return nil, fmt.Errorf("[DB::loadMigrations] failed to read migrations: %w", err)
+	}
+
+	migrations := make([]migration, 0, len(entries))
+	for _, entry := range entries {
+		number, name, ok := strings.Cut(strings.TrimSuffix(entry.Name(), ".sql"), "_")
+		version, err := strconv.Atoi(number)
+		if !ok || err != nil {
+			return nil, fmt.Errorf("[DB::loadMigrations] migration %s isn't named NNNN_name.sql", entry.Name())
+		}
+
+		content, err := fs.ReadFile(migrationFiles, path.Join("migrations", entry.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("[DB::loadMigrations] failed to read %s: %w", entry.Name(), err)
+		}
+
+		migrations = append(migrations, migration{version: version, name: name, sql: string(content)})
+	}
+
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].version < migrations[j].version })
+	for i, m := range migrations {
+		// a gap or a duplicate would make the stored version mean different things to different builds
+		if m.version != i+1 {
+			return nil, fmt.Errorf("[DB::loadMigrations] expected migration %d, found %d_%s", i+1, m.version, m.name)
+		}
+	}
+
+	return migrations, nil
+}
+
+// migrate brings the database up to the newest migration. every migration runs in its own
+// transaction together with its schema_version row, so a failed one leaves the database at the
+// version before it. existing databases get a copy next to them before anything is changed.
+func migrate(ctx context.Context, pool *sql.DB, dbPath string) error {
+	migrations, err := loadMigrations()
+	if err != nil {
+		return err
+	}
+
+	// the pragma below only holds for one connection, so everything runs on the same one
+	dbConn, err := pool.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("[DB::migrate] failed to get a connection: %w", err)
+	}
+	defer dbConn.Close()
+
+	_, err = dbConn.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_version
+(
+    version    INTEGER PRIMARY KEY,
+    applied_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+)`)
+	if err != nil {
+		return fmt.Errorf("[DB::migrate] failed to create schema_version: %w", err)
+	}
+
+	var current int
+	if err = dbConn.QueryRowContext(ctx, "SELECT COALESCE(MAX(version), 0) FROM schema_version").Scan(&current); err != nil {
+		return fmt.Errorf("[DB::migrate] failed to read schema version: %w", err)
+	}
+
+	latest := len(migrations)
+	if current > latest {
+		return fmt.Errorf("[DB::migrate] database is at schema version %d but this build only knows %d, update clmail", current, latest)
+	}
+	if current == latest {
+		return nil
+	}
+
+	// a database from before migrations has tables but no version yet
+	legacy := false
+	if current == 0 {
+		var tables int
+		err = dbConn.QueryRowContext(ctx, "SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'accounts'").Scan(&tables)
+		if err != nil {
+			return fmt.Errorf("[DB::migrate] failed to look for existing tables: %w", err)
+		}
+		legacy = tables > 0
+	}
+
+	// rebuilding a table means dropping it, with foreign keys on that would cascade into every
+	// table referencing it. apply checks them before each commit instead.
+	if _, err = dbConn.ExecContext(ctx, "PRAGMA foreign_keys = OFF"); err != nil {
+		return fmt.Errorf("[DB::migrate] failed to turn off foreign keys: %w", err)
+	}
+	defer func() {
+		if _, err := dbConn.ExecContext(context.Background(), "PRAGMA foreign_keys = ON"); err != nil {
+			log.Printf("[DB::migrate] Failed to turn foreign keys back on: %v", err)
+		}
+	}()
+
+	if current > 0 || legacy {
+		if err = backup(ctx, dbConn, dbPath, current); err != nil {
+			return err
+		}
+	}
+
+	if legacy {
+		if err = addMissingColumns(ctx, dbConn); err != nil {
+			return err
+		}
+	}
+
+	for _, m := range migrations[current:] {
+		if err = apply(ctx, dbConn, m); err != nil {
+			return err
+		}
+		log.Printf("[DB::migrate] Applied migration %d_%s", m.version, m.name)
+	}
+
+	return nil
+}
+
+func apply(ctx context.Context, dbConn *sql.Conn, m migration) error {
+	tx, err := dbConn.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("[DB::apply] failed to begin migration %d: %w", m.version, err)
+	}
+	defer tx.Rollback()
+
+	if _, err = tx.ExecContext(ctx, m.sql); err != nil {
+		return fmt.Errorf("[DB::apply] migration %d_%s failed: %w", m.version, m.name, err)
+	}
+	if _, err = tx.ExecContext(ctx, "INSERT INTO schema_version (version) VALUES (?)", m.version); err != nil {
+		return fmt.Errorf("[DB::apply] failed to record migration %d: %w", m.version, err)
+	}
+
+	var violations int
+	if err = tx.QueryRowContext(ctx, "SELECT COUNT(*) FROM pragma_foreign_key_check").Scan(&violations); err != nil {
+		return fmt.Errorf("[DB::apply] failed to check foreign keys after migration %d: %w", m.version, err)
+	}
+	if violations > 0 {
+		return fmt.Errorf("[DB::apply] migration %d_%s left %d rows with a broken foreign key", m.version, m.name, violations)
+	}
+
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("[DB::apply] failed to commit migration %d: %w", m.version, err)
+	}
+	return nil
+}
+
+// backup writes a consistent copy of the database to db.sqlite.v<version>.bak. VACUUM INTO reads
+// through sqlite, so unlike copying the file it also sees what is still in the WAL.
+func backup(ctx context.Context, dbConn *sql.Conn, dbPath string, version int) error {
+	backupPath := fmt.Sprintf("%s.v%d.bak", dbPath, version)
+
+	// VACUUM INTO won't overwrite, an old copy from an earlier attempt at the same version goes
+	if err := os.Remove(backupPath); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("[DB::backup] failed to remove old backup: %w", err)
+	}
+
+	if _, err := dbConn.ExecContext(ctx, "VACUUM INTO ?", backupPath); err != nil {
+		return fmt.Errorf("[DB::backup] failed to back up database: %w", err)
+	}
+
+	log.Printf("[DB::backup] Backed up schema version %d to %s", version, backupPath)
+	return nil
+}
+
+// columns that were added to databases before migrations existed, when every start checked for
+// them. databases from back then may have any of them, they are brought to the state of
+// migration 1 before it runs over them. new columns go into a migration, not here.
+var addedColumns = []struct {
+	table      string
+	column     string
+	definition string
+	// backfill runs once, right after the column was added
+	backfill string
+}{
+	{"accounts", "imap_security", "TEXT NOT NULL DEFAULT 'tls'", "UPDATE accounts SET imap_security = 'starttls' WHERE imap_use_ssl = FALSE"},
+	{"accounts", "imap_ca_file", "TEXT", ""},
+	{"accounts", "imap_tls_fingerprint", "TEXT", ""},
+	{"accounts", "secret_store", "TEXT NOT NULL DEFAULT 'keyring'", ""},
+	{"accounts", "password_command", "TEXT", ""},
+	{"folders", "last_synced_at", "TIMESTAMP", ""},
+	{"accounts", "body_size_limit_kb", "INTEGER NOT NULL DEFAULT 1024", ""},
+	{"emails", "size_bytes", "INTEGER NOT NULL DEFAULT 0", ""},
+	// only INBOX had push before it was configurable
+	{"folders", "push", "BOOLEAN NOT NULL DEFAULT FALSE", "UPDATE folders SET push = TRUE WHERE name = 'INBOX'"},
+}
+
+func addMissingColumns(ctx context.Context, dbConn *sql.Conn) error {
+	for _, added := range addedColumns {
+		var count int
+		err := dbConn.QueryRowContext(ctx, "SELECT COUNT(*) FROM pragma_table_info(?) WHERE name = ?", added.table, added.column).Scan(&count)
+		if err != nil {
+			return fmt.Errorf("[DB::addMissingColumns] failed to read table info of %s: %w", added.table, err)
+		}
+		if count > 0 {
+			continue
+		}
+
+		if _, err := dbConn.ExecContext(ctx, fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", added.table, added.column, added.definition)); err != nil {
+			return fmt.Errorf("[DB::addMissingColumns] failed to add %s.%s: %w", added.table, added.column, err)
+		}
+
+		if added.backfill != "" {
+			if _, err := dbConn.ExecContext(ctx, added.backfill); err != nil {
+				return fmt.Errorf("[DB::addMissingColumns] failed to backfill %s.%s: %w", added.table, added.column, err)
+			}
+		}
+	}
+
+	return nil
+}
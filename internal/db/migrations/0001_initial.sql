@@ -0,0 +1,206 @@
+-- the schema from before migrations existed. it keeps IF NOT EXISTS so databases created back then
+-- can be adopted by running it over them, later migrations must not rely on that.
+
+CREATE TABLE IF NOT EXISTS accounts
+(
+    id                       INTEGER PRIMARY KEY,
+    name                     TEXT      NOT NULL,
+    display_name             TEXT      NOT NULL,
+    email                    TEXT      NOT NULL,
+
+    imap_server              TEXT      NOT NULL,
+    imap_port                INTEGER   NOT NULL DEFAULT 993,
+    imap_username            TEXT      NOT NULL,
+    imap_use_ssl             BOOLEAN   NOT NULL DEFAULT TRUE,
+    imap_auth_method         TEXT      NOT NULL DEFAULT 'plain',
+    -- tls, starttls or none (localhost only)
+    imap_security            TEXT      NOT NULL DEFAULT 'tls',
+    imap_ca_file             TEXT,
+    imap_tls_fingerprint     TEXT,
+
+    smtp_server              TEXT      NOT NULL,
+    smtp_port                INTEGER   NOT NULL DEFAULT 587,
+    smtp_username            TEXT      NOT NULL,
+    smtp_use_tls             BOOLEAN   NOT NULL DEFAULT TRUE,
+    smtp_auth_method         TEXT      NOT NULL DEFAULT 'plain',
+
+    -- where the password lives: keyring, command or file
+    secret_store             TEXT      NOT NULL DEFAULT 'keyring',
+    password_command         TEXT,
+
+    refresh_interval_minutes INTEGER   NOT NULL DEFAULT 15,
+    -- messages above this only get their text parts fetched, 0 fetches everything whole
+    body_size_limit_kb       INTEGER   NOT NULL DEFAULT 1024,
+    signature                TEXT,
+    is_default               BOOLEAN   NOT NULL DEFAULT FALSE,
+    created_at               TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at               TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS folders
+(
+    id             INTEGER PRIMARY KEY,
+    account_id     INTEGER NOT NULL,
+    name           TEXT    NOT NULL,
+    -- when the sync scheduler last finished this folder, null until it did once
+    last_synced_at TIMESTAMP,
+    -- push folders are watched with NOTIFY or IDLE instead of being polled
+    push           BOOLEAN NOT NULL DEFAULT FALSE,
+
+    FOREIGN KEY (account_id) REFERENCES accounts (id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS threads
+(
+    id                  INTEGER PRIMARY KEY,
+    account_id          INTEGER   NOT NULL,
+    subject             TEXT      NOT NULL,
+    snippet             TEXT,
+    is_read             BOOLEAN   NOT NULL DEFAULT FALSE,
+    is_starred          BOOLEAN   NOT NULL DEFAULT FALSE,
+    has_attachments     BOOLEAN   NOT NULL DEFAULT FALSE,
+    message_count       INTEGER   NOT NULL DEFAULT 1,
+    latest_message_date TIMESTAMP NOT NULL,
+
+    FOREIGN KEY (account_id) REFERENCES accounts (id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS emails
+(
+    id            INTEGER PRIMARY KEY,
+    uid           INTEGER   NOT NULL,
+    thread_id     INTEGER   NOT NULL,
+    account_id    INTEGER   NOT NULL,
+    folder_id     INTEGER   NOT NULL,
+    message_id    TEXT      NOT NULL,
+    from_address  TEXT      NOT NULL,
+    from_name     TEXT,
+    to_addresses  TEXT      NOT NULL,
+    cc_addresses  TEXT,
+    bcc_addresses TEXT,
+    reference_id  TEXT,
+    subject       TEXT      NOT NULL,
+    body_text     TEXT,
+    body_html     TEXT,
+    received_date TIMESTAMP NOT NULL,
+    is_read       BOOLEAN   NOT NULL DEFAULT FALSE,
+    is_starred    BOOLEAN   NOT NULL DEFAULT FALSE,
+    is_draft      BOOLEAN   NOT NULL DEFAULT FALSE,
+    -- RFC822.SIZE as the server reported it, 0 for mail stored before we kept it
+    size_bytes    INTEGER   NOT NULL DEFAULT 0,
+
+    FOREIGN KEY (thread_id) REFERENCES threads (id) ON DELETE CASCADE,
+    FOREIGN KEY (account_id) REFERENCES accounts (id) ON DELETE CASCADE,
+    FOREIGN KEY (folder_id) REFERENCES folders (id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS attachments
+(
+    id         INTEGER PRIMARY KEY,
+    email_id   INTEGER NOT NULL,
+    filename   TEXT    NOT NULL,
+    mime_type  TEXT    NOT NULL,
+    size_bytes INTEGER NOT NULL,
+    content    BLOB,
+    local_path TEXT,
+
+    FOREIGN KEY (email_id) REFERENCES emails (id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS saved_searches
+(
+    id         INTEGER PRIMARY KEY,
+    account_id INTEGER   NOT NULL,
+    name       TEXT      NOT NULL,
+    query      TEXT      NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+
+    FOREIGN KEY (account_id) REFERENCES accounts (id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_threads_account_id ON threads (account_id);
+CREATE INDEX IF NOT EXISTS idx_emails_thread_id ON emails (thread_id);
+CREATE INDEX IF NOT EXISTS idx_emails_account_id ON emails (account_id);
+CREATE INDEX IF NOT EXISTS idx_emails_folder_id ON emails (folder_id);
+CREATE INDEX IF NOT EXISTS idx_attachments_email_id ON attachments (email_id);
+CREATE INDEX IF NOT EXISTS idx_saved_searches_account_id ON saved_searches (account_id);
+
+-- full-text index over everything the search view can query. it is kept in sync with emails and
+-- attachments by the triggers below, rowid is always the emails.id it belongs to.
+CREATE VIRTUAL TABLE IF NOT EXISTS emails_fts USING fts5
+(
+    subject,
+    body_text,
+    body_html,
+    from_address,
+    from_name,
+    to_addresses,
+    cc_addresses,
+    attachment_names,
+    tokenize = 'unicode61 remove_diacritics 2'
+);
+
+CREATE TRIGGER IF NOT EXISTS emails_fts_insert
+    AFTER INSERT
+    ON emails
+BEGIN
+    INSERT INTO emails_fts (rowid, subject, body_text, body_html,
+                            from_address, from_name, to_addresses, cc_addresses, attachment_names)
+    VALUES (new.id, new.subject, new.body_text, new.body_html,
+            new.from_address, new.from_name, new.to_addresses, new.cc_addresses, '');
+END;
+
+CREATE TRIGGER IF NOT EXISTS emails_fts_update
+    AFTER UPDATE OF subject, body_text, body_html, from_address, from_name, to_addresses, cc_addresses
+    ON emails
+BEGIN
+    UPDATE emails_fts
+    SET subject      = new.subject,
+        body_text    = new.body_text,
+        body_html    = new.body_html,
+        from_address = new.from_address,
+        from_name    = new.from_name,
+        to_addresses = new.to_addresses,
+        cc_addresses = new.cc_addresses
+    WHERE rowid = new.id;
+END;
+
+CREATE TRIGGER IF NOT EXISTS emails_fts_delete
+    AFTER DELETE
+    ON emails
+BEGIN
+    DELETE FROM emails_fts WHERE rowid = old.id;
+END;
+
+CREATE TRIGGER IF NOT EXISTS attachments_fts_insert
+    AFTER INSERT
+    ON attachments
+BEGIN
+    UPDATE emails_fts
+    SET attachment_names = (SELECT group_concat(filename, ' ') FROM attachments WHERE email_id = new.email_id)
+    WHERE rowid = new.email_id;
+END;
+
+CREATE TRIGGER IF NOT EXISTS attachments_fts_delete
+    AFTER DELETE
+    ON attachments
+BEGIN
+    UPDATE emails_fts
+    SET attachment_names = COALESCE((SELECT group_concat(filename, ' ') FROM attachments WHERE email_id = old.email_id), '')
+    WHERE rowid = old.email_id;
+END;
+
+-- index mail that was stored before the fts table existed
+INSERT INTO emails_fts (rowid, subject, body_text, body_html,
+                        from_address, from_name, to_addresses, cc_addresses, attachment_names)
+SELECT e.id,
+       e.subject,
+       e.body_text,
+       e.body_html,
+       e.from_address,
+       e.from_name,
+       e.to_addresses,
+       e.cc_addresses,
+       COALESCE((SELECT group_concat(a.filename, ' ') FROM attachments a WHERE a.email_id = e.id), '')
+FROM emails e
+WHERE e.id NOT IN (SELECT rowid FROM emails_fts);
@@ -0,0 +1,167 @@
+-- a message can be in more than one folder (a copy in INBOX and Archive, gmail's All Mail), so
+-- emails holds every message once per account and email_folders where it is and under which uid.
+
+CREATE TABLE email_folders
+(
+    email_id   INTEGER NOT NULL,
+    folder_id  INTEGER NOT NULL,
+    uid        INTEGER NOT NULL,
+    -- the flags as the server reported them for this copy, emails has the ones we show
+    is_read    BOOLEAN NOT NULL DEFAULT FALSE,
+    is_starred BOOLEAN NOT NULL DEFAULT FALSE,
+    is_draft   BOOLEAN NOT NULL DEFAULT FALSE,
+
+    PRIMARY KEY (folder_id, uid),
+    FOREIGN KEY (email_id) REFERENCES emails (id) ON DELETE CASCADE,
+    FOREIGN KEY (folder_id) REFERENCES folders (id) ON DELETE CASCADE
+);
+
+CREATE INDEX idx_email_folders_email_id ON email_folders (email_id);
+
+-- the sync skipped a Message-ID it had seen, but two folders syncing at the same time could both
+-- store it. every copy after the first of an account is merged into the first one.
+CREATE TEMP TABLE duplicate_emails AS
+SELECT e.id, k.keep_id
+FROM emails e
+         JOIN (SELECT account_id, message_id, MIN(id) AS keep_id
+               FROM emails
+               WHERE message_id != ''
+               GROUP BY account_id, message_id
+               HAVING COUNT(*) > 1) k ON k.account_id = e.account_id AND k.message_id = e.message_id
+WHERE e.id != k.keep_id;
+
+-- what one copy has, the one that stays gets
+UPDATE emails
+SET is_read    = (SELECT MAX(c.is_read) FROM emails c WHERE c.account_id = emails.account_id AND c.message_id = emails.message_id),
+    is_starred = (SELECT MAX(c.is_starred) FROM emails c WHERE c.account_id = emails.account_id AND c.message_id = emails.message_id),
+    body_text  = COALESCE(body_text, (SELECT c.body_text
+                                      FROM emails c
+                                      WHERE c.account_id = emails.account_id
+                                        AND c.message_id = emails.message_id
+                                        AND c.body_text IS NOT NULL
+                                      LIMIT 1)),
+    body_html  = COALESCE(body_html, (SELECT c.body_html
+                                      FROM emails c
+                                      WHERE c.account_id = emails.account_id
+                                        AND c.message_id = emails.message_id
+                                        AND c.body_html IS NOT NULL
+                                      LIMIT 1))
+WHERE id IN (SELECT keep_id FROM duplicate_emails);
+
+-- the attachments of a copy are the same, they are only kept when the first one has none
+UPDATE attachments
+SET email_id = (SELECT keep_id FROM duplicate_emails WHERE id = attachments.email_id)
+WHERE email_id IN (SELECT MIN(id) FROM duplicate_emails GROUP BY keep_id)
+  AND NOT EXISTS (SELECT 1
+                  FROM attachments a
+                  WHERE a.email_id = (SELECT keep_id FROM duplicate_emails WHERE id = attachments.email_id));
+DELETE
+FROM attachments
+WHERE email_id IN (SELECT id FROM duplicate_emails);
+DELETE
+FROM emails_fts
+WHERE rowid IN (SELECT id FROM duplicate_emails);
+
+-- a copy's folder and uid become a membership of the one that stays
+INSERT OR IGNORE INTO email_folders (email_id, folder_id, uid, is_read, is_starred, is_draft)
+SELECT COALESCE(d.keep_id, e.id), e.folder_id, e.uid, e.is_read, e.is_starred, e.is_draft
+FROM emails e
+         LEFT JOIN duplicate_emails d ON d.id = e.id;
+
+-- sqlite can't drop columns that are part of a foreign key, so emails is rebuilt without them
+CREATE TABLE emails_new
+(
+    id            INTEGER PRIMARY KEY,
+    thread_id     INTEGER   NOT NULL,
+    account_id    INTEGER   NOT NULL,
+    message_id    TEXT      NOT NULL,
+    -- what copies of the same message are matched by: the Message-ID, or a hash of the headers
+    -- for mail without one
+    message_key   TEXT      NOT NULL,
+    from_address  TEXT      NOT NULL,
+    from_name     TEXT,
+    to_addresses  TEXT      NOT NULL,
+    cc_addresses  TEXT,
+    bcc_addresses TEXT,
+    reference_id  TEXT,
+    subject       TEXT      NOT NULL,
+    body_text     TEXT,
+    body_html     TEXT,
+    received_date TIMESTAMP NOT NULL,
+    is_read       BOOLEAN   NOT NULL DEFAULT FALSE,
+    is_starred    BOOLEAN   NOT NULL DEFAULT FALSE,
+    is_draft      BOOLEAN   NOT NULL DEFAULT FALSE,
+    -- RFC822.SIZE as the server reported it, 0 for mail stored before we kept it
+    size_bytes    INTEGER   NOT NULL DEFAULT 0,
+
+    UNIQUE (account_id, message_key),
+    FOREIGN KEY (thread_id) REFERENCES threads (id) ON DELETE CASCADE,
+    FOREIGN KEY (account_id) REFERENCES accounts (id) ON DELETE CASCADE
+);
+
+-- mail without a Message-ID has no headers left to hash, every one of those stays its own message
+INSERT INTO emails_new (id, thread_id, account_id, message_id, message_key,
+                        from_address, from_name, to_addresses, cc_addresses, bcc_addresses,
+                        reference_id, subject, body_text, body_html, received_date,
+                        is_read, is_starred, is_draft, size_bytes)
+SELECT id,
+       thread_id,
+       account_id,
+       message_id,
+       CASE WHEN message_id = '' THEN 'legacy:' || id ELSE message_id END,
+       from_address,
+       from_name,
+       to_addresses,
+       cc_addresses,
+       bcc_addresses,
+       reference_id,
+       subject,
+       body_text,
+       body_html,
+       received_date,
+       is_read,
+       is_starred,
+       is_draft,
+       size_bytes
+FROM emails
+WHERE id NOT IN (SELECT id FROM duplicate_emails);
+
+DROP TABLE duplicate_emails;
+DROP TABLE emails;
+ALTER TABLE emails_new RENAME TO emails;
+
+CREATE INDEX idx_emails_thread_id ON emails (thread_id);
+CREATE INDEX idx_emails_account_id ON emails (account_id);
+
+-- the fts triggers went with the old table
+CREATE TRIGGER emails_fts_insert
+    AFTER INSERT
+    ON emails
+BEGIN
+    INSERT INTO emails_fts (rowid, subject, body_text, body_html,
+                            from_address, from_name, to_addresses, cc_addresses, attachment_names)
+    VALUES (new.id, new.subject, new.body_text, new.body_html,
+            new.from_address, new.from_name, new.to_addresses, new.cc_addresses, '');
+END;
+
+CREATE TRIGGER emails_fts_update
+    AFTER UPDATE OF subject, body_text, body_html, from_address, from_name, to_addresses, cc_addresses
+    ON emails
+BEGIN
+    UPDATE emails_fts
+    SET subject      = new.subject,
+        body_text    = new.body_text,
+        body_html    = new.body_html,
+        from_address = new.from_address,
+        from_name    = new.from_name,
+        to_addresses = new.to_addresses,
+        cc_addresses = new.cc_addresses
+    WHERE rowid = new.id;
+END;
+
+CREATE TRIGGER emails_fts_delete
+    AFTER DELETE
+    ON emails
+BEGIN
+    DELETE FROM emails_fts WHERE rowid = old.id;
+END;
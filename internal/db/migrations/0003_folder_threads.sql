@@ -0,0 +1,136 @@
+-- what the thread list shows for every thread of a folder, kept up to date by the triggers below
+-- so opening a folder is a range scan instead of grouping all of its mail. the triggers recompute
+-- the one row a change touches, they never walk the whole folder.
+
+CREATE TABLE folder_threads
+(
+    folder_id          INTEGER   NOT NULL,
+    thread_id          INTEGER   NOT NULL,
+    message_count      INTEGER   NOT NULL,
+    unread_count       INTEGER   NOT NULL,
+    -- the newest mail of the thread in this folder and who sent it
+    latest_date        TIMESTAMP NOT NULL,
+    latest_sender      TEXT      NOT NULL,
+    latest_sender_name TEXT      NOT NULL,
+
+    PRIMARY KEY (folder_id, thread_id),
+    FOREIGN KEY (folder_id) REFERENCES folders (id) ON DELETE CASCADE,
+    FOREIGN KEY (thread_id) REFERENCES threads (id) ON DELETE CASCADE
+);
+
+-- the thread list pages through this with (latest_date, thread_id) as the cursor
+CREATE INDEX idx_folder_threads_page ON folder_threads (folder_id, latest_date DESC, thread_id DESC);
+
+-- the triggers find the mail of one thread in one folder through these
+DROP INDEX idx_email_folders_email_id;
+CREATE INDEX idx_email_folders_email_folder ON email_folders (email_id, folder_id);
+DROP INDEX idx_emails_thread_id;
+CREATE INDEX idx_emails_thread_received ON emails (thread_id, received_date);
+
+-- sqlite fills bare columns from the row that produced MAX(), so the sender is the newest mail's
+INSERT INTO folder_threads (folder_id, thread_id, message_count, unread_count,
+                            latest_date, latest_sender, latest_sender_name)
+SELECT ef.folder_id,
+       e.thread_id,
+       COUNT(*),
+       SUM(CASE WHEN e.is_read = FALSE THEN 1 ELSE 0 END),
+       MAX(e.received_date),
+       e.from_address,
+       COALESCE(e.from_name, '')
+FROM email_folders ef
+         JOIN emails e ON e.id = ef.email_id
+GROUP BY ef.folder_id, e.thread_id;
+
+CREATE TRIGGER folder_threads_membership_insert
+    AFTER INSERT
+    ON email_folders
+BEGIN
+    INSERT INTO folder_threads (folder_id, thread_id, message_count, unread_count,
+                                latest_date, latest_sender, latest_sender_name)
+    SELECT ef.folder_id,
+           e.thread_id,
+           COUNT(*),
+           SUM(CASE WHEN e.is_read = FALSE THEN 1 ELSE 0 END),
+           MAX(e.received_date),
+           e.from_address,
+           COALESCE(e.from_name, '')
+    -- CROSS JOIN makes sqlite start from the mail of the thread, left to itself it may walk the
+    -- whole folder instead
+    FROM emails e
+             CROSS JOIN email_folders ef ON ef.email_id = e.id
+             -- while a folder or thread is being deleted its mail goes one by one, the join
+             -- keeps us from adding a row for it again
+             JOIN folders f ON f.id = ef.folder_id
+             JOIN threads t ON t.id = e.thread_id
+    WHERE ef.folder_id = new.folder_id
+      AND e.thread_id = (SELECT thread_id FROM emails WHERE id = new.email_id)
+    GROUP BY ef.folder_id, e.thread_id
+    ON CONFLICT (folder_id, thread_id) DO UPDATE SET message_count      = excluded.message_count,
+                                                     unread_count       = excluded.unread_count,
+                                                     latest_date        = excluded.latest_date,
+                                                     latest_sender      = excluded.latest_sender,
+                                                     latest_sender_name = excluded.latest_sender_name;
+END;
+
+CREATE TRIGGER folder_threads_membership_delete
+    AFTER DELETE
+    ON email_folders
+BEGIN
+    DELETE
+    FROM folder_threads
+    WHERE folder_id = old.folder_id
+      AND thread_id = (SELECT thread_id FROM emails WHERE id = old.email_id);
+
+    INSERT INTO folder_threads (folder_id, thread_id, message_count, unread_count,
+                                latest_date, latest_sender, latest_sender_name)
+    SELECT ef.folder_id,
+           e.thread_id,
+           COUNT(*),
+           SUM(CASE WHEN e.is_read = FALSE THEN 1 ELSE 0 END),
+           MAX(e.received_date),
+           e.from_address,
+           COALESCE(e.from_name, '')
+    FROM emails e
+             CROSS JOIN email_folders ef ON ef.email_id = e.id
+             JOIN folders f ON f.id = ef.folder_id
+             JOIN threads t ON t.id = e.thread_id
+    WHERE ef.folder_id = old.folder_id
+      AND e.thread_id = (SELECT thread_id FROM emails WHERE id = old.email_id)
+    GROUP BY ef.folder_id, e.thread_id;
+END;
+
+-- the cascade from emails to email_folders runs after the email is gone, and the trigger above
+-- needs it to find the thread. removing the memberships first keeps it around for that.
+CREATE TRIGGER folder_threads_email_delete
+    BEFORE DELETE
+    ON emails
+BEGIN
+    DELETE FROM email_folders WHERE email_id = old.id;
+END;
+
+CREATE TRIGGER folder_threads_email_update
+    AFTER UPDATE OF thread_id, is_read, received_date, from_address, from_name
+    ON emails
+BEGIN
+    DELETE
+    FROM folder_threads
+    WHERE thread_id IN (old.thread_id, new.thread_id)
+      AND folder_id IN (SELECT folder_id FROM email_folders WHERE email_id = new.id);
+
+    INSERT INTO folder_threads (folder_id, thread_id, message_count, unread_count,
+                                latest_date, latest_sender, latest_sender_name)
+    SELECT ef.folder_id,
+           e.thread_id,
+           COUNT(*),
+           SUM(CASE WHEN e.is_read = FALSE THEN 1 ELSE 0 END),
+           MAX(e.received_date),
+           e.from_address,
+           COALESCE(e.from_name, '')
+    FROM emails e
+             CROSS JOIN email_folders ef ON ef.email_id = e.id
+             JOIN folders f ON f.id = ef.folder_id
+             JOIN threads t ON t.id = e.thread_id
+    WHERE ef.folder_id IN (SELECT folder_id FROM email_folders WHERE email_id = new.id)
+      AND e.thread_id IN (old.thread_id, new.thread_id)
+    GROUP BY ef.folder_id, e.thread_id;
+END;
@@ -0,0 +1,11 @@
+-- how long downloaded bodies are kept, the headers always stay. 0 keeps bodies forever.
+ALTER TABLE accounts ADD COLUMN body_retention_days INTEGER NOT NULL DEFAULT 0;
+-- bodies and attachments of the account beyond this are evicted oldest first, 0 has no limit
+ALTER TABLE accounts ADD COLUMN body_retention_mb INTEGER NOT NULL DEFAULT 0;
+
+-- set when retention dropped the body. the prefetcher leaves those alone, opening the thread
+-- fetches them again.
+ALTER TABLE emails ADD COLUMN body_evicted BOOLEAN NOT NULL DEFAULT FALSE;
+
+-- retention picks the oldest bodies of an account first
+CREATE INDEX idx_emails_account_received ON emails (account_id, received_date);
@@ -0,0 +1,18 @@
+-- the message as the server sent it, zlib compressed. only mail that was downloaded whole has
+-- one, exports rebuild the others from the parsed columns.
+CREATE TABLE email_sources
+(
+    email_id INTEGER PRIMARY KEY,
+    raw      BLOB NOT NULL,
+
+    FOREIGN KEY (email_id) REFERENCES emails (id) ON DELETE CASCADE
+);
+
+-- the source is the biggest copy of the body, it goes with it when retention evicts it
+CREATE TRIGGER email_sources_evict
+    AFTER UPDATE OF body_evicted
+    ON emails
+    WHEN new.body_evicted = TRUE
+BEGIN
+    DELETE FROM email_sources WHERE email_id = new.id;
+END;
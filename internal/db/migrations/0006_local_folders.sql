@@ -0,0 +1,3 @@
+-- local folders only exist in this database, imported archives go there. they are never synced
+-- and their mail can't be fetched again, so retention leaves it alone.
+ALTER TABLE folders ADD COLUMN local BOOLEAN NOT NULL DEFAULT FALSE;
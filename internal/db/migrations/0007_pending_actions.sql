@@ -0,0 +1,42 @@
+-- the uids of a folder only mean something together with its UIDVALIDITY, a new one means the
+-- folder was recreated on the server and every uid we have for it points at nothing, or worse at
+-- another message. 0 until the next sync of the folder.
+ALTER TABLE folders ADD COLUMN uid_validity INTEGER NOT NULL DEFAULT 0;
+
+-- what the user did that the server doesn't know about yet. the change is made to the local store
+-- right away and recorded here, the replayer pushes it to the server in order once it can be
+-- reached.
+CREATE TABLE pending_actions
+(
+    id               INTEGER PRIMARY KEY,
+    account_id       INTEGER   NOT NULL,
+    -- flag, move, delete, send, append, create_folder, rename_folder or delete_folder
+    kind             TEXT      NOT NULL,
+    -- the message the action is about, 0 for sends and folder ops
+    email_id         INTEGER   NOT NULL DEFAULT 0,
+    -- where the message was when the action was taken, or the folder a folder op is about. a uid
+    -- of 0 is a copy the server hasn't told us the uid of yet, it is looked up by its Message-ID.
+    folder_id        INTEGER   NOT NULL DEFAULT 0,
+    folder           TEXT      NOT NULL DEFAULT '',
+    uid              INTEGER   NOT NULL DEFAULT 0,
+    uid_validity     INTEGER   NOT NULL DEFAULT 0,
+    -- the flag of a flag, the folder of a move, the new name of a rename, the recipients of a send
+    target_folder_id INTEGER   NOT NULL DEFAULT 0,
+    target           TEXT      NOT NULL DEFAULT '',
+    -- whether the flag is set or cleared
+    value            BOOLEAN   NOT NULL DEFAULT FALSE,
+    -- the message of a send or append
+    payload          BLOB,
+    -- what the queue shows, the subject or the folder name
+    summary          TEXT      NOT NULL DEFAULT '',
+    -- pending, failed once it ran out of retries, or conflict when the server changed under it
+    status           TEXT      NOT NULL DEFAULT 'pending',
+    attempts         INTEGER   NOT NULL DEFAULT 0,
+    last_error       TEXT      NOT NULL DEFAULT '',
+    next_attempt_at  TIMESTAMP NOT NULL,
+    created_at       TIMESTAMP NOT NULL,
+
+    FOREIGN KEY (account_id) REFERENCES accounts (id) ON DELETE CASCADE
+);
+
+CREATE INDEX idx_pending_actions_account ON pending_actions (account_id, status, id);
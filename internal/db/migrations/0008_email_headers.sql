@@ -0,0 +1,10 @@
+-- the full header block of the message, zlib compressed. it is what debugging delivery needs
+-- (Received, Authentication-Results, List-*), small enough to outlive the source when retention
+-- evicts the body, and large messages have one without ever being downloaded whole.
+CREATE TABLE email_headers
+(
+    email_id INTEGER PRIMARY KEY,
+    raw      BLOB NOT NULL,
+
+    FOREIGN KEY (email_id) REFERENCES emails (id) ON DELETE CASCADE
+);
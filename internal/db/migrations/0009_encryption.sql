@@ -0,0 +1,13 @@
+-- the data keys of an encrypted database, never in the clear so the file alone can't open them. a
+-- passphrase key is wrapped with age and the passphrase, a keyring key is kept in the os keyring
+-- and wrapped is only the name of its entry. the current one seals, a rotation replaces every
+-- row with the new one in the same transaction. no rows means the database isn't encrypted.
+CREATE TABLE encryption_keys
+(
+    id         INTEGER PRIMARY KEY,
+    -- passphrase or keyring
+    backend    TEXT      NOT NULL,
+    wrapped    BLOB      NOT NULL,
+    current    BOOLEAN   NOT NULL DEFAULT FALSE,
+    created_at TIMESTAMP NOT NULL
+);
@@ -19,16 +19,24 @@ type Account struct {
 	ImapUsername           string
 	ImapUseSsl             bool
 	ImapAuthMethod         string
+	ImapSecurity           string
+	ImapCaFile             sql.NullString
+	ImapTlsFingerprint     sql.NullString
 	SmtpServer             string
 	SmtpPort               int64
 	SmtpUsername           string
 	SmtpUseTls             bool
 	SmtpAuthMethod         string
+	SecretStore            string
+	PasswordCommand        sql.NullString
 	RefreshIntervalMinutes int64
+	BodySizeLimitKb        int64
 	Signature              sql.NullString
 	IsDefault              bool
 	CreatedAt              time.Time
 	UpdatedAt              time.Time
+	BodyRetentionDays      int64
+	BodyRetentionMb        int64
 }
 
 type Attachment struct {
@@ -43,11 +51,10 @@ type Attachment struct {
 
 type Email struct {
 	ID           int64
-	Uid          int64
 	ThreadID     int64
 	AccountID    int64
-	FolderID     int64
 	MessageID    string
+	MessageKey   string
 	FromAddress  string
 	FromName     sql.NullString
 	ToAddresses  string
@@ -61,12 +68,95 @@ type Email struct {
 	IsRead       bool
 	IsStarred    bool
 	IsDraft      bool
+	SizeBytes    int64
+	BodyEvicted  bool
+}
+
+type EmailFolder struct {
+	EmailID   int64
+	FolderID  int64
+	Uid       int64
+	IsRead    bool
+	IsStarred bool
+	IsDraft   bool
+}
+
+type EmailHeader struct {
+	EmailID int64
+	Raw     []byte
+}
+
+type EmailSource struct {
+	EmailID int64
+	Raw     []byte
+}
+
+type EmailsFt struct {
+	Subject         string
+	BodyText        string
+	BodyHtml        string
+	FromAddress     string
+	FromName        string
+	ToAddresses     string
+	CcAddresses     string
+	AttachmentNames string
+}
+
+type EncryptionKey struct {
+	ID        int64
+	Backend   string
+	Wrapped   []byte
+	Current   bool
+	CreatedAt time.Time
 }
 
 type Folder struct {
+	ID           int64
+	AccountID    int64
+	Name         string
+	LastSyncedAt sql.NullTime
+	Push         bool
+	Local        bool
+	UidValidity  int64
+}
+
+type FolderThread struct {
+	FolderID         int64
+	ThreadID         int64
+	MessageCount     int64
+	UnreadCount      int64
+	LatestDate       time.Time
+	LatestSender     string
+	LatestSenderName string
+}
+
+type PendingAction struct {
+	ID             int64
+	AccountID      int64
+	Kind           string
+	EmailID        int64
+	FolderID       int64
+	Folder         string
+	Uid            int64
+	UidValidity    int64
+	TargetFolderID int64
+	Target         string
+	Value          bool
+	Payload        []byte
+	Summary        string
+	Status         string
+	Attempts       int64
+	LastError      string
+	NextAttemptAt  time.Time
+	CreatedAt      time.Time
+}
+
+type SavedSearch struct {
 	ID        int64
 	AccountID int64
 	Name      string
+	Query     string
+	CreatedAt time.Time
 }
 
 type Thread struct {
@@ -11,12 +11,18 @@ ORDER BY name;
 -- name: CreateAccount :one
 INSERT INTO accounts (name, display_name, email,
                       imap_server, imap_port, imap_username, imap_use_ssl, imap_auth_method,
+                      imap_security, imap_ca_file, imap_tls_fingerprint,
                       smtp_server, smtp_port, smtp_username, smtp_use_tls, smtp_auth_method,
-                      refresh_interval_minutes, signature, is_default)
+                      secret_store, password_command,
+                      refresh_interval_minutes, body_size_limit_kb,
+                      body_retention_days, body_retention_mb, signature, is_default)
 VALUES (?, ?, ?,
         ?, ?, ?, ?, ?,
+        ?, ?, ?,
         ?, ?, ?, ?, ?,
-        ?, ?, ?) RETURNING *;
+        ?, ?,
+        ?, ?,
+        ?, ?, ?, ?) RETURNING *;
 
 -- name: UpdateAccount :one
 UPDATE accounts
@@ -28,17 +34,30 @@ SET name                     = ?,
     imap_username            = ?,
     imap_use_ssl             = ?,
     imap_auth_method         = ?,
+    imap_security            = ?,
+    imap_ca_file             = ?,
+    imap_tls_fingerprint     = ?,
     smtp_server              = ?,
     smtp_port                = ?,
     smtp_username            = ?,
     smtp_use_tls             = ?,
     smtp_auth_method         = ?,
+    secret_store             = ?,
+    password_command         = ?,
     refresh_interval_minutes = ?,
+    body_size_limit_kb       = ?,
+    body_retention_days      = ?,
+    body_retention_mb        = ?,
     signature                = ?,
     is_default               = ?,
     updated_at               = CURRENT_TIMESTAMP
 WHERE id = ? RETURNING *;
 
+-- name: ClearDefaultAccount :exec
+UPDATE accounts
+SET is_default = FALSE
+WHERE id != ?;
+
 -- name: DeleteAccount :exec
 DELETE
 FROM accounts
@@ -55,7 +74,7 @@ FROM folders
 WHERE id = ? LIMIT 1;
 
 -- name: GetFolderByName :one
-SELECT id, account_id, name
+SELECT *
 FROM folders
 WHERE name = ? AND account_id = ?;
 
@@ -66,57 +85,88 @@ WHERE account_id = ?
 ORDER BY name;
 
 -- name: CreateFolder :one
-INSERT INTO folders (account_id, name)
-VALUES (?, ?) RETURNING *;
+INSERT INTO folders (account_id, name, push)
+VALUES (?, ?, ?) RETURNING *;
+
+-- name: CreateLocalFolder :one
+INSERT INTO folders (account_id, name, local)
+VALUES (?, ?, TRUE) RETURNING *;
 
 -- name: UpdateFolder :one
 UPDATE folders
 SET name = ?
 WHERE id = ? RETURNING *;
 
+-- name: UpdateFolderLastSynced :exec
+UPDATE folders
+SET last_synced_at = ?
+WHERE id = ?;
+
+-- name: ListPushFolders :many
+SELECT name
+FROM folders
+WHERE account_id = ?
+  AND push = TRUE
+ORDER BY name;
+
+-- name: ClearFolderPush :exec
+UPDATE folders
+SET push = FALSE
+WHERE account_id = ?;
+
+-- name: SetFolderPush :execrows
+UPDATE folders
+SET push = TRUE
+WHERE account_id = ?
+  AND name = ?
+  AND local = FALSE;
+
 -- name: DeleteFolder :exec
 DELETE
 FROM folders
 WHERE id = ?;
 
+-- name: SetFolderUIDValidity :exec
+UPDATE folders
+SET uid_validity = ?
+WHERE id = ?;
+
+-- name: ClearFolderEmails :exec
+-- the uids of the folder point at nothing any more, the sync fetches all of it again
+DELETE
+FROM email_folders
+WHERE folder_id = ?;
+
 -- name: GetThread :one
 SELECT *
 FROM threads
 WHERE id = ? LIMIT 1;
 
 -- name: GetHighestUIDInFolder :one
+-- placeholders of moves the server hasn't seen yet have negative uids
 SELECT uid
-FROM emails
+FROM email_folders
 WHERE folder_id = ?
+  AND uid > 0
 ORDER BY uid DESC LIMIT 1;
 
 -- name: GetThreadsInFolder :many
+-- newest first, a page at a time. the next page starts before the (folder_latest_date, id) of the
+-- last thread of this one, the first page starts before a cursor that is after everything. the
+-- <= keeps it a range on idx_folder_threads_page, the second condition only sorts out ties.
 SELECT t.*,
-       folder_emails.folder_count,
-       folder_emails.folder_unread_count,
-       folder_emails.latest_folder_sender,
-       folder_emails.latest_folder_sender_name
-FROM threads t
-         INNER JOIN (SELECT e.thread_id,
-                            COUNT(*)                                         as folder_count,
-                            SUM(CASE WHEN e.is_read = false THEN 1 ELSE 0 END) as folder_unread_count,
-                            CAST((SELECT e2.from_address
-                                  FROM emails e2
-                                  WHERE e2.thread_id = e.thread_id
-                                  ORDER BY e2.received_date DESC
-                                LIMIT 1) AS TEXT) as latest_folder_sender,
-                            CAST((SELECT e2.from_name
-                                  FROM emails e2
-                                  WHERE e2.thread_id = e.thread_id
-                                  ORDER BY e2.received_date DESC
-                                LIMIT 1) AS TEXT) as latest_folder_sender_name
-                     FROM emails e
-                     WHERE e.folder_id = ?
-                     GROUP BY e.thread_id
-) folder_emails ON t.id = folder_emails.thread_id
-WHERE t.account_id = ?
-ORDER BY t.latest_message_date DESC
-    LIMIT ?;
+       ft.message_count      AS folder_count,
+       ft.unread_count       AS folder_unread_count,
+       ft.latest_sender      AS latest_folder_sender,
+       ft.latest_sender_name AS latest_folder_sender_name,
+       ft.latest_date        AS folder_latest_date
+FROM folder_threads ft
+         JOIN threads t ON t.id = ft.thread_id
+WHERE ft.folder_id = sqlc.arg(folder_id)
+  AND ft.latest_date <= sqlc.arg(before_date)
+  AND (ft.latest_date < sqlc.arg(before_date) OR ft.thread_id < sqlc.arg(before_id))
+ORDER BY ft.latest_date DESC, ft.thread_id DESC
+LIMIT sqlc.arg(page_size);
 
 -- name: CreateThread :one
 INSERT INTO threads (account_id, subject, snippet,
@@ -158,16 +208,33 @@ FROM emails
 WHERE id = ? LIMIT 1;
 
 -- name: GetEmailByFolderAndUID :one
-SELECT *
-FROM emails
-WHERE uid = ? AND folder_id = ?
+SELECT e.*
+FROM emails e
+         JOIN email_folders ef ON ef.email_id = e.id
+WHERE ef.uid = ? AND ef.folder_id = ?
 LIMIT 1;
 
+-- name: ListEmailsByFolderAndUIDs :many
+SELECT sqlc.embed(e), ef.uid
+FROM emails e
+         JOIN email_folders ef ON ef.email_id = e.id
+WHERE ef.folder_id = ?
+  AND ef.uid IN (sqlc.slice('uids'))
+ORDER BY e.received_date DESC;
+
 -- name: GetEmailByMessageID :one
 SELECT thread_id,
        message_id
 FROM emails
-WHERE message_id = ?;
+WHERE account_id = ?
+  AND message_id = ?
+LIMIT 1;
+
+-- name: GetEmailByMessageKey :one
+SELECT id, thread_id
+FROM emails
+WHERE account_id = ?
+  AND message_key = ?;
 
 -- name: ListEmailsByThread :many
 SELECT *
@@ -175,30 +242,99 @@ FROM emails
 WHERE thread_id = ?
 ORDER BY received_date DESC;
 
--- name: GetEmailsWithoutBodies :many
-SELECT id, uid, folder_id, subject, from_address, received_date
-FROM emails
-WHERE account_id = ?
-  AND folder_id = ?
-  AND (body_text IS NULL OR body_text = '')
-ORDER BY received_date DESC LIMIT ?;
+-- name: ListEmailsWithoutBodies :many
+-- every email is fetched from one folder on the server that has it, with a uid we know
+SELECT e.id, ef.uid, ef.folder_id, f.name AS folder_name, e.size_bytes
+FROM emails e
+         JOIN email_folders ef ON ef.email_id = e.id
+    AND ef.folder_id = (SELECT MIN(ef2.folder_id)
+                        FROM email_folders ef2
+                                 JOIN folders f2 ON f2.id = ef2.folder_id
+                        WHERE ef2.email_id = e.id
+                          AND f2.local = FALSE
+                          AND ef2.uid > 0)
+         JOIN folders f ON f.id = ef.folder_id
+WHERE e.account_id = ?
+  AND e.body_text IS NULL
+  AND e.body_evicted = FALSE
+ORDER BY e.received_date DESC, e.id DESC LIMIT ? OFFSET ?;
+
+-- name: ListThreadEmailsWithoutBodies :many
+SELECT e.id, ef.uid, ef.folder_id, f.name AS folder_name, e.size_bytes
+FROM emails e
+         JOIN email_folders ef ON ef.email_id = e.id
+    AND ef.folder_id = (SELECT MIN(ef2.folder_id)
+                        FROM email_folders ef2
+                                 JOIN folders f2 ON f2.id = ef2.folder_id
+                        WHERE ef2.email_id = e.id
+                          AND f2.local = FALSE
+                          AND ef2.uid > 0)
+         JOIN folders f ON f.id = ef.folder_id
+WHERE e.account_id = ?
+  AND e.thread_id = ?
+  AND e.body_text IS NULL
+ORDER BY e.received_date DESC;
 
 -- name: CreateEmail :one
-INSERT INTO emails (uid, thread_id, account_id, folder_id, message_id,
+INSERT INTO emails (thread_id, account_id, message_id, message_key,
                     from_address, from_name, to_addresses,
                     cc_addresses, bcc_addresses, subject,
                     body_text, body_html, received_date,
-                    is_read, is_starred, is_draft)
-VALUES (?, ?, ?, ?, ?,
+                    is_read, is_starred, is_draft, size_bytes)
+VALUES (?, ?, ?, ?,
         ?, ?, ?,
         ?, ?, ?,
         ?, ?, ?,
-        ?, ?, ?) RETURNING *;
+        ?, ?, ?, ?) RETURNING *;
+
+-- name: AddEmailToFolder :execrows
+INSERT INTO email_folders (email_id, folder_id, uid, is_read, is_starred, is_draft)
+VALUES (?, ?, ?, ?, ?, ?)
+ON CONFLICT (folder_id, uid) DO NOTHING;
+
+-- name: RemoveEmailFromFolder :exec
+DELETE
+FROM email_folders
+WHERE folder_id = ?
+  AND uid = ?;
+
+-- name: GetEmailFolder :one
+SELECT *
+FROM email_folders
+WHERE email_id = ?
+  AND folder_id = ?
+ORDER BY uid DESC LIMIT 1;
+
+-- name: ListEmailFolders :many
+SELECT ef.folder_id, ef.uid, ef.is_read, ef.is_starred, ef.is_draft, f.name, f.local, f.uid_validity
+FROM email_folders ef
+         JOIN folders f ON f.id = ef.folder_id
+WHERE ef.email_id = ?
+ORDER BY ef.folder_id, ef.uid;
+
+-- name: CountEmailFolders :one
+SELECT COUNT(*)
+FROM email_folders
+WHERE email_id = ?;
+
+-- name: RemovePlaceholders :exec
+-- a moved message sits in its new folder under a negative uid until the sync finds the real copy
+DELETE
+FROM email_folders
+WHERE email_id = ?
+  AND folder_id = ?
+  AND uid < 0;
+
+-- name: DeleteOrphanEmails :execrows
+-- mail that is in no folder any more, once its folder was deleted
+DELETE
+FROM emails
+WHERE account_id = ?
+  AND id NOT IN (SELECT email_id FROM email_folders);
 
 -- name: UpdateEmail :one
 UPDATE emails
-SET folder_id  = ?,
-    is_read    = ?,
+SET is_read    = ?,
     is_starred = ?,
     is_draft   = ?,
     body_text  = ?
@@ -206,9 +342,125 @@ WHERE id = ? RETURNING *;
 
 -- name: UpdateEmailBodyAndReferences :one
 UPDATE emails
-SET body_text = ?, reference_id = ?
+SET body_text = ?, reference_id = ?, body_evicted = FALSE
 WHERE id = ? RETURNING *;
 
+-- name: EvictBodiesBefore :execrows
+-- starred mail, or mail in a starred thread, keeps its body no matter how old it is
+UPDATE emails
+SET body_text = NULL, body_html = NULL, body_evicted = TRUE
+WHERE emails.account_id = ?
+  AND emails.received_date < ?
+  AND emails.body_text IS NOT NULL
+  AND emails.is_starred = FALSE
+  AND emails.thread_id NOT IN (SELECT t.id FROM threads t WHERE t.is_starred = TRUE)
+  -- imported mail can't be fetched again
+  AND emails.id NOT IN (SELECT lef.email_id
+                        FROM email_folders lef
+                                 JOIN folders lf ON lf.id = lef.folder_id
+                        WHERE lf.local = TRUE);
+
+-- name: EvictAttachmentsBefore :execrows
+UPDATE attachments
+SET content = NULL
+WHERE content IS NOT NULL
+  AND email_id IN (SELECT e.id
+                   FROM emails e
+                   WHERE e.account_id = ?
+                     AND e.received_date < ?
+                     AND e.is_starred = FALSE
+                     AND e.thread_id NOT IN (SELECT t.id FROM threads t WHERE t.is_starred = TRUE)
+                     AND e.id NOT IN (SELECT lef.email_id
+                                      FROM email_folders lef
+                                               JOIN folders lf ON lf.id = lef.folder_id
+                                      WHERE lf.local = TRUE));
+
+-- name: ListEvictableBodies :many
+-- the oldest stored bodies of the account with what they, their source and their attachments take up
+SELECT e.id,
+       CAST(COALESCE(length(CAST(e.body_text AS BLOB)), 0) +
+            COALESCE(length(CAST(e.body_html AS BLOB)), 0) +
+            COALESCE((SELECT length(s.raw) FROM email_sources s WHERE s.email_id = e.id), 0) +
+            COALESCE((SELECT SUM(length(a.content)) FROM attachments a WHERE a.email_id = e.id), 0)
+           AS INTEGER) AS stored_bytes
+FROM emails e
+WHERE e.account_id = ?
+  AND e.body_text IS NOT NULL
+  AND e.is_starred = FALSE
+  AND e.thread_id NOT IN (SELECT t.id FROM threads t WHERE t.is_starred = TRUE)
+  AND e.id NOT IN (SELECT lef.email_id
+                   FROM email_folders lef
+                            JOIN folders lf ON lf.id = lef.folder_id
+                   WHERE lf.local = TRUE)
+ORDER BY e.received_date LIMIT ?;
+
+-- name: EvictBody :exec
+UPDATE emails
+SET body_text = NULL, body_html = NULL, body_evicted = TRUE
+WHERE id = ?;
+
+-- name: EvictAttachments :exec
+UPDATE attachments
+SET content = NULL
+WHERE email_id = ?;
+
+-- name: GetAccountStorage :one
+-- what the account keeps locally, sizes in bytes
+SELECT CAST(COUNT(*) AS INTEGER)                                                        AS messages,
+       CAST(COALESCE(SUM(CASE WHEN body_text IS NOT NULL THEN 1 ELSE 0 END), 0) AS INTEGER) AS bodies,
+       CAST(COALESCE(SUM(CASE WHEN body_evicted THEN 1 ELSE 0 END), 0) AS INTEGER)          AS evicted,
+       CAST(COALESCE(SUM(length(CAST(subject AS BLOB)) + length(CAST(from_address AS BLOB)) +
+                         length(CAST(to_addresses AS BLOB)) + COALESCE(length(CAST(from_name AS BLOB)), 0) +
+                         COALESCE(length(CAST(cc_addresses AS BLOB)), 0) +
+                         COALESCE(length(CAST(bcc_addresses AS BLOB)), 0)), 0) +
+            COALESCE((SELECT SUM(length(h.raw))
+                      FROM email_headers h
+                               JOIN emails he ON he.id = h.email_id
+                      WHERE he.account_id = sqlc.arg(account_id)), 0) AS INTEGER)         AS header_bytes,
+       CAST(COALESCE(SUM(COALESCE(length(CAST(body_text AS BLOB)), 0) +
+                         COALESCE(length(CAST(body_html AS BLOB)), 0)), 0) AS INTEGER)      AS body_bytes,
+       CAST(COALESCE((SELECT SUM(length(a.content))
+                      FROM attachments a
+                               JOIN emails ae ON ae.id = a.email_id
+                      WHERE ae.account_id = sqlc.arg(account_id)), 0) AS INTEGER)         AS attachment_bytes,
+       CAST(COALESCE((SELECT SUM(length(s.raw))
+                      FROM email_sources s
+                               JOIN emails se ON se.id = s.email_id
+                      WHERE se.account_id = sqlc.arg(account_id)), 0) AS INTEGER)         AS source_bytes
+FROM emails
+WHERE account_id = sqlc.arg(account_id);
+
+-- name: UpsertEmailSource :exec
+INSERT INTO email_sources (email_id, raw)
+VALUES (?, ?)
+ON CONFLICT (email_id) DO UPDATE SET raw = excluded.raw;
+
+-- name: GetEmailSource :one
+SELECT raw
+FROM email_sources
+WHERE email_id = ?;
+
+-- name: UpsertEmailHeader :exec
+INSERT INTO email_headers (email_id, raw)
+VALUES (?, ?)
+ON CONFLICT (email_id) DO UPDATE SET raw = excluded.raw;
+
+-- name: GetEmailHeader :one
+SELECT raw
+FROM email_headers
+WHERE email_id = ?;
+
+-- name: IsEmailInFolder :one
+SELECT CAST(EXISTS (SELECT 1 FROM email_folders WHERE email_id = ? AND folder_id = ?) AS BOOLEAN) AS in_folder;
+
+-- name: ListFolderEmailIDs :many
+-- oldest first, the order an archive is read in
+SELECT e.id
+FROM emails e
+         JOIN email_folders ef ON ef.email_id = e.id
+WHERE ef.folder_id = ?
+ORDER BY e.received_date, e.id;
+
 -- name: DeleteEmail :exec
 DELETE
 FROM emails
@@ -219,6 +471,16 @@ UPDATE emails
 SET is_read = TRUE
 WHERE id = ?;
 
+-- name: UpdateFolderEmailFlags :one
+UPDATE email_folders
+SET is_read = ?, is_starred = ?, is_draft = ?
+WHERE folder_id = ? AND uid = ? RETURNING email_id;
+
+-- name: UpdateEmailFlags :exec
+UPDATE emails
+SET is_read = ?, is_starred = ?, is_draft = ?
+WHERE id = ?;
+
 -- name: ToggleEmailStarred :one
 UPDATE emails
 SET is_starred = NOT is_starred
@@ -250,33 +512,121 @@ DELETE
 FROM attachments
 WHERE id = ?;
 
--- name: SearchEmails :many
-SELECT e.*
-FROM emails e
-         JOIN threads t ON e.thread_id = t.id
-WHERE (e.subject LIKE '%' || ? || '%'
-    OR e.body_text LIKE '%' || ? || '%'
-    OR e.from_address LIKE '%' || ? || '%'
-    OR e.from_name LIKE '%' || ? || '%')
-  AND e.account_id = ?
-ORDER BY e.received_date DESC LIMIT ?
-OFFSET ?;
-
--- name: MoveEmail :exec
-UPDATE emails
-SET folder_id = ?
+-- name: ListSavedSearches :many
+SELECT *
+FROM saved_searches
+WHERE account_id = ?
+ORDER BY name;
+
+-- name: CreateSavedSearch :one
+INSERT INTO saved_searches (account_id, name, query)
+VALUES (?, ?, ?) RETURNING *;
+
+-- name: DeleteSavedSearch :exec
+DELETE
+FROM saved_searches
 WHERE id = ?;
 
 -- name: GetEmailsStats :one
-SELECT COUNT(*)                                         as total_emails,
-       SUM(CASE WHEN is_read = FALSE THEN 1 ELSE 0 END) as unread_count
-FROM emails
-WHERE account_id = ?
-  AND folder_id = ?;
+SELECT COUNT(*)                                           as total_emails,
+       SUM(CASE WHEN e.is_read = FALSE THEN 1 ELSE 0 END) as unread_count
+FROM emails e
+         JOIN email_folders ef ON ef.email_id = e.id
+WHERE e.account_id = ?
+  AND ef.folder_id = ?;
 
 -- name: UpdateThreadMessageCount :exec
 UPDATE threads
 SET message_count = (SELECT COUNT(*)
                      FROM emails
                      WHERE thread_id = threads.id)
-WHERE threads.id = ?;
\ No newline at end of file
+WHERE threads.id = ?;
+-- name: CreatePendingAction :one
+INSERT INTO pending_actions (account_id, kind, email_id, folder_id, folder, uid, uid_validity,
+                             target_folder_id, target, value, payload, summary,
+                             next_attempt_at, created_at)
+VALUES (?, ?, ?, ?, ?, ?, ?,
+        ?, ?, ?, ?, ?,
+        ?, ?) RETURNING *;
+
+-- name: GetPendingAction :one
+SELECT *
+FROM pending_actions
+WHERE id = ?;
+
+-- name: ListPendingActions :many
+SELECT *
+FROM pending_actions
+ORDER BY id;
+
+-- name: ListAccountPendingActions :many
+-- the failed and conflicting ones too, the replayer holds back what comes after them
+SELECT *
+FROM pending_actions
+WHERE account_id = ?
+ORDER BY id;
+
+-- name: CountPendingActions :many
+SELECT account_id, status, COUNT(*) AS count
+FROM pending_actions
+GROUP BY account_id, status;
+
+-- name: UpdatePendingAction :exec
+UPDATE pending_actions
+SET status          = ?,
+    attempts        = ?,
+    last_error      = ?,
+    next_attempt_at = ?
+WHERE id = ?;
+
+-- name: SetPendingActionKind :exec
+UPDATE pending_actions
+SET kind       = ?,
+    attempts   = 0,
+    last_error = ''
+WHERE id = ?;
+
+-- name: RetryPendingAction :exec
+UPDATE pending_actions
+SET status          = 'pending',
+    attempts        = 0,
+    last_error      = '',
+    next_attempt_at = ?
+WHERE id = ?;
+
+-- name: DeletePendingAction :exec
+DELETE
+FROM pending_actions
+WHERE id = ?;
+
+-- name: DeleteSupersededFlagActions :exec
+-- a flag that is set again before the last change reached the server only needs the last one
+DELETE
+FROM pending_actions
+WHERE kind = 'flag'
+  AND status = 'pending'
+  AND attempts = 0
+  AND email_id = ?
+  AND folder_id = ?
+  AND uid = ?
+  AND target = ?;
+
+-- name: CreateEncryptionKey :one
+INSERT INTO encryption_keys (backend, wrapped, current, created_at)
+VALUES (?, ?, FALSE, ?)
+RETURNING *;
+
+-- name: ListEncryptionKeys :many
+SELECT *
+FROM encryption_keys
+ORDER BY id;
+
+-- name: SetCurrentEncryptionKey :exec
+UPDATE encryption_keys
+SET current = (id = ?);
+
+-- name: DeleteOtherEncryptionKeys :exec
+-- once everything is sealed with the current key the old ones open nothing anymore
+DELETE
+FROM encryption_keys
+WHERE current = FALSE;
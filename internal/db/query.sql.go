@@ -8,18 +8,137 @@ package db
 import (
 	"context"
 	"database/sql"
+	"strings"
 	"time"
 )
 
+const addEmailToFolder = `-- name: AddEmailToFolder :execrows
+INSERT INTO email_folders (email_id, folder_id, uid, is_read, is_starred, is_draft)
+VALUES (?, ?, ?, ?, ?, ?)
+ON CONFLICT (folder_id, uid) DO NOTHING
+`
+
+type AddEmailToFolderParams struct {
+	EmailID   int64
+	FolderID  int64
+	Uid       int64
+	IsRead    bool
+	IsStarred bool
+	IsDraft   bool
+}
+
+func (q *Queries) AddEmailToFolder(ctx context.Context, arg AddEmailToFolderParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, addEmailToFolder,
+		arg.EmailID,
+		arg.FolderID,
+		arg.Uid,
+		arg.IsRead,
+		arg.IsStarred,
+		arg.IsDraft,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const clearDefaultAccount = `-- name: ClearDefaultAccount :exec
+UPDATE accounts
+SET is_default = FALSE
+WHERE id != ?
+`
+
+func (q *Queries) ClearDefaultAccount(ctx context.Context, id int64) error {
+	_, err := q.db.ExecContext(ctx, clearDefaultAccount, id)
+	return err
+}
+
+const clearFolderEmails = `-- name: ClearFolderEmails :exec
+DELETE
+FROM email_folders
+WHERE folder_id = ?
+`
+
+// the uids of the folder point at nothing any more, the sync fetches all of it again
+func (q *Queries) ClearFolderEmails(ctx context.Context, folderID int64) error {
+	_, err := q.db.ExecContext(ctx, clearFolderEmails, folderID)
+	return err
+}
+
+const clearFolderPush = `-- name: ClearFolderPush :exec
+UPDATE folders
+SET push = FALSE
+WHERE account_id = ?
+`
+
+func (q *Queries) ClearFolderPush(ctx context.Context, accountID int64) error {
+	_, err := q.db.ExecContext(ctx, clearFolderPush, accountID)
+	return err
+}
+
+const countEmailFolders = `-- name: CountEmailFolders :one
+SELECT COUNT(*)
+FROM email_folders
+WHERE email_id = ?
+`
+
+func (q *Queries) CountEmailFolders(ctx context.Context, emailID int64) (int64, error) {
+	row := q.db.QueryRowContext(ctx, countEmailFolders, emailID)
+	var count int64
+	err := row.Scan(&count)
+	return count, err
+}
+
+const countPendingActions = `-- name: CountPendingActions :many
+SELECT account_id, status, COUNT(*) AS count
+FROM pending_actions
+GROUP BY account_id, status
+`
+
+type CountPendingActionsRow struct {
+	AccountID int64
+	Status    string
+	Count     int64
+}
+
+func (q *Queries) CountPendingActions(ctx context.Context) ([]CountPendingActionsRow, error) {
+	rows, err := q.db.QueryContext(ctx, countPendingActions)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []CountPendingActionsRow
+	for rows.Next() {
+		var i CountPendingActionsRow
+		if err := rows.Scan(&i.AccountID, &i.Status, &i.Count); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
 const createAccount = `-- name: CreateAccount :one
 INSERT INTO accounts (name, display_name, email,
                       imap_server, imap_port, imap_username, imap_use_ssl, imap_auth_method,
+                      imap_security, imap_ca_file, imap_tls_fingerprint,
                       smtp_server, smtp_port, smtp_username, smtp_use_tls, smtp_auth_method,
-                      refresh_interval_minutes, signature, is_default)
+                      secret_store, password_command,
+                      refresh_interval_minutes, body_size_limit_kb,
+                      body_retention_days, body_retention_mb, signature, is_default)
 VALUES (?, ?, ?,
         ?, ?, ?, ?, ?,
+        ?, ?, ?,
         ?, ?, ?, ?, ?,
-        ?, ?, ?) RETURNING id, name, display_name, email, imap_server, imap_port, imap_username, imap_use_ssl, imap_auth_method, smtp_server, smtp_port, smtp_username, smtp_use_tls, smtp_auth_method, refresh_interval_minutes, signature, is_default, created_at, updated_at
+        ?, ?,
+        ?, ?,
+        ?, ?, ?, ?) RETURNING id, name, display_name, email, imap_server, imap_port, imap_username, imap_use_ssl, imap_auth_method, imap_security, imap_ca_file, imap_tls_fingerprint, smtp_server, smtp_port, smtp_username, smtp_use_tls, smtp_auth_method, secret_store, password_command, refresh_interval_minutes, body_size_limit_kb, signature, is_default, created_at, updated_at, body_retention_days, body_retention_mb
 `
 
 type CreateAccountParams struct {
@@ -31,12 +150,20 @@ type CreateAccountParams struct {
 	ImapUsername           string
 	ImapUseSsl             bool
 	ImapAuthMethod         string
+	ImapSecurity           string
+	ImapCaFile             sql.NullString
+	ImapTlsFingerprint     sql.NullString
 	SmtpServer             string
 	SmtpPort               int64
 	SmtpUsername           string
 	SmtpUseTls             bool
 	SmtpAuthMethod         string
+	SecretStore            string
+	PasswordCommand        sql.NullString
 	RefreshIntervalMinutes int64
+	BodySizeLimitKb        int64
+	BodyRetentionDays      int64
+	BodyRetentionMb        int64
 	Signature              sql.NullString
 	IsDefault              bool
 }
@@ -51,12 +178,20 @@ func (q *Queries) CreateAccount(ctx context.Context, arg CreateAccountParams) (A
 		arg.ImapUsername,
 		arg.ImapUseSsl,
 		arg.ImapAuthMethod,
+		arg.ImapSecurity,
+		arg.ImapCaFile,
+		arg.ImapTlsFingerprint,
 		arg.SmtpServer,
 		arg.SmtpPort,
 		arg.SmtpUsername,
 		arg.SmtpUseTls,
 		arg.SmtpAuthMethod,
+		arg.SecretStore,
+		arg.PasswordCommand,
 		arg.RefreshIntervalMinutes,
+		arg.BodySizeLimitKb,
+		arg.BodyRetentionDays,
+		arg.BodyRetentionMb,
 		arg.Signature,
 		arg.IsDefault,
 	)
@@ -71,16 +206,24 @@ func (q *Queries) CreateAccount(ctx context.Context, arg CreateAccountParams) (A
 		&i.ImapUsername,
 		&i.ImapUseSsl,
 		&i.ImapAuthMethod,
+		&i.ImapSecurity,
+		&i.ImapCaFile,
+		&i.ImapTlsFingerprint,
 		&i.SmtpServer,
 		&i.SmtpPort,
 		&i.SmtpUsername,
 		&i.SmtpUseTls,
 		&i.SmtpAuthMethod,
+		&i.SecretStore,
+		&i.PasswordCommand,
 		&i.RefreshIntervalMinutes,
+		&i.BodySizeLimitKb,
 		&i.Signature,
 		&i.IsDefault,
 		&i.CreatedAt,
 		&i.UpdatedAt,
+		&i.BodyRetentionDays,
+		&i.BodyRetentionMb,
 	)
 	return i, err
 }
@@ -124,24 +267,23 @@ func (q *Queries) CreateAttachment(ctx context.Context, arg CreateAttachmentPara
 }
 
 const createEmail = `-- name: CreateEmail :one
-INSERT INTO emails (uid, thread_id, account_id, folder_id, message_id,
+INSERT INTO emails (thread_id, account_id, message_id, message_key,
                     from_address, from_name, to_addresses,
                     cc_addresses, bcc_addresses, subject,
                     body_text, body_html, received_date,
-                    is_read, is_starred, is_draft)
-VALUES (?, ?, ?, ?, ?,
+                    is_read, is_starred, is_draft, size_bytes)
+VALUES (?, ?, ?, ?,
         ?, ?, ?,
         ?, ?, ?,
         ?, ?, ?,
-        ?, ?, ?) RETURNING id, uid, thread_id, account_id, folder_id, message_id, from_address, from_name, to_addresses, cc_addresses, bcc_addresses, reference_id, subject, body_text, body_html, received_date, is_read, is_starred, is_draft
+        ?, ?, ?, ?) RETURNING id, thread_id, account_id, message_id, message_key, from_address, from_name, to_addresses, cc_addresses, bcc_addresses, reference_id, subject, body_text, body_html, received_date, is_read, is_starred, is_draft, size_bytes, body_evicted
 `
 
 type CreateEmailParams struct {
-	Uid          int64
 	ThreadID     int64
 	AccountID    int64
-	FolderID     int64
 	MessageID    string
+	MessageKey   string
 	FromAddress  string
 	FromName     sql.NullString
 	ToAddresses  string
@@ -154,15 +296,15 @@ type CreateEmailParams struct {
 	IsRead       bool
 	IsStarred    bool
 	IsDraft      bool
+	SizeBytes    int64
 }
 
 func (q *Queries) CreateEmail(ctx context.Context, arg CreateEmailParams) (Email, error) {
 	row := q.db.QueryRowContext(ctx, createEmail,
-		arg.Uid,
 		arg.ThreadID,
 		arg.AccountID,
-		arg.FolderID,
 		arg.MessageID,
+		arg.MessageKey,
 		arg.FromAddress,
 		arg.FromName,
 		arg.ToAddresses,
@@ -175,15 +317,15 @@ func (q *Queries) CreateEmail(ctx context.Context, arg CreateEmailParams) (Email
 		arg.IsRead,
 		arg.IsStarred,
 		arg.IsDraft,
+		arg.SizeBytes,
 	)
 	var i Email
 	err := row.Scan(
 		&i.ID,
-		&i.Uid,
 		&i.ThreadID,
 		&i.AccountID,
-		&i.FolderID,
 		&i.MessageID,
+		&i.MessageKey,
 		&i.FromAddress,
 		&i.FromName,
 		&i.ToAddresses,
@@ -197,24 +339,176 @@ func (q *Queries) CreateEmail(ctx context.Context, arg CreateEmailParams) (Email
 		&i.IsRead,
 		&i.IsStarred,
 		&i.IsDraft,
+		&i.SizeBytes,
+		&i.BodyEvicted,
+	)
+	return i, err
+}
+
+const createEncryptionKey = `-- name: CreateEncryptionKey :one
+INSERT INTO encryption_keys (backend, wrapped, current, created_at)
+VALUES (?, ?, FALSE, ?)
+RETURNING id, backend, wrapped, "current", created_at
+`
+
+type CreateEncryptionKeyParams struct {
+	Backend   string
+	Wrapped   []byte
+	CreatedAt time.Time
+}
+
+func (q *Queries) CreateEncryptionKey(ctx context.Context, arg CreateEncryptionKeyParams) (EncryptionKey, error) {
+	row := q.db.QueryRowContext(ctx, createEncryptionKey, arg.Backend, arg.Wrapped, arg.CreatedAt)
+	var i EncryptionKey
+	err := row.Scan(
+		&i.ID,
+		&i.Backend,
+		&i.Wrapped,
+		&i.Current,
+		&i.CreatedAt,
 	)
 	return i, err
 }
 
 const createFolder = `-- name: CreateFolder :one
-INSERT INTO folders (account_id, name)
-VALUES (?, ?) RETURNING id, account_id, name
+INSERT INTO folders (account_id, name, push)
+VALUES (?, ?, ?) RETURNING id, account_id, name, last_synced_at, push, local, uid_validity
 `
 
 type CreateFolderParams struct {
 	AccountID int64
 	Name      string
+	Push      bool
 }
 
 func (q *Queries) CreateFolder(ctx context.Context, arg CreateFolderParams) (Folder, error) {
-	row := q.db.QueryRowContext(ctx, createFolder, arg.AccountID, arg.Name)
+	row := q.db.QueryRowContext(ctx, createFolder, arg.AccountID, arg.Name, arg.Push)
+	var i Folder
+	err := row.Scan(
+		&i.ID,
+		&i.AccountID,
+		&i.Name,
+		&i.LastSyncedAt,
+		&i.Push,
+		&i.Local,
+		&i.UidValidity,
+	)
+	return i, err
+}
+
+const createLocalFolder = `-- name: CreateLocalFolder :one
+INSERT INTO folders (account_id, name, local)
+VALUES (?, ?, TRUE) RETURNING id, account_id, name, last_synced_at, push, local, uid_validity
+`
+
+type CreateLocalFolderParams struct {
+	AccountID int64
+	Name      string
+}
+
+func (q *Queries) CreateLocalFolder(ctx context.Context, arg CreateLocalFolderParams) (Folder, error) {
+	row := q.db.QueryRowContext(ctx, createLocalFolder, arg.AccountID, arg.Name)
 	var i Folder
-	err := row.Scan(&i.ID, &i.AccountID, &i.Name)
+	err := row.Scan(
+		&i.ID,
+		&i.AccountID,
+		&i.Name,
+		&i.LastSyncedAt,
+		&i.Push,
+		&i.Local,
+		&i.UidValidity,
+	)
+	return i, err
+}
+
+const createPendingAction = `-- name: CreatePendingAction :one
+INSERT INTO pending_actions (account_id, kind, email_id, folder_id, folder, uid, uid_validity,
+                             target_folder_id, target, value, payload, summary,
+                             next_attempt_at, created_at)
+VALUES (?, ?, ?, ?, ?, ?, ?,
+        ?, ?, ?, ?, ?,
+        ?, ?) RETURNING id, account_id, kind, email_id, folder_id, folder, uid, uid_validity, target_folder_id, target, value, payload, summary, status, attempts, last_error, next_attempt_at, created_at
+`
+
+type CreatePendingActionParams struct {
+	AccountID      int64
+	Kind           string
+	EmailID        int64
+	FolderID       int64
+	Folder         string
+	Uid            int64
+	UidValidity    int64
+	TargetFolderID int64
+	Target         string
+	Value          bool
+	Payload        []byte
+	Summary        string
+	NextAttemptAt  time.Time
+	CreatedAt      time.Time
+}
+
+func (q *Queries) CreatePendingAction(ctx context.Context, arg CreatePendingActionParams) (PendingAction, error) {
+	row := q.db.QueryRowContext(ctx, createPendingAction,
+		arg.AccountID,
+		arg.Kind,
+		arg.EmailID,
+		arg.FolderID,
+		arg.Folder,
+		arg.Uid,
+		arg.UidValidity,
+		arg.TargetFolderID,
+		arg.Target,
+		arg.Value,
+		arg.Payload,
+		arg.Summary,
+		arg.NextAttemptAt,
+		arg.CreatedAt,
+	)
+	var i PendingAction
+	err := row.Scan(
+		&i.ID,
+		&i.AccountID,
+		&i.Kind,
+		&i.EmailID,
+		&i.FolderID,
+		&i.Folder,
+		&i.Uid,
+		&i.UidValidity,
+		&i.TargetFolderID,
+		&i.Target,
+		&i.Value,
+		&i.Payload,
+		&i.Summary,
+		&i.Status,
+		&i.Attempts,
+		&i.LastError,
+		&i.NextAttemptAt,
+		&i.CreatedAt,
+	)
+	return i, err
+}
+
+const createSavedSearch = `-- name: CreateSavedSearch :one
+INSERT INTO saved_searches (account_id, name, query)
+VALUES (?, ?, ?) RETURNING id, account_id, name, "query", created_at
+`
+
+type CreateSavedSearchParams struct {
+	AccountID int64
+	Name      string
+	Query     string
+}
+
+func (q *Queries) CreateSavedSearch(ctx context.Context, arg CreateSavedSearchParams) (SavedSearch, error) {
+	row := q.db.QueryRowContext(ctx, createSavedSearch, arg.AccountID, arg.Name, arg.Query)
+	var i SavedSearch
+	err := row.Scan(
+		&i.ID,
+		&i.AccountID,
+		&i.Name,
+		&i.Query,
+		&i.CreatedAt,
+	)
 	return i, err
 }
 
@@ -308,6 +602,86 @@ func (q *Queries) DeleteFolder(ctx context.Context, id int64) error {
 	return err
 }
 
+const deleteOrphanEmails = `-- name: DeleteOrphanEmails :execrows
+DELETE
+FROM emails
+WHERE account_id = ?
+  AND id NOT IN (SELECT email_id FROM email_folders)
+`
+
+// mail that is in no folder any more, once its folder was deleted
+func (q *Queries) DeleteOrphanEmails(ctx context.Context, accountID int64) (int64, error) {
+	result, err := q.db.ExecContext(ctx, deleteOrphanEmails, accountID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const deleteOtherEncryptionKeys = `-- name: DeleteOtherEncryptionKeys :exec
+DELETE
+FROM encryption_keys
+WHERE current = FALSE
+`
+
+// once everything is sealed with the current key the old ones open nothing anymore
+func (q *Queries) DeleteOtherEncryptionKeys(ctx context.Context) error {
+	_, err := q.db.ExecContext(ctx, deleteOtherEncryptionKeys)
+	return err
+}
+
+const deletePendingAction = `-- name: DeletePendingAction :exec
+DELETE
+FROM pending_actions
+WHERE id = ?
+`
+
+func (q *Queries) DeletePendingAction(ctx context.Context, id int64) error {
+	_, err := q.db.ExecContext(ctx, deletePendingAction, id)
+	return err
+}
+
+const deleteSavedSearch = `-- name: DeleteSavedSearch :exec
+DELETE
+FROM saved_searches
+WHERE id = ?
+`
+
+func (q *Queries) DeleteSavedSearch(ctx context.Context, id int64) error {
+	_, err := q.db.ExecContext(ctx, deleteSavedSearch, id)
+	return err
+}
+
+const deleteSupersededFlagActions = `-- name: DeleteSupersededFlagActions :exec
+DELETE
+FROM pending_actions
+WHERE kind = 'flag'
+  AND status = 'pending'
+  AND attempts = 0
+  AND email_id = ?
+  AND folder_id = ?
+  AND uid = ?
+  AND target = ?
+`
+
+type DeleteSupersededFlagActionsParams struct {
+	EmailID  int64
+	FolderID int64
+	Uid      int64
+	Target   string
+}
+
+// a flag that is set again before the last change reached the server only needs the last one
+func (q *Queries) DeleteSupersededFlagActions(ctx context.Context, arg DeleteSupersededFlagActionsParams) error {
+	_, err := q.db.ExecContext(ctx, deleteSupersededFlagActions,
+		arg.EmailID,
+		arg.FolderID,
+		arg.Uid,
+		arg.Target,
+	)
+	return err
+}
+
 const deleteThread = `-- name: DeleteThread :exec
 DELETE
 FROM threads
@@ -319,8 +693,88 @@ func (q *Queries) DeleteThread(ctx context.Context, id int64) error {
 	return err
 }
 
+const evictAttachments = `-- name: EvictAttachments :exec
+UPDATE attachments
+SET content = NULL
+WHERE email_id = ?
+`
+
+func (q *Queries) EvictAttachments(ctx context.Context, emailID int64) error {
+	_, err := q.db.ExecContext(ctx, evictAttachments, emailID)
+	return err
+}
+
+const evictAttachmentsBefore = `-- name: EvictAttachmentsBefore :execrows
+UPDATE attachments
+SET content = NULL
+WHERE content IS NOT NULL
+  AND email_id IN (SELECT e.id
+                   FROM emails e
+                   WHERE e.account_id = ?
+                     AND e.received_date < ?
+                     AND e.is_starred = FALSE
+                     AND e.thread_id NOT IN (SELECT t.id FROM threads t WHERE t.is_starred = TRUE)
+                     AND e.id NOT IN (SELECT lef.email_id
+                                      FROM email_folders lef
+                                               JOIN folders lf ON lf.id = lef.folder_id
+                                      WHERE lf.local = TRUE))
+`
+
+type EvictAttachmentsBeforeParams struct {
+	AccountID    int64
+	ReceivedDate time.Time
+}
+
+func (q *Queries) EvictAttachmentsBefore(ctx context.Context, arg EvictAttachmentsBeforeParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, evictAttachmentsBefore, arg.AccountID, arg.ReceivedDate)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const evictBodiesBefore = `-- name: EvictBodiesBefore :execrows
+UPDATE emails
+SET body_text = NULL, body_html = NULL, body_evicted = TRUE
+WHERE emails.account_id = ?
+  AND emails.received_date < ?
+  AND emails.body_text IS NOT NULL
+  AND emails.is_starred = FALSE
+  AND emails.thread_id NOT IN (SELECT t.id FROM threads t WHERE t.is_starred = TRUE)
+  -- imported mail can't be fetched again
+  AND emails.id NOT IN (SELECT lef.email_id
+                        FROM email_folders lef
+                                 JOIN folders lf ON lf.id = lef.folder_id
+                        WHERE lf.local = TRUE)
+`
+
+type EvictBodiesBeforeParams struct {
+	AccountID    int64
+	ReceivedDate time.Time
+}
+
+// starred mail, or mail in a starred thread, keeps its body no matter how old it is
+func (q *Queries) EvictBodiesBefore(ctx context.Context, arg EvictBodiesBeforeParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, evictBodiesBefore, arg.AccountID, arg.ReceivedDate)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const evictBody = `-- name: EvictBody :exec
+UPDATE emails
+SET body_text = NULL, body_html = NULL, body_evicted = TRUE
+WHERE id = ?
+`
+
+func (q *Queries) EvictBody(ctx context.Context, id int64) error {
+	_, err := q.db.ExecContext(ctx, evictBody, id)
+	return err
+}
+
 const getAccount = `-- name: GetAccount :one
-SELECT id, name, display_name, email, imap_server, imap_port, imap_username, imap_use_ssl, imap_auth_method, smtp_server, smtp_port, smtp_username, smtp_use_tls, smtp_auth_method, refresh_interval_minutes, signature, is_default, created_at, updated_at
+SELECT id, name, display_name, email, imap_server, imap_port, imap_username, imap_use_ssl, imap_auth_method, imap_security, imap_ca_file, imap_tls_fingerprint, smtp_server, smtp_port, smtp_username, smtp_use_tls, smtp_auth_method, secret_store, password_command, refresh_interval_minutes, body_size_limit_kb, signature, is_default, created_at, updated_at, body_retention_days, body_retention_mb
 FROM accounts
 WHERE id = ? LIMIT 1
 `
@@ -338,16 +792,76 @@ func (q *Queries) GetAccount(ctx context.Context, id int64) (Account, error) {
 		&i.ImapUsername,
 		&i.ImapUseSsl,
 		&i.ImapAuthMethod,
+		&i.ImapSecurity,
+		&i.ImapCaFile,
+		&i.ImapTlsFingerprint,
 		&i.SmtpServer,
 		&i.SmtpPort,
 		&i.SmtpUsername,
 		&i.SmtpUseTls,
 		&i.SmtpAuthMethod,
+		&i.SecretStore,
+		&i.PasswordCommand,
 		&i.RefreshIntervalMinutes,
+		&i.BodySizeLimitKb,
 		&i.Signature,
 		&i.IsDefault,
 		&i.CreatedAt,
 		&i.UpdatedAt,
+		&i.BodyRetentionDays,
+		&i.BodyRetentionMb,
+	)
+	return i, err
+}
+
+const getAccountStorage = `-- name: GetAccountStorage :one
+SELECT CAST(COUNT(*) AS INTEGER)                                                        AS messages,
+       CAST(COALESCE(SUM(CASE WHEN body_text IS NOT NULL THEN 1 ELSE 0 END), 0) AS INTEGER) AS bodies,
+       CAST(COALESCE(SUM(CASE WHEN body_evicted THEN 1 ELSE 0 END), 0) AS INTEGER)          AS evicted,
+       CAST(COALESCE(SUM(length(CAST(subject AS BLOB)) + length(CAST(from_address AS BLOB)) +
+                         length(CAST(to_addresses AS BLOB)) + COALESCE(length(CAST(from_name AS BLOB)), 0) +
+                         COALESCE(length(CAST(cc_addresses AS BLOB)), 0) +
+                         COALESCE(length(CAST(bcc_addresses AS BLOB)), 0)), 0) +
+            COALESCE((SELECT SUM(length(h.raw))
+                      FROM email_headers h
+                               JOIN emails he ON he.id = h.email_id
+                      WHERE he.account_id = ?1), 0) AS INTEGER)         AS header_bytes,
+       CAST(COALESCE(SUM(COALESCE(length(CAST(body_text AS BLOB)), 0) +
+                         COALESCE(length(CAST(body_html AS BLOB)), 0)), 0) AS INTEGER)      AS body_bytes,
+       CAST(COALESCE((SELECT SUM(length(a.content))
+                      FROM attachments a
+                               JOIN emails ae ON ae.id = a.email_id
+                      WHERE ae.account_id = ?1), 0) AS INTEGER)         AS attachment_bytes,
+       CAST(COALESCE((SELECT SUM(length(s.raw))
+                      FROM email_sources s
+                               JOIN emails se ON se.id = s.email_id
+                      WHERE se.account_id = ?1), 0) AS INTEGER)         AS source_bytes
+FROM emails
+WHERE account_id = ?1
+`
+
+type GetAccountStorageRow struct {
+	Messages        int64
+	Bodies          int64
+	Evicted         int64
+	HeaderBytes     int64
+	BodyBytes       int64
+	AttachmentBytes int64
+	SourceBytes     int64
+}
+
+// what the account keeps locally, sizes in bytes
+func (q *Queries) GetAccountStorage(ctx context.Context, accountID int64) (GetAccountStorageRow, error) {
+	row := q.db.QueryRowContext(ctx, getAccountStorage, accountID)
+	var i GetAccountStorageRow
+	err := row.Scan(
+		&i.Messages,
+		&i.Bodies,
+		&i.Evicted,
+		&i.HeaderBytes,
+		&i.BodyBytes,
+		&i.AttachmentBytes,
+		&i.SourceBytes,
 	)
 	return i, err
 }
@@ -374,7 +888,7 @@ func (q *Queries) GetAttachment(ctx context.Context, id int64) (Attachment, erro
 }
 
 const getDefaultAccount = `-- name: GetDefaultAccount :one
-SELECT id, name, display_name, email, imap_server, imap_port, imap_username, imap_use_ssl, imap_auth_method, smtp_server, smtp_port, smtp_username, smtp_use_tls, smtp_auth_method, refresh_interval_minutes, signature, is_default, created_at, updated_at
+SELECT id, name, display_name, email, imap_server, imap_port, imap_username, imap_use_ssl, imap_auth_method, imap_security, imap_ca_file, imap_tls_fingerprint, smtp_server, smtp_port, smtp_username, smtp_use_tls, smtp_auth_method, secret_store, password_command, refresh_interval_minutes, body_size_limit_kb, signature, is_default, created_at, updated_at, body_retention_days, body_retention_mb
 FROM accounts
 WHERE is_default = TRUE LIMIT 1
 `
@@ -392,22 +906,30 @@ func (q *Queries) GetDefaultAccount(ctx context.Context) (Account, error) {
 		&i.ImapUsername,
 		&i.ImapUseSsl,
 		&i.ImapAuthMethod,
+		&i.ImapSecurity,
+		&i.ImapCaFile,
+		&i.ImapTlsFingerprint,
 		&i.SmtpServer,
 		&i.SmtpPort,
 		&i.SmtpUsername,
 		&i.SmtpUseTls,
 		&i.SmtpAuthMethod,
+		&i.SecretStore,
+		&i.PasswordCommand,
 		&i.RefreshIntervalMinutes,
+		&i.BodySizeLimitKb,
 		&i.Signature,
 		&i.IsDefault,
 		&i.CreatedAt,
 		&i.UpdatedAt,
+		&i.BodyRetentionDays,
+		&i.BodyRetentionMb,
 	)
 	return i, err
 }
 
 const getEmail = `-- name: GetEmail :one
-SELECT id, uid, thread_id, account_id, folder_id, message_id, from_address, from_name, to_addresses, cc_addresses, bcc_addresses, reference_id, subject, body_text, body_html, received_date, is_read, is_starred, is_draft
+SELECT id, thread_id, account_id, message_id, message_key, from_address, from_name, to_addresses, cc_addresses, bcc_addresses, reference_id, subject, body_text, body_html, received_date, is_read, is_starred, is_draft, size_bytes, body_evicted
 FROM emails
 WHERE id = ? LIMIT 1
 `
@@ -417,11 +939,10 @@ func (q *Queries) GetEmail(ctx context.Context, id int64) (Email, error) {
 	var i Email
 	err := row.Scan(
 		&i.ID,
-		&i.Uid,
 		&i.ThreadID,
 		&i.AccountID,
-		&i.FolderID,
 		&i.MessageID,
+		&i.MessageKey,
 		&i.FromAddress,
 		&i.FromName,
 		&i.ToAddresses,
@@ -435,14 +956,17 @@ func (q *Queries) GetEmail(ctx context.Context, id int64) (Email, error) {
 		&i.IsRead,
 		&i.IsStarred,
 		&i.IsDraft,
+		&i.SizeBytes,
+		&i.BodyEvicted,
 	)
 	return i, err
 }
 
 const getEmailByFolderAndUID = `-- name: GetEmailByFolderAndUID :one
-SELECT id, uid, thread_id, account_id, folder_id, message_id, from_address, from_name, to_addresses, cc_addresses, bcc_addresses, reference_id, subject, body_text, body_html, received_date, is_read, is_starred, is_draft
-FROM emails
-WHERE uid = ? AND folder_id = ?
+SELECT e.id, e.thread_id, e.account_id, e.message_id, e.message_key, e.from_address, e.from_name, e.to_addresses, e.cc_addresses, e.bcc_addresses, e.reference_id, e.subject, e.body_text, e.body_html, e.received_date, e.is_read, e.is_starred, e.is_draft, e.size_bytes, e.body_evicted
+FROM emails e
+         JOIN email_folders ef ON ef.email_id = e.id
+WHERE ef.uid = ? AND ef.folder_id = ?
 LIMIT 1
 `
 
@@ -456,11 +980,10 @@ func (q *Queries) GetEmailByFolderAndUID(ctx context.Context, arg GetEmailByFold
 	var i Email
 	err := row.Scan(
 		&i.ID,
-		&i.Uid,
 		&i.ThreadID,
 		&i.AccountID,
-		&i.FolderID,
 		&i.MessageID,
+		&i.MessageKey,
 		&i.FromAddress,
 		&i.FromName,
 		&i.ToAddresses,
@@ -474,6 +997,8 @@ func (q *Queries) GetEmailByFolderAndUID(ctx context.Context, arg GetEmailByFold
 		&i.IsRead,
 		&i.IsStarred,
 		&i.IsDraft,
+		&i.SizeBytes,
+		&i.BodyEvicted,
 	)
 	return i, err
 }
@@ -482,102 +1007,133 @@ const getEmailByMessageID = `-- name: GetEmailByMessageID :one
 SELECT thread_id,
        message_id
 FROM emails
-WHERE message_id = ?
+WHERE account_id = ?
+  AND message_id = ?
+LIMIT 1
 `
 
+type GetEmailByMessageIDParams struct {
+	AccountID int64
+	MessageID string
+}
+
 type GetEmailByMessageIDRow struct {
 	ThreadID  int64
 	MessageID string
 }
 
-func (q *Queries) GetEmailByMessageID(ctx context.Context, messageID string) (GetEmailByMessageIDRow, error) {
-	row := q.db.QueryRowContext(ctx, getEmailByMessageID, messageID)
+func (q *Queries) GetEmailByMessageID(ctx context.Context, arg GetEmailByMessageIDParams) (GetEmailByMessageIDRow, error) {
+	row := q.db.QueryRowContext(ctx, getEmailByMessageID, arg.AccountID, arg.MessageID)
 	var i GetEmailByMessageIDRow
 	err := row.Scan(&i.ThreadID, &i.MessageID)
 	return i, err
 }
 
-const getEmailsStats = `-- name: GetEmailsStats :one
-SELECT COUNT(*)                                         as total_emails,
-       SUM(CASE WHEN is_read = FALSE THEN 1 ELSE 0 END) as unread_count
+const getEmailByMessageKey = `-- name: GetEmailByMessageKey :one
+SELECT id, thread_id
 FROM emails
 WHERE account_id = ?
-  AND folder_id = ?
+  AND message_key = ?
 `
 
-type GetEmailsStatsParams struct {
-	AccountID int64
-	FolderID  int64
+type GetEmailByMessageKeyParams struct {
+	AccountID  int64
+	MessageKey string
 }
 
-type GetEmailsStatsRow struct {
-	TotalEmails int64
-	UnreadCount sql.NullFloat64
+type GetEmailByMessageKeyRow struct {
+	ID       int64
+	ThreadID int64
 }
 
-func (q *Queries) GetEmailsStats(ctx context.Context, arg GetEmailsStatsParams) (GetEmailsStatsRow, error) {
-	row := q.db.QueryRowContext(ctx, getEmailsStats, arg.AccountID, arg.FolderID)
-	var i GetEmailsStatsRow
-	err := row.Scan(&i.TotalEmails, &i.UnreadCount)
+func (q *Queries) GetEmailByMessageKey(ctx context.Context, arg GetEmailByMessageKeyParams) (GetEmailByMessageKeyRow, error) {
+	row := q.db.QueryRowContext(ctx, getEmailByMessageKey, arg.AccountID, arg.MessageKey)
+	var i GetEmailByMessageKeyRow
+	err := row.Scan(&i.ID, &i.ThreadID)
 	return i, err
 }
 
-const getEmailsWithoutBodies = `-- name: GetEmailsWithoutBodies :many
-SELECT id, uid, folder_id, subject, from_address, received_date
-FROM emails
-WHERE account_id = ?
+const getEmailFolder = `-- name: GetEmailFolder :one
+SELECT email_id, folder_id, uid, is_read, is_starred, is_draft
+FROM email_folders
+WHERE email_id = ?
   AND folder_id = ?
-  AND (body_text IS NULL OR body_text = '')
-ORDER BY received_date DESC LIMIT ?
+ORDER BY uid DESC LIMIT 1
+`
+
+type GetEmailFolderParams struct {
+	EmailID  int64
+	FolderID int64
+}
+
+func (q *Queries) GetEmailFolder(ctx context.Context, arg GetEmailFolderParams) (EmailFolder, error) {
+	row := q.db.QueryRowContext(ctx, getEmailFolder, arg.EmailID, arg.FolderID)
+	var i EmailFolder
+	err := row.Scan(
+		&i.EmailID,
+		&i.FolderID,
+		&i.Uid,
+		&i.IsRead,
+		&i.IsStarred,
+		&i.IsDraft,
+	)
+	return i, err
+}
+
+const getEmailHeader = `-- name: GetEmailHeader :one
+SELECT raw
+FROM email_headers
+WHERE email_id = ?
+`
+
+func (q *Queries) GetEmailHeader(ctx context.Context, emailID int64) ([]byte, error) {
+	row := q.db.QueryRowContext(ctx, getEmailHeader, emailID)
+	var raw []byte
+	err := row.Scan(&raw)
+	return raw, err
+}
+
+const getEmailSource = `-- name: GetEmailSource :one
+SELECT raw
+FROM email_sources
+WHERE email_id = ?
+`
+
+func (q *Queries) GetEmailSource(ctx context.Context, emailID int64) ([]byte, error) {
+	row := q.db.QueryRowContext(ctx, getEmailSource, emailID)
+	var raw []byte
+	err := row.Scan(&raw)
+	return raw, err
+}
+
+const getEmailsStats = `-- name: GetEmailsStats :one
+SELECT COUNT(*)                                           as total_emails,
+       SUM(CASE WHEN e.is_read = FALSE THEN 1 ELSE 0 END) as unread_count
+FROM emails e
+         JOIN email_folders ef ON ef.email_id = e.id
+WHERE e.account_id = ?
+  AND ef.folder_id = ?
 `
 
-type GetEmailsWithoutBodiesParams struct {
+type GetEmailsStatsParams struct {
 	AccountID int64
 	FolderID  int64
-	Limit     int64
 }
 
-type GetEmailsWithoutBodiesRow struct {
-	ID           int64
-	Uid          int64
-	FolderID     int64
-	Subject      string
-	FromAddress  string
-	ReceivedDate time.Time
+type GetEmailsStatsRow struct {
+	TotalEmails int64
+	UnreadCount sql.NullFloat64
 }
 
-func (q *Queries) GetEmailsWithoutBodies(ctx context.Context, arg GetEmailsWithoutBodiesParams) ([]GetEmailsWithoutBodiesRow, error) {
-	rows, err := q.db.QueryContext(ctx, getEmailsWithoutBodies, arg.AccountID, arg.FolderID, arg.Limit)
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-	var items []GetEmailsWithoutBodiesRow
-	for rows.Next() {
-		var i GetEmailsWithoutBodiesRow
-		if err := rows.Scan(
-			&i.ID,
-			&i.Uid,
-			&i.FolderID,
-			&i.Subject,
-			&i.FromAddress,
-			&i.ReceivedDate,
-		); err != nil {
-			return nil, err
-		}
-		items = append(items, i)
-	}
-	if err := rows.Close(); err != nil {
-		return nil, err
-	}
-	if err := rows.Err(); err != nil {
-		return nil, err
-	}
-	return items, nil
+func (q *Queries) GetEmailsStats(ctx context.Context, arg GetEmailsStatsParams) (GetEmailsStatsRow, error) {
+	row := q.db.QueryRowContext(ctx, getEmailsStats, arg.AccountID, arg.FolderID)
+	var i GetEmailsStatsRow
+	err := row.Scan(&i.TotalEmails, &i.UnreadCount)
+	return i, err
 }
 
 const getFolder = `-- name: GetFolder :one
-SELECT id, account_id, name
+SELECT id, account_id, name, last_synced_at, push, local, uid_validity
 FROM folders
 WHERE id = ? LIMIT 1
 `
@@ -585,12 +1141,20 @@ WHERE id = ? LIMIT 1
 func (q *Queries) GetFolder(ctx context.Context, id int64) (Folder, error) {
 	row := q.db.QueryRowContext(ctx, getFolder, id)
 	var i Folder
-	err := row.Scan(&i.ID, &i.AccountID, &i.Name)
+	err := row.Scan(
+		&i.ID,
+		&i.AccountID,
+		&i.Name,
+		&i.LastSyncedAt,
+		&i.Push,
+		&i.Local,
+		&i.UidValidity,
+	)
 	return i, err
 }
 
 const getFolderByName = `-- name: GetFolderByName :one
-SELECT id, account_id, name
+SELECT id, account_id, name, last_synced_at, push, local, uid_validity
 FROM folders
 WHERE name = ? AND account_id = ?
 `
@@ -603,17 +1167,27 @@ type GetFolderByNameParams struct {
 func (q *Queries) GetFolderByName(ctx context.Context, arg GetFolderByNameParams) (Folder, error) {
 	row := q.db.QueryRowContext(ctx, getFolderByName, arg.Name, arg.AccountID)
 	var i Folder
-	err := row.Scan(&i.ID, &i.AccountID, &i.Name)
+	err := row.Scan(
+		&i.ID,
+		&i.AccountID,
+		&i.Name,
+		&i.LastSyncedAt,
+		&i.Push,
+		&i.Local,
+		&i.UidValidity,
+	)
 	return i, err
 }
 
 const getHighestUIDInFolder = `-- name: GetHighestUIDInFolder :one
 SELECT uid
-FROM emails
+FROM email_folders
 WHERE folder_id = ?
+  AND uid > 0
 ORDER BY uid DESC LIMIT 1
 `
 
+// placeholders of moves the server hasn't seen yet have negative uids
 func (q *Queries) GetHighestUIDInFolder(ctx context.Context, folderID int64) (int64, error) {
 	row := q.db.QueryRowContext(ctx, getHighestUIDInFolder, folderID)
 	var uid int64
@@ -621,6 +1195,38 @@ func (q *Queries) GetHighestUIDInFolder(ctx context.Context, folderID int64) (in
 	return uid, err
 }
 
+const getPendingAction = `-- name: GetPendingAction :one
+SELECT id, account_id, kind, email_id, folder_id, folder, uid, uid_validity, target_folder_id, target, value, payload, summary, status, attempts, last_error, next_attempt_at, created_at
+FROM pending_actions
+WHERE id = ?
+`
+
+func (q *Queries) GetPendingAction(ctx context.Context, id int64) (PendingAction, error) {
+	row := q.db.QueryRowContext(ctx, getPendingAction, id)
+	var i PendingAction
+	err := row.Scan(
+		&i.ID,
+		&i.AccountID,
+		&i.Kind,
+		&i.EmailID,
+		&i.FolderID,
+		&i.Folder,
+		&i.Uid,
+		&i.UidValidity,
+		&i.TargetFolderID,
+		&i.Target,
+		&i.Value,
+		&i.Payload,
+		&i.Summary,
+		&i.Status,
+		&i.Attempts,
+		&i.LastError,
+		&i.NextAttemptAt,
+		&i.CreatedAt,
+	)
+	return i, err
+}
+
 const getThread = `-- name: GetThread :one
 SELECT id, account_id, subject, snippet, is_read, is_starred, has_attachments, message_count, latest_message_date
 FROM threads
@@ -646,37 +1252,25 @@ func (q *Queries) GetThread(ctx context.Context, id int64) (Thread, error) {
 
 const getThreadsInFolder = `-- name: GetThreadsInFolder :many
 SELECT t.id, t.account_id, t.subject, t.snippet, t.is_read, t.is_starred, t.has_attachments, t.message_count, t.latest_message_date,
-       folder_emails.folder_count,
-       folder_emails.folder_unread_count,
-       folder_emails.latest_folder_sender,
-       folder_emails.latest_folder_sender_name
-FROM threads t
-         INNER JOIN (SELECT e.thread_id,
-                            COUNT(*)                                         as folder_count,
-                            SUM(CASE WHEN e.is_read = false THEN 1 ELSE 0 END) as folder_unread_count,
-                            CAST((SELECT e2.from_address
-                                  FROM emails e2
-                                  WHERE e2.thread_id = e.thread_id
-                                  ORDER BY e2.received_date DESC
-                                LIMIT 1) AS TEXT) as latest_folder_sender,
-                            CAST((SELECT e2.from_name
-                                  FROM emails e2
-                                  WHERE e2.thread_id = e.thread_id
-                                  ORDER BY e2.received_date DESC
-                                LIMIT 1) AS TEXT) as latest_folder_sender_name
-                     FROM emails e
-                     WHERE e.folder_id = ?
-                     GROUP BY e.thread_id
-) folder_emails ON t.id = folder_emails.thread_id
-WHERE t.account_id = ?
-ORDER BY t.latest_message_date DESC
-    LIMIT ?
+       ft.message_count      AS folder_count,
+       ft.unread_count       AS folder_unread_count,
+       ft.latest_sender      AS latest_folder_sender,
+       ft.latest_sender_name AS latest_folder_sender_name,
+       ft.latest_date        AS folder_latest_date
+FROM folder_threads ft
+         JOIN threads t ON t.id = ft.thread_id
+WHERE ft.folder_id = ?1
+  AND ft.latest_date <= ?2
+  AND (ft.latest_date < ?2 OR ft.thread_id < ?3)
+ORDER BY ft.latest_date DESC, ft.thread_id DESC
+LIMIT ?4
 `
 
 type GetThreadsInFolderParams struct {
-	FolderID  int64
-	AccountID int64
-	Limit     int64
+	FolderID   int64
+	BeforeDate time.Time
+	BeforeID   int64
+	PageSize   int64
 }
 
 type GetThreadsInFolderRow struct {
@@ -690,13 +1284,22 @@ type GetThreadsInFolderRow struct {
 	MessageCount           int64
 	LatestMessageDate      time.Time
 	FolderCount            int64
-	FolderUnreadCount      sql.NullFloat64
+	FolderUnreadCount      int64
 	LatestFolderSender     string
 	LatestFolderSenderName string
+	FolderLatestDate       time.Time
 }
 
+// newest first, a page at a time. the next page starts before the (folder_latest_date, id) of the
+// last thread of this one, the first page starts before a cursor that is after everything. the
+// <= keeps it a range on idx_folder_threads_page, the second condition only sorts out ties.
 func (q *Queries) GetThreadsInFolder(ctx context.Context, arg GetThreadsInFolderParams) ([]GetThreadsInFolderRow, error) {
-	rows, err := q.db.QueryContext(ctx, getThreadsInFolder, arg.FolderID, arg.AccountID, arg.Limit)
+	rows, err := q.db.QueryContext(ctx, getThreadsInFolder,
+		arg.FolderID,
+		arg.BeforeDate,
+		arg.BeforeID,
+		arg.PageSize,
+	)
 	if err != nil {
 		return nil, err
 	}
@@ -718,6 +1321,73 @@ func (q *Queries) GetThreadsInFolder(ctx context.Context, arg GetThreadsInFolder
 			&i.FolderUnreadCount,
 			&i.LatestFolderSender,
 			&i.LatestFolderSenderName,
+			&i.FolderLatestDate,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const isEmailInFolder = `-- name: IsEmailInFolder :one
+SELECT CAST(EXISTS (SELECT 1 FROM email_folders WHERE email_id = ? AND folder_id = ?) AS BOOLEAN) AS in_folder
+`
+
+type IsEmailInFolderParams struct {
+	EmailID  int64
+	FolderID int64
+}
+
+func (q *Queries) IsEmailInFolder(ctx context.Context, arg IsEmailInFolderParams) (bool, error) {
+	row := q.db.QueryRowContext(ctx, isEmailInFolder, arg.EmailID, arg.FolderID)
+	var in_folder bool
+	err := row.Scan(&in_folder)
+	return in_folder, err
+}
+
+const listAccountPendingActions = `-- name: ListAccountPendingActions :many
+SELECT id, account_id, kind, email_id, folder_id, folder, uid, uid_validity, target_folder_id, target, value, payload, summary, status, attempts, last_error, next_attempt_at, created_at
+FROM pending_actions
+WHERE account_id = ?
+ORDER BY id
+`
+
+// the failed and conflicting ones too, the replayer holds back what comes after them
+func (q *Queries) ListAccountPendingActions(ctx context.Context, accountID int64) ([]PendingAction, error) {
+	rows, err := q.db.QueryContext(ctx, listAccountPendingActions, accountID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []PendingAction
+	for rows.Next() {
+		var i PendingAction
+		if err := rows.Scan(
+			&i.ID,
+			&i.AccountID,
+			&i.Kind,
+			&i.EmailID,
+			&i.FolderID,
+			&i.Folder,
+			&i.Uid,
+			&i.UidValidity,
+			&i.TargetFolderID,
+			&i.Target,
+			&i.Value,
+			&i.Payload,
+			&i.Summary,
+			&i.Status,
+			&i.Attempts,
+			&i.LastError,
+			&i.NextAttemptAt,
+			&i.CreatedAt,
 		); err != nil {
 			return nil, err
 		}
@@ -733,7 +1403,7 @@ func (q *Queries) GetThreadsInFolder(ctx context.Context, arg GetThreadsInFolder
 }
 
 const listAccounts = `-- name: ListAccounts :many
-SELECT id, name, display_name, email, imap_server, imap_port, imap_username, imap_use_ssl, imap_auth_method, smtp_server, smtp_port, smtp_username, smtp_use_tls, smtp_auth_method, refresh_interval_minutes, signature, is_default, created_at, updated_at
+SELECT id, name, display_name, email, imap_server, imap_port, imap_username, imap_use_ssl, imap_auth_method, imap_security, imap_ca_file, imap_tls_fingerprint, smtp_server, smtp_port, smtp_username, smtp_use_tls, smtp_auth_method, secret_store, password_command, refresh_interval_minutes, body_size_limit_kb, signature, is_default, created_at, updated_at, body_retention_days, body_retention_mb
 FROM accounts
 ORDER BY name
 `
@@ -757,16 +1427,505 @@ func (q *Queries) ListAccounts(ctx context.Context) ([]Account, error) {
 			&i.ImapUsername,
 			&i.ImapUseSsl,
 			&i.ImapAuthMethod,
+			&i.ImapSecurity,
+			&i.ImapCaFile,
+			&i.ImapTlsFingerprint,
 			&i.SmtpServer,
 			&i.SmtpPort,
 			&i.SmtpUsername,
 			&i.SmtpUseTls,
 			&i.SmtpAuthMethod,
+			&i.SecretStore,
+			&i.PasswordCommand,
 			&i.RefreshIntervalMinutes,
+			&i.BodySizeLimitKb,
 			&i.Signature,
 			&i.IsDefault,
 			&i.CreatedAt,
-			&i.UpdatedAt,
+			&i.UpdatedAt,
+			&i.BodyRetentionDays,
+			&i.BodyRetentionMb,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listAttachmentsByEmail = `-- name: ListAttachmentsByEmail :many
+SELECT id, email_id, filename, mime_type, size_bytes, content, local_path
+FROM attachments
+WHERE email_id = ?
+`
+
+func (q *Queries) ListAttachmentsByEmail(ctx context.Context, emailID int64) ([]Attachment, error) {
+	rows, err := q.db.QueryContext(ctx, listAttachmentsByEmail, emailID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Attachment
+	for rows.Next() {
+		var i Attachment
+		if err := rows.Scan(
+			&i.ID,
+			&i.EmailID,
+			&i.Filename,
+			&i.MimeType,
+			&i.SizeBytes,
+			&i.Content,
+			&i.LocalPath,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listEmailFolders = `-- name: ListEmailFolders :many
+SELECT ef.folder_id, ef.uid, ef.is_read, ef.is_starred, ef.is_draft, f.name, f.local, f.uid_validity
+FROM email_folders ef
+         JOIN folders f ON f.id = ef.folder_id
+WHERE ef.email_id = ?
+ORDER BY ef.folder_id, ef.uid
+`
+
+type ListEmailFoldersRow struct {
+	FolderID    int64
+	Uid         int64
+	IsRead      bool
+	IsStarred   bool
+	IsDraft     bool
+	Name        string
+	Local       bool
+	UidValidity int64
+}
+
+func (q *Queries) ListEmailFolders(ctx context.Context, emailID int64) ([]ListEmailFoldersRow, error) {
+	rows, err := q.db.QueryContext(ctx, listEmailFolders, emailID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ListEmailFoldersRow
+	for rows.Next() {
+		var i ListEmailFoldersRow
+		if err := rows.Scan(
+			&i.FolderID,
+			&i.Uid,
+			&i.IsRead,
+			&i.IsStarred,
+			&i.IsDraft,
+			&i.Name,
+			&i.Local,
+			&i.UidValidity,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listEmailsByFolderAndUIDs = `-- name: ListEmailsByFolderAndUIDs :many
+SELECT e.id, e.thread_id, e.account_id, e.message_id, e.message_key, e.from_address, e.from_name, e.to_addresses, e.cc_addresses, e.bcc_addresses, e.reference_id, e.subject, e.body_text, e.body_html, e.received_date, e.is_read, e.is_starred, e.is_draft, e.size_bytes, e.body_evicted, ef.uid
+FROM emails e
+         JOIN email_folders ef ON ef.email_id = e.id
+WHERE ef.folder_id = ?
+  AND ef.uid IN (/*SLICE:uids*/?)
+ORDER BY e.received_date DESC
+`
+
+type ListEmailsByFolderAndUIDsParams struct {
+	FolderID int64
+	Uids     []int64
+}
+
+type ListEmailsByFolderAndUIDsRow struct {
+	Email Email
+	Uid   int64
+}
+
+func (q *Queries) ListEmailsByFolderAndUIDs(ctx context.Context, arg ListEmailsByFolderAndUIDsParams) ([]ListEmailsByFolderAndUIDsRow, error) {
+	query := listEmailsByFolderAndUIDs
+	var queryParams []interface{}
+	queryParams = append(queryParams, arg.FolderID)
+	if len(arg.Uids) > 0 {
+		for _, v := range arg.Uids {
+			queryParams = append(queryParams, v)
+		}
+		query = strings.Replace(query, "/*SLICE:uids*/?", strings.Repeat(",?", len(arg.Uids))[1:], 1)
+	} else {
+		query = strings.Replace(query, "/*SLICE:uids*/?", "NULL", 1)
+	}
+	rows, err := q.db.QueryContext(ctx, query, queryParams...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ListEmailsByFolderAndUIDsRow
+	for rows.Next() {
+		var i ListEmailsByFolderAndUIDsRow
+		if err := rows.Scan(
+			&i.Email.ID,
+			&i.Email.ThreadID,
+			&i.Email.AccountID,
+			&i.Email.MessageID,
+			&i.Email.MessageKey,
+			&i.Email.FromAddress,
+			&i.Email.FromName,
+			&i.Email.ToAddresses,
+			&i.Email.CcAddresses,
+			&i.Email.BccAddresses,
+			&i.Email.ReferenceID,
+			&i.Email.Subject,
+			&i.Email.BodyText,
+			&i.Email.BodyHtml,
+			&i.Email.ReceivedDate,
+			&i.Email.IsRead,
+			&i.Email.IsStarred,
+			&i.Email.IsDraft,
+			&i.Email.SizeBytes,
+			&i.Email.BodyEvicted,
+			&i.Uid,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listEmailsByThread = `-- name: ListEmailsByThread :many
+SELECT id, thread_id, account_id, message_id, message_key, from_address, from_name, to_addresses, cc_addresses, bcc_addresses, reference_id, subject, body_text, body_html, received_date, is_read, is_starred, is_draft, size_bytes, body_evicted
+FROM emails
+WHERE thread_id = ?
+ORDER BY received_date DESC
+`
+
+func (q *Queries) ListEmailsByThread(ctx context.Context, threadID int64) ([]Email, error) {
+	rows, err := q.db.QueryContext(ctx, listEmailsByThread, threadID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Email
+	for rows.Next() {
+		var i Email
+		if err := rows.Scan(
+			&i.ID,
+			&i.ThreadID,
+			&i.AccountID,
+			&i.MessageID,
+			&i.MessageKey,
+			&i.FromAddress,
+			&i.FromName,
+			&i.ToAddresses,
+			&i.CcAddresses,
+			&i.BccAddresses,
+			&i.ReferenceID,
+			&i.Subject,
+			&i.BodyText,
+			&i.BodyHtml,
+			&i.ReceivedDate,
+			&i.IsRead,
+			&i.IsStarred,
+			&i.IsDraft,
+			&i.SizeBytes,
+			&i.BodyEvicted,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listEmailsWithoutBodies = `-- name: ListEmailsWithoutBodies :many
+SELECT e.id, ef.uid, ef.folder_id, f.name AS folder_name, e.size_bytes
+FROM emails e
+         JOIN email_folders ef ON ef.email_id = e.id
+    AND ef.folder_id = (SELECT MIN(ef2.folder_id)
+                        FROM email_folders ef2
+                                 JOIN folders f2 ON f2.id = ef2.folder_id
+                        WHERE ef2.email_id = e.id
+                          AND f2.local = FALSE
+                          AND ef2.uid > 0)
+         JOIN folders f ON f.id = ef.folder_id
+WHERE e.account_id = ?
+  AND e.body_text IS NULL
+  AND e.body_evicted = FALSE
+ORDER BY e.received_date DESC, e.id DESC LIMIT ? OFFSET ?
+`
+
+type ListEmailsWithoutBodiesParams struct {
+	AccountID int64
+	Limit     int64
+	Offset    int64
+}
+
+type ListEmailsWithoutBodiesRow struct {
+	ID         int64
+	Uid        int64
+	FolderID   int64
+	FolderName string
+	SizeBytes  int64
+}
+
+// every email is fetched from one folder on the server that has it, with a uid we know
+func (q *Queries) ListEmailsWithoutBodies(ctx context.Context, arg ListEmailsWithoutBodiesParams) ([]ListEmailsWithoutBodiesRow, error) {
+	rows, err := q.db.QueryContext(ctx, listEmailsWithoutBodies, arg.AccountID, arg.Limit, arg.Offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ListEmailsWithoutBodiesRow
+	for rows.Next() {
+		var i ListEmailsWithoutBodiesRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.Uid,
+			&i.FolderID,
+			&i.FolderName,
+			&i.SizeBytes,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listEncryptionKeys = `-- name: ListEncryptionKeys :many
+SELECT id, backend, wrapped, "current", created_at
+FROM encryption_keys
+ORDER BY id
+`
+
+func (q *Queries) ListEncryptionKeys(ctx context.Context) ([]EncryptionKey, error) {
+	rows, err := q.db.QueryContext(ctx, listEncryptionKeys)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []EncryptionKey
+	for rows.Next() {
+		var i EncryptionKey
+		if err := rows.Scan(
+			&i.ID,
+			&i.Backend,
+			&i.Wrapped,
+			&i.Current,
+			&i.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listEvictableBodies = `-- name: ListEvictableBodies :many
+SELECT e.id,
+       CAST(COALESCE(length(CAST(e.body_text AS BLOB)), 0) +
+            COALESCE(length(CAST(e.body_html AS BLOB)), 0) +
+            COALESCE((SELECT length(s.raw) FROM email_sources s WHERE s.email_id = e.id), 0) +
+            COALESCE((SELECT SUM(length(a.content)) FROM attachments a WHERE a.email_id = e.id), 0)
+           AS INTEGER) AS stored_bytes
+FROM emails e
+WHERE e.account_id = ?
+  AND e.body_text IS NOT NULL
+  AND e.is_starred = FALSE
+  AND e.thread_id NOT IN (SELECT t.id FROM threads t WHERE t.is_starred = TRUE)
+  AND e.id NOT IN (SELECT lef.email_id
+                   FROM email_folders lef
+                            JOIN folders lf ON lf.id = lef.folder_id
+                   WHERE lf.local = TRUE)
+ORDER BY e.received_date LIMIT ?
+`
+
+type ListEvictableBodiesParams struct {
+	AccountID int64
+	Limit     int64
+}
+
+type ListEvictableBodiesRow struct {
+	ID          int64
+	StoredBytes int64
+}
+
+// the oldest stored bodies of the account with what they, their source and their attachments take up
+func (q *Queries) ListEvictableBodies(ctx context.Context, arg ListEvictableBodiesParams) ([]ListEvictableBodiesRow, error) {
+	rows, err := q.db.QueryContext(ctx, listEvictableBodies, arg.AccountID, arg.Limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ListEvictableBodiesRow
+	for rows.Next() {
+		var i ListEvictableBodiesRow
+		if err := rows.Scan(&i.ID, &i.StoredBytes); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listFolderEmailIDs = `-- name: ListFolderEmailIDs :many
+SELECT e.id
+FROM emails e
+         JOIN email_folders ef ON ef.email_id = e.id
+WHERE ef.folder_id = ?
+ORDER BY e.received_date, e.id
+`
+
+// oldest first, the order an archive is read in
+func (q *Queries) ListFolderEmailIDs(ctx context.Context, folderID int64) ([]int64, error) {
+	rows, err := q.db.QueryContext(ctx, listFolderEmailIDs, folderID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		items = append(items, id)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listFolders = `-- name: ListFolders :many
+SELECT id, account_id, name, last_synced_at, push, local, uid_validity
+FROM folders
+WHERE account_id = ?
+ORDER BY name
+`
+
+func (q *Queries) ListFolders(ctx context.Context, accountID int64) ([]Folder, error) {
+	rows, err := q.db.QueryContext(ctx, listFolders, accountID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Folder
+	for rows.Next() {
+		var i Folder
+		if err := rows.Scan(
+			&i.ID,
+			&i.AccountID,
+			&i.Name,
+			&i.LastSyncedAt,
+			&i.Push,
+			&i.Local,
+			&i.UidValidity,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listPendingActions = `-- name: ListPendingActions :many
+SELECT id, account_id, kind, email_id, folder_id, folder, uid, uid_validity, target_folder_id, target, value, payload, summary, status, attempts, last_error, next_attempt_at, created_at
+FROM pending_actions
+ORDER BY id
+`
+
+func (q *Queries) ListPendingActions(ctx context.Context) ([]PendingAction, error) {
+	rows, err := q.db.QueryContext(ctx, listPendingActions)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []PendingAction
+	for rows.Next() {
+		var i PendingAction
+		if err := rows.Scan(
+			&i.ID,
+			&i.AccountID,
+			&i.Kind,
+			&i.EmailID,
+			&i.FolderID,
+			&i.Folder,
+			&i.Uid,
+			&i.UidValidity,
+			&i.TargetFolderID,
+			&i.Target,
+			&i.Value,
+			&i.Payload,
+			&i.Summary,
+			&i.Status,
+			&i.Attempts,
+			&i.LastError,
+			&i.NextAttemptAt,
+			&i.CreatedAt,
 		); err != nil {
 			return nil, err
 		}
@@ -781,33 +1940,27 @@ func (q *Queries) ListAccounts(ctx context.Context) ([]Account, error) {
 	return items, nil
 }
 
-const listAttachmentsByEmail = `-- name: ListAttachmentsByEmail :many
-SELECT id, email_id, filename, mime_type, size_bytes, content, local_path
-FROM attachments
-WHERE email_id = ?
+const listPushFolders = `-- name: ListPushFolders :many
+SELECT name
+FROM folders
+WHERE account_id = ?
+  AND push = TRUE
+ORDER BY name
 `
 
-func (q *Queries) ListAttachmentsByEmail(ctx context.Context, emailID int64) ([]Attachment, error) {
-	rows, err := q.db.QueryContext(ctx, listAttachmentsByEmail, emailID)
+func (q *Queries) ListPushFolders(ctx context.Context, accountID int64) ([]string, error) {
+	rows, err := q.db.QueryContext(ctx, listPushFolders, accountID)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
-	var items []Attachment
+	var items []string
 	for rows.Next() {
-		var i Attachment
-		if err := rows.Scan(
-			&i.ID,
-			&i.EmailID,
-			&i.Filename,
-			&i.MimeType,
-			&i.SizeBytes,
-			&i.Content,
-			&i.LocalPath,
-		); err != nil {
+		var name string
+		if err := rows.Scan(&name); err != nil {
 			return nil, err
 		}
-		items = append(items, i)
+		items = append(items, name)
 	}
 	if err := rows.Close(); err != nil {
 		return nil, err
@@ -818,42 +1971,28 @@ func (q *Queries) ListAttachmentsByEmail(ctx context.Context, emailID int64) ([]
 	return items, nil
 }
 
-const listEmailsByThread = `-- name: ListEmailsByThread :many
-SELECT id, uid, thread_id, account_id, folder_id, message_id, from_address, from_name, to_addresses, cc_addresses, bcc_addresses, reference_id, subject, body_text, body_html, received_date, is_read, is_starred, is_draft
-FROM emails
-WHERE thread_id = ?
-ORDER BY received_date DESC
+const listSavedSearches = `-- name: ListSavedSearches :many
+SELECT id, account_id, name, "query", created_at
+FROM saved_searches
+WHERE account_id = ?
+ORDER BY name
 `
 
-func (q *Queries) ListEmailsByThread(ctx context.Context, threadID int64) ([]Email, error) {
-	rows, err := q.db.QueryContext(ctx, listEmailsByThread, threadID)
+func (q *Queries) ListSavedSearches(ctx context.Context, accountID int64) ([]SavedSearch, error) {
+	rows, err := q.db.QueryContext(ctx, listSavedSearches, accountID)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
-	var items []Email
+	var items []SavedSearch
 	for rows.Next() {
-		var i Email
+		var i SavedSearch
 		if err := rows.Scan(
 			&i.ID,
-			&i.Uid,
-			&i.ThreadID,
 			&i.AccountID,
-			&i.FolderID,
-			&i.MessageID,
-			&i.FromAddress,
-			&i.FromName,
-			&i.ToAddresses,
-			&i.CcAddresses,
-			&i.BccAddresses,
-			&i.ReferenceID,
-			&i.Subject,
-			&i.BodyText,
-			&i.BodyHtml,
-			&i.ReceivedDate,
-			&i.IsRead,
-			&i.IsStarred,
-			&i.IsDraft,
+			&i.Name,
+			&i.Query,
+			&i.CreatedAt,
 		); err != nil {
 			return nil, err
 		}
@@ -868,23 +2007,52 @@ func (q *Queries) ListEmailsByThread(ctx context.Context, threadID int64) ([]Ema
 	return items, nil
 }
 
-const listFolders = `-- name: ListFolders :many
-SELECT id, account_id, name
-FROM folders
-WHERE account_id = ?
-ORDER BY name
+const listThreadEmailsWithoutBodies = `-- name: ListThreadEmailsWithoutBodies :many
+SELECT e.id, ef.uid, ef.folder_id, f.name AS folder_name, e.size_bytes
+FROM emails e
+         JOIN email_folders ef ON ef.email_id = e.id
+    AND ef.folder_id = (SELECT MIN(ef2.folder_id)
+                        FROM email_folders ef2
+                                 JOIN folders f2 ON f2.id = ef2.folder_id
+                        WHERE ef2.email_id = e.id
+                          AND f2.local = FALSE
+                          AND ef2.uid > 0)
+         JOIN folders f ON f.id = ef.folder_id
+WHERE e.account_id = ?
+  AND e.thread_id = ?
+  AND e.body_text IS NULL
+ORDER BY e.received_date DESC
 `
 
-func (q *Queries) ListFolders(ctx context.Context, accountID int64) ([]Folder, error) {
-	rows, err := q.db.QueryContext(ctx, listFolders, accountID)
+type ListThreadEmailsWithoutBodiesParams struct {
+	AccountID int64
+	ThreadID  int64
+}
+
+type ListThreadEmailsWithoutBodiesRow struct {
+	ID         int64
+	Uid        int64
+	FolderID   int64
+	FolderName string
+	SizeBytes  int64
+}
+
+func (q *Queries) ListThreadEmailsWithoutBodies(ctx context.Context, arg ListThreadEmailsWithoutBodiesParams) ([]ListThreadEmailsWithoutBodiesRow, error) {
+	rows, err := q.db.QueryContext(ctx, listThreadEmailsWithoutBodies, arg.AccountID, arg.ThreadID)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
-	var items []Folder
+	var items []ListThreadEmailsWithoutBodiesRow
 	for rows.Next() {
-		var i Folder
-		if err := rows.Scan(&i.ID, &i.AccountID, &i.Name); err != nil {
+		var i ListThreadEmailsWithoutBodiesRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.Uid,
+			&i.FolderID,
+			&i.FolderName,
+			&i.SizeBytes,
+		); err != nil {
 			return nil, err
 		}
 		items = append(items, i)
@@ -920,94 +2088,124 @@ func (q *Queries) MarkThreadRead(ctx context.Context, id int64) error {
 	return err
 }
 
-const moveEmail = `-- name: MoveEmail :exec
-UPDATE emails
-SET folder_id = ?
-WHERE id = ?
+const removeEmailFromFolder = `-- name: RemoveEmailFromFolder :exec
+DELETE
+FROM email_folders
+WHERE folder_id = ?
+  AND uid = ?
 `
 
-type MoveEmailParams struct {
+type RemoveEmailFromFolderParams struct {
 	FolderID int64
-	ID       int64
+	Uid      int64
 }
 
-func (q *Queries) MoveEmail(ctx context.Context, arg MoveEmailParams) error {
-	_, err := q.db.ExecContext(ctx, moveEmail, arg.FolderID, arg.ID)
+func (q *Queries) RemoveEmailFromFolder(ctx context.Context, arg RemoveEmailFromFolderParams) error {
+	_, err := q.db.ExecContext(ctx, removeEmailFromFolder, arg.FolderID, arg.Uid)
 	return err
 }
 
-const searchEmails = `-- name: SearchEmails :many
-SELECT e.id, e.uid, e.thread_id, e.account_id, e.folder_id, e.message_id, e.from_address, e.from_name, e.to_addresses, e.cc_addresses, e.bcc_addresses, e.reference_id, e.subject, e.body_text, e.body_html, e.received_date, e.is_read, e.is_starred, e.is_draft
-FROM emails e
-         JOIN threads t ON e.thread_id = t.id
-WHERE (e.subject LIKE '%' || ? || '%'
-    OR e.body_text LIKE '%' || ? || '%'
-    OR e.from_address LIKE '%' || ? || '%'
-    OR e.from_name LIKE '%' || ? || '%')
-  AND e.account_id = ?
-ORDER BY e.received_date DESC LIMIT ?
-OFFSET ?
-`
-
-type SearchEmailsParams struct {
-	Column1   sql.NullString
-	Column2   sql.NullString
-	Column3   sql.NullString
-	Column4   sql.NullString
+const removePlaceholders = `-- name: RemovePlaceholders :exec
+DELETE
+FROM email_folders
+WHERE email_id = ?
+  AND folder_id = ?
+  AND uid < 0
+`
+
+type RemovePlaceholdersParams struct {
+	EmailID  int64
+	FolderID int64
+}
+
+// a moved message sits in its new folder under a negative uid until the sync finds the real copy
+func (q *Queries) RemovePlaceholders(ctx context.Context, arg RemovePlaceholdersParams) error {
+	_, err := q.db.ExecContext(ctx, removePlaceholders, arg.EmailID, arg.FolderID)
+	return err
+}
+
+const retryPendingAction = `-- name: RetryPendingAction :exec
+UPDATE pending_actions
+SET status          = 'pending',
+    attempts        = 0,
+    last_error      = '',
+    next_attempt_at = ?
+WHERE id = ?
+`
+
+type RetryPendingActionParams struct {
+	NextAttemptAt time.Time
+	ID            int64
+}
+
+func (q *Queries) RetryPendingAction(ctx context.Context, arg RetryPendingActionParams) error {
+	_, err := q.db.ExecContext(ctx, retryPendingAction, arg.NextAttemptAt, arg.ID)
+	return err
+}
+
+const setCurrentEncryptionKey = `-- name: SetCurrentEncryptionKey :exec
+UPDATE encryption_keys
+SET current = (id = ?)
+`
+
+func (q *Queries) SetCurrentEncryptionKey(ctx context.Context, id int64) error {
+	_, err := q.db.ExecContext(ctx, setCurrentEncryptionKey, id)
+	return err
+}
+
+const setFolderPush = `-- name: SetFolderPush :execrows
+UPDATE folders
+SET push = TRUE
+WHERE account_id = ?
+  AND name = ?
+  AND local = FALSE
+`
+
+type SetFolderPushParams struct {
 	AccountID int64
-	Limit     int64
-	Offset    int64
+	Name      string
 }
 
-func (q *Queries) SearchEmails(ctx context.Context, arg SearchEmailsParams) ([]Email, error) {
-	rows, err := q.db.QueryContext(ctx, searchEmails,
-		arg.Column1,
-		arg.Column2,
-		arg.Column3,
-		arg.Column4,
-		arg.AccountID,
-		arg.Limit,
-		arg.Offset,
-	)
+func (q *Queries) SetFolderPush(ctx context.Context, arg SetFolderPushParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, setFolderPush, arg.AccountID, arg.Name)
 	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-	var items []Email
-	for rows.Next() {
-		var i Email
-		if err := rows.Scan(
-			&i.ID,
-			&i.Uid,
-			&i.ThreadID,
-			&i.AccountID,
-			&i.FolderID,
-			&i.MessageID,
-			&i.FromAddress,
-			&i.FromName,
-			&i.ToAddresses,
-			&i.CcAddresses,
-			&i.BccAddresses,
-			&i.ReferenceID,
-			&i.Subject,
-			&i.BodyText,
-			&i.BodyHtml,
-			&i.ReceivedDate,
-			&i.IsRead,
-			&i.IsStarred,
-			&i.IsDraft,
-		); err != nil {
-			return nil, err
-		}
-		items = append(items, i)
-	}
-	if err := rows.Close(); err != nil {
-		return nil, err
+		return 0, err
 	}
-	if err := rows.Err(); err != nil {
-		return nil, err
-	}
-	return items, nil
+	return result.RowsAffected()
+}
+
+const setFolderUIDValidity = `-- name: SetFolderUIDValidity :exec
+UPDATE folders
+SET uid_validity = ?
+WHERE id = ?
+`
+
+type SetFolderUIDValidityParams struct {
+	UidValidity int64
+	ID          int64
+}
+
+func (q *Queries) SetFolderUIDValidity(ctx context.Context, arg SetFolderUIDValidityParams) error {
+	_, err := q.db.ExecContext(ctx, setFolderUIDValidity, arg.UidValidity, arg.ID)
+	return err
+}
+
+const setPendingActionKind = `-- name: SetPendingActionKind :exec
+UPDATE pending_actions
+SET kind       = ?,
+    attempts   = 0,
+    last_error = ''
+WHERE id = ?
+`
+
+type SetPendingActionKindParams struct {
+	Kind string
+	ID   int64
+}
+
+func (q *Queries) SetPendingActionKind(ctx context.Context, arg SetPendingActionKindParams) error {
+	_, err := q.db.ExecContext(ctx, setPendingActionKind, arg.Kind, arg.ID)
+	return err
 }
 
 const toggleEmailStarred = `-- name: ToggleEmailStarred :one
@@ -1046,16 +2244,24 @@ SET name                     = ?,
     imap_username            = ?,
     imap_use_ssl             = ?,
     imap_auth_method         = ?,
+    imap_security            = ?,
+    imap_ca_file             = ?,
+    imap_tls_fingerprint     = ?,
     smtp_server              = ?,
     smtp_port                = ?,
     smtp_username            = ?,
     smtp_use_tls             = ?,
     smtp_auth_method         = ?,
+    secret_store             = ?,
+    password_command         = ?,
     refresh_interval_minutes = ?,
+    body_size_limit_kb       = ?,
+    body_retention_days      = ?,
+    body_retention_mb        = ?,
     signature                = ?,
     is_default               = ?,
     updated_at               = CURRENT_TIMESTAMP
-WHERE id = ? RETURNING id, name, display_name, email, imap_server, imap_port, imap_username, imap_use_ssl, imap_auth_method, smtp_server, smtp_port, smtp_username, smtp_use_tls, smtp_auth_method, refresh_interval_minutes, signature, is_default, created_at, updated_at
+WHERE id = ? RETURNING id, name, display_name, email, imap_server, imap_port, imap_username, imap_use_ssl, imap_auth_method, imap_security, imap_ca_file, imap_tls_fingerprint, smtp_server, smtp_port, smtp_username, smtp_use_tls, smtp_auth_method, secret_store, password_command, refresh_interval_minutes, body_size_limit_kb, signature, is_default, created_at, updated_at, body_retention_days, body_retention_mb
 `
 
 type UpdateAccountParams struct {
@@ -1067,12 +2273,20 @@ type UpdateAccountParams struct {
 	ImapUsername           string
 	ImapUseSsl             bool
 	ImapAuthMethod         string
+	ImapSecurity           string
+	ImapCaFile             sql.NullString
+	ImapTlsFingerprint     sql.NullString
 	SmtpServer             string
 	SmtpPort               int64
 	SmtpUsername           string
 	SmtpUseTls             bool
 	SmtpAuthMethod         string
+	SecretStore            string
+	PasswordCommand        sql.NullString
 	RefreshIntervalMinutes int64
+	BodySizeLimitKb        int64
+	BodyRetentionDays      int64
+	BodyRetentionMb        int64
 	Signature              sql.NullString
 	IsDefault              bool
 	ID                     int64
@@ -1088,12 +2302,20 @@ func (q *Queries) UpdateAccount(ctx context.Context, arg UpdateAccountParams) (A
 		arg.ImapUsername,
 		arg.ImapUseSsl,
 		arg.ImapAuthMethod,
+		arg.ImapSecurity,
+		arg.ImapCaFile,
+		arg.ImapTlsFingerprint,
 		arg.SmtpServer,
 		arg.SmtpPort,
 		arg.SmtpUsername,
 		arg.SmtpUseTls,
 		arg.SmtpAuthMethod,
+		arg.SecretStore,
+		arg.PasswordCommand,
 		arg.RefreshIntervalMinutes,
+		arg.BodySizeLimitKb,
+		arg.BodyRetentionDays,
+		arg.BodyRetentionMb,
 		arg.Signature,
 		arg.IsDefault,
 		arg.ID,
@@ -1109,16 +2331,24 @@ func (q *Queries) UpdateAccount(ctx context.Context, arg UpdateAccountParams) (A
 		&i.ImapUsername,
 		&i.ImapUseSsl,
 		&i.ImapAuthMethod,
+		&i.ImapSecurity,
+		&i.ImapCaFile,
+		&i.ImapTlsFingerprint,
 		&i.SmtpServer,
 		&i.SmtpPort,
 		&i.SmtpUsername,
 		&i.SmtpUseTls,
 		&i.SmtpAuthMethod,
+		&i.SecretStore,
+		&i.PasswordCommand,
 		&i.RefreshIntervalMinutes,
+		&i.BodySizeLimitKb,
 		&i.Signature,
 		&i.IsDefault,
 		&i.CreatedAt,
 		&i.UpdatedAt,
+		&i.BodyRetentionDays,
+		&i.BodyRetentionMb,
 	)
 	return i, err
 }
@@ -1151,16 +2381,14 @@ func (q *Queries) UpdateAttachment(ctx context.Context, arg UpdateAttachmentPara
 
 const updateEmail = `-- name: UpdateEmail :one
 UPDATE emails
-SET folder_id  = ?,
-    is_read    = ?,
+SET is_read    = ?,
     is_starred = ?,
     is_draft   = ?,
     body_text  = ?
-WHERE id = ? RETURNING id, uid, thread_id, account_id, folder_id, message_id, from_address, from_name, to_addresses, cc_addresses, bcc_addresses, reference_id, subject, body_text, body_html, received_date, is_read, is_starred, is_draft
+WHERE id = ? RETURNING id, thread_id, account_id, message_id, message_key, from_address, from_name, to_addresses, cc_addresses, bcc_addresses, reference_id, subject, body_text, body_html, received_date, is_read, is_starred, is_draft, size_bytes, body_evicted
 `
 
 type UpdateEmailParams struct {
-	FolderID  int64
 	IsRead    bool
 	IsStarred bool
 	IsDraft   bool
@@ -1170,7 +2398,6 @@ type UpdateEmailParams struct {
 
 func (q *Queries) UpdateEmail(ctx context.Context, arg UpdateEmailParams) (Email, error) {
 	row := q.db.QueryRowContext(ctx, updateEmail,
-		arg.FolderID,
 		arg.IsRead,
 		arg.IsStarred,
 		arg.IsDraft,
@@ -1180,11 +2407,10 @@ func (q *Queries) UpdateEmail(ctx context.Context, arg UpdateEmailParams) (Email
 	var i Email
 	err := row.Scan(
 		&i.ID,
-		&i.Uid,
 		&i.ThreadID,
 		&i.AccountID,
-		&i.FolderID,
 		&i.MessageID,
+		&i.MessageKey,
 		&i.FromAddress,
 		&i.FromName,
 		&i.ToAddresses,
@@ -1198,14 +2424,16 @@ func (q *Queries) UpdateEmail(ctx context.Context, arg UpdateEmailParams) (Email
 		&i.IsRead,
 		&i.IsStarred,
 		&i.IsDraft,
+		&i.SizeBytes,
+		&i.BodyEvicted,
 	)
 	return i, err
 }
 
 const updateEmailBodyAndReferences = `-- name: UpdateEmailBodyAndReferences :one
 UPDATE emails
-SET body_text = ?, reference_id = ?
-WHERE id = ? RETURNING id, uid, thread_id, account_id, folder_id, message_id, from_address, from_name, to_addresses, cc_addresses, bcc_addresses, reference_id, subject, body_text, body_html, received_date, is_read, is_starred, is_draft
+SET body_text = ?, reference_id = ?, body_evicted = FALSE
+WHERE id = ? RETURNING id, thread_id, account_id, message_id, message_key, from_address, from_name, to_addresses, cc_addresses, bcc_addresses, reference_id, subject, body_text, body_html, received_date, is_read, is_starred, is_draft, size_bytes, body_evicted
 `
 
 type UpdateEmailBodyAndReferencesParams struct {
@@ -1219,11 +2447,10 @@ func (q *Queries) UpdateEmailBodyAndReferences(ctx context.Context, arg UpdateEm
 	var i Email
 	err := row.Scan(
 		&i.ID,
-		&i.Uid,
 		&i.ThreadID,
 		&i.AccountID,
-		&i.FolderID,
 		&i.MessageID,
+		&i.MessageKey,
 		&i.FromAddress,
 		&i.FromName,
 		&i.ToAddresses,
@@ -1237,14 +2464,39 @@ func (q *Queries) UpdateEmailBodyAndReferences(ctx context.Context, arg UpdateEm
 		&i.IsRead,
 		&i.IsStarred,
 		&i.IsDraft,
+		&i.SizeBytes,
+		&i.BodyEvicted,
 	)
 	return i, err
 }
 
+const updateEmailFlags = `-- name: UpdateEmailFlags :exec
+UPDATE emails
+SET is_read = ?, is_starred = ?, is_draft = ?
+WHERE id = ?
+`
+
+type UpdateEmailFlagsParams struct {
+	IsRead    bool
+	IsStarred bool
+	IsDraft   bool
+	ID        int64
+}
+
+func (q *Queries) UpdateEmailFlags(ctx context.Context, arg UpdateEmailFlagsParams) error {
+	_, err := q.db.ExecContext(ctx, updateEmailFlags,
+		arg.IsRead,
+		arg.IsStarred,
+		arg.IsDraft,
+		arg.ID,
+	)
+	return err
+}
+
 const updateFolder = `-- name: UpdateFolder :one
 UPDATE folders
 SET name = ?
-WHERE id = ? RETURNING id, account_id, name
+WHERE id = ? RETURNING id, account_id, name, last_synced_at, push, local, uid_validity
 `
 
 type UpdateFolderParams struct {
@@ -1255,10 +2507,89 @@ type UpdateFolderParams struct {
 func (q *Queries) UpdateFolder(ctx context.Context, arg UpdateFolderParams) (Folder, error) {
 	row := q.db.QueryRowContext(ctx, updateFolder, arg.Name, arg.ID)
 	var i Folder
-	err := row.Scan(&i.ID, &i.AccountID, &i.Name)
+	err := row.Scan(
+		&i.ID,
+		&i.AccountID,
+		&i.Name,
+		&i.LastSyncedAt,
+		&i.Push,
+		&i.Local,
+		&i.UidValidity,
+	)
 	return i, err
 }
 
+const updateFolderEmailFlags = `-- name: UpdateFolderEmailFlags :one
+UPDATE email_folders
+SET is_read = ?, is_starred = ?, is_draft = ?
+WHERE folder_id = ? AND uid = ? RETURNING email_id
+`
+
+type UpdateFolderEmailFlagsParams struct {
+	IsRead    bool
+	IsStarred bool
+	IsDraft   bool
+	FolderID  int64
+	Uid       int64
+}
+
+func (q *Queries) UpdateFolderEmailFlags(ctx context.Context, arg UpdateFolderEmailFlagsParams) (int64, error) {
+	row := q.db.QueryRowContext(ctx, updateFolderEmailFlags,
+		arg.IsRead,
+		arg.IsStarred,
+		arg.IsDraft,
+		arg.FolderID,
+		arg.Uid,
+	)
+	var email_id int64
+	err := row.Scan(&email_id)
+	return email_id, err
+}
+
+const updateFolderLastSynced = `-- name: UpdateFolderLastSynced :exec
+UPDATE folders
+SET last_synced_at = ?
+WHERE id = ?
+`
+
+type UpdateFolderLastSyncedParams struct {
+	LastSyncedAt sql.NullTime
+	ID           int64
+}
+
+func (q *Queries) UpdateFolderLastSynced(ctx context.Context, arg UpdateFolderLastSyncedParams) error {
+	_, err := q.db.ExecContext(ctx, updateFolderLastSynced, arg.LastSyncedAt, arg.ID)
+	return err
+}
+
+const updatePendingAction = `-- name: UpdatePendingAction :exec
+UPDATE pending_actions
+SET status          = ?,
+    attempts        = ?,
+    last_error      = ?,
+    next_attempt_at = ?
+WHERE id = ?
+`
+
+type UpdatePendingActionParams struct {
+	Status        string
+	Attempts      int64
+	LastError     string
+	NextAttemptAt time.Time
+	ID            int64
+}
+
+func (q *Queries) UpdatePendingAction(ctx context.Context, arg UpdatePendingActionParams) error {
+	_, err := q.db.ExecContext(ctx, updatePendingAction,
+		arg.Status,
+		arg.Attempts,
+		arg.LastError,
+		arg.NextAttemptAt,
+		arg.ID,
+	)
+	return err
+}
+
 const updateThread = `-- name: UpdateThread :one
 UPDATE threads
 SET subject             = ?,
@@ -1320,3 +2651,35 @@ func (q *Queries) UpdateThreadMessageCount(ctx context.Context, id int64) error
 	_, err := q.db.ExecContext(ctx, updateThreadMessageCount, id)
 	return err
 }
+
+const upsertEmailHeader = `-- name: UpsertEmailHeader :exec
+INSERT INTO email_headers (email_id, raw)
+VALUES (?, ?)
+ON CONFLICT (email_id) DO UPDATE SET raw = excluded.raw
+`
+
+type UpsertEmailHeaderParams struct {
+	EmailID int64
+	Raw     []byte
+}
+
+func (q *Queries) UpsertEmailHeader(ctx context.Context, arg UpsertEmailHeaderParams) error {
+	_, err := q.db.ExecContext(ctx, upsertEmailHeader, arg.EmailID, arg.Raw)
+	return err
+}
+
+const upsertEmailSource = `-- name: UpsertEmailSource :exec
+INSERT INTO email_sources (email_id, raw)
+VALUES (?, ?)
+ON CONFLICT (email_id) DO UPDATE SET raw = excluded.raw
+`
+
+type UpsertEmailSourceParams struct {
+	EmailID int64
+	Raw     []byte
+}
+
+func (q *Queries) UpsertEmailSource(ctx context.Context, arg UpsertEmailSourceParams) error {
+	_, err := q.db.ExecContext(ctx, upsertEmailSource, arg.EmailID, arg.Raw)
+	return err
+}
@@ -0,0 +1,294 @@
+package db
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"database/sql/driver"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"maps"
+	"modernc.org/sqlite"
+	"strings"
+	"sync"
+)
+
+// a sealed value is sealMagic, the id of the key, whether it was text or a blob, the place it is
+// sealed for, the nonce and the aes-gcm ciphertext. anything without the magic in front is
+// plaintext. the place is table.column.row, with the header authenticated a value can't be moved
+// to another row or column without clmail_open noticing.
+var sealMagic = []byte("\x00clmail-sealed\x00")
+
+const (
+	sealedText = 't'
+	sealedBlob = 'b'
+	// KeySize is the length of a data key, aes-256
+	KeySize = 32
+)
+
+var (
+	// ErrLocked means the database is encrypted and its key wasn't handed over with UseKeys
+	ErrLocked = errors.New("the database is encrypted and locked")
+	// ErrUnknownKey is a value sealed with a key that was rotated away
+	ErrUnknownKey = errors.New("value is sealed with an unknown key")
+)
+
+// sealKeys are the data keys of the open database. sqlite functions are registered for every
+// connection of the process, so they live here and not on the Client.
+var sealKeys = struct {
+	mu      sync.RWMutex
+	current int64
+	aeads   map[int64]cipher.AEAD
+}{aeads: make(map[int64]cipher.AEAD)}
+
+func init() {
+	// clmail_seal(value, place) seals text and blobs with the current key for place. a value
+	// already sealed for place is left alone, one sealed for another place, copied over by a
+	// trigger, is opened and sealed again.
+	sqlite.MustRegisterScalarFunction("clmail_seal", 2, func(_ *sqlite.FunctionContext, args []driver.Value) (driver.Value, error) {
+		place, ok := args[1].(string)
+		if !ok || place == "" {
+			return nil, errors.New("clmail_seal needs the place of the value")
+		}
+		return seal(args[0], place)
+	})
+	// clmail_open(value, place) is the plaintext of a value sealed for place, plaintext is
+	// returned as it is
+	sqlite.MustRegisterScalarFunction("clmail_open", 2, func(_ *sqlite.FunctionContext, args []driver.Value) (driver.Value, error) {
+		place, ok := args[1].(string)
+		if !ok || place == "" {
+			return nil, errors.New("clmail_open needs the place of the value")
+		}
+		return open(args[0], place)
+	})
+	// clmail_search(needle, values...) is whether any of the values contains needle, ignoring
+	// case. it stands in for the fts index, which an encrypted database doesn't have. the values
+	// are opened with clmail_open first.
+	sqlite.MustRegisterScalarFunction("clmail_search", -1, func(_ *sqlite.FunctionContext, args []driver.Value) (driver.Value, error) {
+		if len(args) < 2 {
+			return nil, errors.New("clmail_search needs a needle and at least one value")
+		}
+		needle, _ := args[0].(string)
+		needle = strings.ToLower(needle)
+
+		for _, arg := range args[1:] {
+			var text string
+			switch v := arg.(type) {
+			case string:
+				text = v
+			case []byte:
+				text = string(v)
+			default:
+				continue
+			}
+			if strings.Contains(strings.ToLower(text), needle) {
+				return int64(1), nil
+			}
+		}
+		return int64(0), nil
+	})
+}
+
+// UseKeys hands the data keys of the database to the sealing layer, replacing the ones it had.
+// current seals everything written from now on, the others only open what they sealed before a
+// rotation replaced them.
+func UseKeys(keys map[int64][]byte, current int64) error {
+	aeads := make(map[int64]cipher.AEAD, len(keys))
+	for id, key := range keys {
+		aead, err := newAEAD(key)
+		if err != nil {
+			return fmt.Errorf("[DB::UseKeys] key %d: %w", id, err)
+		}
+		aeads[id] = aead
+	}
+	if _, ok := aeads[current]; !ok {
+		return fmt.Errorf("[DB::UseKeys] missing the current key %d", current)
+	}
+
+	sealKeys.mu.Lock()
+	defer sealKeys.mu.Unlock()
+	sealKeys.aeads = aeads
+	sealKeys.current = current
+	return nil
+}
+
+// addKey makes a new data key the one that seals, the keys from before still open their values.
+// restore puts back the keys from before.
+func addKey(id int64, key []byte) (restore func(), err error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, fmt.Errorf("[DB::addKey] key %d: %w", id, err)
+	}
+
+	sealKeys.mu.Lock()
+	defer sealKeys.mu.Unlock()
+
+	previous, previousCurrent := maps.Clone(sealKeys.aeads), sealKeys.current
+	sealKeys.aeads[id] = aead
+	sealKeys.current = id
+
+	return func() {
+		sealKeys.mu.Lock()
+		defer sealKeys.mu.Unlock()
+		sealKeys.aeads, sealKeys.current = previous, previousCurrent
+	}, nil
+}
+
+// forgetOtherKeys drops every key but the current one, once nothing is sealed with them anymore
+func forgetOtherKeys() {
+	sealKeys.mu.Lock()
+	defer sealKeys.mu.Unlock()
+	for id := range sealKeys.aeads {
+		if id != sealKeys.current {
+			delete(sealKeys.aeads, id)
+		}
+	}
+}
+
+// NewKey returns a random data key
+func NewKey() ([]byte, error) {
+	key := make([]byte, KeySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, fmt.Errorf("[DB::NewKey] failed to generate key: %w", err)
+	}
+	return key, nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	if len(key) != KeySize {
+		return nil, fmt.Errorf("a key has %d bytes, not %d", KeySize, len(key))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func sealed(value []byte) bool {
+	return bytes.HasPrefix(value, sealMagic)
+}
+
+// envelope is the header of a sealed value
+type envelope struct {
+	key   int64
+	kind  byte
+	place string
+	// size of the header up to the nonce
+	size int
+}
+
+func parseEnvelope(value []byte) (envelope, error) {
+	rest := value[len(sealMagic):]
+	if len(rest) < 7 {
+		return envelope{}, errors.New("[DB::open] sealed value is cut off")
+	}
+	placeSize := int(binary.BigEndian.Uint16(rest[5:]))
+	if len(rest) < 7+placeSize {
+		return envelope{}, errors.New("[DB::open] sealed value is cut off")
+	}
+	return envelope{
+		key:   int64(binary.BigEndian.Uint32(rest)),
+		kind:  rest[4],
+		place: string(rest[7 : 7+placeSize]),
+		size:  len(sealMagic) + 7 + placeSize,
+	}, nil
+}
+
+func seal(value driver.Value, place string) (driver.Value, error) {
+	var kind byte
+	var plaintext []byte
+	switch v := value.(type) {
+	case string:
+		kind, plaintext = sealedText, []byte(v)
+	case []byte:
+		if !sealed(v) {
+			kind, plaintext = sealedBlob, v
+			break
+		}
+		header, err := parseEnvelope(v)
+		if err != nil {
+			return nil, err
+		}
+		if header.place == place {
+			return v, nil
+		}
+		opened, err := open(v, header.place)
+		if err != nil {
+			return nil, err
+		}
+		return seal(opened, place)
+	default:
+		// null, numbers and dates say nothing about the mail
+		return value, nil
+	}
+
+	sealKeys.mu.RLock()
+	id := sealKeys.current
+	aead, ok := sealKeys.aeads[id]
+	sealKeys.mu.RUnlock()
+	if !ok {
+		return nil, ErrLocked
+	}
+
+	header := make([]byte, 0, len(sealMagic)+7+len(place)+aead.NonceSize())
+	header = append(header, sealMagic...)
+	header = binary.BigEndian.AppendUint32(header, uint32(id))
+	header = append(header, kind)
+	header = binary.BigEndian.AppendUint16(header, uint16(len(place)))
+	header = append(header, place...)
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("[DB::seal] failed to generate nonce: %w", err)
+	}
+	header = append(header, nonce...)
+
+	// the header is authenticated too, a value can't be moved to another key, kind or place
+	return aead.Seal(header, nonce, plaintext, header), nil
+}
+
+// open returns the plaintext of a sealed value. with a place it also has to be sealed for it, the
+// driver reads values without knowing where they come from and passes none.
+func open(value driver.Value, place string) (driver.Value, error) {
+	v, ok := value.([]byte)
+	if !ok || !sealed(v) {
+		return value, nil
+	}
+
+	header, err := parseEnvelope(v)
+	if err != nil {
+		return nil, err
+	}
+	if place != "" && header.place != place {
+		return nil, fmt.Errorf("[DB::open] value of %s is sealed for %s", place, header.place)
+	}
+
+	sealKeys.mu.RLock()
+	aead, ok := sealKeys.aeads[header.key]
+	locked := len(sealKeys.aeads) == 0
+	sealKeys.mu.RUnlock()
+	if locked {
+		return nil, ErrLocked
+	}
+	if !ok {
+		return nil, ErrUnknownKey
+	}
+
+	headerSize := header.size + aead.NonceSize()
+	if len(v) < headerSize {
+		return nil, errors.New("[DB::open] sealed value is cut off")
+	}
+	nonce := v[header.size:headerSize]
+
+	plaintext, err := aead.Open(nil, nonce, v[headerSize:], v[:headerSize])
+	if err != nil {
+		return nil, fmt.Errorf("[DB::open] failed to open sealed value: %w", err)
+	}
+	if header.kind == sealedText {
+		return string(plaintext), nil
+	}
+	return plaintext, nil
+}
@@ -0,0 +1,107 @@
+package db
+
+import (
+	"bytes"
+	"compress/zlib"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// StoreSource saves the raw message of the email, compressed. mail is mostly text, it shrinks
+// to about a third. the header is stored on its own too, it stays when the source is evicted.
+func (q *Queries) StoreSource(ctx context.Context, emailID int64, raw []byte) error {
+	compressed, err := compress(raw)
+	if err != nil {
+		return fmt.Errorf("[DB::StoreSource] failed to compress: %w", err)
+	}
+
+	if err = q.UpsertEmailSource(ctx, UpsertEmailSourceParams{EmailID: emailID, Raw: compressed}); err != nil {
+		return fmt.Errorf("[DB::StoreSource] failed to store source: %w", err)
+	}
+	return q.StoreHeader(ctx, emailID, raw)
+}
+
+// StoreHeader saves the header block of the email, compressed. whatever follows the header, like
+// the empty line a server sends with it, is cut off.
+func (q *Queries) StoreHeader(ctx context.Context, emailID int64, header []byte) error {
+	compressed, err := compress(SplitHeader(header))
+	if err != nil {
+		return fmt.Errorf("[DB::StoreHeader] failed to compress: %w", err)
+	}
+
+	if err = q.UpsertEmailHeader(ctx, UpsertEmailHeaderParams{EmailID: emailID, Raw: compressed}); err != nil {
+		return fmt.Errorf("[DB::StoreHeader] failed to store header: %w", err)
+	}
+	return nil
+}
+
+// Source returns the raw message of the email, sql.ErrNoRows when we never downloaded it whole
+func (q *Queries) Source(ctx context.Context, emailID int64) ([]byte, error) {
+	compressed, err := q.GetEmailSource(ctx, emailID)
+	if err != nil {
+		return nil, err
+	}
+
+	raw, err := decompress(compressed)
+	if err != nil {
+		return nil, fmt.Errorf("[DB::Source] failed to decompress: %w", err)
+	}
+	return raw, nil
+}
+
+// Header returns the header block of the email, sql.ErrNoRows when we have neither it nor the
+// source. sources stored before headers were kept on their own have theirs in the source only.
+func (q *Queries) Header(ctx context.Context, emailID int64) ([]byte, error) {
+	compressed, err := q.GetEmailHeader(ctx, emailID)
+	if errors.Is(err, sql.ErrNoRows) {
+		raw, err := q.Source(ctx, emailID)
+		if err != nil {
+			return nil, err
+		}
+		return SplitHeader(raw), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	header, err := decompress(compressed)
+	if err != nil {
+		return nil, fmt.Errorf("[DB::Header] failed to decompress: %w", err)
+	}
+	return header, nil
+}
+
+// SplitHeader cuts the header block off the message, up to and without the empty line
+func SplitHeader(raw []byte) []byte {
+	for _, separator := range []string{"\r\n\r\n", "\n\n"} {
+		if i := bytes.Index(raw, []byte(separator)); i >= 0 {
+			return raw[:i+len(separator)/2]
+		}
+	}
+	return raw
+}
+
+func compress(raw []byte) ([]byte, error) {
+	var compressed bytes.Buffer
+	writer := zlib.NewWriter(&compressed)
+	if _, err := writer.Write(raw); err != nil {
+		return nil, err
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+	return compressed.Bytes(), nil
+}
+
+func decompress(compressed []byte) ([]byte, error) {
+	reader, err := zlib.NewReader(bytes.NewReader(compressed))
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	return io.ReadAll(reader)
+}
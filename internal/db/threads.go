@@ -0,0 +1,31 @@
+package db
+
+import (
+	"context"
+	"math"
+	"time"
+)
+
+// ThreadCursor is where a page of GetThreadsInFolder starts, it returns the threads before it
+type ThreadCursor struct {
+	Date time.Time
+	ID   int64
+}
+
+// FirstPage is after every thread, far enough in the future that no mail is dated after it
+var FirstPage = ThreadCursor{Date: time.Date(9999, 12, 31, 0, 0, 0, 0, time.UTC), ID: math.MaxInt64}
+
+// CursorAfter returns the cursor of the page that follows the one ending with thread
+func CursorAfter(thread GetThreadsInFolderRow) ThreadCursor {
+	return ThreadCursor{Date: thread.FolderLatestDate, ID: thread.ID}
+}
+
+// ThreadsPage returns the threads of the folder after the cursor, newest first
+func (q *Queries) ThreadsPage(ctx context.Context, folderID int64, cursor ThreadCursor, pageSize int64) ([]GetThreadsInFolderRow, error) {
+	return q.GetThreadsInFolder(ctx, GetThreadsInFolderParams{
+		FolderID:   folderID,
+		BeforeDate: cursor.Date,
+		BeforeID:   cursor.ID,
+		PageSize:   pageSize,
+	})
+}
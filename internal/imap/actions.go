@@ -0,0 +1,191 @@
+package imap
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/emersion/go-imap/v2"
+)
+
+// the conflicts an action can run into on the server, trying it again won't help
+var (
+	ErrMessageGone        = errors.New("message is no longer on the server")
+	ErrUIDValidityChanged = errors.New("folder was recreated on the server")
+)
+
+// Target is the message an action is about, where it was when the user acted on it. a uid of 0 is
+// a copy we don't know the uid of yet, it is looked up by its Message-ID.
+type Target struct {
+	Folder      string
+	UID         uint32
+	UIDValidity uint32
+	MessageID   string
+}
+
+// StoreFlag sets or clears the flag on the message
+func (c *syncClient) StoreFlag(target Target, flag imap.Flag, set bool) (err error) {
+	conn, err := c.pool.Get(context.Background(), target.Folder, false)
+	if err != nil {
+		return fmt.Errorf("[SyncClient::StoreFlag] failed to get connection: %w", err)
+	}
+	defer func() { conn.Release(err) }()
+
+	uid, err := locate(conn, target)
+	if err != nil {
+		return err
+	}
+
+	op := imap.StoreFlagsAdd
+	if !set {
+		op = imap.StoreFlagsDel
+	}
+
+	err = conn.Store(imap.UIDSetNum(uid), &imap.StoreFlags{Op: op, Silent: true, Flags: []imap.Flag{flag}}, nil).Close()
+	if err != nil {
+		return fmt.Errorf("[SyncClient::StoreFlag] failed to store flag: %w", err)
+	}
+	return nil
+}
+
+// Move moves the message to another folder, go-imap falls back to COPY and EXPUNGE on servers
+// without MOVE
+func (c *syncClient) Move(target Target, folder string) (err error) {
+	conn, err := c.pool.Get(context.Background(), target.Folder, false)
+	if err != nil {
+		return fmt.Errorf("[SyncClient::Move] failed to get connection: %w", err)
+	}
+	defer func() { conn.Release(err) }()
+
+	uid, err := locate(conn, target)
+	if err != nil {
+		return err
+	}
+
+	if _, err = conn.Client.Move(imap.UIDSetNum(uid), folder).Wait(); err != nil {
+		return fmt.Errorf("[SyncClient::Move] failed to move to %s: %w", folder, err)
+	}
+	return nil
+}
+
+// Expunge deletes the message for good
+func (c *syncClient) Expunge(target Target) (err error) {
+	conn, err := c.pool.Get(context.Background(), target.Folder, false)
+	if err != nil {
+		return fmt.Errorf("[SyncClient::Expunge] failed to get connection: %w", err)
+	}
+	defer func() { conn.Release(err) }()
+
+	uid, err := locate(conn, target)
+	if err != nil {
+		return err
+	}
+
+	uidSet := imap.UIDSetNum(uid)
+	err = conn.Store(uidSet, &imap.StoreFlags{Op: imap.StoreFlagsAdd, Silent: true, Flags: []imap.Flag{imap.FlagDeleted}}, nil).Close()
+	if err != nil {
+		return fmt.Errorf("[SyncClient::Expunge] failed to flag as deleted: %w", err)
+	}
+
+	// a plain EXPUNGE also takes whatever else is flagged as deleted, only when there is no other way
+	if conn.Caps().Has(imap.CapUIDPlus) || conn.Caps().Has(imap.CapIMAP4rev2) {
+		err = conn.UIDExpunge(uidSet).Close()
+	} else {
+		err = conn.Client.Expunge().Close()
+	}
+	if err != nil {
+		return fmt.Errorf("[SyncClient::Expunge] failed to expunge: %w", err)
+	}
+	return nil
+}
+
+// CreateFolder creates the folder, one that already exists is fine
+func (c *syncClient) CreateFolder(name string) error {
+	return c.folderOp("CreateFolder", func(conn *Conn) error {
+		err := conn.Create(name, nil).Wait()
+		if hasCode(err, imap.ResponseCodeAlreadyExists) {
+			return nil
+		}
+		return err
+	})
+}
+
+func (c *syncClient) RenameFolder(name, newName string) error {
+	return c.folderOp("RenameFolder", func(conn *Conn) error {
+		return conn.Rename(name, newName).Wait()
+	})
+}
+
+// DeleteFolder deletes the folder, one that is gone already is fine
+func (c *syncClient) DeleteFolder(name string) error {
+	return c.folderOp("DeleteFolder", func(conn *Conn) error {
+		err := conn.Delete(name).Wait()
+		if hasCode(err, imap.ResponseCodeNonExistent) {
+			return nil
+		}
+		return err
+	})
+}
+
+func (c *syncClient) folderOp(name string, op func(conn *Conn) error) error {
+	conn, err := c.pool.Get(context.Background(), "", false)
+	if err != nil {
+		return fmt.Errorf("[SyncClient::%s] failed to get connection: %w", name, err)
+	}
+
+	// the connection may have the folder selected under its old name
+	defer conn.Discard()
+
+	if err = op(conn); err != nil {
+		return fmt.Errorf("[SyncClient::%s] %w", name, err)
+	}
+	return nil
+}
+
+// locate finds the uid of the message in the selected folder, or tells why it can't
+func locate(conn *Conn, target Target) (imap.UID, error) {
+	if target.UID == 0 {
+		if target.MessageID == "" {
+			return 0, ErrMessageGone
+		}
+
+		data, err := conn.UIDSearch(&imap.SearchCriteria{
+			Header: []imap.SearchCriteriaHeaderField{{Key: "Message-ID", Value: target.MessageID}},
+		}, nil).Wait()
+		if err != nil {
+			return 0, fmt.Errorf("[IMAP::locate] failed to search message: %w", err)
+		}
+
+		uids := data.AllUIDs()
+		if len(uids) == 0 {
+			return 0, ErrMessageGone
+		}
+		return uids[len(uids)-1], nil
+	}
+
+	if target.UIDValidity != 0 && conn.UIDValidity() != target.UIDValidity {
+		return 0, ErrUIDValidityChanged
+	}
+
+	uid := imap.UID(target.UID)
+	messages, err := conn.Fetch(imap.UIDSetNum(uid), &imap.FetchOptions{UID: true}).Collect()
+	if err != nil {
+		return 0, fmt.Errorf("[IMAP::locate] failed to fetch message: %w", err)
+	}
+	if len(messages) == 0 {
+		return 0, ErrMessageGone
+	}
+	return uid, nil
+}
+
+// hasCode reports whether err is a response from the server with the code
+func hasCode(err error, code imap.ResponseCode) bool {
+	var imapErr *imap.Error
+	return errors.As(err, &imapErr) && imapErr.Code == code
+}
+
+// IsConflict reports whether the action failed because the server changed under it: the message
+// or folder is gone, or the folder was recreated
+func IsConflict(err error) bool {
+	return errors.Is(err, ErrMessageGone) || errors.Is(err, ErrUIDValidityChanged) ||
+		hasCode(err, imap.ResponseCodeNonExistent) || hasCode(err, imap.ResponseCodeTryCreate)
+}
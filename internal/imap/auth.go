@@ -0,0 +1,23 @@
+package imap
+
+import (
+	"context"
+	"fmt"
+	"github.com/emersion/go-imap/v2/imapclient"
+	"github.com/rexxDigital/clmail/internal/db"
+	"github.com/rexxDigital/clmail/internal/oauth"
+)
+
+// login authenticates with the password, or with a fresh oauth token when the account uses oauth
+func login(client *imapclient.Client, account db.Account, username, password string) error {
+	if !oauth.IsOAuth(account.ImapAuthMethod) {
+		return client.Login(username, password).Wait()
+	}
+
+	token, err := oauth.AccessToken(context.Background(), account)
+	if err != nil {
+		return fmt.Errorf("failed to get access token: %w", err)
+	}
+
+	return client.Authenticate(oauth.NewSASLClient(account.ImapAuthMethod, username, token, account.ImapServer, int(account.ImapPort)))
+}
@@ -0,0 +1,565 @@
+package imap
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"github.com/emersion/go-imap/v2"
+	"github.com/emersion/go-message"
+	"github.com/emersion/go-message/mail"
+	"github.com/emersion/go-message/textproto"
+	"github.com/rexxDigital/clmail/internal/db"
+	"github.com/rexxDigital/clmail/internal/services/events"
+	"io"
+	"log"
+	"mime/quotedprintable"
+	"net"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// how many whole messages one FETCH asks for
+	bodyBatchSize = 25
+	// how many missing bodies are looked at per round, a batch is taken from the front of them
+	bodyLookahead = 200
+	// how often we look for missing bodies when nobody woke us up
+	bodyRetry = time.Minute
+)
+
+// truncatedNote ends a text part that was cut off at the size limit
+const truncatedNote = "\n\n[the rest of this message is above the size limit and wasn't downloaded]"
+
+// BodyFetcher downloads the bodies the header sync leaves out, for every folder of the account.
+// the open thread goes first, then the newest mail. messages above the size limit of the account
+// only get their text part, and only up to the limit.
+type BodyFetcher struct {
+	account  db.Account
+	pool     *Pool
+	dbClient *db.Client
+
+	mu     sync.Mutex
+	thread int64
+	// skipped didn't come back from the server, they are tried again when a thread is opened
+	skipped map[int64]bool
+	wake    chan struct{}
+}
+
+type missingBody = db.ListEmailsWithoutBodiesRow
+
+func NewBodyFetcher(account db.Account, password string, dbClient *db.Client) *BodyFetcher {
+	return &BodyFetcher{
+		account:  account,
+		pool:     PoolFor(account, password),
+		dbClient: dbClient,
+		skipped:  make(map[int64]bool),
+		wake:     make(chan struct{}, 1),
+	}
+}
+
+// Run fetches bodies until ctx is done, it sleeps while there is nothing to do
+func (f *BodyFetcher) Run(ctx context.Context) {
+	for {
+		fetched, err := f.fetchBatch(ctx)
+		if err != nil && ctx.Err() == nil {
+			log.Printf("[IMAP::BodyFetcher] Failed to fetch bodies: %v", err)
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		// a batch the server refused counts as fetched, it is skipped and the next one can go
+		if fetched {
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-f.wake:
+		case <-time.After(bodyRetry):
+		}
+	}
+}
+
+// Wake makes the fetcher look for missing bodies right away, e.g. after a sync brought in new mail
+func (f *BodyFetcher) Wake() {
+	select {
+	case f.wake <- struct{}{}:
+	default:
+	}
+}
+
+// FocusThread puts the missing bodies of the thread in front of everything else, this is how a
+// message that is opened without a body gets it on demand
+func (f *BodyFetcher) FocusThread(threadID int64) {
+	f.mu.Lock()
+	f.thread = threadID
+	clear(f.skipped)
+	f.mu.Unlock()
+
+	f.Wake()
+}
+
+// fetchBatch fetches the next batch of bodies, fetched is false when nothing was missing or the
+// server couldn't be reached
+func (f *BodyFetcher) fetchBatch(ctx context.Context) (fetched bool, err error) {
+	folder, batch, err := f.next(ctx)
+	if err != nil {
+		return false, fmt.Errorf("[IMAP::BodyFetcher] failed to find missing bodies: %w", err)
+	}
+	if len(batch) == 0 {
+		return false, nil
+	}
+
+	stored := make(map[int64]bool)
+	defer func() {
+		// a folder the server refuses would otherwise come first on every round, only a server
+		// we can't reach is worth asking again for the same batch
+		if err != nil && !unreachable(err) && ctx.Err() == nil {
+			f.skip(batch, stored)
+			fetched = true
+		}
+	}()
+
+	// read only, so nothing we do here can mark a message as seen
+	conn, err := f.pool.Get(ctx, folder, true)
+	if err != nil {
+		return false, fmt.Errorf("[IMAP::BodyFetcher] failed to get connection: %w", err)
+	}
+	defer func() { conn.Release(err) }()
+
+	limit := f.account.BodySizeLimitKb * 1024
+
+	var whole, large []missingBody
+	for _, email := range batch {
+		if limit > 0 && email.SizeBytes > limit {
+			large = append(large, email)
+		} else {
+			whole = append(whole, email)
+		}
+	}
+
+	if len(whole) > 0 {
+		if err = f.fetchWhole(conn, whole, stored); err != nil {
+			return false, err
+		}
+	}
+	if len(large) > 0 {
+		if err = f.fetchTextParts(conn, large, limit, stored); err != nil {
+			return false, err
+		}
+	}
+
+	// probably expunged on the server, don't ask for it again and again
+	f.skip(batch, stored)
+
+	return true, nil
+}
+
+// skip leaves the messages of the batch that weren't stored out until a thread is opened
+func (f *BodyFetcher) skip(batch []missingBody, stored map[int64]bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for _, email := range batch {
+		if !stored[email.ID] {
+			f.skipped[email.ID] = true
+		}
+	}
+}
+
+// unreachable reports whether err means we didn't get to talk to the server, as opposed to the
+// server saying no
+func unreachable(err error) bool {
+	var netErr net.Error
+	return errors.Is(err, ErrOffline) || errors.As(err, &netErr) || errors.Is(err, net.ErrClosed) ||
+		errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// next picks the batch, all from one folder: the open thread first, then the newest mail
+func (f *BodyFetcher) next(ctx context.Context) (string, []missingBody, error) {
+	f.mu.Lock()
+	thread := f.thread
+	f.mu.Unlock()
+
+	var folder string
+	var folderID int64
+	var batch []missingBody
+	pick := func(candidates []missingBody) {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+
+		for _, email := range candidates {
+			if len(batch) == bodyBatchSize {
+				return
+			}
+			if f.skipped[email.ID] || slices.ContainsFunc(batch, func(b missingBody) bool { return b.ID == email.ID }) {
+				continue
+			}
+			if folder == "" {
+				folder, folderID = email.FolderName, email.FolderID
+			}
+			if email.FolderID == folderID {
+				batch = append(batch, email)
+			}
+		}
+	}
+
+	if thread != 0 {
+		rows, err := f.dbClient.ListThreadEmailsWithoutBodies(ctx, db.ListThreadEmailsWithoutBodiesParams{
+			AccountID: f.account.ID,
+			ThreadID:  thread,
+		})
+		if err != nil {
+			return "", nil, err
+		}
+		candidates := make([]missingBody, 0, len(rows))
+		for _, row := range rows {
+			candidates = append(candidates, missingBody(row))
+		}
+		pick(candidates)
+	}
+
+	// the newest missing bodies may all be skipped, the older ones behind them still need theirs
+	for offset := int64(0); len(batch) < bodyBatchSize; offset += bodyLookahead {
+		rows, err := f.dbClient.ListEmailsWithoutBodies(ctx, db.ListEmailsWithoutBodiesParams{
+			AccountID: f.account.ID,
+			Limit:     bodyLookahead,
+			Offset:    offset,
+		})
+		if err != nil {
+			return "", nil, err
+		}
+		pick(rows)
+
+		if len(batch) > 0 || len(rows) < bodyLookahead {
+			break
+		}
+	}
+
+	return folder, batch, nil
+}
+
+// fetchWhole downloads complete messages, one FETCH for the whole batch
+func (f *BodyFetcher) fetchWhole(conn *Conn, emails []missingBody, stored map[int64]bool) error {
+	byUID := make(map[imap.UID]missingBody, len(emails))
+	for _, email := range emails {
+		byUID[imap.UID(email.Uid)] = email
+	}
+
+	section := &imap.FetchItemBodySection{Peek: true}
+	messages, err := conn.Fetch(uidSet(emails), &imap.FetchOptions{
+		UID:         true,
+		BodySection: []*imap.FetchItemBodySection{section},
+	}).Collect()
+	if err != nil {
+		return fmt.Errorf("[IMAP::BodyFetcher] failed to fetch bodies: %w", err)
+	}
+
+	for _, msg := range messages {
+		email, ok := byUID[msg.UID]
+		if !ok {
+			continue
+		}
+
+		raw := msg.FindBodySection(section)
+		body, refs := parseBody(bytes.NewReader(raw))
+		if err := f.store(email.ID, body, refs, nil, raw); err != nil {
+			return err
+		}
+		stored[email.ID] = true
+	}
+
+	return nil
+}
+
+// fetchTextParts downloads only the text part of large messages, cut off at the limit. the
+// structure comes first so we know which part that is, with the whole header.
+func (f *BodyFetcher) fetchTextParts(conn *Conn, emails []missingBody, limit int64, stored map[int64]bool) error {
+	byUID := make(map[imap.UID]missingBody, len(emails))
+	for _, email := range emails {
+		byUID[imap.UID(email.Uid)] = email
+	}
+
+	headers := &imap.FetchItemBodySection{Specifier: imap.PartSpecifierHeader, Peek: true}
+	messages, err := conn.Fetch(uidSet(emails), &imap.FetchOptions{
+		UID:           true,
+		BodyStructure: &imap.FetchItemBodyStructure{Extended: true},
+		BodySection:   []*imap.FetchItemBodySection{headers},
+	}).Collect()
+	if err != nil {
+		return fmt.Errorf("[IMAP::BodyFetcher] failed to fetch body structures: %w", err)
+	}
+
+	for _, msg := range messages {
+		email, ok := byUID[msg.UID]
+		if !ok {
+			continue
+		}
+
+		header := msg.FindBodySection(headers)
+		refs := headerReferences(header)
+
+		path, part := textPart(msg.BodyStructure)
+		if part == nil {
+			// nothing we could show, the attachments are in the db already
+			if err := f.store(email.ID, "", refs, header, nil); err != nil {
+				return err
+			}
+			stored[email.ID] = true
+			continue
+		}
+
+		section := &imap.FetchItemBodySection{Part: path, Partial: &imap.SectionPartial{Offset: 0, Size: limit}, Peek: true}
+		parts, err := conn.Fetch(imap.UIDSetNum(msg.UID), &imap.FetchOptions{
+			UID:         true,
+			BodySection: []*imap.FetchItemBodySection{section},
+		}).Collect()
+		if err != nil {
+			return fmt.Errorf("[IMAP::BodyFetcher] failed to fetch text part: %w", err)
+		}
+		if len(parts) == 0 {
+			continue
+		}
+
+		body := decodePart(part, parts[0].FindBodySection(section))
+		if int64(part.Size) > limit {
+			body += truncatedNote
+		}
+
+		if err := f.store(email.ID, body, refs, header, nil); err != nil {
+			return err
+		}
+		stored[email.ID] = true
+	}
+
+	return nil
+}
+
+// FetchSource downloads the message, or only its header, for a look at what the server sent. it
+// is stored so the next look doesn't need the server, the source of an evicted body isn't, that
+// would bring back what retention took.
+func (f *BodyFetcher) FetchSource(ctx context.Context, emailID int64, headerOnly bool) (raw []byte, err error) {
+	email, err := f.dbClient.GetEmail(ctx, emailID)
+	if err != nil {
+		return nil, fmt.Errorf("[IMAP::FetchSource] failed to get email: %w", err)
+	}
+
+	copies, err := f.dbClient.ListEmailFolders(ctx, emailID)
+	if err != nil {
+		return nil, fmt.Errorf("[IMAP::FetchSource] failed to get folders of email: %w", err)
+	}
+	index := slices.IndexFunc(copies, func(c db.ListEmailFoldersRow) bool { return !c.Local && c.Uid > 0 })
+	if index < 0 {
+		return nil, ErrMessageGone
+	}
+	serverCopy := copies[index]
+
+	// read only, a look at the source doesn't make the message seen
+	conn, err := f.pool.Get(ctx, serverCopy.Name, true)
+	if err != nil {
+		return nil, fmt.Errorf("[IMAP::FetchSource] failed to get connection: %w", err)
+	}
+	defer func() { conn.Release(err) }()
+
+	uid, err := locate(conn, Target{
+		Folder:      serverCopy.Name,
+		UID:         uint32(serverCopy.Uid),
+		UIDValidity: uint32(serverCopy.UidValidity),
+		MessageID:   email.MessageID,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	section := &imap.FetchItemBodySection{Peek: true}
+	if headerOnly {
+		section.Specifier = imap.PartSpecifierHeader
+	}
+	messages, err := conn.Fetch(imap.UIDSetNum(uid), &imap.FetchOptions{
+		UID:         true,
+		BodySection: []*imap.FetchItemBodySection{section},
+	}).Collect()
+	if err != nil {
+		return nil, fmt.Errorf("[IMAP::FetchSource] failed to fetch message: %w", err)
+	}
+	if len(messages) == 0 {
+		return nil, ErrMessageGone
+	}
+	raw = messages[0].FindBodySection(section)
+
+	var storeErr error
+	if headerOnly || email.BodyEvicted {
+		storeErr = f.dbClient.StoreHeader(ctx, emailID, raw)
+	} else {
+		storeErr = f.dbClient.StoreSource(ctx, emailID, raw)
+	}
+	if storeErr != nil {
+		// we have what was asked for, it is only fetched again next time
+		log.Printf("[IMAP::FetchSource] Failed to store source: %v", storeErr)
+	}
+
+	return raw, nil
+}
+
+// store saves the body, an empty body is still stored so we don't fetch the message again
+func (f *BodyFetcher) store(emailID int64, body, refs string, header, raw []byte) error {
+	if err := storeBody(f.dbClient, emailID, body, refs, header, raw); err != nil {
+		return err
+	}
+
+	events.Publish(events.BodyFetched{AccountID: f.account.ID, EmailID: emailID})
+	return nil
+}
+
+// storeBody saves the body and references of the email. raw is the whole message when we have
+// it, nil for the text part of a large one, which comes with its header instead.
+func storeBody(dbClient *db.Client, emailID int64, body, refs string, header, raw []byte) error {
+	tx, err := dbClient.DB.BeginTx(context.Background(), nil)
+	if err != nil {
+		return fmt.Errorf("[IMAP::storeBody] failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	queries := dbClient.WithTx(tx)
+
+	_, err = queries.UpdateEmailBodyAndReferences(context.Background(), db.UpdateEmailBodyAndReferencesParams{
+		ID:          emailID,
+		BodyText:    sql.NullString{String: body, Valid: true},
+		ReferenceID: sql.NullString{String: refs, Valid: refs != ""},
+	})
+	if err != nil {
+		return fmt.Errorf("[IMAP::storeBody] failed to update email: %w", err)
+	}
+	if raw != nil {
+		if err = queries.StoreSource(context.Background(), emailID, raw); err != nil {
+			return fmt.Errorf("[IMAP::storeBody] failed to store source: %w", err)
+		}
+	} else if header != nil {
+		if err = queries.StoreHeader(context.Background(), emailID, header); err != nil {
+			return fmt.Errorf("[IMAP::storeBody] failed to store header: %w", err)
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("[IMAP::storeBody] failed to commit: %w", err)
+	}
+	return nil
+}
+
+func uidSet(emails []missingBody) imap.UIDSet {
+	uids := make([]imap.UID, 0, len(emails))
+	for _, email := range emails {
+		uids = append(uids, imap.UID(email.Uid))
+	}
+	return imap.UIDSetNum(uids...)
+}
+
+// headerReferences reads the References of a header block without a body, parseBody would
+// stumble over the missing parts of a multipart message
+func headerReferences(header []byte) string {
+	fields, err := textproto.ReadHeader(bufio.NewReader(bytes.NewReader(header)))
+	if err != nil {
+		return ""
+	}
+
+	mailHeader := mail.Header{Header: message.Header{Header: fields}}
+	references, _ := mailHeader.MsgIDList("References")
+	return strings.Join(references, ",")
+}
+
+// parseBody reads the plain text and the references out of a raw message
+func parseBody(raw io.Reader) (string, string) {
+	mailReader, err := mail.CreateReader(raw)
+	if err != nil && !message.IsUnknownCharset(err) {
+		log.Printf("[IMAP::parseBody] Could not create mail reader: %v", err)
+		return "", ""
+	}
+
+	mailReferences, _ := mailReader.Header.MsgIDList("References")
+	refs := strings.Join(mailReferences, ",")
+
+	var bodyPlain []byte
+	for {
+		part, err := mailReader.NextPart()
+		if err == io.EOF {
+			break
+		} else if message.IsUnknownCharset(err) {
+			log.Printf("[IMAP::parseBody] Could not read mail part trying to decode charset with error: %v", err)
+		} else if err != nil {
+			log.Printf("[IMAP::parseBody] Could not read mail part with error: %v", err)
+			break
+		}
+
+		header, ok := part.Header.(*mail.InlineHeader)
+		if !ok {
+			continue
+		}
+
+		ct, params, _ := header.ContentType()
+		if ct != "text/plain" || bodyPlain != nil {
+			continue
+		}
+
+		decodedBody, err := decodeCharset(params["charset"], part.Body)
+		if err != nil {
+			log.Printf("[IMAP::parseBody] Could not decode charset with error: %v", err)
+			continue
+		}
+		bodyPlain, _ = io.ReadAll(decodedBody)
+	}
+
+	return string(bodyPlain), refs
+}
+
+// textPart finds the first text/plain part that isn't an attachment
+func textPart(bodyStructure imap.BodyStructure) ([]int, *imap.BodyStructureSinglePart) {
+	if bodyStructure == nil {
+		return nil, nil
+	}
+
+	var path []int
+	var text *imap.BodyStructureSinglePart
+	bodyStructure.Walk(func(partPath []int, part imap.BodyStructure) bool {
+		singlePart, ok := part.(*imap.BodyStructureSinglePart)
+		if !ok || text != nil {
+			return text == nil
+		}
+
+		disposition := singlePart.Disposition()
+		if singlePart.MediaType() == "text/plain" && (disposition == nil || !strings.EqualFold(disposition.Value, "attachment")) {
+			path, text = slices.Clone(partPath), singlePart
+		}
+		return true
+	})
+
+	return path, text
+}
+
+// decodePart undoes the transfer encoding and charset of a single part. a part cut off at the
+// limit can end in the middle of an encoded word, whatever decoded up to there is kept.
+func decodePart(part *imap.BodyStructureSinglePart, raw []byte) string {
+	var reader io.Reader = bytes.NewReader(raw)
+	switch strings.ToLower(part.Encoding) {
+	case "base64":
+		reader = base64.NewDecoder(base64.StdEncoding, reader)
+	case "quoted-printable":
+		reader = quotedprintable.NewReader(reader)
+	}
+	text, _ := io.ReadAll(reader)
+
+	decoded, err := decodeCharset(part.Params["charset"], bytes.NewReader(text))
+	if err != nil {
+		log.Printf("[IMAP::decodePart] Could not decode charset with error: %v", err)
+		return string(text)
+	}
+
+	body, _ := io.ReadAll(decoded)
+	return string(body)
+}
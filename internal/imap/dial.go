@@ -0,0 +1,147 @@
+package imap
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/emersion/go-imap/v2/imapclient"
+	"github.com/rexxDigital/clmail/internal/db"
+	"net"
+	"os"
+	"strings"
+)
+
+// connection security modes stored in accounts.imap_security
+const (
+	SecurityTLS      = "tls"
+	SecurityStartTLS = "starttls"
+	// SecurityNone is only allowed for servers on localhost, meant for local test servers and bridges
+	SecurityNone = "none"
+)
+
+// ValidateSecurity checks the connection settings of the account without connecting
+func ValidateSecurity(account db.Account) error {
+	switch account.ImapSecurity {
+	// accounts that never chose a mode get implicit tls
+	case "", SecurityTLS, SecurityStartTLS:
+	case SecurityNone:
+		if !isLocalhost(account.ImapServer) {
+			return errors.New("unencrypted connections are only allowed to localhost")
+		}
+	default:
+		return fmt.Errorf("unknown security mode %q, use tls, starttls or none", account.ImapSecurity)
+	}
+
+	if account.ImapTlsFingerprint.Valid {
+		if _, err := parseFingerprint(account.ImapTlsFingerprint.String); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// dial connects to the imap server of the account the way its security settings say, every
+// connection we make goes through here.
+func dial(account db.Account, options *imapclient.Options) (*imapclient.Client, error) {
+	if err := ValidateSecurity(account); err != nil {
+		return nil, err
+	}
+
+	address := net.JoinHostPort(account.ImapServer, fmt.Sprint(account.ImapPort))
+
+	if options == nil {
+		options = &imapclient.Options{}
+	}
+
+	if account.ImapSecurity == SecurityNone {
+		return imapclient.DialInsecure(address, options)
+	}
+
+	tlsConfig, err := tlsConfig(account)
+	if err != nil {
+		return nil, err
+	}
+
+	withTLS := *options
+	withTLS.TLSConfig = tlsConfig
+
+	if account.ImapSecurity == SecurityStartTLS {
+		// fails when the server doesn't offer STARTTLS, we never fall back to plain text
+		return imapclient.DialStartTLS(address, &withTLS)
+	}
+
+	return imapclient.DialTLS(address, &withTLS)
+}
+
+func tlsConfig(account db.Account) (*tls.Config, error) {
+	config := &tls.Config{
+		ServerName: account.ImapServer,
+		MinVersion: tls.VersionTLS12,
+	}
+
+	if account.ImapCaFile.Valid && account.ImapCaFile.String != "" {
+		pem, err := os.ReadFile(account.ImapCaFile.String)
+		if err != nil {
+			return nil, fmt.Errorf("[IMAP::tlsConfig] failed to read ca file: %w", err)
+		}
+
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("[IMAP::tlsConfig] no certificates found in %s", account.ImapCaFile.String)
+		}
+		config.RootCAs = pool
+	}
+
+	if account.ImapTlsFingerprint.Valid && account.ImapTlsFingerprint.String != "" {
+		pinned, err := parseFingerprint(account.ImapTlsFingerprint.String)
+		if err != nil {
+			return nil, err
+		}
+
+		// a pinned certificate replaces the chain verification, that's what makes self signed
+		// certificates work. the pin is stricter than any CA anyway.
+		config.InsecureSkipVerify = true
+		config.VerifyConnection = func(state tls.ConnectionState) error {
+			if len(state.PeerCertificates) == 0 {
+				return errors.New("server sent no certificate")
+			}
+
+			fingerprint := sha256.Sum256(state.PeerCertificates[0].Raw)
+			if fingerprint != pinned {
+				return fmt.Errorf("certificate fingerprint %s does not match the pinned one", hex.EncodeToString(fingerprint[:]))
+			}
+
+			return nil
+		}
+	}
+
+	return config, nil
+}
+
+// parseFingerprint accepts the sha256 fingerprint as plain hex or with colons like openssl prints it
+func parseFingerprint(fingerprint string) ([sha256.Size]byte, error) {
+	var pinned [sha256.Size]byte
+
+	decoded, err := hex.DecodeString(strings.ReplaceAll(strings.TrimSpace(fingerprint), ":", ""))
+	if err != nil || len(decoded) != sha256.Size {
+		return pinned, errors.New("the certificate fingerprint must be a sha256 hash in hex")
+	}
+
+	copy(pinned[:], decoded)
+	return pinned, nil
+}
+
+func isLocalhost(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
@@ -1,14 +1,10 @@
 package imap
 
-// TODO: Needs full reconnection support. I am short on time, will add this later
-
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
-	"github.com/emersion/go-message"
-	"github.com/emersion/go-message/mail"
-	"io"
 	"log"
 	"slices"
 	"sync"
@@ -17,102 +13,79 @@ import (
 	"github.com/emersion/go-imap/v2"
 	"github.com/emersion/go-imap/v2/imapclient"
 	"github.com/rexxDigital/clmail/internal/db"
+	"github.com/rexxDigital/clmail/internal/services/events"
+)
+
+// maxIdleConnections caps the IDLE fallback, servers limit the connections per user and the pool
+// needs some too. push folders above the cap are polled like the rest.
+const maxIdleConnections = 4
+
+const (
+	// the first wait before a push connection reconnects, it doubles up to pushMaxBackoff
+	pushBackoff    = 5 * time.Second
+	pushMaxBackoff = 5 * time.Minute
 )
 
 type IdleClient interface {
 	GetFolders() []string
-	Idle(folder string) error
-	StopIdle() error
+	// Push watches the folders, with NOTIFY when the server has it and otherwise with an IDLE
+	// connection per folder. it returns right away, the handler hears what is being watched.
+	Push(folders []string, handler PushHandler)
 	Close() error
 }
 
+// PushHandler is called from the push connections, it must not block
+type PushHandler struct {
+	// Changed is called when mail arrived in or was removed from the folder
+	Changed func(folder string)
+	// Active is called when push for the folder started or stopped working
+	Active func(folder string, active bool)
+}
+
 type idleClient struct {
-	client       *imapclient.Client
-	idleCtx      context.Context
-	idleCancel   context.CancelFunc
-	currIdleCmd  *imapclient.IdleCommand
-	idleMutex    sync.Mutex
-	isIdle       bool
-	accountID    int64
-	currFolder   string
-	currFolderID int64
-	dbClient     *db.Client
-	account      db.Account
-	password     string
-
-	bodyFetchQueue  chan int64
-	bodyFetchCtx    context.Context
-	bodyFetchCancel context.CancelFunc
+	client   *imapclient.Client
+	account  db.Account
+	password string
+	dbClient *db.Client
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
 }
 
 func NewIdleClient(account db.Account, password string, dbClient *db.Client) (IdleClient, error) {
-	clientInstance := &idleClient{
-		accountID:      account.ID,
-		dbClient:       dbClient,
-		account:        account,
-		password:       password,
-		bodyFetchQueue: make(chan int64, 1),
-	}
-
-	options := imapclient.Options{
-		UnilateralDataHandler: &imapclient.UnilateralDataHandler{
-			Expunge: func(seqNum uint32) {
-				log.Printf("message %v has been expunged", seqNum)
-			},
-			Mailbox: func(data *imapclient.UnilateralDataMailbox) {
-				if data.NumMessages != nil {
-					if clientInstance != nil {
-						go func() {
-							err := clientInstance.fetchMessageHeaders()
-							if err != nil {
-								log.Printf("[IMAP::UnilateralData] Failed to fetch messages: %v", err)
-							} else {
-								log.Printf("[IMAP::UnilateralData] Successfully fetched new messages")
-							}
-						}()
-					}
-				}
-			},
-		},
-	}
-
-	client, err := imapclient.DialTLS(fmt.Sprintf("%v:%v", account.ImapServer, account.ImapPort), &options)
+	client, err := dial(account, nil)
 	if err != nil {
 		return nil, fmt.Errorf("[IMAP::NewIdleClient] failed to dial: %w", err)
 	}
 
-	if err = client.Login(account.ImapUsername, password).Wait(); err != nil {
+	if err = login(client, account, account.ImapUsername, password); err != nil {
 		return nil, fmt.Errorf("[IMAP::NewIdleClient] failed to login: %w", err)
 	}
 
-	hasIdleCap := client.Caps().Has(imap.CapIdle)
-	if !hasIdleCap {
-		return nil, fmt.Errorf("[IMAP::NewIdleClient] server does not support idle")
+	clientInstance := &idleClient{
+		client:   client,
+		account:  account,
+		password: password,
+		dbClient: dbClient,
 	}
-
-	clientInstance.client = client
-
-	clientInstance.bodyFetchCtx, clientInstance.bodyFetchCancel = context.WithCancel(context.Background())
-	go clientInstance.startFetch()
-	go clientInstance.bodyFetchTicker()
+	clientInstance.ctx, clientInstance.cancel = context.WithCancel(context.Background())
 
 	return clientInstance, nil
 }
 
 func TestLoginAndGetFolders(account db.Account, password string, dbClient *db.Client) ([]string, error) {
 	clientInstance := &idleClient{
-		accountID:      account.ID,
-		dbClient:       dbClient,
-		account:        account,
-		password:       password,
-		bodyFetchQueue: make(chan int64, 1),
+		dbClient: dbClient,
+		account:  account,
+		password: password,
 	}
-	client, err := imapclient.DialTLS(fmt.Sprintf("%v:%v", account.ImapServer, account.ImapPort), nil)
+	client, err := dial(account, nil)
 	if err != nil {
 		return nil, fmt.Errorf("[IMAP::NewIdleClient] failed to dial: %w", err)
 	}
 
-	if err = client.Login(account.Email, password).Wait(); err != nil {
+	if err = login(client, account, account.Email, password); err != nil {
 		return nil, fmt.Errorf("[IMAP::NewIdleClient] failed to login: %w", err)
 	}
 
@@ -148,410 +121,192 @@ func (c *idleClient) GetFolders() []string {
 	return mailboxes
 }
 
-// Idle just starts an idle on the INBOX folder as this is the most important one IMO
-func (c *idleClient) Idle(folder string) error {
-	if c.isIdle {
-		return fmt.Errorf("[IMAP::Idle] already idle")
-	}
-
-	_, err := c.client.Select(folder, nil).Wait()
-	if err != nil {
-		return fmt.Errorf("[IMAP::Idle] failed to select folder: %w", err)
-	}
-
-	folderID, err := getFolderID(folder, c.accountID, c.dbClient)
-	if err != nil {
-		return fmt.Errorf("[IMAP::Idle] failed to get folder ID: %w", err)
-	}
-
-	c.currFolder = folder
-	c.currFolderID = folderID
-
-	err = c.fetchMessageHeaders()
-	if err != nil {
-		log.Printf("[IMAP::Idle] Failed to fetch existing messages: %v", err)
+func (c *idleClient) Push(folders []string, handler PushHandler) {
+	if len(folders) == 0 {
+		return
 	}
 
-	c.idleCtx, c.idleCancel = context.WithCancel(context.Background())
-
-	go c.runIdle()
+	c.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
 
-	c.isIdle = true
-	return nil
-}
-
-// TODO: fix this for gracefull shutdown
-func (c *idleClient) StopIdle() error {
-	return nil
-}
-
-// handles our idle command by restarting every 20 minutes and retrying on err.
-func (c *idleClient) runIdle() {
-	defer func() {
-		c.isIdle = false
-	}()
-
-	for {
-		c.idleMutex.Lock()
-		idleCmd, err := c.client.Idle()
-		if err != nil {
-			log.Printf("[IMAP::runIdle] Failed to start idle: %v", err)
-			c.idleMutex.Unlock()
-
-			select {
-			case <-c.idleCtx.Done():
+		if c.client.Caps().Has(imap.CapNotify) {
+			err := c.notify(folders, handler)
+			if !errors.Is(err, errNotifyRefused) {
 				return
-			case <-time.After(5 * time.Second):
-				continue
 			}
+			log.Printf("[IMAP::Push] Falling back to IDLE: %v", err)
 		}
 
-		c.currIdleCmd = idleCmd
-		c.idleMutex.Unlock()
-
-		select {
-		case <-c.idleCtx.Done():
-			c.idleMutex.Lock()
-			if c.currIdleCmd != nil {
-				idleCmd.Close()
-				c.currIdleCmd = nil
-			}
-			c.idleMutex.Unlock()
-			return
-		case <-time.After(20 * time.Minute):
-			c.idleMutex.Lock()
-			if c.currIdleCmd != nil {
-				idleCmd.Close()
-				c.currIdleCmd = nil
-			}
-			c.idleMutex.Unlock()
-		}
-	}
+		c.idleFolders(folders, handler)
+	}()
 }
 
-// fetchMessageHeaders has to close idle since it is a blocking command
-// and then restart our idle, so we keep track of it inside our struct.
-func (c *idleClient) fetchMessageHeaders() error {
-	c.idleMutex.Lock()
-	defer c.idleMutex.Unlock()
+// idleFolders is the fallback without NOTIFY, one connection per folder
+func (c *idleClient) idleFolders(folders []string, handler PushHandler) {
+	if !c.client.Caps().Has(imap.CapIdle) {
+		log.Printf("[IMAP::Push] Server supports neither NOTIFY nor IDLE, %v will be polled", folders)
+		return
+	}
 
-	if c.currIdleCmd != nil {
-		log.Printf("[IMAP::fetchWithIdleRestart] Stopping IDLE...")
-		err := c.currIdleCmd.Close()
-		if err != nil {
-			log.Printf("[IMAP::fetchWithIdleRestart] Failed to stop IDLE: %v", err)
-		}
-		c.currIdleCmd = nil
+	if len(folders) > maxIdleConnections {
+		log.Printf("[IMAP::Push] Only %d folders get an IDLE connection, %v will be polled", maxIdleConnections, folders[maxIdleConnections:])
+		folders = folders[:maxIdleConnections]
 	}
 
-	if c.currFolder == "" {
-		log.Printf("[IMAP::fetchNewMessages] No folder selected")
-		return nil
+	for _, folder := range folders {
+		c.wg.Add(1)
+		go func() {
+			defer c.wg.Done()
+			reconnect(c.ctx, "IDLE on "+folder, func() error {
+				return c.idleSession(folder, handler)
+			})
+		}()
 	}
+}
 
-	status, err := c.client.Status(c.currFolder, &imap.StatusOptions{
-		NumMessages: true,
-		UIDNext:     true,
-	}).Wait()
+// idleSession idles on the folder until ctx is done or the connection breaks
+func (c *idleClient) idleSession(folder string, handler PushHandler) error {
+	folderID, err := getFolderID(folder, c.account.ID, c.dbClient)
 	if err != nil {
-
-		return fmt.Errorf("[IMAP::fetchMessages] Failed to get status: %v", err)
+		return fmt.Errorf("[IMAP::idleSession] failed to get folder ID: %w", err)
 	}
 
-	if *status.NumMessages == 0 {
-		return nil
+	options := imapclient.Options{
+		UnilateralDataHandler: &imapclient.UnilateralDataHandler{
+			Expunge: func(seqNum uint32) {
+				events.Publish(events.Expunged{AccountID: c.account.ID, FolderID: folderID})
+			},
+			Fetch: func(msg *imapclient.FetchMessageData) {
+				c.updateFlags(msg, folderID)
+			},
+			Mailbox: func(data *imapclient.UnilateralDataMailbox) {
+				if data.NumMessages != nil {
+					handler.Changed(folder)
+				}
+			},
+		},
 	}
 
-	uidSet := imap.UIDSet{}
-
-	// only get new messages, so we don't refetch large amounts of mails that we already track.
-	highestUID, err := getHighestUIDInFolder(c.currFolderID, c.dbClient)
-	if err != nil || highestUID == 0 {
-		uidSet.AddRange(1, 0)
-	} else {
-		uidSet.AddRange(imap.UID(highestUID+1), 0)
+	client, err := dial(c.account, &options)
+	if err != nil {
+		return fmt.Errorf("[IMAP::idleSession] failed to dial: %w: %w", ErrOffline, err)
 	}
+	defer client.Close()
 
-	currentMailbox := c.client.Mailbox()
-	if currentMailbox == nil || currentMailbox.Name != c.currFolder {
-		_, err = c.client.Select(c.currFolder, nil).Wait()
-		if err != nil {
-			return fmt.Errorf("[IMAP::fetchMessageHeaders] Failed to select folder: %w", err)
-		}
+	if err = login(client, c.account, c.account.ImapUsername, c.password); err != nil {
+		return fmt.Errorf("[IMAP::idleSession] failed to login: %w", err)
 	}
 
-	// we are fetching the body structure first as it is fast, and we can get all necessary metadata
-	// for displaying new mails in the mail list
-	fetchOptions := &imap.FetchOptions{
-		Flags:         true,
-		Envelope:      true,
-		UID:           true,
-		BodyStructure: &imap.FetchItemBodyStructure{},
+	if _, err = client.Select(folder, &imap.SelectOptions{ReadOnly: true}).Wait(); err != nil {
+		return fmt.Errorf("[IMAP::idleSession] failed to select folder: %w", err)
 	}
 
-	messages, err := c.client.Fetch(uidSet, fetchOptions).Collect()
+	// go-imap restarts the IDLE before servers time it out, it only ends when we stop it or the
+	// connection is gone
+	idleCmd, err := client.Idle()
 	if err != nil {
-		return fmt.Errorf("failed to fetch messages: %w", err)
+		return fmt.Errorf("[IMAP::idleSession] failed to start idle: %w", err)
 	}
 
-	// Process each message
-	for _, msg := range messages {
-		processBodyStructure(msg, c.currFolderID, c.accountID, c.dbClient)
-	}
+	handler.Active(folder, true)
+	defer handler.Active(folder, false)
 
-	return nil
-}
+	stopped := make(chan error, 1)
+	go func() { stopped <- idleCmd.Wait() }()
 
-func (c *idleClient) startFetch() {
-	for {
-		select {
-		case <-c.bodyFetchCtx.Done():
-			return
-		case emailID := <-c.bodyFetchQueue:
-			err := c.fetchEmailBody(emailID)
-			if err != nil {
-				// could implement retry logic here
-				log.Printf("[IMAP::startFetch] Error: %d", err)
-			}
-
-			// small delay so we do not spam the server
-			time.Sleep(100 * time.Millisecond)
+	select {
+	case <-c.ctx.Done():
+		idleCmd.Close()
+		<-stopped
+		if err := client.Logout().Wait(); err != nil {
+			log.Printf("[IMAP::idleSession] Failed to logout: %v", err)
 		}
+		return nil
+	case err = <-stopped:
+		return fmt.Errorf("[IMAP::idleSession] idle stopped: %v", err)
 	}
 }
 
-// bodyFetchTicker fetches email bodies every second
-func (c *idleClient) bodyFetchTicker() {
-	ticker := time.NewTicker(20 * time.Second) // check every second
-	defer ticker.Stop()
+// reconnect runs the session again whenever it ends, waiting longer after every failure in a row.
+// only a refused NOTIFY ends it, that one won't work on the next try either.
+func reconnect(ctx context.Context, name string, session func() error) error {
+	backoff := pushBackoff
 
 	for {
-		select {
-		case <-c.bodyFetchCtx.Done():
-			return
-		case <-ticker.C:
-			c.queueEmailsForBodyFetching()
+		started := time.Now()
+		err := session()
+		if ctx.Err() != nil {
+			return nil
+		}
+		if errors.Is(err, errNotifyRefused) {
+			return err
 		}
-	}
-}
 
-// queueEmailsForBodyFetching finds emails without bodies and queues them
-func (c *idleClient) queueEmailsForBodyFetching() {
-	emails, err := c.dbClient.GetEmailsWithoutBodies(context.Background(), db.GetEmailsWithoutBodiesParams{
-		AccountID: c.accountID,
-		FolderID:  c.currFolderID,
-		Limit:     50,
-	})
-	if err != nil {
-		log.Printf("[IMAP::queueEmailsForBodyFetching] Failed to get emails without bodies: %v", err)
-		return
-	}
+		// a session that ran for a while wasn't a failure in a row
+		if time.Since(started) > pushMaxBackoff {
+			backoff = pushBackoff
+		}
+		log.Printf("[IMAP::reconnect] %s stopped, reconnecting in %v: %v", name, backoff, err)
 
-	for _, email := range emails {
 		select {
-		case c.bodyFetchQueue <- email.Uid:
-		default:
-			return
+		case <-ctx.Done():
+			return nil
+		case <-time.After(backoff):
 		}
+		backoff = min(backoff*2, pushMaxBackoff)
 	}
 }
 
-// fetchEmailBody creates a new client, since fetching body content takes a long time. We want the idle command to still be able to fetch new mails in the meantime.
-func (c *idleClient) fetchEmailBody(emailID int64) error {
-	client, err := imapclient.DialTLS(fmt.Sprintf("%v:%v", c.account.ImapServer, c.account.ImapPort), nil)
-	if err != nil {
-		return fmt.Errorf("[IMAP::fetchEmailBody] failed to dial: %w", err)
-	}
-
-	if err = client.Login(c.account.ImapUsername, c.password).Wait(); err != nil {
-		return fmt.Errorf("[IMAP::fetchEmailBody] failed to login: %w", err)
-	}
-
-	// defer logging out and closing our connection!!
-	defer func() {
-		if client != nil {
-			logoutCmd := client.Logout()
-			if logoutCmd != nil {
-				logoutCmd.Wait()
-			}
-			client.Close()
-		}
-	}()
-
-	email, err := c.dbClient.GetEmailByFolderAndUID(context.Background(), db.GetEmailByFolderAndUIDParams{
-		Uid:      emailID,
-		FolderID: c.currFolderID,
-	})
-	if err != nil {
-		return fmt.Errorf("[IMAP::fetchEmailBody] failed to get email: %w", err)
-	}
-
-	if email.BodyText.Valid {
-		log.Printf("[IMAP::fetchEmailBody] VALID??")
-		return nil
-	}
-
-	folder, err := c.dbClient.GetFolder(context.Background(), c.currFolderID)
+// updateFlags stores the flags the server sends on its own while we idle, another client read or
+// starred a message. without a uid we can't tell which one it was, the next sync picks it up.
+func (c *idleClient) updateFlags(msg *imapclient.FetchMessageData, folderID int64) {
+	buf, err := msg.Collect()
 	if err != nil {
-		return fmt.Errorf("[IMAP::fetchEmailBody] failed to get folder: %w", err)
-	}
-
-	_, err = client.Select(folder.Name, nil).Wait()
-	if err != nil {
-		return fmt.Errorf("[IMAP::fetchEmailBody] failed to select folder: %w", err)
-	}
-
-	fetchOptions := &imap.FetchOptions{
-		BodySection: []*imap.FetchItemBodySection{{}},
+		log.Printf("[IMAP::updateFlags] Failed to read fetch data: %v", err)
+		return
 	}
 
-	uidSet := imap.UIDSet{}
-	uidSet.AddNum(imap.UID(email.Uid))
-
-	fetchCmd := client.Fetch(uidSet, fetchOptions)
-	defer fetchCmd.Close()
-
-	// loop through each mail message
-	for {
-		msg := fetchCmd.Next()
-
-		/*
-			if the message is nil we don't have any more messages to fetch
-			if we don't have anything more to fetch we break
-		*/
-		if msg == nil {
-			break
-		}
-
-		var bodySection imapclient.FetchItemDataBodySection
-
-		// loop through the mail data to find each item we are looking for
-		ok := false
-		for {
-			// get next data point
-			item := msg.Next()
-
-			// check if we have read all the data points we want
-			if item == nil {
-				break
-			}
-
-			// go through each section and set data
-			bodySection, ok = item.(imapclient.FetchItemDataBodySection)
-			switch item := item.(type) {
-			case imapclient.FetchItemDataBodySection:
-				bodySection = item
-				ok = true
-			default:
-			}
-			if ok {
-				break
-			}
-		}
-		if !ok {
-			log.Println("[IMAP::fetchEmailBody] Could not fetch all data from message")
-			continue
-		}
-
-		// create mail reader
-		mailReader, err := mail.CreateReader(bodySection.Literal)
-		if err != nil {
-			log.Printf("[IMAP::fetchEmailBody] Could not create mail reader: %v", err)
-			continue
-		}
-
-		// get References from mail header
-		header := mailReader.Header
-		/*
-		   try to get references from msg-id if available
-		*/
-		mailReferences, err := header.MsgIDList("References")
-		if mailReferences == nil {
-			mailReferences = make([]string, 0)
-		}
-
-		// get mail body data
-		var _, bodyPlain []byte
-
-		for {
-			part, err := mailReader.NextPart()
-			if err == io.EOF {
-				break
-			} else if message.IsUnknownCharset(err) {
-				log.Printf("[IMAP::fetchEmailBody] Could not read mail part trying to decode charset with error: %v", err.Error())
-			} else if err != nil {
-				log.Printf("[IMAP::fetchEmailBody] Could not read mail part with error: %v", err.Error())
-				break
-			}
-
-			switch h := part.Header.(type) {
-			case *mail.InlineHeader:
-				ct, params, _ := h.ContentType()
-
-				charset := params["charset"]
+	if buf.UID != 0 && buf.Flags != nil {
+		isRead := slices.Contains(buf.Flags, imap.FlagSeen)
+		isStarred := slices.Contains(buf.Flags, imap.FlagFlagged)
+		isDraft := slices.Contains(buf.Flags, imap.FlagDraft)
 
-				decodedBody, err := decodeCharset(charset, part.Body)
-				if err != nil {
-					log.Printf("[IMAP::fetchEmailBody] Could not decode charset with error: %v", err.Error())
-				}
-
-				switch ct {
-				case "text/plain":
-					bodyPlain, _ = io.ReadAll(decodedBody)
-				case "text/html":
-					_, _ = io.ReadAll(decodedBody)
-				}
-			case *mail.AttachmentHeader:
-				// TODO: Add support for attachments
-				_, _ = h.Filename()
-			}
-		}
-
-		refs := ""
-		for i, ref := range mailReferences {
-			if i != len(mailReferences)-1 {
-				refs += ref + ","
-			} else {
-				refs += ref
-			}
+		emailID, err := c.dbClient.UpdateFolderEmailFlags(context.Background(), db.UpdateFolderEmailFlagsParams{
+			IsRead:    isRead,
+			IsStarred: isStarred,
+			IsDraft:   isDraft,
+			FolderID:  folderID,
+			Uid:       int64(buf.UID),
+		})
+		if errors.Is(err, sql.ErrNoRows) {
+			// not synced yet, it gets its flags when it is
+			return
+		} else if err != nil {
+			log.Printf("[IMAP::updateFlags] Failed to update flags: %v", err)
+			return
 		}
 
-		_, err = c.dbClient.UpdateEmailBodyAndReferences(context.Background(), db.UpdateEmailBodyAndReferencesParams{
-			ID: email.ID,
-			BodyText: sql.NullString{
-				String: (string)(bodyPlain),
-				Valid:  bodyPlain != nil,
-			},
-			ReferenceID: sql.NullString{
-				String: refs,
-				Valid:  refs != "",
-			},
+		// the copy that changed last decides what we show
+		err = c.dbClient.UpdateEmailFlags(context.Background(), db.UpdateEmailFlagsParams{
+			IsRead:    isRead,
+			IsStarred: isStarred,
+			IsDraft:   isDraft,
+			ID:        emailID,
 		})
-
 		if err != nil {
-			return fmt.Errorf("[IMAP::fetchEmailBody] failed to update email: %w", err)
+			log.Printf("[IMAP::updateFlags] Failed to update flags: %v", err)
+			return
 		}
 	}
 
-	return nil
+	events.Publish(events.FlagsChanged{AccountID: c.account.ID, FolderID: folderID})
 }
 
-// Close logs out our user and closes the connection
+// Close stops the push connections, logs out our user and closes the connection
 func (c *idleClient) Close() error {
-	if c.idleCancel != nil {
-		c.idleCancel()
-	}
-
-	if c.bodyFetchCancel != nil {
-		c.bodyFetchCancel()
-	}
-
-	c.idleMutex.Lock()
-	if c.currIdleCmd != nil {
-		c.currIdleCmd.Close()
-		c.currIdleCmd = nil
-	}
-	c.idleMutex.Unlock()
+	c.cancel()
+	c.wg.Wait()
 
 	err := c.client.Logout().Wait()
 	if err != nil {
@@ -0,0 +1,200 @@
+package imap
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/emersion/go-imap/v2"
+	"github.com/emersion/go-imap/v2/imapclient"
+	"github.com/emersion/go-imap/v2/imapserver"
+	"github.com/emersion/go-message/textproto"
+	"github.com/rexxDigital/clmail/internal/archive"
+	"github.com/rexxDigital/clmail/internal/db"
+)
+
+// ErrNotImported is returned for messages the importer leaves out: ones already in the folder, and
+// ones without a sender or recipient, which the sync skips as well
+var ErrNotImported = errors.New("message not imported")
+
+// Importer puts messages from an archive into one folder of an account. a local folder gets them
+// stored right here, a folder on the server gets them with APPEND and the next sync brings them in.
+type Importer struct {
+	account  db.Account
+	dbClient *db.Client
+	folder   db.Folder
+	// pool is nil for local folders
+	pool *Pool
+	// the uids of a local folder are ours to hand out
+	nextUID uint32
+	// imported has the message keys of this run, uploads only reach the database with the next
+	// sync and an archive can have a message twice
+	imported map[string]bool
+}
+
+// NewLocalImporter imports into a local folder of the account, it is created if it doesn't exist
+func NewLocalImporter(account db.Account, folderName string, dbClient *db.Client) (*Importer, error) {
+	folder, err := dbClient.GetFolderByName(context.Background(), db.GetFolderByNameParams{
+		Name:      folderName,
+		AccountID: account.ID,
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		folder, err = dbClient.CreateLocalFolder(context.Background(), db.CreateLocalFolderParams{
+			AccountID: account.ID,
+			Name:      folderName,
+		})
+	}
+	if err != nil {
+		return nil, fmt.Errorf("[IMAP::NewLocalImporter] failed to get folder %s: %w", folderName, err)
+	}
+	if !folder.Local {
+		return nil, fmt.Errorf("[IMAP::NewLocalImporter] %s is a folder on the server, upload to it instead", folderName)
+	}
+
+	highest, err := getHighestUIDInFolder(folder.ID, dbClient)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Importer{account: account, dbClient: dbClient, folder: folder, nextUID: highest + 1, imported: make(map[string]bool)}, nil
+}
+
+// NewUploadImporter imports into a folder on the server
+func NewUploadImporter(account db.Account, password, folderName string, dbClient *db.Client) (*Importer, error) {
+	folder, err := dbClient.GetFolderByName(context.Background(), db.GetFolderByNameParams{
+		Name:      folderName,
+		AccountID: account.ID,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("[IMAP::NewUploadImporter] no folder %s in %s: %w", folderName, account.Email, err)
+	}
+	if folder.Local {
+		return nil, fmt.Errorf("[IMAP::NewUploadImporter] %s is a local folder", folderName)
+	}
+
+	return &Importer{account: account, dbClient: dbClient, folder: folder, pool: PoolFor(account, password), imported: make(map[string]bool)}, nil
+}
+
+// Import adds the message to the folder, ErrNotImported when it is left out
+func (i *Importer) Import(ctx context.Context, msg archive.Message) error {
+	header, err := textproto.ReadHeader(bufio.NewReader(bytes.NewReader(msg.Raw)))
+	if err != nil {
+		return fmt.Errorf("[IMAP::Importer] failed to read header: %w", err)
+	}
+
+	envelope := imapserver.ExtractEnvelope(header)
+	if envelope.Date.IsZero() {
+		envelope.Date = msg.Date
+	}
+	if len(envelope.From) == 0 || len(envelope.To) == 0 {
+		return ErrNotImported
+	}
+
+	key := messageKey(envelope)
+	if i.imported[key] {
+		return ErrNotImported
+	}
+
+	existing, err := i.dbClient.GetEmailByMessageKey(ctx, db.GetEmailByMessageKeyParams{
+		AccountID:  i.account.ID,
+		MessageKey: key,
+	})
+	if err == nil {
+		inFolder, err := i.dbClient.IsEmailInFolder(ctx, db.IsEmailInFolderParams{EmailID: existing.ID, FolderID: i.folder.ID})
+		if err != nil {
+			return fmt.Errorf("[IMAP::Importer] failed to look up email: %w", err)
+		}
+		if inFolder {
+			return ErrNotImported
+		}
+	} else if !errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("[IMAP::Importer] failed to look up email: %w", err)
+	}
+
+	if i.pool != nil {
+		err = i.upload(ctx, msg, envelope.MessageID)
+	} else {
+		err = i.store(ctx, msg, envelope)
+	}
+	if err == nil || errors.Is(err, ErrNotImported) {
+		i.imported[key] = true
+	}
+	return err
+}
+
+// store goes through what the sync does with a new message, the header first and then the body
+func (i *Importer) store(ctx context.Context, msg archive.Message, envelope *imap.Envelope) error {
+	uid := i.nextUID
+	i.nextUID++
+
+	processBodyStructure(&imapclient.FetchMessageBuffer{
+		UID:           imap.UID(uid),
+		Flags:         flags(msg),
+		Envelope:      envelope,
+		BodyStructure: imapserver.ExtractBodyStructure(bytes.NewReader(msg.Raw)),
+		RFC822Size:    int64(len(msg.Raw)),
+	}, i.folder.ID, i.account.ID, i.dbClient)
+
+	email, err := i.dbClient.GetEmailByMessageKey(ctx, db.GetEmailByMessageKeyParams{
+		AccountID:  i.account.ID,
+		MessageKey: messageKey(envelope),
+	})
+	if err != nil {
+		return fmt.Errorf("[IMAP::Importer] failed to store email: %w", err)
+	}
+
+	body, refs := parseBody(bytes.NewReader(msg.Raw))
+	return storeBody(i.dbClient, email.ID, body, refs, nil, msg.Raw)
+}
+
+// upload appends the message with its flags and date, the date is what the server sorts by. a
+// message the folder has already, e.g. from an earlier run that was cut short, isn't uploaded again.
+func (i *Importer) upload(ctx context.Context, msg archive.Message, messageID string) (err error) {
+	// read only, the search mustn't mark anything as seen
+	conn, err := i.pool.Get(ctx, i.folder.Name, true)
+	if err != nil {
+		return fmt.Errorf("[IMAP::Importer] failed to get connection: %w", err)
+	}
+	defer func() { conn.Release(err) }()
+
+	if messageID != "" {
+		_, err = locate(conn, Target{Folder: i.folder.Name, MessageID: messageID})
+		if err == nil {
+			return ErrNotImported
+		}
+		if !errors.Is(err, ErrMessageGone) {
+			return err
+		}
+	}
+
+	appendCmd := conn.Append(i.folder.Name, int64(len(msg.Raw)), &imap.AppendOptions{
+		Flags: flags(msg),
+		Time:  msg.Date,
+	})
+	if _, err = appendCmd.Write(msg.Raw); err != nil {
+		return fmt.Errorf("[IMAP::Importer] failed to upload: %w", err)
+	}
+	if err = appendCmd.Close(); err != nil {
+		return fmt.Errorf("[IMAP::Importer] failed to upload: %w", err)
+	}
+	if _, err = appendCmd.Wait(); err != nil {
+		return fmt.Errorf("[IMAP::Importer] failed to upload: %w", err)
+	}
+	return nil
+}
+
+func flags(msg archive.Message) []imap.Flag {
+	var flags []imap.Flag
+	if msg.Seen {
+		flags = append(flags, imap.FlagSeen)
+	}
+	if msg.Flagged {
+		flags = append(flags, imap.FlagFlagged)
+	}
+	if msg.Draft {
+		flags = append(flags, imap.FlagDraft)
+	}
+	return flags
+}
@@ -0,0 +1,431 @@
+package imap
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rexxDigital/clmail/internal/db"
+	"github.com/rexxDigital/clmail/internal/oauth"
+)
+
+// NOTIFY (RFC 5465) isn't in go-imap yet, so this speaks just enough IMAP on its own connection to
+// log in, ask for updates of the push folders and read the STATUS responses the server sends.
+
+var errNotifyRefused = errors.New("server refused NOTIFY")
+
+const (
+	// a NOOP now and then so the server doesn't log us out for inactivity
+	notifyKeepAlive = 20 * time.Minute
+	// nothing we ask for comes with big literals, anything larger is a broken server
+	maxLiteralSize = 1 << 20
+)
+
+type notifyConn struct {
+	conn    net.Conn
+	reader  *bufio.Reader
+	writeMu sync.Mutex
+	tag     int
+}
+
+// statusError is a NO or BAD to one of our commands
+type statusError struct {
+	command string
+	text    string
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("%s failed: %s", e.command, e.text)
+}
+
+// notify watches the folders on one connection until ctx is done. it only gives up when the
+// server refuses NOTIFY, then the caller falls back to IDLE.
+func (c *idleClient) notify(folders []string, handler PushHandler) error {
+	return reconnect(c.ctx, "NOTIFY", func() error {
+		return c.notifySession(folders, handler)
+	})
+}
+
+func (c *idleClient) notifySession(folders []string, handler PushHandler) error {
+	conn, err := dialNotify(c.account)
+	if err != nil {
+		return fmt.Errorf("[IMAP::notifySession] failed to dial: %w: %w", ErrOffline, err)
+	}
+	defer conn.conn.Close()
+
+	// reads block, closing the connection is how we stop them
+	stop := context.AfterFunc(c.ctx, func() { conn.conn.Close() })
+	defer stop()
+
+	if err = conn.login(c.account, c.password); err != nil {
+		return fmt.Errorf("[IMAP::notifySession] failed to login: %w", err)
+	}
+
+	watched := make(map[string]string, len(folders))
+	for _, folder := range folders {
+		watched[encodeUTF7(folder)] = folder
+	}
+
+	err = conn.command(notifySetCommand(folders), nil)
+	var refused *statusError
+	if errors.As(err, &refused) {
+		return fmt.Errorf("%w: %v", errNotifyRefused, err)
+	} else if err != nil {
+		return fmt.Errorf("[IMAP::notifySession] failed to set up NOTIFY: %w", err)
+	}
+
+	for _, folder := range folders {
+		handler.Active(folder, true)
+	}
+	defer func() {
+		for _, folder := range folders {
+			handler.Active(folder, false)
+		}
+	}()
+
+	done := make(chan struct{})
+	defer close(done)
+	go conn.keepAlive(done)
+
+	for {
+		line, err := conn.readResponse()
+		if err != nil {
+			return fmt.Errorf("[IMAP::notifySession] connection lost: %w", err)
+		}
+
+		switch {
+		case strings.HasPrefix(line, "* BYE"):
+			return fmt.Errorf("[IMAP::notifySession] server closed the connection: %s", line)
+		case hasPrefixFold(line, "* STATUS "):
+			mailbox, ok := parseAString(line[len("* STATUS "):])
+			if !ok {
+				continue
+			}
+			if folder, ok := watchedFolder(watched, mailbox); ok {
+				handler.Changed(folder)
+			}
+		case !strings.HasPrefix(line, "*") && !strings.HasPrefix(line, "+"):
+			// the tagged answer to a keep alive NOOP
+			if _, status, text := splitTagged(line); status != "OK" {
+				return fmt.Errorf("[IMAP::notifySession] NOOP failed: %s", text)
+			}
+		}
+	}
+}
+
+// notifySetCommand asks for new and expunged mail in the folders. the filter and its events are
+// one event group, `"(" filter-mailboxes SP events ")"`, and a list of mailboxes has its own
+// parentheses.
+func notifySetCommand(folders []string) string {
+	mailboxes := make([]string, 0, len(folders))
+	for _, folder := range folders {
+		mailboxes = append(mailboxes, quote(encodeUTF7(folder)))
+	}
+	return "NOTIFY SET (mailboxes (" + strings.Join(mailboxes, " ") + ") (MessageNew MessageExpunge))"
+}
+
+// watchedFolder maps the mailbox of a STATUS response to the folder name we know it by
+func watchedFolder(watched map[string]string, mailbox string) (string, bool) {
+	if folder, ok := watched[mailbox]; ok {
+		return folder, true
+	}
+
+	// INBOX is case insensitive, the server may spell it differently than we did
+	if strings.EqualFold(mailbox, "INBOX") {
+		for encoded, folder := range watched {
+			if strings.EqualFold(encoded, "INBOX") {
+				return folder, true
+			}
+		}
+	}
+
+	decoded, err := decodeUTF7(mailbox)
+	if err != nil {
+		return "", false
+	}
+	for _, folder := range watched {
+		if folder == decoded {
+			return folder, true
+		}
+	}
+	return "", false
+}
+
+func dialNotify(account db.Account) (*notifyConn, error) {
+	if err := ValidateSecurity(account); err != nil {
+		return nil, err
+	}
+
+	address := net.JoinHostPort(account.ImapServer, fmt.Sprint(account.ImapPort))
+	dialer := &net.Dialer{Timeout: 30 * time.Second}
+
+	var conn net.Conn
+	var err error
+	if account.ImapSecurity == SecurityNone || account.ImapSecurity == SecurityStartTLS {
+		conn, err = dialer.Dial("tcp", address)
+	} else {
+		config, configErr := tlsConfig(account)
+		if configErr != nil {
+			return nil, configErr
+		}
+		conn, err = tls.DialWithDialer(dialer, "tcp", address, config)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	c := &notifyConn{conn: conn, reader: bufio.NewReader(conn)}
+
+	greeting, err := c.readResponse()
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to read greeting: %w", err)
+	}
+	if !strings.HasPrefix(greeting, "* OK") && !strings.HasPrefix(greeting, "* PREAUTH") {
+		conn.Close()
+		return nil, fmt.Errorf("unexpected greeting: %s", greeting)
+	}
+
+	if account.ImapSecurity == SecurityStartTLS {
+		// like dial, we never fall back to plain text when STARTTLS fails
+		if err = c.command("STARTTLS", nil); err != nil {
+			conn.Close()
+			return nil, err
+		}
+
+		config, err := tlsConfig(account)
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+		tlsConn := tls.Client(conn, config)
+		if err = tlsConn.Handshake(); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("tls handshake failed: %w", err)
+		}
+		c.conn = tlsConn
+		c.reader = bufio.NewReader(tlsConn)
+	}
+
+	return c, nil
+}
+
+// login does what login in auth.go does, but without go-imap
+func (c *notifyConn) login(account db.Account, password string) error {
+	username := account.ImapUsername
+
+	if !oauth.IsOAuth(account.ImapAuthMethod) {
+		// literals work for every username and password, quoted strings don't
+		pieces := []string{username + " {" + strconv.Itoa(len(password)) + "}", password}
+		return c.command("LOGIN {"+strconv.Itoa(len(username))+"}", func(string) (string, error) {
+			if len(pieces) == 0 {
+				return "", errors.New("unexpected continuation")
+			}
+			piece := pieces[0]
+			pieces = pieces[1:]
+			return piece, nil
+		})
+	}
+
+	token, err := oauth.AccessToken(context.Background(), account)
+	if err != nil {
+		return fmt.Errorf("failed to get access token: %w", err)
+	}
+
+	client := oauth.NewSASLClient(account.ImapAuthMethod, username, token, account.ImapServer, int(account.ImapPort))
+	mechanism, initial, err := client.Start()
+	if err != nil {
+		return err
+	}
+
+	started := false
+	return c.command("AUTHENTICATE "+mechanism, func(challenge string) (string, error) {
+		if !started {
+			started = true
+			return base64.StdEncoding.EncodeToString(initial), nil
+		}
+
+		decoded, err := base64.StdEncoding.DecodeString(challenge)
+		if err != nil {
+			return "*", nil
+		}
+		response, err := client.Next(decoded)
+		if err != nil {
+			// cancels the exchange, the server answers with NO
+			return "*", nil
+		}
+		return base64.StdEncoding.EncodeToString(response), nil
+	})
+}
+
+// command sends the command and reads until its tagged response. continuation requests are
+// answered by cont, untagged responses in between are dropped.
+func (c *notifyConn) command(command string, cont func(challenge string) (string, error)) error {
+	c.writeMu.Lock()
+	c.tag++
+	tag := "N" + strconv.Itoa(c.tag)
+	err := c.writeLine(tag + " " + command)
+	c.writeMu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	name, _, _ := strings.Cut(command, " ")
+
+	for {
+		line, err := c.readResponse()
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case strings.HasPrefix(line, "+"):
+			if cont == nil {
+				return fmt.Errorf("%s: unexpected continuation request", name)
+			}
+			response, err := cont(strings.TrimSpace(strings.TrimPrefix(line, "+")))
+			if err != nil {
+				return err
+			}
+			c.writeMu.Lock()
+			err = c.writeLine(response)
+			c.writeMu.Unlock()
+			if err != nil {
+				return err
+			}
+		case strings.HasPrefix(line, "* BYE"):
+			return fmt.Errorf("%s: server closed the connection: %s", name, line)
+		case strings.HasPrefix(line, tag+" "):
+			if _, status, text := splitTagged(line); status != "OK" {
+				return &statusError{command: name, text: text}
+			}
+			return nil
+		}
+	}
+}
+
+func (c *notifyConn) keepAlive(done <-chan struct{}) {
+	ticker := time.NewTicker(notifyKeepAlive)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			c.writeMu.Lock()
+			c.tag++
+			err := c.writeLine("N" + strconv.Itoa(c.tag) + " NOOP")
+			c.writeMu.Unlock()
+			if err != nil {
+				// the read loop notices the closed connection
+				c.conn.Close()
+				return
+			}
+		}
+	}
+}
+
+func (c *notifyConn) writeLine(line string) error {
+	_, err := io.WriteString(c.conn, line+"\r\n")
+	return err
+}
+
+// readResponse reads one response line. literals are turned into quoted strings, so the parsing
+// only has to know about those.
+func (c *notifyConn) readResponse() (string, error) {
+	var response strings.Builder
+
+	for {
+		line, err := c.reader.ReadString('\n')
+		if err != nil {
+			return "", err
+		}
+		line = strings.TrimRight(line, "\r\n")
+
+		size, prefix, ok := literalSize(line)
+		if !ok {
+			response.WriteString(line)
+			return response.String(), nil
+		}
+		if size > maxLiteralSize {
+			return "", fmt.Errorf("literal of %d bytes is too large", size)
+		}
+
+		literal := make([]byte, size)
+		if _, err = io.ReadFull(c.reader, literal); err != nil {
+			return "", err
+		}
+		response.WriteString(prefix)
+		response.WriteString(quote(string(literal)))
+	}
+}
+
+// literalSize finds the {n} a line ends with when a literal follows it
+func literalSize(line string) (int, string, bool) {
+	if !strings.HasSuffix(line, "}") {
+		return 0, "", false
+	}
+	start := strings.LastIndexByte(line, '{')
+	if start < 0 {
+		return 0, "", false
+	}
+
+	size, err := strconv.Atoi(strings.TrimSuffix(line[start+1:len(line)-1], "+"))
+	if err != nil || size < 0 {
+		return 0, "", false
+	}
+	return size, line[:start], true
+}
+
+// splitTagged splits "tag STATUS text"
+func splitTagged(line string) (string, string, string) {
+	tag, rest, _ := strings.Cut(line, " ")
+	status, text, _ := strings.Cut(rest, " ")
+	return tag, strings.ToUpper(status), text
+}
+
+// parseAString reads the quoted string or atom at the start of s
+func parseAString(s string) (string, bool) {
+	if s == "" {
+		return "", false
+	}
+
+	if s[0] != '"' {
+		atom, _, _ := strings.Cut(s, " ")
+		return atom, atom != ""
+	}
+
+	var value strings.Builder
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+			if i < len(s) {
+				value.WriteByte(s[i])
+			}
+		case '"':
+			return value.String(), true
+		default:
+			value.WriteByte(s[i])
+		}
+	}
+	return "", false
+}
+
+func quote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
+
+func hasPrefixFold(s, prefix string) bool {
+	return len(s) >= len(prefix) && strings.EqualFold(s[:len(prefix)], prefix)
+}
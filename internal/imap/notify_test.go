@@ -0,0 +1,132 @@
+package imap
+
+import (
+	"bufio"
+	"context"
+	"github.com/rexxDigital/clmail/internal/db"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNotifySetCommand(t *testing.T) {
+	tests := []struct {
+		folders []string
+		want    string
+	}{
+		{[]string{"INBOX"}, `NOTIFY SET (mailboxes ("INBOX") (MessageNew MessageExpunge))`},
+		{[]string{"INBOX", "Work"}, `NOTIFY SET (mailboxes ("INBOX" "Work") (MessageNew MessageExpunge))`},
+		{[]string{"Entwürfe", `a"b`}, `NOTIFY SET (mailboxes ("Entw&APw-rfe" "a\"b") (MessageNew MessageExpunge))`},
+	}
+
+	for _, test := range tests {
+		if got := notifySetCommand(test.folders); got != test.want {
+			t.Errorf("notifySetCommand(%q) = %s, want %s", test.folders, got, test.want)
+		}
+	}
+}
+
+// scriptedServer answers one connection with the replies, in order, to whatever the client sends,
+// and hands every line it reads to lines
+func scriptedServer(t *testing.T, replies []string, lines chan<- string) (string, int64) {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		reader := bufio.NewReader(conn)
+		conn.Write([]byte("* OK scripted server ready\r\n"))
+		for _, reply := range replies {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				return
+			}
+			lines <- strings.TrimRight(line, "\r\n")
+			conn.Write([]byte(reply))
+		}
+		// keep the connection open until the client closes it
+		reader.ReadString('\n')
+	}()
+
+	addr := listener.Addr().(*net.TCPAddr)
+	return addr.IP.String(), int64(addr.Port)
+}
+
+func TestNotifySessionSendsNotifySet(t *testing.T) {
+	lines := make(chan string, 10)
+	host, port := scriptedServer(t, []string{
+		// LOGIN {4}, then the username line with the password literal, then the password
+		"+ ready\r\n",
+		"+ ready\r\n",
+		"N1 OK logged in\r\n",
+		"N2 OK NOTIFY done\r\n* STATUS \"Work\" (MESSAGES 2)\r\n",
+	}, lines)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	client := &idleClient{
+		account: db.Account{
+			ImapServer:     host,
+			ImapPort:       port,
+			ImapSecurity:   SecurityNone,
+			ImapUsername:   "user",
+			ImapAuthMethod: "plain",
+		},
+		password: "secret",
+		ctx:      ctx,
+	}
+
+	changed := make(chan string, 1)
+	handler := PushHandler{
+		Changed: func(folder string) { changed <- folder },
+		Active:  func(string, bool) {},
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- client.notifySession([]string{"INBOX", "Work"}, handler) }()
+
+	var sent []string
+	for len(sent) < 4 {
+		select {
+		case line := <-lines:
+			sent = append(sent, line)
+		case err := <-done:
+			t.Fatalf("session ended early: %v, sent %q", err, sent)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out, sent %q", sent)
+		}
+	}
+
+	want := `N2 NOTIFY SET (mailboxes ("INBOX" "Work") (MessageNew MessageExpunge))`
+	if sent[3] != want {
+		t.Errorf("sent %s, want %s", sent[3], want)
+	}
+
+	select {
+	case folder := <-changed:
+		if folder != "Work" {
+			t.Errorf("changed %q, want Work", folder)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("STATUS response didn't reach the handler")
+	}
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("session didn't stop with its context")
+	}
+}
@@ -0,0 +1,264 @@
+package imap
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/emersion/go-imap/v2"
+	"github.com/emersion/go-imap/v2/imapclient"
+	"github.com/rexxDigital/clmail/internal/db"
+	"io"
+	"log"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultPoolSize is how many connections one account may have open besides the idle one,
+	// most providers allow around 10 per account so this leaves room for other clients
+	defaultPoolSize = 3
+	// connections that sat unused for longer get a NOOP before they are handed out again
+	healthCheckAfter = 30 * time.Second
+	// servers may log us out after 30 minutes without a command, don't even try those
+	maxIdleTime = 25 * time.Minute
+)
+
+var ErrPoolClosed = errors.New("connection pool is closed")
+
+// ErrOffline wraps failures to reach the server, as opposed to the server refusing something
+var ErrOffline = errors.New("server unreachable")
+
+var (
+	pools      = make(map[int64]*Pool)
+	poolsMutex sync.Mutex
+)
+
+// Pool keeps a few logged in connections to one account and hands them out one user at a time.
+// sync, body fetching, saving sent mail and user actions all share it, so we don't log in for
+// every folder.
+type Pool struct {
+	account  db.Account
+	password string
+
+	// slots holds one token per connection that may be in use, Get blocks when it's empty
+	slots chan struct{}
+
+	mu     sync.Mutex
+	idle   []*Conn
+	closed bool
+}
+
+// Conn is a pooled connection, give it back with Release when done
+type Conn struct {
+	*imapclient.Client
+
+	pool        *Pool
+	readOnly    bool
+	uidValidity uint32
+	lastUsed    time.Time
+}
+
+// PoolFor returns the pool of the account, creating it on first use
+func PoolFor(account db.Account, password string) *Pool {
+	poolsMutex.Lock()
+	defer poolsMutex.Unlock()
+
+	if pool, ok := pools[account.ID]; ok {
+		return pool
+	}
+
+	pool := NewPool(account, password, defaultPoolSize)
+	pools[account.ID] = pool
+	return pool
+}
+
+// ClosePool logs out all connections of the account, the next PoolFor starts a new pool with
+// whatever settings and password it gets
+func ClosePool(accountID int64) {
+	poolsMutex.Lock()
+	pool, ok := pools[accountID]
+	delete(pools, accountID)
+	poolsMutex.Unlock()
+
+	if ok {
+		pool.Close()
+	}
+}
+
+func NewPool(account db.Account, password string, size int) *Pool {
+	slots := make(chan struct{}, size)
+	for i := 0; i < size; i++ {
+		slots <- struct{}{}
+	}
+
+	return &Pool{
+		account:  account,
+		password: password,
+		slots:    slots,
+	}
+}
+
+// Get hands out a logged in connection with the folder selected, or with nothing selected when
+// folder is empty. it waits when all connections are in use.
+func (p *Pool) Get(ctx context.Context, folder string, readOnly bool) (*Conn, error) {
+	select {
+	case <-p.slots:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	conn, err := p.connection()
+	if err != nil {
+		p.slots <- struct{}{}
+		return nil, err
+	}
+
+	if folder != "" {
+		if err = conn.Select(folder, readOnly); err != nil {
+			conn.Release(err)
+			return nil, err
+		}
+	}
+
+	return conn, nil
+}
+
+// connection takes a healthy idle connection or dials a new one
+func (p *Pool) connection() (*Conn, error) {
+	for {
+		p.mu.Lock()
+		if p.closed {
+			p.mu.Unlock()
+			return nil, ErrPoolClosed
+		}
+		if len(p.idle) == 0 {
+			p.mu.Unlock()
+			break
+		}
+		// the most recently used one is the most likely to still be alive
+		conn := p.idle[len(p.idle)-1]
+		p.idle = p.idle[:len(p.idle)-1]
+		p.mu.Unlock()
+
+		if conn.healthy() {
+			return conn, nil
+		}
+		// no logout, it would just hang on a dead connection
+		conn.Client.Close()
+	}
+
+	client, err := dial(p.account, nil)
+	if err != nil {
+		return nil, fmt.Errorf("[IMAP::Pool] failed to dial: %w: %w", ErrOffline, err)
+	}
+
+	if err = login(client, p.account, p.account.ImapUsername, p.password); err != nil {
+		client.Close()
+		return nil, fmt.Errorf("[IMAP::Pool] failed to login: %w", err)
+	}
+
+	return &Conn{Client: client, pool: p, lastUsed: time.Now()}, nil
+}
+
+// Close logs out the idle connections, the ones in use are closed when they are released
+func (p *Pool) Close() {
+	p.mu.Lock()
+	p.closed = true
+	idle := p.idle
+	p.idle = nil
+	p.mu.Unlock()
+
+	for _, conn := range idle {
+		conn.close()
+	}
+}
+
+// Select selects the folder unless it already is. a read only request is happy with a read write
+// selection, the other way around we have to select again.
+func (c *Conn) Select(folder string, readOnly bool) error {
+	if mailbox := c.Mailbox(); mailbox != nil && mailbox.Name == folder && (readOnly || !c.readOnly) {
+		return nil
+	}
+
+	data, err := c.Client.Select(folder, &imap.SelectOptions{ReadOnly: readOnly}).Wait()
+	if err != nil {
+		return fmt.Errorf("[IMAP::Conn] failed to select %s: %w", folder, err)
+	}
+
+	c.readOnly = readOnly
+	c.uidValidity = data.UIDValidity
+	return nil
+}
+
+// UIDValidity of the selected folder, the uids we have for it are only good while it stays the same
+func (c *Conn) UIDValidity() uint32 {
+	return c.uidValidity
+}
+
+// Release gives the connection back to the pool. err is whatever the last command returned, only
+// a network or protocol error drops the connection.
+func (c *Conn) Release(err error) {
+	broken := c.broken(err)
+
+	c.pool.mu.Lock()
+	closed := c.pool.closed
+	if !broken && !closed {
+		c.lastUsed = time.Now()
+		c.pool.idle = append(c.pool.idle, c)
+	}
+	c.pool.mu.Unlock()
+
+	if broken {
+		c.Client.Close()
+	} else if closed {
+		c.close()
+	}
+
+	c.pool.slots <- struct{}{}
+}
+
+// broken reports whether err means the connection is gone. a NO or BAD from the server, a conflict
+// like ErrMessageGone and a failed write to the database leave it usable.
+func (c *Conn) broken(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) || errors.Is(err, net.ErrClosed) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return true
+	}
+	// imapclient closes itself on a response it can't parse, the error doesn't say so
+	return c.Client.State() == imap.ConnStateLogout
+}
+
+// Discard logs the connection out instead of giving it back. after a folder was renamed or deleted
+// the connection may still think it has the old one selected.
+func (c *Conn) Discard() {
+	c.close()
+	c.pool.slots <- struct{}{}
+}
+
+func (c *Conn) healthy() bool {
+	idleFor := time.Since(c.lastUsed)
+	if idleFor > maxIdleTime {
+		return false
+	}
+	if idleFor < healthCheckAfter {
+		return true
+	}
+
+	if err := c.Noop().Wait(); err != nil {
+		log.Printf("[IMAP::Conn] Pooled connection failed health check: %v", err)
+		return false
+	}
+	return true
+}
+
+func (c *Conn) close() {
+	if err := c.Logout().Wait(); err != nil {
+		log.Printf("[IMAP::Conn] Failed to logout: %v", err)
+	}
+	c.Client.Close()
+}
@@ -2,52 +2,53 @@ package imap
 
 import (
 	"context"
-	"database/sql"
 	"fmt"
 	"github.com/emersion/go-imap/v2"
-	"github.com/emersion/go-imap/v2/imapclient"
-	"github.com/emersion/go-message"
-	"github.com/emersion/go-message/mail"
 	"github.com/rexxDigital/clmail/internal/db"
-	"io"
+	"github.com/rexxDigital/clmail/internal/services/events"
 	"log"
 	"strings"
 	"time"
 )
 
+// Progress is told how far a sync got, total is the number of messages the current step fetches
+type Progress func(done, total int)
+
+// headerBatchSize is how many headers one FETCH asks for, so progress moves and memory stays flat
+const headerBatchSize = 100
+
 type SyncClient interface {
-	SyncFolder(folder string) error
+	SyncFolder(folder string, progress Progress) error
 	SaveSent(mail string, date time.Time) error
+	Search(folder string, criteria *imap.SearchCriteria) ([]imap.UID, error)
+	FetchHeaders(folder string, uids []imap.UID) error
+	// what the user did, replayed from the queue of pending actions
+	StoreFlag(target Target, flag imap.Flag, set bool) error
+	Move(target Target, folder string) error
+	Expunge(target Target) error
+	CreateFolder(name string) error
+	RenameFolder(name, newName string) error
+	DeleteFolder(name string) error
 	Close() error
 }
 
 type syncClient struct {
 	account  db.Account
-	password string
-	client   *imapclient.Client
+	pool     *Pool
 	dbClient *db.Client
-	folderID int64
 }
 
+// NewSyncClient borrows connections from the pool of the account for every call instead of
+// holding its own, so creating one is cheap and doesn't log in
 func NewSyncClient(account db.Account, password string, dbClient *db.Client) (SyncClient, error) {
-	client, err := imapclient.DialTLS(fmt.Sprintf("%v:%v", account.ImapServer, account.ImapPort), nil)
-	if err != nil {
-		return nil, fmt.Errorf("[SyncClient::NewIdleClient] failed to dial: %w", err)
-	}
-
-	if err = client.Login(account.ImapUsername, password).Wait(); err != nil {
-		return nil, fmt.Errorf("[SyncClient::NewIdleClient] failed to login: %w", err)
-	}
-
 	return &syncClient{
 		account:  account,
-		password: password,
-		client:   client,
+		pool:     PoolFor(account, password),
 		dbClient: dbClient,
 	}, nil
 }
 
-func (c *syncClient) SyncFolder(folder string) error {
+func (c *syncClient) SyncFolder(folder string, progress Progress) (err error) {
 	dbFolder, err := c.dbClient.GetFolderByName(context.Background(), db.GetFolderByNameParams{
 		Name:      folder,
 		AccountID: c.account.ID,
@@ -56,28 +57,25 @@ func (c *syncClient) SyncFolder(folder string) error {
 		return err
 	}
 
-	if dbFolder.Name == "INBOX" {
-		return nil
-	}
-
-	c.folderID = dbFolder.ID
-
-	_, err = c.client.Select(folder, nil).Wait()
+	conn, err := c.pool.Get(context.Background(), folder, false)
 	if err != nil {
-		return fmt.Errorf("[SyncClient::SyncFolder] failed to select folder: %w", err)
+		return fmt.Errorf("[SyncClient::SyncFolder] failed to get connection: %w", err)
 	}
+	defer func() { conn.Release(err) }()
 
-	if err = c.fetchMessageHeaders(folder, dbFolder.ID); err != nil {
-		return err
+	if progress == nil {
+		progress = func(int, int) {}
 	}
 
-	if err = c.fetchBodiesForFolder(folder); err != nil {
+	if err = c.checkUIDValidity(conn, dbFolder); err != nil {
 		return err
 	}
-	return nil
+
+	// bodies are left to the BodyFetcher
+	return c.fetchMessageHeaders(conn, folder, dbFolder.ID, progress)
 }
 
-func (c *syncClient) SaveSent(mail string, date time.Time) error {
+func (c *syncClient) SaveSent(mail string, date time.Time) (err error) {
 	folders, err := c.dbClient.ListFolders(context.Background(), c.account.ID)
 	if err != nil {
 		return err
@@ -85,13 +83,19 @@ func (c *syncClient) SaveSent(mail string, date time.Time) error {
 
 	sentFolder := ""
 	for _, folder := range folders {
-		if strings.Contains(strings.ToLower(folder.Name), strings.ToLower("sent")) {
+		if !folder.Local && strings.Contains(strings.ToLower(folder.Name), strings.ToLower("sent")) {
 			sentFolder = folder.Name
 			break
 		}
 	}
 
-	appendCmd := c.client.Append(sentFolder, int64(len(mail)), &imap.AppendOptions{
+	conn, err := c.pool.Get(context.Background(), "", false)
+	if err != nil {
+		return fmt.Errorf("[SyncClient::SaveSent] failed to get connection: %w", err)
+	}
+	defer func() { conn.Release(err) }()
+
+	appendCmd := conn.Append(sentFolder, int64(len(mail)), &imap.AppendOptions{
 		Flags: []imap.Flag{imap.FlagSeen},
 		Time:  date,
 	})
@@ -114,54 +118,60 @@ func (c *syncClient) SaveSent(mail string, date time.Time) error {
 	return nil
 }
 
-func (c *syncClient) Close() error {
-	if err := c.client.Logout().Wait(); err != nil {
-		return fmt.Errorf("[SyncClient::Close] failed to logout: %w", err)
+// Search runs a UID SEARCH in the folder, asking for an ESEARCH reply when the server supports it
+// so large result sets come back as uid ranges instead of one number per message.
+func (c *syncClient) Search(folder string, criteria *imap.SearchCriteria) (_ []imap.UID, err error) {
+	conn, err := c.pool.Get(context.Background(), folder, true)
+	if err != nil {
+		return nil, fmt.Errorf("[SyncClient::Search] failed to select folder: %w", err)
 	}
-	return c.client.Close()
-}
+	defer func() { conn.Release(err) }()
 
-// fetchMessageHeaders has to close idle since it is a blocking command
-// and then restart our idle, so we keep track of it inside our struct.
-func (c *syncClient) fetchMessageHeaders(folder string, folderID int64) error {
-	status, err := c.client.Status(folder, &imap.StatusOptions{
-		NumMessages: true,
-		UIDNext:     true,
-	}).Wait()
-	if err != nil {
+	var options *imap.SearchOptions
+	if conn.Caps().Has(imap.CapESearch) || conn.Caps().Has(imap.CapIMAP4rev2) {
+		options = &imap.SearchOptions{ReturnAll: true}
+	}
 
-		return fmt.Errorf("[SyncClient::fetchMessages] Failed to get status: %v", err)
+	data, err := conn.UIDSearch(criteria, options).Wait()
+	if err != nil {
+		return nil, fmt.Errorf("[SyncClient::Search] failed to search: %w", err)
 	}
 
-	if *status.NumMessages == 0 {
+	return data.AllUIDs(), nil
+}
+
+// FetchHeaders stores the headers of specific messages, used for search hits we have not synced yet.
+func (c *syncClient) FetchHeaders(folder string, uids []imap.UID) (err error) {
+	if len(uids) == 0 {
 		return nil
 	}
 
-	uidSet := imap.UIDSet{}
+	folderID, err := getFolderID(folder, c.account.ID, c.dbClient)
+	if err != nil {
+		return fmt.Errorf("[SyncClient::FetchHeaders] failed to get folder: %w", err)
+	}
 
-	// only get new messages, so we don't refetch large amounts of mails that we already track.
-	highestUID, err := getHighestUIDInFolder(folderID, c.dbClient)
-	if err != nil || highestUID == 0 {
-		uidSet.AddRange(1, 0)
-	} else {
-		uidSet.AddRange(imap.UID(highestUID+1), 0)
+	conn, err := c.pool.Get(context.Background(), folder, true)
+	if err != nil {
+		return fmt.Errorf("[SyncClient::FetchHeaders] failed to select folder: %w", err)
 	}
+	defer func() { conn.Release(err) }()
+
+	uidSet := imap.UIDSetNum(uids...)
 
-	// we are fetching the body structure first as it is fast, and we can get all necessary metadata
-	// for displaying new mails in the mail list
 	fetchOptions := &imap.FetchOptions{
 		Flags:         true,
 		Envelope:      true,
 		UID:           true,
-		BodyStructure: &imap.FetchItemBodyStructure{},
+		BodyStructure: &imap.FetchItemBodyStructure{Extended: true},
+		RFC822Size:    true,
 	}
 
-	messages, err := c.client.Fetch(uidSet, fetchOptions).Collect()
+	messages, err := conn.Fetch(uidSet, fetchOptions).Collect()
 	if err != nil {
-		return fmt.Errorf("failed to fetch messages: %w", err)
+		return fmt.Errorf("[SyncClient::FetchHeaders] failed to fetch messages: %w", err)
 	}
 
-	// Process each message
 	for _, msg := range messages {
 		processBodyStructure(msg, folderID, c.account.ID, c.dbClient)
 	}
@@ -169,179 +179,106 @@ func (c *syncClient) fetchMessageHeaders(folder string, folderID int64) error {
 	return nil
 }
 
-// fetchBodiesForFolder finds emails without bodies fetches all body data
-func (c *syncClient) fetchBodiesForFolder(folder string) error {
-	emails, err := c.dbClient.GetEmailsWithoutBodies(context.Background(), db.GetEmailsWithoutBodiesParams{
-		AccountID: c.account.ID,
-		FolderID:  c.folderID,
-		Limit:     50,
-	})
-	if err != nil {
-
-		return fmt.Errorf("[SyncClient::queueEmailsForBodyFetching] Failed to get emails without bodies: %v", err)
+// checkUIDValidity stores the UIDVALIDITY of the folder. when it changed the folder was recreated,
+// the uids we have point at nothing or at other messages, so everything is fetched again.
+func (c *syncClient) checkUIDValidity(conn *Conn, folder db.Folder) error {
+	validity := int64(conn.UIDValidity())
+	if validity == 0 || validity == folder.UidValidity {
+		return nil
 	}
 
-	_, err = c.client.Select(folder, nil).Wait()
-	if err != nil {
-		return fmt.Errorf("failed to select folder %s: %w", folder, err)
+	if folder.UidValidity != 0 {
+		log.Printf("[SyncClient::checkUIDValidity] UIDVALIDITY of %s changed, fetching it again", folder.Name)
+		if err := c.dbClient.ClearFolderEmails(context.Background(), folder.ID); err != nil {
+			return fmt.Errorf("[SyncClient::checkUIDValidity] failed to clear folder: %w", err)
+		}
+		events.Publish(events.Expunged{AccountID: c.account.ID, FolderID: folder.ID})
 	}
 
-	// fetch bodies immediately unlike idle
-	for _, email := range emails {
-		err := c.fetchEmailBody(email.Uid)
-		if err != nil {
-			return fmt.Errorf("[SyncClient::fetchBodiesForFolder] Failed to fetch body for email %d (UID %d): %v",
-				email.ID, email.Uid, err)
-		}
+	err := c.dbClient.SetFolderUIDValidity(context.Background(), db.SetFolderUIDValidityParams{
+		UidValidity: validity,
+		ID:          folder.ID,
+	})
+	if err != nil {
+		return fmt.Errorf("[SyncClient::checkUIDValidity] failed to store uid validity: %w", err)
 	}
+	return nil
+}
 
+// Close is a no-op, the connections belong to the pool and are closed with ClosePool
+func (c *syncClient) Close() error {
 	return nil
 }
 
-func (c *syncClient) fetchEmailBody(emailID int64) error {
-	email, err := c.dbClient.GetEmailByFolderAndUID(context.Background(), db.GetEmailByFolderAndUIDParams{
-		Uid:      emailID,
-		FolderID: c.folderID,
-	})
+// fetchMessageHeaders has to close idle since it is a blocking command
+// and then restart our idle, so we keep track of it inside our struct.
+func (c *syncClient) fetchMessageHeaders(conn *Conn, folder string, folderID int64, progress Progress) error {
+	status, err := conn.Status(folder, &imap.StatusOptions{
+		NumMessages: true,
+		UIDNext:     true,
+	}).Wait()
 	if err != nil {
-		return fmt.Errorf("[SyncClient::fetchEmailBody] failed to get email: %w", err)
+
+		return fmt.Errorf("[SyncClient::fetchMessages] Failed to get status: %v", err)
 	}
 
-	if email.BodyText.Valid {
-		log.Printf("[SyncClient::fetchEmailBody] VALID??")
+	if *status.NumMessages == 0 {
 		return nil
 	}
 
-	fetchOptions := &imap.FetchOptions{
-		BodySection: []*imap.FetchItemBodySection{{}},
+	// only get new messages, so we don't refetch large amounts of mails that we already track.
+	highestUID, _ := getHighestUIDInFolder(folderID, c.dbClient)
+
+	// search first so we know how many there are, that's the total of the progress
+	searchSet := imap.UIDSet{}
+	searchSet.AddRange(imap.UID(highestUID+1), 0)
+	var searchOptions *imap.SearchOptions
+	if conn.Caps().Has(imap.CapESearch) || conn.Caps().Has(imap.CapIMAP4rev2) {
+		searchOptions = &imap.SearchOptions{ReturnAll: true}
 	}
 
-	uidSet := imap.UIDSet{}
-	uidSet.AddNum(imap.UID(email.Uid))
-
-	fetchCmd := c.client.Fetch(uidSet, fetchOptions)
-	defer fetchCmd.Close()
-
-	// loop through each mail message
-	for {
-		msg := fetchCmd.Next()
+	data, err := conn.UIDSearch(&imap.SearchCriteria{UID: []imap.UIDSet{searchSet}}, searchOptions).Wait()
+	if err != nil {
+		return fmt.Errorf("[SyncClient::fetchMessages] Failed to search new messages: %w", err)
+	}
 
-		/*
-			if the message is nil we don't have any more messages to fetch
-			if we don't have anything more to fetch we break
-		*/
-		if msg == nil {
-			break
+	// n:* always matches the last message, even when its uid is below n
+	var uids []imap.UID
+	for _, uid := range data.AllUIDs() {
+		if uint32(uid) > highestUID {
+			uids = append(uids, uid)
 		}
+	}
 
-		var bodySection imapclient.FetchItemDataBodySection
-
-		// loop through the mail data to find each item we are looking for
-		ok := false
-		for {
-			// get next data point
-			item := msg.Next()
-
-			// check if we have read all the data points we want
-			if item == nil {
-				break
-			}
-
-			// go through each section and set data
-			bodySection, ok = item.(imapclient.FetchItemDataBodySection)
-			switch item := item.(type) {
-			case imapclient.FetchItemDataBodySection:
-				bodySection = item
-				ok = true
-			default:
-			}
-			if ok {
-				break
-			}
-		}
-		if !ok {
-			log.Println("[SyncClient::fetchEmailBody] Could not fetch all data from message")
-			continue
-		}
+	if len(uids) == 0 {
+		return nil
+	}
 
-		// create mail reader
-		mailReader, err := mail.CreateReader(bodySection.Literal)
-		if err != nil {
-			log.Printf("[SyncClient::fetchEmailBody] Could not create mail reader: %v", err)
-			continue
-		}
+	// we are fetching the body structure first as it is fast, and we can get all necessary metadata
+	// for displaying new mails in the mail list
+	fetchOptions := &imap.FetchOptions{
+		Flags:         true,
+		Envelope:      true,
+		UID:           true,
+		BodyStructure: &imap.FetchItemBodyStructure{Extended: true},
+		RFC822Size:    true,
+	}
 
-		// get References from mail header
-		header := mailReader.Header
-		/*
-		   try to get references from msg-id if available
-		*/
-		mailReferences, err := header.MsgIDList("References")
-		if mailReferences == nil {
-			mailReferences = make([]string, 0)
-		}
+	progress(0, len(uids))
+	for start := 0; start < len(uids); start += headerBatchSize {
+		batch := uids[start:min(start+headerBatchSize, len(uids))]
 
-		// get mail body data
-		var _, bodyPlain []byte
-
-		for {
-			part, err := mailReader.NextPart()
-			if err == io.EOF {
-				break
-			} else if message.IsUnknownCharset(err) {
-				log.Printf("[SyncClient::fetchEmailBody] Could not read mail part trying to decode charset with error: %v", err.Error())
-			} else if err != nil {
-				log.Printf("[SyncClient::fetchEmailBody] Could not read mail part with error: %v", err.Error())
-				break
-			}
-
-			switch h := part.Header.(type) {
-			case *mail.InlineHeader:
-				ct, params, _ := h.ContentType()
-
-				charset := params["charset"]
-
-				decodedBody, err := decodeCharset(charset, part.Body)
-				if err != nil {
-					log.Printf("[SyncClient::fetchEmailBody] Could not decode charset with error: %v", err.Error())
-				}
-
-				switch ct {
-				case "text/plain":
-					bodyPlain, _ = io.ReadAll(decodedBody)
-				case "text/html":
-					_, _ = io.ReadAll(decodedBody)
-				}
-			case *mail.AttachmentHeader:
-				// TODO: Add support for attachments
-				_, _ = h.Filename()
-			}
+		messages, err := conn.Fetch(imap.UIDSetNum(batch...), fetchOptions).Collect()
+		if err != nil {
+			return fmt.Errorf("failed to fetch messages: %w", err)
 		}
 
-		refs := ""
-		for i, ref := range mailReferences {
-			if i != len(mailReferences)-1 {
-				refs += ref + ","
-			} else {
-				refs += ref
-			}
+		// Process each message
+		for _, msg := range messages {
+			processBodyStructure(msg, folderID, c.account.ID, c.dbClient)
 		}
 
-		_, err = c.dbClient.UpdateEmailBodyAndReferences(context.Background(), db.UpdateEmailBodyAndReferencesParams{
-			ID: email.ID,
-			BodyText: sql.NullString{
-				String: (string)(bodyPlain),
-				Valid:  bodyPlain != nil,
-			},
-			ReferenceID: sql.NullString{
-				String: refs,
-				Valid:  refs != "",
-			},
-		})
-
-		if err != nil {
-			return fmt.Errorf("[SyncClient::fetchEmailBody] failed to update email: %w", err)
-		}
+		progress(start+len(batch), len(uids))
 	}
 
 	return nil
@@ -0,0 +1,83 @@
+package imap
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"strings"
+	"unicode/utf16"
+)
+
+// the modified UTF-7 of mailbox names (RFC 3501 5.1.3). go-imap handles it for its own commands,
+// this is for the ones we send ourselves.
+var utf7Encoding = base64.NewEncoding("ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789+,").WithPadding(base64.NoPadding)
+
+func encodeUTF7(name string) string {
+	var encoded strings.Builder
+	var run []rune
+
+	flush := func() {
+		if len(run) == 0 {
+			return
+		}
+		units := utf16.Encode(run)
+		buf := make([]byte, 2*len(units))
+		for i, unit := range units {
+			binary.BigEndian.PutUint16(buf[2*i:], unit)
+		}
+		encoded.WriteByte('&')
+		encoded.WriteString(utf7Encoding.EncodeToString(buf))
+		encoded.WriteByte('-')
+		run = run[:0]
+	}
+
+	for _, r := range name {
+		switch {
+		case r == '&':
+			flush()
+			encoded.WriteString("&-")
+		case r >= 0x20 && r <= 0x7e:
+			flush()
+			encoded.WriteRune(r)
+		default:
+			run = append(run, r)
+		}
+	}
+	flush()
+
+	return encoded.String()
+}
+
+func decodeUTF7(name string) (string, error) {
+	var decoded strings.Builder
+
+	for {
+		start := strings.IndexByte(name, '&')
+		if start < 0 {
+			decoded.WriteString(name)
+			return decoded.String(), nil
+		}
+		decoded.WriteString(name[:start])
+		name = name[start+1:]
+
+		end := strings.IndexByte(name, '-')
+		if end < 0 {
+			return "", errors.New("unterminated modified UTF-7 in mailbox name")
+		}
+
+		if end == 0 {
+			decoded.WriteByte('&')
+		} else {
+			buf, err := utf7Encoding.DecodeString(name[:end])
+			if err != nil || len(buf)%2 != 0 {
+				return "", errors.New("invalid modified UTF-7 in mailbox name")
+			}
+			units := make([]uint16, len(buf)/2)
+			for i := range units {
+				units[i] = binary.BigEndian.Uint16(buf[2*i:])
+			}
+			decoded.WriteString(string(utf16.Decode(units)))
+		}
+		name = name[end+1:]
+	}
+}
@@ -1,12 +1,17 @@
 package imap
 
 import (
+	"bytes"
 	"context"
+	"crypto/sha256"
 	"database/sql"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"github.com/emersion/go-imap/v2"
 	"github.com/emersion/go-imap/v2/imapclient"
 	"github.com/rexxDigital/clmail/internal/db"
+	"github.com/rexxDigital/clmail/internal/services/events"
 	"github.com/wlynxg/chardet"
 	"golang.org/x/text/encoding"
 	"golang.org/x/text/encoding/charmap"
@@ -35,7 +40,10 @@ func buildAddressListString(addresses []imap.Address) string {
 func threadMail(envelope *imap.Envelope, accountID int64, dbClient *db.Client) (int64, error) {
 	// check if we have a reply-to value, if we do, link to thread
 	if len(envelope.InReplyTo) != 0 {
-		email, err := dbClient.GetEmailByMessageID(context.Background(), envelope.InReplyTo[0])
+		email, err := dbClient.GetEmailByMessageID(context.Background(), db.GetEmailByMessageIDParams{
+			AccountID: accountID,
+			MessageID: envelope.InReplyTo[0],
+		})
 		if err == nil {
 			_, err = dbClient.UpdateThread(context.Background(), db.UpdateThreadParams{
 				Subject:           envelope.Subject,
@@ -79,14 +87,22 @@ func getHighestUIDInFolder(folderID int64, dbClient *db.Client) (uint32, error)
 	return uint32(uid), nil
 }
 
-func processBodyStructure(msg *imapclient.FetchMessageBuffer, folderID int64, accountID int64, dbClient *db.Client) {
-	if msg.Envelope.MessageID != "" {
-		_, err := dbClient.GetEmailByMessageID(context.Background(), msg.Envelope.MessageID)
-		if err == nil {
-			return
-		}
+// messageKey is what copies of a message in different folders are matched by. mail without a
+// Message-ID gets a hash of the headers that don't change between copies.
+func messageKey(envelope *imap.Envelope) string {
+	if envelope.MessageID != "" {
+		return envelope.MessageID
 	}
 
+	hash := sha256.New()
+	fmt.Fprintf(hash, "%d\n%s\n", envelope.Date.Unix(), envelope.Subject)
+	for _, addresses := range [][]imap.Address{envelope.From, envelope.To, envelope.Cc} {
+		fmt.Fprintf(hash, "%s\n", buildAddressListString(addresses))
+	}
+	return "sha256:" + hex.EncodeToString(hash.Sum(nil))
+}
+
+func processBodyStructure(msg *imapclient.FetchMessageBuffer, folderID int64, accountID int64, dbClient *db.Client) {
 	// comma separated cc and bcc for db strings
 	cscc := buildAddressListString(msg.Envelope.Cc)
 	csbcc := buildAddressListString(msg.Envelope.Bcc)
@@ -95,18 +111,65 @@ func processBodyStructure(msg *imapclient.FetchMessageBuffer, folderID int64, ac
 		return
 	}
 
+	membership := db.AddEmailToFolderParams{
+		FolderID:  folderID,
+		Uid:       int64(msg.UID),
+		IsRead:    slices.Contains(msg.Flags, "\\Seen"),
+		IsStarred: slices.Contains(msg.Flags, "\\Flagged"),
+		IsDraft:   slices.Contains(msg.Flags, "\\Draft"),
+	}
+
+	key := messageKey(msg.Envelope)
+	existing, err := dbClient.GetEmailByMessageKey(context.Background(), db.GetEmailByMessageKeyParams{
+		AccountID:  accountID,
+		MessageKey: key,
+	})
+	if err == nil {
+		// we have it from another folder already, it is only in one more place now
+		membership.EmailID = existing.ID
+
+		// a placeholder of a move has the flags set since, their actions may not have been replayed yet
+		placeholder, err := dbClient.GetEmailFolder(context.Background(), db.GetEmailFolderParams{
+			EmailID:  existing.ID,
+			FolderID: folderID,
+		})
+		if err == nil && placeholder.Uid < 0 {
+			membership.IsRead = placeholder.IsRead
+			membership.IsStarred = placeholder.IsStarred
+		}
+
+		added, err := dbClient.AddEmailToFolder(context.Background(), membership)
+		if err != nil {
+			log.Printf("[IMAP::processBodyStructure] Failed to add email to folder: %v", err)
+			return
+		}
+		if added > 0 {
+			// the real copy of a message we moved here, it replaces the one we put in its place
+			if err = dbClient.RemovePlaceholders(context.Background(), db.RemovePlaceholdersParams{
+				EmailID:  existing.ID,
+				FolderID: folderID,
+			}); err != nil {
+				log.Printf("[IMAP::processBodyStructure] Failed to remove placeholders: %v", err)
+			}
+			events.Publish(events.NewMail{AccountID: accountID, FolderID: folderID})
+		}
+		return
+	} else if !errors.Is(err, sql.ErrNoRows) {
+		log.Printf("[IMAP::processBodyStructure] Failed to look up email: %v", err)
+		return
+	}
+
 	threadID, err := threadMail(msg.Envelope, accountID, dbClient)
 	if err != nil {
 		log.Printf("[IMAP::processBodyStructure] Failed to thread mail: %v", err)
 		return
 	}
 
-	_, err = dbClient.CreateEmail(context.Background(), db.CreateEmailParams{
-		Uid:          int64(msg.UID),
+	email, err := createEmail(db.CreateEmailParams{
 		ThreadID:     threadID,
 		AccountID:    accountID,
-		FolderID:     folderID,
 		MessageID:    msg.Envelope.MessageID,
+		MessageKey:   key,
 		FromAddress:  msg.Envelope.From[0].Addr(),
 		FromName:     sql.NullString{String: msg.Envelope.From[0].Name, Valid: msg.Envelope.From[0].Name != ""},
 		ToAddresses:  msg.Envelope.To[0].Addr(),
@@ -116,19 +179,89 @@ func processBodyStructure(msg *imapclient.FetchMessageBuffer, folderID int64, ac
 		BodyText:     sql.NullString{},
 		BodyHtml:     sql.NullString{},
 		ReceivedDate: msg.Envelope.Date,
-		IsRead:       slices.Contains(msg.Flags, "\\Seen"),
-		IsStarred:    slices.Contains(msg.Flags, "\\Flagged"),
-		IsDraft:      slices.Contains(msg.Flags, "\\Draft"),
-	})
+		IsRead:       membership.IsRead,
+		IsStarred:    membership.IsStarred,
+		IsDraft:      membership.IsDraft,
+		SizeBytes:    msg.RFC822Size,
+	}, membership, dbClient)
 
 	if err != nil {
 		log.Printf("[IMAP::processBodyStructure] Failed to create email: %v", err)
 		return
 	}
 
+	storeAttachmentInfo(msg.BodyStructure, email.ID, dbClient)
+	events.Publish(events.NewMail{AccountID: accountID, FolderID: folderID})
+
 	return
 }
 
+// createEmail stores the email and the folder it was found in together, an email in no folder
+// would never be shown
+func createEmail(params db.CreateEmailParams, membership db.AddEmailToFolderParams, dbClient *db.Client) (db.Email, error) {
+	tx, err := dbClient.DB.BeginTx(context.Background(), nil)
+	if err != nil {
+		return db.Email{}, fmt.Errorf("[IMAP::createEmail] failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	queries := dbClient.WithTx(tx)
+
+	email, err := queries.CreateEmail(context.Background(), params)
+	if err != nil {
+		return db.Email{}, fmt.Errorf("[IMAP::createEmail] failed to create email: %w", err)
+	}
+
+	membership.EmailID = email.ID
+	if _, err = queries.AddEmailToFolder(context.Background(), membership); err != nil {
+		return db.Email{}, fmt.Errorf("[IMAP::createEmail] failed to add email to folder: %w", err)
+	}
+
+	if err = tx.Commit(); err != nil {
+		return db.Email{}, fmt.Errorf("[IMAP::createEmail] failed to commit: %w", err)
+	}
+	return email, nil
+}
+
+// storeAttachmentInfo saves the name, type and size of every attachment in the body structure,
+// the content itself is left empty until we actually download it.
+func storeAttachmentInfo(bodyStructure imap.BodyStructure, emailID int64, dbClient *db.Client) {
+	if bodyStructure == nil {
+		return
+	}
+
+	bodyStructure.Walk(func(path []int, part imap.BodyStructure) bool {
+		singlePart, ok := part.(*imap.BodyStructureSinglePart)
+		if !ok {
+			return true
+		}
+
+		filename := singlePart.Filename()
+		disposition := singlePart.Disposition()
+		isAttachment := disposition != nil && strings.EqualFold(disposition.Value, "attachment")
+
+		if filename == "" && !isAttachment {
+			return true
+		}
+
+		if filename == "" {
+			filename = "unnamed"
+		}
+
+		_, err := dbClient.CreateAttachment(context.Background(), db.CreateAttachmentParams{
+			EmailID:   emailID,
+			Filename:  filename,
+			MimeType:  singlePart.MediaType(),
+			SizeBytes: int64(singlePart.Size),
+		})
+		if err != nil {
+			log.Printf("[IMAP::storeAttachmentInfo] Failed to create attachment: %v", err)
+		}
+
+		return true
+	})
+}
+
 // i wrote this decodeCharset function when we had issues with charsets at Sendswift
 
 func decodeCharset(charset string, input io.Reader) (io.Reader, error) {
@@ -158,6 +291,8 @@ func decodeCharset(charset string, input io.Reader) (io.Reader, error) {
 		result := detector.GetResult()
 
 		charset = result.Encoding // use detected charset
+		// the detector read everything, hand the data on instead of the drained reader
+		input = bytes.NewReader(totalData)
 	}
 
 	var decoder *encoding.Decoder
@@ -0,0 +1,247 @@
+package oauth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os/exec"
+	"runtime"
+	"strings"
+	"time"
+)
+
+type Token struct {
+	Provider     string    `json:"provider"`
+	AccessToken  string    `json:"access_token"`
+	RefreshToken string    `json:"refresh_token"`
+	Expiry       time.Time `json:"expiry"`
+}
+
+// Valid reports whether the access token can still be used for a login, with a bit of slack
+// so it doesn't expire halfway through the handshake
+func (t *Token) Valid() bool {
+	return t.AccessToken != "" && time.Now().Add(time.Minute).Before(t.Expiry)
+}
+
+// Client runs the oauth flows, the http client and browser can be swapped out to test against
+// a local mock authorization server
+type Client struct {
+	HTTP        *http.Client
+	OpenBrowser func(url string) error
+}
+
+func NewClient() *Client {
+	return &Client{
+		HTTP:        &http.Client{Timeout: 30 * time.Second},
+		OpenBrowser: openBrowser,
+	}
+}
+
+// Authorize runs the authorization code flow with PKCE. it listens on a random loopback port for
+// the redirect, opens the browser on the consent page and exchanges the code for a token.
+func (c *Client) Authorize(ctx context.Context, provider Provider, loginHint string) (*Token, error) {
+	verifier, err := randomString(32)
+	if err != nil {
+		return nil, fmt.Errorf("[OAUTH::Authorize] failed to create verifier: %w", err)
+	}
+	state, err := randomString(16)
+	if err != nil {
+		return nil, fmt.Errorf("[OAUTH::Authorize] failed to create state: %w", err)
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, fmt.Errorf("[OAUTH::Authorize] failed to listen for the redirect: %w", err)
+	}
+	defer listener.Close()
+
+	redirectURI := fmt.Sprintf("http://%s/callback", listener.Addr().String())
+
+	type callbackResult struct {
+		code string
+		err  error
+	}
+	results := make(chan callbackResult, 1)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/callback", func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+
+		var result callbackResult
+		switch {
+		case query.Get("state") != state:
+			result.err = errors.New("state mismatch in redirect")
+		case query.Get("error") != "":
+			result.err = fmt.Errorf("authorization denied: %s %s", query.Get("error"), query.Get("error_description"))
+		case query.Get("code") == "":
+			result.err = errors.New("no code in redirect")
+		default:
+			result.code = query.Get("code")
+		}
+
+		message := "You can close this window and return to clmail."
+		if result.err != nil {
+			message = "Sign in failed: " + result.err.Error()
+		}
+		fmt.Fprintf(w, "<html><body><p>%s</p></body></html>", html.EscapeString(message))
+
+		select {
+		case results <- result:
+		default:
+		}
+	})
+
+	server := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go server.Serve(listener)
+	defer server.Close()
+
+	challenge := sha256.Sum256([]byte(verifier))
+	params := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {provider.ClientID},
+		"redirect_uri":          {redirectURI},
+		"scope":                 {strings.Join(provider.Scopes, " ")},
+		"state":                 {state},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(challenge[:])},
+		"code_challenge_method": {"S256"},
+		// google only hands out a refresh token with these
+		"access_type": {"offline"},
+		"prompt":      {"consent"},
+	}
+	if loginHint != "" {
+		params.Set("login_hint", loginHint)
+	}
+
+	authURL := provider.AuthURL + "?" + params.Encode()
+	if strings.Contains(provider.AuthURL, "?") {
+		authURL = provider.AuthURL + "&" + params.Encode()
+	}
+
+	if err := c.OpenBrowser(authURL); err != nil {
+		return nil, fmt.Errorf("[OAUTH::Authorize] failed to open browser, visit %s: %w", authURL, err)
+	}
+
+	var result callbackResult
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case result = <-results:
+	}
+
+	if result.err != nil {
+		return nil, fmt.Errorf("[OAUTH::Authorize] %w", result.err)
+	}
+
+	return c.requestToken(ctx, provider, url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {result.code},
+		"redirect_uri":  {redirectURI},
+		"code_verifier": {verifier},
+	})
+}
+
+// Refresh gets a new access token, providers may or may not rotate the refresh token
+func (c *Client) Refresh(ctx context.Context, provider Provider, token *Token) (*Token, error) {
+	if token.RefreshToken == "" {
+		return nil, errors.New("[OAUTH::Refresh] no refresh token, sign in again")
+	}
+
+	refreshed, err := c.requestToken(ctx, provider, url.Values{
+		"grant_type":    {"refresh_token"},
+		"refresh_token": {token.RefreshToken},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if refreshed.RefreshToken == "" {
+		refreshed.RefreshToken = token.RefreshToken
+	}
+
+	return refreshed, nil
+}
+
+func (c *Client) requestToken(ctx context.Context, provider Provider, form url.Values) (*Token, error) {
+	form.Set("client_id", provider.ClientID)
+	if provider.ClientSecret != "" {
+		form.Set("client_secret", provider.ClientSecret)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, provider.TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, fmt.Errorf("[OAUTH::requestToken] failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("[OAUTH::requestToken] failed to request token: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, fmt.Errorf("[OAUTH::requestToken] failed to read response: %w", err)
+	}
+
+	var tokenResponse struct {
+		AccessToken      string `json:"access_token"`
+		RefreshToken     string `json:"refresh_token"`
+		ExpiresIn        int64  `json:"expires_in"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err := json.Unmarshal(body, &tokenResponse); err != nil {
+		return nil, fmt.Errorf("[OAUTH::requestToken] failed to parse response (%s): %w", resp.Status, err)
+	}
+
+	if resp.StatusCode != http.StatusOK || tokenResponse.Error != "" {
+		return nil, fmt.Errorf("[OAUTH::requestToken] token request failed: %s %s", tokenResponse.Error, tokenResponse.ErrorDescription)
+	}
+
+	if tokenResponse.AccessToken == "" {
+		return nil, errors.New("[OAUTH::requestToken] no access token in response")
+	}
+
+	// tokens without an expiry get an hour, which is what most providers hand out
+	expiresIn := tokenResponse.ExpiresIn
+	if expiresIn <= 0 {
+		expiresIn = 3600
+	}
+
+	return &Token{
+		Provider:     provider.Name,
+		AccessToken:  tokenResponse.AccessToken,
+		RefreshToken: tokenResponse.RefreshToken,
+		Expiry:       time.Now().Add(time.Duration(expiresIn) * time.Second),
+	}, nil
+}
+
+func randomString(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func openBrowser(url string) error {
+	switch runtime.GOOS {
+	case "darwin":
+		return exec.Command("open", url).Start()
+	case "windows":
+		return exec.Command("rundll32", "url.dll,FileProtocolHandler", url).Start()
+	default:
+		return exec.Command("xdg-open", url).Start()
+	}
+}
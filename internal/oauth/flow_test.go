@@ -0,0 +1,304 @@
+package oauth
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// authServer is a mock authorization server with PKCE. the authorization endpoint signs the user
+// in right away and redirects back with a code, the token endpoint checks the verifier against
+// the challenge of that code.
+type authServer struct {
+	provider Provider
+
+	mu sync.Mutex
+	// the authorization request of every code handed out
+	codes map[string]url.Values
+	// deny answers the authorization request with an error instead of a code
+	deny bool
+	// rotate hands out a new refresh token on every refresh
+	rotate bool
+	// refreshes counts the refresh grants
+	refreshes int
+}
+
+func newAuthServer(t *testing.T) *authServer {
+	t.Helper()
+
+	s := &authServer{codes: make(map[string]url.Values)}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/authorize", s.authorize)
+	mux.HandleFunc("/token", s.token)
+
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+
+	s.provider = Provider{
+		Name:         "mock",
+		ClientID:     "client-id",
+		ClientSecret: "client-secret",
+		AuthURL:      server.URL + "/authorize",
+		TokenURL:     server.URL + "/token",
+		Scopes:       []string{"mail", "offline_access"},
+		Mechanism:    MechanismXOAuth2,
+	}
+	return s
+}
+
+func (s *authServer) authorize(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	redirect, err := url.Parse(query.Get("redirect_uri"))
+	if err != nil || redirect.Hostname() != "127.0.0.1" {
+		http.Error(w, "bad redirect_uri", http.StatusBadRequest)
+		return
+	}
+
+	back := url.Values{"state": {query.Get("state")}}
+	s.mu.Lock()
+	if s.deny {
+		back.Set("error", "access_denied")
+		back.Set("error_description", "the user said no")
+	} else {
+		code := "code-" + query.Get("state")
+		s.codes[code] = query
+		back.Set("code", code)
+	}
+	s.mu.Unlock()
+
+	redirect.RawQuery = back.Encode()
+	http.Redirect(w, r, redirect.String(), http.StatusFound)
+}
+
+func (s *authServer) token(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if r.Form.Get("client_id") != s.provider.ClientID || r.Form.Get("client_secret") != s.provider.ClientSecret {
+		tokenError(w, "invalid_client")
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch r.Form.Get("grant_type") {
+	case "authorization_code":
+		request, ok := s.codes[r.Form.Get("code")]
+		if !ok {
+			tokenError(w, "invalid_grant")
+			return
+		}
+		delete(s.codes, r.Form.Get("code"))
+
+		challenge := sha256.Sum256([]byte(r.Form.Get("code_verifier")))
+		if request.Get("code_challenge_method") != "S256" ||
+			request.Get("code_challenge") != base64.RawURLEncoding.EncodeToString(challenge[:]) {
+			tokenError(w, "invalid_grant")
+			return
+		}
+		if r.Form.Get("redirect_uri") != request.Get("redirect_uri") {
+			tokenError(w, "invalid_grant")
+			return
+		}
+
+		json.NewEncoder(w).Encode(map[string]any{
+			"access_token":  "access-1",
+			"refresh_token": "refresh-1",
+			"expires_in":    3600,
+		})
+
+	case "refresh_token":
+		if !strings.HasPrefix(r.Form.Get("refresh_token"), "refresh-") {
+			tokenError(w, "invalid_grant")
+			return
+		}
+		s.refreshes++
+
+		response := map[string]any{"access_token": "access-refreshed"}
+		if s.rotate {
+			response["refresh_token"] = "refresh-rotated"
+		}
+		json.NewEncoder(w).Encode(response)
+
+	default:
+		tokenError(w, "unsupported_grant_type")
+	}
+}
+
+func tokenError(w http.ResponseWriter, code string) {
+	w.WriteHeader(http.StatusBadRequest)
+	json.NewEncoder(w).Encode(map[string]string{"error": code, "error_description": "mock says " + code})
+}
+
+// browser follows the consent page like a browser would, redirects included
+func browser(t *testing.T, opened *string) func(string) error {
+	return func(authURL string) error {
+		*opened = authURL
+		go func() {
+			resp, err := http.Get(authURL)
+			if err != nil {
+				t.Errorf("browser failed: %v", err)
+				return
+			}
+			resp.Body.Close()
+		}()
+		return nil
+	}
+}
+
+func TestAuthorize(t *testing.T) {
+	server := newAuthServer(t)
+
+	var opened string
+	client := &Client{HTTP: &http.Client{Timeout: 5 * time.Second}, OpenBrowser: browser(t, &opened)}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	token, err := client.Authorize(ctx, server.provider, "jane@example.org")
+	if err != nil {
+		t.Fatalf("Authorize failed: %v", err)
+	}
+
+	if token.Provider != "mock" || token.AccessToken != "access-1" || token.RefreshToken != "refresh-1" {
+		t.Errorf("Authorize = %+v, want the tokens of the mock", token)
+	}
+	if !token.Valid() || token.Expiry.After(time.Now().Add(time.Hour)) {
+		t.Errorf("expiry %v isn't about an hour from now", token.Expiry)
+	}
+
+	authURL, err := url.Parse(opened)
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := authURL.Query()
+	for name, want := range map[string]string{
+		"response_type": "code",
+		"client_id":     "client-id",
+		"scope":         "mail offline_access",
+		"login_hint":    "jane@example.org",
+	} {
+		if got := query.Get(name); got != want {
+			t.Errorf("%s = %q, want %q", name, got, want)
+		}
+	}
+	if query.Get("client_secret") != "" {
+		t.Error("the client secret ended up in the browser")
+	}
+}
+
+func TestAuthorizeDenied(t *testing.T) {
+	server := newAuthServer(t)
+	server.deny = true
+
+	var opened string
+	client := &Client{HTTP: &http.Client{Timeout: 5 * time.Second}, OpenBrowser: browser(t, &opened)}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	_, err := client.Authorize(ctx, server.provider, "")
+	if err == nil || !strings.Contains(err.Error(), "access_denied") {
+		t.Fatalf("Authorize = %v, want access_denied", err)
+	}
+}
+
+func TestAuthorizeStateMismatch(t *testing.T) {
+	server := newAuthServer(t)
+
+	// a redirect that didn't come from our consent page
+	client := &Client{HTTP: &http.Client{Timeout: 5 * time.Second}, OpenBrowser: func(authURL string) error {
+		parsed, err := url.Parse(authURL)
+		if err != nil {
+			return err
+		}
+		go func() {
+			resp, err := http.Get(parsed.Query().Get("redirect_uri") + "?code=forged&state=other")
+			if err == nil {
+				resp.Body.Close()
+			}
+		}()
+		return nil
+	}}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	_, err := client.Authorize(ctx, server.provider, "")
+	if err == nil || !strings.Contains(err.Error(), "state mismatch") {
+		t.Fatalf("Authorize = %v, want a state mismatch", err)
+	}
+}
+
+func TestAuthorizeCancelled(t *testing.T) {
+	server := newAuthServer(t)
+
+	// the user never finishes signing in
+	client := &Client{HTTP: &http.Client{Timeout: 5 * time.Second}, OpenBrowser: func(string) error { return nil }}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	if _, err := client.Authorize(ctx, server.provider, ""); err != context.DeadlineExceeded {
+		t.Fatalf("Authorize = %v, want the context error", err)
+	}
+}
+
+func TestRefresh(t *testing.T) {
+	tests := []struct {
+		name        string
+		rotate      bool
+		wantRefresh string
+	}{
+		{"keeps the refresh token", false, "refresh-1"},
+		{"takes a rotated refresh token", true, "refresh-rotated"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := newAuthServer(t)
+			server.rotate = test.rotate
+			client := &Client{HTTP: &http.Client{Timeout: 5 * time.Second}}
+
+			expired := &Token{Provider: "mock", AccessToken: "access-1", RefreshToken: "refresh-1", Expiry: time.Now().Add(-time.Minute)}
+			if expired.Valid() {
+				t.Fatal("an expired token is valid")
+			}
+
+			token, err := client.Refresh(context.Background(), server.provider, expired)
+			if err != nil {
+				t.Fatalf("Refresh failed: %v", err)
+			}
+			if token.AccessToken != "access-refreshed" || token.RefreshToken != test.wantRefresh || !token.Valid() {
+				t.Errorf("Refresh = %+v, want access-refreshed with %s", token, test.wantRefresh)
+			}
+			if server.refreshes != 1 {
+				t.Errorf("%d refresh requests, want 1", server.refreshes)
+			}
+		})
+	}
+}
+
+func TestRefreshErrors(t *testing.T) {
+	server := newAuthServer(t)
+	client := &Client{HTTP: &http.Client{Timeout: 5 * time.Second}}
+
+	if _, err := client.Refresh(context.Background(), server.provider, &Token{}); err == nil {
+		t.Error("Refresh without a refresh token worked")
+	}
+
+	_, err := client.Refresh(context.Background(), server.provider, &Token{RefreshToken: "revoked"})
+	if err == nil || !strings.Contains(err.Error(), "invalid_grant") {
+		t.Errorf("Refresh with a revoked token = %v, want invalid_grant", err)
+	}
+}
@@ -0,0 +1,170 @@
+package oauth
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/rexxDigital/clmail/internal/config"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// auth methods stored in accounts.imap_auth_method and smtp_auth_method
+const (
+	MechanismXOAuth2     = "xoauth2"
+	MechanismOAuthBearer = "oauthbearer"
+)
+
+// IsOAuth reports whether the auth method needs a token instead of a password
+func IsOAuth(authMethod string) bool {
+	return authMethod == MechanismXOAuth2 || authMethod == MechanismOAuthBearer
+}
+
+type Provider struct {
+	Name         string   `json:"name"`
+	ClientID     string   `json:"client_id"`
+	ClientSecret string   `json:"client_secret,omitempty"`
+	AuthURL      string   `json:"auth_url"`
+	TokenURL     string   `json:"token_url"`
+	Scopes       []string `json:"scopes"`
+	Mechanism    string   `json:"mechanism"`
+	// Domains are matched against the email domain and the imap server to pick the provider
+	Domains []string `json:"domains"`
+}
+
+// defaultProviders only know the endpoints, a client id has to be registered with the provider
+// and set in oauth.json. corporate tenants can override the endpoints there as well.
+var defaultProviders = []Provider{
+	{
+		Name:      "google",
+		AuthURL:   "https://accounts.google.com/o/oauth2/v2/auth",
+		TokenURL:  "https://oauth2.googleapis.com/token",
+		Scopes:    []string{"https://mail.google.com/"},
+		Mechanism: MechanismXOAuth2,
+		Domains:   []string{"gmail.com", "googlemail.com", "google.com"},
+	},
+	{
+		Name:     "microsoft",
+		AuthURL:  "https://login.microsoftonline.com/common/oauth2/v2.0/authorize",
+		TokenURL: "https://login.microsoftonline.com/common/oauth2/v2.0/token",
+		Scopes: []string{
+			"https://outlook.office.com/IMAP.AccessAsUser.All",
+			"https://outlook.office.com/SMTP.Send",
+			"offline_access",
+		},
+		Mechanism: MechanismXOAuth2,
+		Domains:   []string{"outlook.com", "hotmail.com", "live.com", "msn.com", "office365.com"},
+	},
+}
+
+var ErrNoProvider = errors.New("no oauth provider configured for this account")
+
+const providersFile = "oauth.json"
+
+// LoadProviders returns the built in providers merged with the ones from oauth.json in the
+// config dir. entries with the name of a built in provider override its non empty fields.
+func LoadProviders() ([]Provider, error) {
+	providers := make([]Provider, len(defaultProviders))
+	copy(providers, defaultProviders)
+
+	configDir, err := config.ConfigDir()
+	if err != nil {
+		return nil, fmt.Errorf("[OAUTH::LoadProviders] failed to get config dir: %w", err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(configDir, providersFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return providers, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("[OAUTH::LoadProviders] failed to read %s: %w", providersFile, err)
+	}
+
+	var configured []Provider
+	if err := json.Unmarshal(data, &configured); err != nil {
+		return nil, fmt.Errorf("[OAUTH::LoadProviders] failed to parse %s: %w", providersFile, err)
+	}
+
+	for _, provider := range configured {
+		merged := false
+		for i := range providers {
+			if providers[i].Name == provider.Name {
+				providers[i] = providers[i].merge(provider)
+				merged = true
+				break
+			}
+		}
+		if !merged {
+			providers = append(providers, provider)
+		}
+	}
+
+	return providers, nil
+}
+
+func (p Provider) merge(other Provider) Provider {
+	if other.ClientID != "" {
+		p.ClientID = other.ClientID
+	}
+	if other.ClientSecret != "" {
+		p.ClientSecret = other.ClientSecret
+	}
+	if other.AuthURL != "" {
+		p.AuthURL = other.AuthURL
+	}
+	if other.TokenURL != "" {
+		p.TokenURL = other.TokenURL
+	}
+	if len(other.Scopes) > 0 {
+		p.Scopes = other.Scopes
+	}
+	if other.Mechanism != "" {
+		p.Mechanism = other.Mechanism
+	}
+	if len(other.Domains) > 0 {
+		p.Domains = other.Domains
+	}
+	return p
+}
+
+// ProviderByName looks up a provider, used when refreshing a stored token
+func ProviderByName(name string) (Provider, error) {
+	providers, err := LoadProviders()
+	if err != nil {
+		return Provider{}, err
+	}
+
+	for _, provider := range providers {
+		if provider.Name == name {
+			return provider, nil
+		}
+	}
+
+	return Provider{}, ErrNoProvider
+}
+
+// ProviderFor picks the provider by the email domain or the imap server, so custom domains
+// hosted at google or microsoft are found as well
+func ProviderFor(email, imapServer string) (Provider, error) {
+	providers, err := LoadProviders()
+	if err != nil {
+		return Provider{}, err
+	}
+
+	_, domain, _ := strings.Cut(strings.ToLower(email), "@")
+	imapServer = strings.ToLower(imapServer)
+
+	for _, provider := range providers {
+		for _, providerDomain := range provider.Domains {
+			if domain == providerDomain || imapServer == providerDomain || strings.HasSuffix(imapServer, "."+providerDomain) {
+				if provider.ClientID == "" {
+					return Provider{}, fmt.Errorf("no client id configured for %s, add one to %s", provider.Name, providersFile)
+				}
+				return provider, nil
+			}
+		}
+	}
+
+	return Provider{}, ErrNoProvider
+}
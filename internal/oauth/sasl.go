@@ -0,0 +1,38 @@
+package oauth
+
+import (
+	"errors"
+	"github.com/emersion/go-sasl"
+)
+
+// xoauth2Client implements the XOAUTH2 mechanism google and microsoft use, go-sasl only ships OAUTHBEARER
+type xoauth2Client struct {
+	username string
+	token    string
+}
+
+func (c *xoauth2Client) Start() (mech string, ir []byte, err error) {
+	return "XOAUTH2", []byte("user=" + c.username + "\x01auth=Bearer " + c.token + "\x01\x01"), nil
+}
+
+// Next answers the error challenge with an empty response, after which the server fails the login
+func (c *xoauth2Client) Next(challenge []byte) ([]byte, error) {
+	if len(challenge) == 0 {
+		return nil, errors.New("unexpected empty challenge")
+	}
+	return []byte{}, nil
+}
+
+// NewSASLClient returns the sasl client for the auth method of the account
+func NewSASLClient(mechanism, username, token, host string, port int) sasl.Client {
+	if mechanism == MechanismOAuthBearer {
+		return sasl.NewOAuthBearerClient(&sasl.OAuthBearerOptions{
+			Username: username,
+			Token:    token,
+			Host:     host,
+			Port:     port,
+		})
+	}
+
+	return &xoauth2Client{username: username, token: token}
+}
@@ -0,0 +1,176 @@
+package oauth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/rexxDigital/clmail/internal/config"
+	"github.com/rexxDigital/clmail/internal/db"
+	"github.com/rexxDigital/clmail/internal/secrets"
+	"github.com/zalando/go-keyring"
+	"log"
+	"sync"
+)
+
+// legacyService is where tokens were kept before they moved to the secret store of the account, the
+// profile named oauth would file its keyring entries under it too and isn't allowed
+const legacyService = "clmail-oauth"
+
+var (
+	defaultClient = NewClient()
+	// every client of an account asks for a token at the same time on startup, refresh only once
+	refreshMu sync.Mutex
+)
+
+// tokenStore is the secret store the account keeps its password in. a password command can only be
+// read, the tokens of such an account go to the keyring.
+func tokenStore(account db.Account) (secrets.Store, error) {
+	return secrets.For(tokenBackend(account), "")
+}
+
+func tokenBackend(account db.Account) string {
+	switch account.SecretStore {
+	case "", secrets.BackendCommand:
+		return secrets.BackendKeyring
+	}
+	return account.SecretStore
+}
+
+// tokenKey keeps the token apart from a password stored under the address
+func tokenKey(email string) string {
+	return "oauth:" + email
+}
+
+func SaveToken(account db.Account, token *Token) error {
+	data, err := json.Marshal(token)
+	if err != nil {
+		return fmt.Errorf("[OAUTH::SaveToken] failed to encode token: %w", err)
+	}
+
+	store, err := tokenStore(account)
+	if err != nil {
+		return fmt.Errorf("[OAUTH::SaveToken] failed to get the secret store: %w", err)
+	}
+	if err := store.Set(tokenKey(account.Email), string(data)); err != nil {
+		return fmt.Errorf("[OAUTH::SaveToken] failed to store token: %w", err)
+	}
+
+	return nil
+}
+
+func LoadToken(account db.Account) (*Token, error) {
+	store, err := tokenStore(account)
+	if err != nil {
+		return nil, fmt.Errorf("[OAUTH::LoadToken] failed to get the secret store: %w", err)
+	}
+
+	data, err := store.Get(tokenKey(account.Email))
+	if errors.Is(err, secrets.ErrNotFound) {
+		data, err = moveLegacyToken(account)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("[OAUTH::LoadToken] failed to get token: %w", err)
+	}
+
+	var token Token
+	if err := json.Unmarshal([]byte(data), &token); err != nil {
+		return nil, fmt.Errorf("[OAUTH::LoadToken] failed to decode token: %w", err)
+	}
+
+	return &token, nil
+}
+
+func DeleteToken(account db.Account) error {
+	store, err := tokenStore(account)
+	if err != nil {
+		return fmt.Errorf("[OAUTH::DeleteToken] failed to get the secret store: %w", err)
+	}
+	if err := store.Delete(tokenKey(account.Email)); err != nil && !errors.Is(err, secrets.ErrNotFound) {
+		return fmt.Errorf("[OAUTH::DeleteToken] failed to delete token: %w", err)
+	}
+	if config.Profile() != "" {
+		return nil
+	}
+	if err := keyring.Delete(legacyService, account.Email); err != nil && !errors.Is(err, keyring.ErrNotFound) {
+		return fmt.Errorf("[OAUTH::DeleteToken] failed to delete token: %w", err)
+	}
+	return nil
+}
+
+// MoveToken moves the token when the address or the secret store of an account changes
+func MoveToken(from, to db.Account) error {
+	if from.Email == to.Email && tokenBackend(from) == tokenBackend(to) {
+		return nil
+	}
+
+	token, err := LoadToken(from)
+	if err != nil {
+		return err
+	}
+	if err = SaveToken(to, token); err != nil {
+		return err
+	}
+	return DeleteToken(from)
+}
+
+// moveLegacyToken moves a token from where older versions kept it to the store of the account
+func moveLegacyToken(account db.Account) (string, error) {
+	// they are from before profiles, only the default profile has them
+	if config.Profile() != "" {
+		return "", secrets.ErrNotFound
+	}
+
+	data, err := keyring.Get(legacyService, account.Email)
+	if errors.Is(err, keyring.ErrNotFound) {
+		return "", secrets.ErrNotFound
+	}
+	if err != nil {
+		return "", err
+	}
+
+	store, err := tokenStore(account)
+	if err == nil {
+		err = store.Set(tokenKey(account.Email), data)
+	}
+	if err != nil {
+		// still usable, it is moved the next time
+		log.Printf("[OAUTH::moveLegacyToken] Failed to move token: %v", err)
+		return data, nil
+	}
+	if err := keyring.Delete(legacyService, account.Email); err != nil {
+		log.Printf("[OAUTH::moveLegacyToken] Failed to delete the old token: %v", err)
+	}
+	return data, nil
+}
+
+// AccessToken returns a usable access token for the account, refreshing it when it's about to expire
+func AccessToken(ctx context.Context, account db.Account) (string, error) {
+	refreshMu.Lock()
+	defer refreshMu.Unlock()
+
+	token, err := LoadToken(account)
+	if err != nil {
+		return "", err
+	}
+
+	if token.Valid() {
+		return token.AccessToken, nil
+	}
+
+	provider, err := ProviderByName(token.Provider)
+	if err != nil {
+		return "", fmt.Errorf("[OAUTH::AccessToken] failed to get provider %s: %w", token.Provider, err)
+	}
+
+	refreshed, err := defaultClient.Refresh(ctx, provider, token)
+	if err != nil {
+		return "", err
+	}
+
+	if err := SaveToken(account, refreshed); err != nil {
+		return "", err
+	}
+
+	return refreshed.AccessToken, nil
+}
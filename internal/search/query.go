@@ -0,0 +1,209 @@
+package search
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Field is the operator in front of a term, e.g. from in from:bob
+type Field string
+
+const (
+	FieldText    Field = ""
+	FieldFrom    Field = "from"
+	FieldTo      Field = "to"
+	FieldSubject Field = "subject"
+	FieldHas     Field = "has"
+	FieldIs      Field = "is"
+	FieldBefore  Field = "before"
+	FieldAfter   Field = "after"
+	FieldFolder  Field = "folder"
+)
+
+// Term is a single part of a query, like from:bob or -"weekly report"
+type Term struct {
+	Field   Field
+	Value   string
+	Negated bool
+	// Date is only set for before: and after: terms
+	Date time.Time
+}
+
+// Query is a parsed search, every term has to match for a mail to be a hit.
+type Query struct {
+	Terms []Term
+}
+
+// IsEmpty reports whether the query has nothing to search for.
+func (q Query) IsEmpty() bool {
+	return len(q.Terms) == 0
+}
+
+// Parse turns a gmail style query like `from:bob has:attachment -is:read "quarterly report"` into a Query.
+// Dates in before: and after: are either absolute (2025-06-17) or relative to now (30d, 2w, 6m, 1y).
+func Parse(input string) (Query, error) {
+	return parseAt(input, time.Now())
+}
+
+func parseAt(input string, now time.Time) (Query, error) {
+	var query Query
+
+	tokens, err := tokenize(input)
+	if err != nil {
+		return Query{}, err
+	}
+
+	for _, token := range tokens {
+		term := Term{Field: FieldText, Value: token.value, Negated: token.negated}
+
+		if term.Value == "" {
+			continue
+		}
+
+		// quoted phrases are always plain text, even if they contain a colon
+		if !token.quoted {
+			if field, value, ok := strings.Cut(term.Value, ":"); ok && value != "" {
+				if f := Field(strings.ToLower(field)); isKnownField(f) {
+					term.Field = f
+					term.Value = unquote(value)
+				}
+			}
+		}
+
+		if err := validateTerm(&term, now); err != nil {
+			return Query{}, err
+		}
+
+		query.Terms = append(query.Terms, term)
+	}
+
+	return query, nil
+}
+
+func isKnownField(field Field) bool {
+	switch field {
+	case FieldFrom, FieldTo, FieldSubject, FieldHas, FieldIs, FieldBefore, FieldAfter, FieldFolder:
+		return true
+	}
+	return false
+}
+
+func validateTerm(term *Term, now time.Time) error {
+	switch term.Field {
+	case FieldHas:
+		term.Value = strings.ToLower(term.Value)
+		if term.Value != "attachment" {
+			return fmt.Errorf("unknown has:%s, only has:attachment is supported", term.Value)
+		}
+	case FieldIs:
+		term.Value = strings.ToLower(term.Value)
+		switch term.Value {
+		case "unread", "read", "starred", "unstarred", "draft":
+		default:
+			return fmt.Errorf("unknown is:%s, use unread, read, starred, unstarred or draft", term.Value)
+		}
+	case FieldBefore, FieldAfter:
+		date, err := parseDate(term.Value, now)
+		if err != nil {
+			return fmt.Errorf("invalid date in %s:%s: %w", term.Field, term.Value, err)
+		}
+		term.Date = date
+	}
+
+	return nil
+}
+
+// parseDate understands 2025-06-17, 2025/06/17 and relative offsets like 30d, 2w, 6m and 1y
+func parseDate(value string, now time.Time) (time.Time, error) {
+	for _, layout := range []string{"2006-01-02", "2006/01/02"} {
+		if date, err := time.ParseInLocation(layout, value, now.Location()); err == nil {
+			return date, nil
+		}
+	}
+
+	if len(value) < 2 {
+		return time.Time{}, fmt.Errorf("expected a date like 2006-01-02 or an offset like 30d")
+	}
+
+	amount, err := strconv.Atoi(value[:len(value)-1])
+	if err != nil || amount < 0 {
+		return time.Time{}, fmt.Errorf("expected a date like 2006-01-02 or an offset like 30d")
+	}
+
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+
+	switch unicode.ToLower(rune(value[len(value)-1])) {
+	case 'd':
+		return today.AddDate(0, 0, -amount), nil
+	case 'w':
+		return today.AddDate(0, 0, -amount*7), nil
+	case 'm':
+		return today.AddDate(0, -amount, 0), nil
+	case 'y':
+		return today.AddDate(-amount, 0, 0), nil
+	}
+
+	return time.Time{}, fmt.Errorf("unknown unit, use d, w, m or y")
+}
+
+type token struct {
+	value   string
+	quoted  bool
+	negated bool
+}
+
+// tokenize splits on whitespace but keeps "quoted phrases" and from:"John Doe" together,
+// a leading - marks the token as negated.
+func tokenize(input string) ([]token, error) {
+	var tokens []token
+	var current strings.Builder
+	var next token
+	inQuotes := false
+
+	flush := func() {
+		if current.Len() > 0 || next.quoted {
+			next.value = current.String()
+			tokens = append(tokens, next)
+		}
+		current.Reset()
+		next = token{}
+	}
+
+	for _, r := range input {
+		switch {
+		case r == '-' && current.Len() == 0 && !inQuotes && !next.quoted && !next.negated:
+			next.negated = true
+		case r == '"':
+			// a quote at the start of a token makes the whole token a phrase,
+			// a quote after field: only quotes the value
+			if current.Len() == 0 && !next.quoted {
+				next.quoted = true
+			} else if !next.quoted {
+				current.WriteRune(r)
+			}
+			inQuotes = !inQuotes
+		case unicode.IsSpace(r) && !inQuotes:
+			flush()
+		default:
+			current.WriteRune(r)
+		}
+	}
+
+	if inQuotes {
+		return nil, fmt.Errorf("unterminated quote")
+	}
+
+	flush()
+
+	return tokens, nil
+}
+
+func unquote(value string) string {
+	if len(value) >= 2 && strings.HasPrefix(value, `"`) && strings.HasSuffix(value, `"`) {
+		return value[1 : len(value)-1]
+	}
+	return value
+}
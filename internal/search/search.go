@@ -0,0 +1,149 @@
+package search
+
+import (
+	"context"
+	"fmt"
+	"github.com/rexxDigital/clmail/internal/db"
+	"slices"
+	"strings"
+)
+
+// markers put around matched text by Search, the tui swaps them for styling
+const (
+	HighlightStart = "\x02"
+	HighlightEnd   = "\x03"
+)
+
+// Result is a matching email with the subject and a snippet of the body highlighted.
+type Result struct {
+	db.Email
+	// FolderName lists every folder the email is in, comma separated
+	FolderName         string
+	HighlightedSubject string
+	Snippet            string
+}
+
+type Params struct {
+	AccountID int64
+	Query     Query
+	Limit     int64
+	Offset    int64
+}
+
+// Search runs the query against the local fts index, newest mail first. an encrypted database
+// has none, its mail is opened and searched row by row.
+func Search(ctx context.Context, dbClient *db.Client, params Params) ([]Result, error) {
+	compiled := params.Query.Compile(params.AccountID, dbClient.Encrypted())
+
+	var sql strings.Builder
+	var args []any
+
+	sql.WriteString(`SELECT e.id, e.thread_id, e.account_id, e.message_id, e.message_key, e.from_address, e.from_name,
+       e.to_addresses, e.cc_addresses, e.bcc_addresses, e.reference_id, e.subject, e.body_text, e.body_html,
+       e.received_date, e.is_read, e.is_starred, e.is_draft, e.size_bytes,
+       COALESCE((SELECT group_concat(f.name, ', ')
+                 FROM email_folders ef
+                          JOIN folders f ON f.id = ef.folder_id
+                 WHERE ef.email_id = e.id), '')`)
+
+	if compiled.Match != "" {
+		sql.WriteString(`,
+       COALESCE(highlight(emails_fts, 0, ?, ?), e.subject),
+       COALESCE(snippet(emails_fts, 1, ?, ?, '…', 16), '')
+FROM emails e
+         JOIN emails_fts ON emails_fts.rowid = e.id
+WHERE emails_fts MATCH ? AND `)
+		args = append(args, HighlightStart, HighlightEnd, HighlightStart, HighlightEnd, compiled.Match)
+	} else {
+		body := "e.body_text"
+		if dbClient.Encrypted() {
+			// substr would cut the sealed bytes
+			body = opened("e", "emails", "body_text")
+		}
+		sql.WriteString(`,
+       e.subject,
+       substr(COALESCE(` + body + `, ''), 1, 120)
+FROM emails e
+WHERE `)
+	}
+
+	sql.WriteString(compiled.Where)
+	sql.WriteString(`
+ORDER BY e.received_date DESC LIMIT ? OFFSET ?`)
+
+	args = append(args, compiled.Args...)
+	args = append(args, params.Limit, params.Offset)
+
+	rows, err := dbClient.DB.QueryContext(ctx, sql.String(), args...)
+	if err != nil {
+		return nil, fmt.Errorf("[SEARCH::Search] failed to query: %w", err)
+	}
+	defer rows.Close()
+
+	var results []Result
+	for rows.Next() {
+		var r Result
+		if err := rows.Scan(
+			&r.ID,
+			&r.ThreadID,
+			&r.AccountID,
+			&r.MessageID,
+			&r.MessageKey,
+			&r.FromAddress,
+			&r.FromName,
+			&r.ToAddresses,
+			&r.CcAddresses,
+			&r.BccAddresses,
+			&r.ReferenceID,
+			&r.Subject,
+			&r.BodyText,
+			&r.BodyHtml,
+			&r.ReceivedDate,
+			&r.IsRead,
+			&r.IsStarred,
+			&r.IsDraft,
+			&r.SizeBytes,
+			&r.FolderName,
+			&r.HighlightedSubject,
+			&r.Snippet,
+		); err != nil {
+			return nil, fmt.Errorf("[SEARCH::Search] failed to scan: %w", err)
+		}
+		results = append(results, r)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("[SEARCH::Search] failed to read rows: %w", err)
+	}
+
+	return results, nil
+}
+
+// EmailIDs returns everything the query finds in the account, oldest first, for when all of it is
+// needed and not a page
+func EmailIDs(ctx context.Context, dbClient *db.Client, accountID int64, query Query) ([]int64, error) {
+	const pageSize = 1000
+
+	var ids []int64
+	for offset := int64(0); ; offset += pageSize {
+		results, err := Search(ctx, dbClient, Params{
+			AccountID: accountID,
+			Query:     query,
+			Limit:     pageSize,
+			Offset:    offset,
+		})
+		if err != nil {
+			return nil, err
+		}
+		for _, result := range results {
+			ids = append(ids, result.ID)
+		}
+		if len(results) < pageSize {
+			break
+		}
+	}
+
+	// Search gives the newest first
+	slices.Reverse(ids)
+	return ids, nil
+}
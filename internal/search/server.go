@@ -0,0 +1,227 @@
+package search
+
+import (
+	"context"
+	"fmt"
+	"github.com/emersion/go-imap/v2"
+	"github.com/rexxDigital/clmail/internal/db"
+	clmailImap "github.com/rexxDigital/clmail/internal/imap"
+	"log"
+	"slices"
+	"strings"
+)
+
+// uidBatchSize keeps us well below sqlites limit on bound parameters
+const uidBatchSize = 500
+
+// IMAPCriteria translates the query into an IMAP SEARCH criteria. folder: terms are not part of
+// it since IMAP searches one mailbox at a time, use Folders to pick which ones to search.
+func (q Query) IMAPCriteria() *imap.SearchCriteria {
+	criteria := &imap.SearchCriteria{}
+
+	for _, term := range q.Terms {
+		if term.Field == FieldFolder {
+			continue
+		}
+
+		termCriteria := imap.SearchCriteria{}
+
+		switch term.Field {
+		case FieldText:
+			termCriteria.Text = []string{term.Value}
+		case FieldFrom:
+			termCriteria.Header = []imap.SearchCriteriaHeaderField{{Key: "From", Value: term.Value}}
+		case FieldTo:
+			termCriteria.Or = [][2]imap.SearchCriteria{{
+				{Header: []imap.SearchCriteriaHeaderField{{Key: "To", Value: term.Value}}},
+				{Header: []imap.SearchCriteriaHeaderField{{Key: "Cc", Value: term.Value}}},
+			}}
+		case FieldSubject:
+			termCriteria.Header = []imap.SearchCriteriaHeaderField{{Key: "Subject", Value: term.Value}}
+		case FieldHas:
+			// IMAP can't search the body structure, multipart/mixed is the closest we can get
+			termCriteria.Header = []imap.SearchCriteriaHeaderField{{Key: "Content-Type", Value: "multipart/mixed"}}
+		case FieldIs:
+			switch term.Value {
+			case "unread":
+				termCriteria.NotFlag = []imap.Flag{imap.FlagSeen}
+			case "read":
+				termCriteria.Flag = []imap.Flag{imap.FlagSeen}
+			case "starred":
+				termCriteria.Flag = []imap.Flag{imap.FlagFlagged}
+			case "unstarred":
+				termCriteria.NotFlag = []imap.Flag{imap.FlagFlagged}
+			case "draft":
+				termCriteria.Flag = []imap.Flag{imap.FlagDraft}
+			}
+		case FieldBefore:
+			termCriteria.SentBefore = term.Date
+		case FieldAfter:
+			termCriteria.SentSince = term.Date
+		}
+
+		if term.Negated {
+			criteria.Not = append(criteria.Not, termCriteria)
+		} else {
+			criteria.And(&termCriteria)
+		}
+	}
+
+	return criteria
+}
+
+// Folders returns the folder names from positive folder: terms
+func (q Query) Folders() []string {
+	var folders []string
+	for _, term := range q.Terms {
+		if term.Field == FieldFolder && !term.Negated {
+			folders = append(folders, term.Value)
+		}
+	}
+	return folders
+}
+
+type ServerParams struct {
+	Account  db.Account
+	Password string
+	Query    Query
+	// Folders to run the search in, when empty every folder of the account is searched
+	Folders []db.Folder
+}
+
+// SearchServer runs the query as UID SEARCH on the server, downloads the headers of any hit we
+// don't have locally yet and returns all hits from the local db.
+func SearchServer(ctx context.Context, dbClient *db.Client, params ServerParams) ([]Result, error) {
+	folders := params.Folders
+	if len(folders) == 0 {
+		var err error
+		folders, err = dbClient.ListFolders(ctx, params.Account.ID)
+		if err != nil {
+			return nil, fmt.Errorf("[SEARCH::SearchServer] failed to list folders: %w", err)
+		}
+	}
+
+	// local folders aren't on the server
+	folders = slices.DeleteFunc(folders, func(folder db.Folder) bool { return folder.Local })
+
+	if names := params.Query.Folders(); len(names) > 0 {
+		folders = slices.DeleteFunc(folders, func(folder db.Folder) bool {
+			return !slices.ContainsFunc(names, func(name string) bool {
+				return strings.EqualFold(name, folder.Name)
+			})
+		})
+	}
+
+	client, err := clmailImap.NewSyncClient(params.Account, params.Password, dbClient)
+	if err != nil {
+		return nil, fmt.Errorf("[SEARCH::SearchServer] failed to connect: %w", err)
+	}
+	defer client.Close()
+
+	criteria := params.Query.IMAPCriteria()
+
+	var results []Result
+	for _, folder := range folders {
+		if ctx.Err() != nil {
+			return results, ctx.Err()
+		}
+
+		uids, err := client.Search(folder.Name, criteria)
+		if err != nil {
+			// some folders refuse SELECT, don't let one of them fail the whole search
+			log.Printf("[SEARCH::SearchServer] Failed to search %s: %v", folder.Name, err)
+			continue
+		}
+
+		folderResults, err := hitsInFolder(ctx, dbClient, client, folder, uids)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, folderResults...)
+	}
+
+	return results, nil
+}
+
+func hitsInFolder(ctx context.Context, dbClient *db.Client, client clmailImap.SyncClient, folder db.Folder, uids []imap.UID) ([]Result, error) {
+	var results []Result
+
+	for batch := range slices.Chunk(uids, uidBatchSize) {
+		dbUIDs := make([]int64, len(batch))
+		for i, uid := range batch {
+			dbUIDs[i] = int64(uid)
+		}
+
+		emails, err := dbClient.ListEmailsByFolderAndUIDs(ctx, db.ListEmailsByFolderAndUIDsParams{
+			FolderID: folder.ID,
+			Uids:     dbUIDs,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("[SEARCH::hitsInFolder] failed to get emails: %w", err)
+		}
+
+		if len(emails) < len(batch) {
+			known := make(map[int64]bool, len(emails))
+			for _, email := range emails {
+				known[email.Uid] = true
+			}
+
+			var missing []imap.UID
+			for _, uid := range batch {
+				if !known[int64(uid)] {
+					missing = append(missing, uid)
+				}
+			}
+
+			if err := client.FetchHeaders(folder.Name, missing); err != nil {
+				log.Printf("[SEARCH::hitsInFolder] Failed to fetch headers in %s: %v", folder.Name, err)
+			} else {
+				emails, err = dbClient.ListEmailsByFolderAndUIDs(ctx, db.ListEmailsByFolderAndUIDsParams{
+					FolderID: folder.ID,
+					Uids:     dbUIDs,
+				})
+				if err != nil {
+					return nil, fmt.Errorf("[SEARCH::hitsInFolder] failed to get emails: %w", err)
+				}
+			}
+		}
+
+		for _, email := range emails {
+			snippet := []rune(email.Email.BodyText.String)
+			snippet = snippet[:min(len(snippet), 120)]
+
+			results = append(results, Result{
+				Email:              email.Email,
+				FolderName:         folder.Name,
+				HighlightedSubject: email.Email.Subject,
+				Snippet:            string(snippet),
+			})
+		}
+	}
+
+	return results, nil
+}
+
+// Merge adds the server hits to the local results, skipping mails we already have and keeping
+// the newest first order.
+func Merge(local, server []Result) []Result {
+	merged := slices.Clone(local)
+
+	seen := make(map[int64]bool, len(local))
+	for _, result := range local {
+		seen[result.ID] = true
+	}
+
+	for _, result := range server {
+		if !seen[result.ID] {
+			seen[result.ID] = true
+			merged = append(merged, result)
+		}
+	}
+
+	slices.SortStableFunc(merged, func(a, b Result) int {
+		return b.ReceivedDate.Compare(a.ReceivedDate)
+	})
+
+	return merged
+}
@@ -0,0 +1,123 @@
+package search
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ftsColumns maps the text operators to the emails_fts columns they search in
+var ftsColumns = map[Field]string{
+	FieldFrom:    "{from_address from_name}",
+	FieldTo:      "{to_addresses cc_addresses}",
+	FieldSubject: "{subject}",
+}
+
+// sealedColumns maps the text operators to what they search in an encrypted database, which has
+// no fts index
+var sealedColumns = map[Field]string{
+	FieldText: opened("e", "emails", "subject", "body_text", "body_html", "from_address", "from_name", "to_addresses", "cc_addresses") +
+		`, (SELECT group_concat(` + opened("a", "attachments", "filename") + `, ' ') FROM attachments a WHERE a.email_id = e.id)`,
+	FieldFrom:    opened("e", "emails", "from_address", "from_name"),
+	FieldTo:      opened("e", "emails", "to_addresses", "cc_addresses"),
+	FieldSubject: opened("e", "emails", "subject"),
+}
+
+// opened is the columns of table as alias, each opened for the row it is in
+func opened(alias, table string, columns ...string) string {
+	values := make([]string, len(columns))
+	for i, column := range columns {
+		values[i] = fmt.Sprintf("clmail_open(%s.%s, '%s.%s.' || %s.id)", alias, column, table, column, alias)
+	}
+	return strings.Join(values, ", ")
+}
+
+// Compiled is a query turned into SQL, Where always starts with the account filter.
+type Compiled struct {
+	// Match is the fts5 expression for all positive text terms, empty if there are none
+	Match string
+	Where string
+	Args  []any
+}
+
+// Compile turns the query into a WHERE clause over emails e. If Match is set the
+// caller has to join emails_fts on emails_fts.rowid = e.id and add `emails_fts MATCH ?`.
+// sealed is for an encrypted database, text terms are then matched as substrings of the opened
+// values and Match is never set.
+func (q Query) Compile(accountID int64, sealed bool) Compiled {
+	var matches []string
+	conditions := []string{"e.account_id = ?"}
+	args := []any{accountID}
+
+	for _, term := range q.Terms {
+		var condition string
+		var conditionArgs []any
+
+		switch term.Field {
+		case FieldText, FieldFrom, FieldTo, FieldSubject:
+			if sealed {
+				condition = "clmail_search(?, " + sealedColumns[term.Field] + ")"
+				conditionArgs = []any{term.Value}
+				break
+			}
+			expression := ftsExpression(term)
+			if !term.Negated {
+				matches = append(matches, expression)
+				continue
+			}
+			condition = "e.id IN (SELECT rowid FROM emails_fts WHERE emails_fts MATCH ?)"
+			conditionArgs = []any{expression}
+		case FieldHas:
+			condition = "EXISTS (SELECT 1 FROM attachments a WHERE a.email_id = e.id)"
+		case FieldIs:
+			switch term.Value {
+			case "unread":
+				condition = "e.is_read = FALSE"
+			case "read":
+				condition = "e.is_read = TRUE"
+			case "starred":
+				condition = "e.is_starred = TRUE"
+			case "unstarred":
+				condition = "e.is_starred = FALSE"
+			case "draft":
+				condition = "e.is_draft = TRUE"
+			}
+		case FieldBefore:
+			// dates are stored as "2006-01-02 15:04:05 -0700", so the first 10 characters are the day it was sent
+			condition = "substr(e.received_date, 1, 10) < ?"
+			conditionArgs = []any{term.Date.Format("2006-01-02")}
+		case FieldAfter:
+			condition = "substr(e.received_date, 1, 10) >= ?"
+			conditionArgs = []any{term.Date.Format("2006-01-02")}
+		case FieldFolder:
+			condition = `e.id IN (SELECT ef.email_id
+           FROM email_folders ef
+                    JOIN folders f ON f.id = ef.folder_id
+           WHERE f.account_id = e.account_id AND f.name = ? COLLATE NOCASE)`
+			conditionArgs = []any{term.Value}
+		}
+
+		if term.Negated {
+			condition = "NOT (" + condition + ")"
+		}
+
+		conditions = append(conditions, condition)
+		args = append(args, conditionArgs...)
+	}
+
+	return Compiled{
+		Match: strings.Join(matches, " AND "),
+		Where: strings.Join(conditions, " AND "),
+		Args:  args,
+	}
+}
+
+// ftsExpression quotes the value as a phrase so user input can never be read as fts5 syntax
+func ftsExpression(term Term) string {
+	phrase := `"` + strings.ReplaceAll(term.Value, `"`, `""`) + `"`
+
+	if column, ok := ftsColumns[term.Field]; ok {
+		return column + " : " + phrase
+	}
+
+	return phrase
+}
@@ -0,0 +1,101 @@
+package search
+
+import (
+	"context"
+	"fmt"
+	"github.com/rexxDigital/clmail/internal/db"
+	"strings"
+)
+
+// fromClause builds the FROM/WHERE part shared by the saved search queries
+func fromClause(compiled Compiled) (string, []any) {
+	if compiled.Match == "" {
+		return `FROM emails e
+WHERE ` + compiled.Where, compiled.Args
+	}
+
+	args := append([]any{compiled.Match}, compiled.Args...)
+	return `FROM emails e
+         JOIN emails_fts ON emails_fts.rowid = e.id
+WHERE emails_fts MATCH ? AND ` + compiled.Where, args
+}
+
+// Threads returns the threads with at least one matching email, in the same shape and paged the
+// same way as GetThreadsInFolder so a saved search can be shown like any other folder.
+func Threads(ctx context.Context, dbClient *db.Client, accountID int64, query Query, cursor db.ThreadCursor, limit int64) ([]db.GetThreadsInFolderRow, error) {
+	from, args := fromClause(query.Compile(accountID, dbClient.Encrypted()))
+
+	var sql strings.Builder
+	// sqlite fills bare columns from the row that produced MAX(), so the sender is the newest matching email
+	sql.WriteString(`SELECT t.id, t.account_id, t.subject, t.snippet, t.is_read, t.is_starred, t.has_attachments,
+       t.message_count, t.latest_message_date,
+       COUNT(*),
+       SUM(CASE WHEN e.is_read = FALSE THEN 1 ELSE 0 END),
+       e.from_address,
+       COALESCE(e.from_name, ''),
+       t.latest_message_date,
+       MAX(e.received_date)
+FROM threads t
+         JOIN (SELECT e.* `)
+	sql.WriteString(from)
+	sql.WriteString(`) e ON e.thread_id = t.id
+WHERE t.latest_message_date <= ?
+  AND (t.latest_message_date < ? OR t.id < ?)
+GROUP BY t.id
+ORDER BY t.latest_message_date DESC, t.id DESC
+LIMIT ?`)
+
+	args = append(args, cursor.Date, cursor.Date, cursor.ID, limit)
+
+	rows, err := dbClient.DB.QueryContext(ctx, sql.String(), args...)
+	if err != nil {
+		return nil, fmt.Errorf("[SEARCH::Threads] failed to query: %w", err)
+	}
+	defer rows.Close()
+
+	var threads []db.GetThreadsInFolderRow
+	for rows.Next() {
+		var t db.GetThreadsInFolderRow
+		var latest any
+		if err := rows.Scan(
+			&t.ID,
+			&t.AccountID,
+			&t.Subject,
+			&t.Snippet,
+			&t.IsRead,
+			&t.IsStarred,
+			&t.HasAttachments,
+			&t.MessageCount,
+			&t.LatestMessageDate,
+			&t.FolderCount,
+			&t.FolderUnreadCount,
+			&t.LatestFolderSender,
+			&t.LatestFolderSenderName,
+			// the cursor of a search goes by the thread, not by the newest match
+			&t.FolderLatestDate,
+			&latest,
+		); err != nil {
+			return nil, fmt.Errorf("[SEARCH::Threads] failed to scan: %w", err)
+		}
+		threads = append(threads, t)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("[SEARCH::Threads] failed to read rows: %w", err)
+	}
+
+	return threads, nil
+}
+
+// CountUnread returns how many unread emails match the query
+func CountUnread(ctx context.Context, dbClient *db.Client, accountID int64, query Query) (int64, error) {
+	from, args := fromClause(query.Compile(accountID, dbClient.Encrypted()))
+
+	var count int64
+	err := dbClient.DB.QueryRowContext(ctx, "SELECT COUNT(*) "+from+" AND e.is_read = FALSE", args...).Scan(&count)
+	if err != nil {
+		return 0, fmt.Errorf("[SEARCH::CountUnread] failed to count: %w", err)
+	}
+
+	return count, nil
+}
@@ -0,0 +1,48 @@
+package secrets
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+// commandStore asks an external password manager, e.g. `pass show mail/work`. the first line of the
+// output is the password, like mutt and isync do it.
+type commandStore struct {
+	command string
+}
+
+func (s commandStore) Get(_ string) (string, error) {
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.Command("cmd", "/C", s.command)
+	} else {
+		cmd = exec.Command("sh", "-c", s.command)
+	}
+
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	output, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("[SECRETS::commandStore] password command failed: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+
+	password, _, _ := strings.Cut(string(output), "\n")
+	password = strings.TrimRight(password, "\r")
+	if password == "" {
+		return "", fmt.Errorf("[SECRETS::commandStore] password command printed nothing")
+	}
+
+	return password, nil
+}
+
+func (s commandStore) Set(_, _ string) error {
+	return ErrReadOnly
+}
+
+func (s commandStore) Delete(_ string) error {
+	return ErrReadOnly
+}
@@ -0,0 +1,169 @@
+package secrets
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"filippo.io/age"
+	"fmt"
+	"github.com/rexxDigital/clmail/internal/db"
+	"io"
+	"log"
+)
+
+// backends of the database key, stored in encryption_keys.backend
+const (
+	DatabasePassphrase = "passphrase"
+	DatabaseKeyring    = "keyring"
+)
+
+// keyringDatabaseStore keeps the keys of the keyring backend, under a random name per key
+func keyringDatabaseStore() keyringStore {
+	return keyringStore{service: keyringService()}
+}
+
+// UnlockDatabase unwraps the data keys of an encrypted database and hands them to the db layer.
+// passphrase is only asked for when a key needs it.
+func UnlockDatabase(ctx context.Context, dbClient *db.Client, passphrase func() (string, error)) error {
+	rows, err := dbClient.ListEncryptionKeys(ctx)
+	if err != nil {
+		return fmt.Errorf("[SECRETS::UnlockDatabase] failed to list keys: %w", err)
+	}
+
+	keys := make(map[int64][]byte, len(rows))
+	var current int64
+	for _, row := range rows {
+		key, err := unwrapDatabaseKey(row, passphrase)
+		if err != nil {
+			return err
+		}
+		keys[row.ID] = key
+		if row.Current {
+			current = row.ID
+		}
+	}
+
+	return db.UseKeys(keys, current)
+}
+
+// EncryptDatabase seals the database with a new data key, encrypting it the first time and
+// rotating the key after that. the new key is wrapped for backend, a rotation may switch it.
+// returns the unencrypted backups it removed.
+func EncryptDatabase(ctx context.Context, dbClient *db.Client, backend string, passphrase string) ([]string, error) {
+	old, err := dbClient.ListEncryptionKeys(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("[SECRETS::EncryptDatabase] failed to list keys: %w", err)
+	}
+
+	key, err := db.NewKey()
+	if err != nil {
+		return nil, err
+	}
+
+	var wrapped []byte
+	switch backend {
+	case DatabasePassphrase:
+		if passphrase == "" {
+			return nil, errors.New("the passphrase can't be empty")
+		}
+		if wrapped, err = wrapWithPassphrase(key, passphrase); err != nil {
+			return nil, err
+		}
+	case DatabaseKeyring:
+		entry := make([]byte, 8)
+		if _, err = rand.Read(entry); err != nil {
+			return nil, fmt.Errorf("[SECRETS::EncryptDatabase] failed to name the keyring entry: %w", err)
+		}
+		wrapped = []byte("database-key-" + hex.EncodeToString(entry))
+		if err = keyringDatabaseStore().Set(string(wrapped), base64.StdEncoding.EncodeToString(key)); err != nil {
+			return nil, fmt.Errorf("[SECRETS::EncryptDatabase] failed to store the key in the keyring: %w", err)
+		}
+	default:
+		return nil, fmt.Errorf("unknown database key backend %q, use passphrase or keyring", backend)
+	}
+
+	removed, err := dbClient.Reseal(ctx, backend, wrapped, key)
+	if err != nil {
+		if backend == DatabaseKeyring {
+			if err := keyringDatabaseStore().Delete(string(wrapped)); err != nil {
+				log.Printf("[SECRETS::EncryptDatabase] Failed to delete the unused keyring entry: %v", err)
+			}
+		}
+		return nil, err
+	}
+
+	// the old keys open nothing anymore
+	for _, row := range old {
+		if row.Backend != DatabaseKeyring {
+			continue
+		}
+		if err := keyringDatabaseStore().Delete(string(row.Wrapped)); err != nil {
+			log.Printf("[SECRETS::EncryptDatabase] Failed to delete the old keyring entry: %v", err)
+		}
+	}
+
+	return removed, nil
+}
+
+func wrapWithPassphrase(key []byte, passphrase string) ([]byte, error) {
+	recipient, err := age.NewScryptRecipient(passphrase)
+	if err != nil {
+		return nil, fmt.Errorf("[SECRETS::wrapWithPassphrase] failed to create recipient: %w", err)
+	}
+
+	var wrapped bytes.Buffer
+	writer, err := age.Encrypt(&wrapped, recipient)
+	if err != nil {
+		return nil, fmt.Errorf("[SECRETS::wrapWithPassphrase] failed to wrap key: %w", err)
+	}
+	if _, err = writer.Write(key); err != nil {
+		return nil, fmt.Errorf("[SECRETS::wrapWithPassphrase] failed to wrap key: %w", err)
+	}
+	if err = writer.Close(); err != nil {
+		return nil, fmt.Errorf("[SECRETS::wrapWithPassphrase] failed to wrap key: %w", err)
+	}
+	return wrapped.Bytes(), nil
+}
+
+func unwrapDatabaseKey(row db.EncryptionKey, passphrase func() (string, error)) ([]byte, error) {
+	switch row.Backend {
+	case DatabaseKeyring:
+		encoded, err := keyringDatabaseStore().Get(string(row.Wrapped))
+		if err != nil {
+			return nil, fmt.Errorf("[SECRETS::unwrapDatabaseKey] failed to read the key from the keyring: %w", err)
+		}
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("[SECRETS::unwrapDatabaseKey] the key in the keyring is damaged: %w", err)
+		}
+		return key, nil
+	case DatabasePassphrase:
+		secret, err := passphrase()
+		if err != nil {
+			return nil, err
+		}
+		identity, err := age.NewScryptIdentity(secret)
+		if err != nil {
+			return nil, fmt.Errorf("[SECRETS::unwrapDatabaseKey] failed to create identity: %w", err)
+		}
+
+		reader, err := age.Decrypt(bytes.NewReader(row.Wrapped), identity)
+		if err != nil {
+			var noMatch *age.NoIdentityMatchError
+			if errors.As(err, &noMatch) {
+				return nil, ErrWrongPassphrase
+			}
+			return nil, fmt.Errorf("[SECRETS::unwrapDatabaseKey] failed to unwrap key: %w", err)
+		}
+		key, err := io.ReadAll(reader)
+		if err != nil {
+			return nil, fmt.Errorf("[SECRETS::unwrapDatabaseKey] failed to unwrap key: %w", err)
+		}
+		return key, nil
+	}
+
+	return nil, fmt.Errorf("unknown database key backend %q", row.Backend)
+}
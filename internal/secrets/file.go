@@ -0,0 +1,208 @@
+package secrets
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"filippo.io/age"
+	"fmt"
+	"github.com/rexxDigital/clmail/internal/config"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+const secretsFile = "secrets.age"
+
+var ErrWrongPassphrase = errors.New("wrong passphrase")
+
+// file is shared by every account using the file backend, it's unlocked once per run
+var file = &encryptedFile{}
+
+// encryptedFile keeps all secrets as json in one age file, encrypted with a scrypt passphrase.
+// meant for machines without a keyring, like a headless jump host.
+type encryptedFile struct {
+	mu         sync.Mutex
+	passphrase string
+	secrets    map[string]string
+}
+
+func filePath() (string, error) {
+	configDir, err := config.ConfigDir()
+	if err != nil {
+		return "", fmt.Errorf("[SECRETS::filePath] failed to get config dir: %w", err)
+	}
+	return filepath.Join(configDir, secretsFile), nil
+}
+
+// FileExists reports whether there is a secrets file to unlock on startup
+func FileExists() bool {
+	path, err := filePath()
+	if err != nil {
+		return false
+	}
+	_, err = os.Stat(path)
+	return err == nil
+}
+
+func FileUnlocked() bool {
+	file.mu.Lock()
+	defer file.mu.Unlock()
+	return file.secrets != nil
+}
+
+// UnlockFile decrypts the secrets file with the master passphrase. when there is no file yet the
+// passphrase becomes the one it will be created with on the first write.
+func UnlockFile(passphrase string) error {
+	if passphrase == "" {
+		return errors.New("the passphrase can't be empty")
+	}
+
+	path, err := filePath()
+	if err != nil {
+		return err
+	}
+
+	file.mu.Lock()
+	defer file.mu.Unlock()
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		file.passphrase = passphrase
+		file.secrets = make(map[string]string)
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("[SECRETS::UnlockFile] failed to read %s: %w", secretsFile, err)
+	}
+
+	identity, err := age.NewScryptIdentity(passphrase)
+	if err != nil {
+		return fmt.Errorf("[SECRETS::UnlockFile] failed to create identity: %w", err)
+	}
+
+	reader, err := age.Decrypt(bytes.NewReader(data), identity)
+	if err != nil {
+		var noMatch *age.NoIdentityMatchError
+		if errors.As(err, &noMatch) {
+			return ErrWrongPassphrase
+		}
+		return fmt.Errorf("[SECRETS::UnlockFile] failed to decrypt %s: %w", secretsFile, err)
+	}
+
+	plaintext, err := io.ReadAll(reader)
+	if err != nil {
+		return fmt.Errorf("[SECRETS::UnlockFile] failed to decrypt %s: %w", secretsFile, err)
+	}
+
+	secrets := make(map[string]string)
+	if err := json.Unmarshal(plaintext, &secrets); err != nil {
+		return fmt.Errorf("[SECRETS::UnlockFile] failed to parse %s: %w", secretsFile, err)
+	}
+
+	file.passphrase = passphrase
+	file.secrets = secrets
+	return nil
+}
+
+func (f *encryptedFile) Get(key string) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.secrets == nil {
+		return "", ErrLocked
+	}
+
+	secret, ok := f.secrets[key]
+	if !ok {
+		return "", ErrNotFound
+	}
+	return secret, nil
+}
+
+func (f *encryptedFile) Set(key, secret string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.secrets == nil {
+		return ErrLocked
+	}
+
+	previous, existed := f.secrets[key]
+	f.secrets[key] = secret
+
+	if err := f.save(); err != nil {
+		if existed {
+			f.secrets[key] = previous
+		} else {
+			delete(f.secrets, key)
+		}
+		return err
+	}
+
+	return nil
+}
+
+func (f *encryptedFile) Delete(key string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.secrets == nil {
+		return ErrLocked
+	}
+
+	previous, ok := f.secrets[key]
+	if !ok {
+		return ErrNotFound
+	}
+	delete(f.secrets, key)
+
+	if err := f.save(); err != nil {
+		f.secrets[key] = previous
+		return err
+	}
+
+	return nil
+}
+
+// save encrypts everything again and swaps the file in with a rename, so a crash never leaves half a file
+func (f *encryptedFile) save() error {
+	path, err := filePath()
+	if err != nil {
+		return err
+	}
+
+	plaintext, err := json.Marshal(f.secrets)
+	if err != nil {
+		return fmt.Errorf("[SECRETS::save] failed to encode secrets: %w", err)
+	}
+
+	recipient, err := age.NewScryptRecipient(f.passphrase)
+	if err != nil {
+		return fmt.Errorf("[SECRETS::save] failed to create recipient: %w", err)
+	}
+
+	var encrypted bytes.Buffer
+	writer, err := age.Encrypt(&encrypted, recipient)
+	if err != nil {
+		return fmt.Errorf("[SECRETS::save] failed to encrypt: %w", err)
+	}
+	if _, err := writer.Write(plaintext); err != nil {
+		return fmt.Errorf("[SECRETS::save] failed to encrypt: %w", err)
+	}
+	if err := writer.Close(); err != nil {
+		return fmt.Errorf("[SECRETS::save] failed to encrypt: %w", err)
+	}
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, encrypted.Bytes(), 0600); err != nil {
+		return fmt.Errorf("[SECRETS::save] failed to write %s: %w", secretsFile, err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		_ = os.Remove(tmp)
+		return fmt.Errorf("[SECRETS::save] failed to write %s: %w", secretsFile, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,40 @@
+package secrets
+
+import (
+	"errors"
+	"github.com/rexxDigital/clmail/internal/config"
+	"github.com/zalando/go-keyring"
+)
+
+// keyringService is what the entries are filed under, every profile has its own
+func keyringService() string {
+	if profile := config.Profile(); profile != "" {
+		return "clmail-" + profile
+	}
+	return "clmail"
+}
+
+// keyringStore uses the os keyring, which needs a running secret service on linux
+type keyringStore struct {
+	service string
+}
+
+func (s keyringStore) Get(key string) (string, error) {
+	secret, err := keyring.Get(s.service, key)
+	if errors.Is(err, keyring.ErrNotFound) {
+		return "", ErrNotFound
+	}
+	return secret, err
+}
+
+func (s keyringStore) Set(key, secret string) error {
+	return keyring.Set(s.service, key, secret)
+}
+
+func (s keyringStore) Delete(key string) error {
+	err := keyring.Delete(s.service, key)
+	if errors.Is(err, keyring.ErrNotFound) {
+		return ErrNotFound
+	}
+	return err
+}
@@ -0,0 +1,50 @@
+package secrets
+
+import (
+	"errors"
+	"fmt"
+	"github.com/rexxDigital/clmail/internal/db"
+)
+
+// backends stored in accounts.secret_store
+const (
+	BackendKeyring = "keyring"
+	BackendCommand = "command"
+	BackendFile    = "file"
+)
+
+var (
+	ErrNotFound = errors.New("secret not found")
+	// ErrReadOnly is returned when writing to a backend we can only read from, like a password command
+	ErrReadOnly = errors.New("secret store is read only")
+	// ErrLocked means the encrypted file needs the master passphrase first, see UnlockFile
+	ErrLocked = errors.New("secrets file is locked")
+)
+
+// Store keeps the password of an account, keyed by the email address
+type Store interface {
+	Get(key string) (string, error)
+	Set(key, secret string) error
+	Delete(key string) error
+}
+
+// ForAccount returns the store the account picked, accounts that never picked one use the keyring
+func ForAccount(account db.Account) (Store, error) {
+	return For(account.SecretStore, account.PasswordCommand.String)
+}
+
+func For(backend, command string) (Store, error) {
+	switch backend {
+	case "", BackendKeyring:
+		return keyringStore{service: keyringService()}, nil
+	case BackendCommand:
+		if command == "" {
+			return nil, errors.New("no password command set")
+		}
+		return commandStore{command: command}, nil
+	case BackendFile:
+		return file, nil
+	}
+
+	return nil, fmt.Errorf("unknown secret store %q, use keyring, command or file", backend)
+}
@@ -0,0 +1,558 @@
+package actions
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/emersion/go-imap/v2"
+	"github.com/rexxDigital/clmail/internal/db"
+	"github.com/rexxDigital/clmail/internal/services/events"
+	"github.com/rexxDigital/clmail/internal/smtp"
+	"github.com/rexxDigital/clmail/types"
+	"strings"
+	"time"
+)
+
+// the kinds of pending actions
+const (
+	KindFlag   = "flag"
+	KindMove   = "move"
+	KindDelete = "delete"
+	KindSend   = "send"
+	// a send that was handed to the smtp server, it is never sent again on its own. it turns into
+	// an append once the server took it, one that stays is left for the user when we crashed or
+	// couldn't record that it went out.
+	KindSending      = "sending"
+	KindAppend       = "append"
+	KindCreateFolder = "create_folder"
+	KindRenameFolder = "rename_folder"
+	KindDeleteFolder = "delete_folder"
+)
+
+// the states of pending actions, only pending ones are replayed
+const (
+	StatusPending  = "pending"
+	StatusFailed   = "failed"
+	StatusConflict = "conflict"
+)
+
+// every change the user makes goes through here: it is applied to the local store right away and
+// queued for the server, so everything works the same offline. the replayer of the account pushes
+// the queue in order.
+
+// SetRead marks the email read or unread
+func SetRead(ctx context.Context, dbClient *db.Client, email db.Email, read bool) error {
+	return setFlag(ctx, dbClient, email, imap.FlagSeen, read)
+}
+
+// SetStarred stars the email or takes the star away
+func SetStarred(ctx context.Context, dbClient *db.Client, email db.Email, starred bool) error {
+	return setFlag(ctx, dbClient, email, imap.FlagFlagged, starred)
+}
+
+func setFlag(ctx context.Context, dbClient *db.Client, email db.Email, flag imap.Flag, value bool) error {
+	err := apply(ctx, dbClient, email.AccountID, func(q *db.Queries) error {
+		if err := updateFlag(ctx, q, email, flag, value); err != nil {
+			return err
+		}
+
+		// the server keeps flags per copy, every copy gets them
+		memberships, err := q.ListEmailFolders(ctx, email.ID)
+		if err != nil {
+			return err
+		}
+		for _, membership := range memberships {
+			if err = updateFolderFlag(ctx, q, membership.FolderID, membership.Uid, membership.IsRead, membership.IsStarred, membership.IsDraft, flag, value); err != nil {
+				return err
+			}
+			if membership.Local {
+				continue
+			}
+
+			action := db.CreatePendingActionParams{
+				AccountID:   email.AccountID,
+				Kind:        KindFlag,
+				EmailID:     email.ID,
+				FolderID:    membership.FolderID,
+				Folder:      membership.Name,
+				Uid:         max(membership.Uid, 0),
+				UidValidity: membership.UidValidity,
+				Target:      string(flag),
+				Value:       value,
+				Summary:     email.Subject,
+			}
+			if err = q.DeleteSupersededFlagActions(ctx, db.DeleteSupersededFlagActionsParams{
+				EmailID:  action.EmailID,
+				FolderID: action.FolderID,
+				Uid:      action.Uid,
+				Target:   action.Target,
+			}); err != nil {
+				return err
+			}
+			if _, err = create(ctx, q, action); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("[ACTIONS::setFlag] failed to set %s: %w", flag, err)
+	}
+
+	events.Publish(events.FlagsChanged{AccountID: email.AccountID})
+	return nil
+}
+
+// Move moves the email from one folder to another. until the server confirmed it the email sits
+// in the new folder under a placeholder uid, the sync swaps it for the real copy.
+func Move(ctx context.Context, dbClient *db.Client, email db.Email, from, to db.Folder) error {
+	if from.ID == to.ID {
+		return nil
+	}
+
+	err := apply(ctx, dbClient, email.AccountID, func(q *db.Queries) error {
+		return move(ctx, q, email, from, to)
+	})
+	if err != nil {
+		return fmt.Errorf("[ACTIONS::Move] failed to move to %s: %w", to.Name, err)
+	}
+
+	events.Publish(events.Expunged{AccountID: email.AccountID, FolderID: from.ID})
+	events.Publish(events.NewMail{AccountID: email.AccountID, FolderID: to.ID})
+	return nil
+}
+
+func move(ctx context.Context, q *db.Queries, email db.Email, from, to db.Folder) error {
+	if from.Local != to.Local {
+		return errors.New("mail can't move between local folders and folders on the server")
+	}
+
+	membership, err := q.GetEmailFolder(ctx, db.GetEmailFolderParams{EmailID: email.ID, FolderID: from.ID})
+	if err != nil {
+		return fmt.Errorf("not in %s: %w", from.Name, err)
+	}
+	if err = q.RemoveEmailFromFolder(ctx, db.RemoveEmailFromFolderParams{FolderID: from.ID, Uid: membership.Uid}); err != nil {
+		return err
+	}
+
+	inTarget, err := q.IsEmailInFolder(ctx, db.IsEmailInFolderParams{EmailID: email.ID, FolderID: to.ID})
+	if err != nil {
+		return err
+	}
+
+	uid := int64(0)
+	if from.Local {
+		// the uids of local folders are ours, nothing to tell a server
+		if highest, err := q.GetHighestUIDInFolder(ctx, to.ID); err == nil {
+			uid = highest
+		}
+		uid++
+	} else {
+		action, err := create(ctx, q, db.CreatePendingActionParams{
+			AccountID:      email.AccountID,
+			Kind:           KindMove,
+			EmailID:        email.ID,
+			FolderID:       from.ID,
+			Folder:         from.Name,
+			Uid:            max(membership.Uid, 0),
+			UidValidity:    from.UidValidity,
+			TargetFolderID: to.ID,
+			Target:         to.Name,
+			Summary:        email.Subject,
+		})
+		if err != nil {
+			return err
+		}
+		uid = -action.ID
+	}
+
+	if inTarget {
+		return nil
+	}
+
+	_, err = q.AddEmailToFolder(ctx, db.AddEmailToFolderParams{
+		EmailID:   email.ID,
+		FolderID:  to.ID,
+		Uid:       uid,
+		IsRead:    membership.IsRead,
+		IsStarred: membership.IsStarred,
+		IsDraft:   membership.IsDraft,
+	})
+	return err
+}
+
+// Delete moves the email to the trash, or deletes it for good when it is in the trash already or
+// the account has none
+func Delete(ctx context.Context, dbClient *db.Client, email db.Email, folder db.Folder) error {
+	if !folder.Local {
+		folders, err := dbClient.ListFolders(ctx, email.AccountID)
+		if err != nil {
+			return fmt.Errorf("[ACTIONS::Delete] failed to get folders: %w", err)
+		}
+		if trash, ok := TrashFolder(folders); ok && trash.ID != folder.ID {
+			return Move(ctx, dbClient, email, folder, trash)
+		}
+	}
+
+	err := apply(ctx, dbClient, email.AccountID, func(q *db.Queries) error {
+		membership, err := q.GetEmailFolder(ctx, db.GetEmailFolderParams{EmailID: email.ID, FolderID: folder.ID})
+		if err != nil {
+			return fmt.Errorf("not in %s: %w", folder.Name, err)
+		}
+		if err = q.RemoveEmailFromFolder(ctx, db.RemoveEmailFromFolderParams{FolderID: folder.ID, Uid: membership.Uid}); err != nil {
+			return err
+		}
+
+		if folder.Local {
+			return deleteIfOrphan(ctx, q, email.ID)
+		}
+
+		// the email row stays until the server deleted it, the queue can still put it back
+		_, err = create(ctx, q, db.CreatePendingActionParams{
+			AccountID:   email.AccountID,
+			Kind:        KindDelete,
+			EmailID:     email.ID,
+			FolderID:    folder.ID,
+			Folder:      folder.Name,
+			Uid:         max(membership.Uid, 0),
+			UidValidity: folder.UidValidity,
+			Summary:     email.Subject,
+		})
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("[ACTIONS::Delete] failed to delete: %w", err)
+	}
+
+	events.Publish(events.Expunged{AccountID: email.AccountID, FolderID: folder.ID})
+	return nil
+}
+
+// TrashFolder finds the folder deleted mail goes to
+func TrashFolder(folders []db.Folder) (db.Folder, bool) {
+	for _, folder := range folders {
+		name := strings.ToLower(folder.Name)
+		if !folder.Local && (strings.Contains(name, "trash") || strings.Contains(name, "deleted")) {
+			return folder, true
+		}
+	}
+	return db.Folder{}, false
+}
+
+// Send queues the mail, it goes out as soon as the smtp server can be reached and is stored in
+// the sent folder after
+func Send(ctx context.Context, dbClient *db.Client, account db.Account, mail types.Mail) error {
+	err := apply(ctx, dbClient, account.ID, func(q *db.Queries) error {
+		_, err := create(ctx, q, db.CreatePendingActionParams{
+			AccountID: account.ID,
+			Kind:      KindSend,
+			Target:    strings.Join(smtp.Recipients(mail), "\n"),
+			Payload:   smtp.Message(mail, &account),
+			Summary:   mail.Subject,
+		})
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("[ACTIONS::Send] failed to queue mail: %w", err)
+	}
+	return nil
+}
+
+// CreateFolder creates a folder on the server
+func CreateFolder(ctx context.Context, dbClient *db.Client, account db.Account, name string) error {
+	err := apply(ctx, dbClient, account.ID, func(q *db.Queries) error {
+		if err := checkFree(ctx, q, account.ID, name); err != nil {
+			return err
+		}
+
+		folder, err := q.CreateFolder(ctx, db.CreateFolderParams{AccountID: account.ID, Name: name})
+		if err != nil {
+			return err
+		}
+
+		_, err = create(ctx, q, db.CreatePendingActionParams{
+			AccountID: account.ID,
+			Kind:      KindCreateFolder,
+			FolderID:  folder.ID,
+			Folder:    name,
+			Summary:   name,
+		})
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("[ACTIONS::CreateFolder] failed to create %s: %w", name, err)
+	}
+
+	events.Publish(events.FoldersChanged{AccountID: account.ID})
+	return nil
+}
+
+// RenameFolder renames the folder, on the server as well unless it is a local one
+func RenameFolder(ctx context.Context, dbClient *db.Client, folder db.Folder, name string) error {
+	err := apply(ctx, dbClient, folder.AccountID, func(q *db.Queries) error {
+		if err := checkFree(ctx, q, folder.AccountID, name); err != nil {
+			return err
+		}
+
+		if _, err := q.UpdateFolder(ctx, db.UpdateFolderParams{Name: name, ID: folder.ID}); err != nil {
+			return err
+		}
+		if folder.Local {
+			return nil
+		}
+
+		_, err := create(ctx, q, db.CreatePendingActionParams{
+			AccountID: folder.AccountID,
+			Kind:      KindRenameFolder,
+			FolderID:  folder.ID,
+			Folder:    folder.Name,
+			Target:    name,
+			Summary:   folder.Name,
+		})
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("[ACTIONS::RenameFolder] failed to rename %s: %w", folder.Name, err)
+	}
+
+	events.Publish(events.FoldersChanged{AccountID: folder.AccountID})
+	return nil
+}
+
+// DeleteFolder deletes the folder with all of its mail, on the server as well unless it is a
+// local one
+func DeleteFolder(ctx context.Context, dbClient *db.Client, folder db.Folder) error {
+	if strings.EqualFold(folder.Name, "INBOX") {
+		return fmt.Errorf("[ACTIONS::DeleteFolder] the inbox can't be deleted")
+	}
+
+	err := apply(ctx, dbClient, folder.AccountID, func(q *db.Queries) error {
+		if err := q.DeleteFolder(ctx, folder.ID); err != nil {
+			return err
+		}
+
+		if folder.Local {
+			_, err := q.DeleteOrphanEmails(ctx, folder.AccountID)
+			return err
+		}
+
+		// its mail is deleted once the server confirmed, until then the queue can bring it back
+		_, err := create(ctx, q, db.CreatePendingActionParams{
+			AccountID: folder.AccountID,
+			Kind:      KindDeleteFolder,
+			FolderID:  folder.ID,
+			Folder:    folder.Name,
+			Summary:   folder.Name,
+		})
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("[ACTIONS::DeleteFolder] failed to delete %s: %w", folder.Name, err)
+	}
+
+	events.Publish(events.FoldersChanged{AccountID: folder.AccountID})
+	return nil
+}
+
+// Retry queues a failed action again, right away. a send that may have gone out already is sent
+// again, the user asked for it.
+func Retry(ctx context.Context, dbClient *db.Client, action db.PendingAction) error {
+	err := apply(ctx, dbClient, action.AccountID, func(q *db.Queries) error {
+		if action.Kind == KindSending {
+			if err := q.SetPendingActionKind(ctx, db.SetPendingActionKindParams{Kind: KindSend, ID: action.ID}); err != nil {
+				return err
+			}
+		}
+		return q.RetryPendingAction(ctx, db.RetryPendingActionParams{NextAttemptAt: time.Now(), ID: action.ID})
+	})
+	if err != nil {
+		return fmt.Errorf("[ACTIONS::Retry] failed to retry action: %w", err)
+	}
+
+	wake(action.AccountID)
+	events.Publish(events.ActionsChanged{AccountID: action.AccountID})
+	return nil
+}
+
+// Discard drops the action from the queue. with undo the local change is taken back as well, the
+// server never heard of it. without, the local store keeps it, which is what you want when the
+// server already has it that way, like a message that is gone anyway.
+func Discard(ctx context.Context, dbClient *db.Client, action db.PendingAction, undo bool) error {
+	err := apply(ctx, dbClient, action.AccountID, func(q *db.Queries) error {
+		if undo {
+			if err := revert(ctx, q, action); err != nil {
+				return err
+			}
+		} else if action.Kind == KindDelete {
+			// the row was only kept around for an undo
+			if err := deleteIfOrphan(ctx, q, action.EmailID); err != nil {
+				return err
+			}
+		}
+		return q.DeletePendingAction(ctx, action.ID)
+	})
+	if err != nil {
+		return fmt.Errorf("[ACTIONS::Discard] failed to discard action: %w", err)
+	}
+
+	if undo {
+		events.Publish(events.FoldersChanged{AccountID: action.AccountID})
+		events.Publish(events.FlagsChanged{AccountID: action.AccountID})
+	}
+	return nil
+}
+
+func revert(ctx context.Context, q *db.Queries, action db.PendingAction) error {
+	switch action.Kind {
+	case KindFlag:
+		email, err := q.GetEmail(ctx, action.EmailID)
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		flag := imap.Flag(action.Target)
+		if err = updateFlag(ctx, q, email, flag, !action.Value); err != nil {
+			return err
+		}
+
+		membership, err := q.GetEmailFolder(ctx, db.GetEmailFolderParams{EmailID: email.ID, FolderID: action.FolderID})
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		return updateFolderFlag(ctx, q, membership.FolderID, membership.Uid, membership.IsRead, membership.IsStarred, membership.IsDraft, flag, !action.Value)
+
+	case KindMove, KindDelete:
+		if action.Kind == KindMove {
+			if err := q.RemoveEmailFromFolder(ctx, db.RemoveEmailFromFolderParams{FolderID: action.TargetFolderID, Uid: -action.ID}); err != nil {
+				return err
+			}
+		}
+
+		// a copy whose uid we never learned can't be put back where it was, the next sync would
+		// not know it is the same message
+		if action.Uid == 0 {
+			return nil
+		}
+		email, err := q.GetEmail(ctx, action.EmailID)
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		_, err = q.AddEmailToFolder(ctx, db.AddEmailToFolderParams{
+			EmailID:   email.ID,
+			FolderID:  action.FolderID,
+			Uid:       action.Uid,
+			IsRead:    email.IsRead,
+			IsStarred: email.IsStarred,
+			IsDraft:   email.IsDraft,
+		})
+		return err
+
+	case KindCreateFolder:
+		return q.DeleteFolder(ctx, action.FolderID)
+
+	case KindRenameFolder:
+		_, err := q.UpdateFolder(ctx, db.UpdateFolderParams{Name: action.Folder, ID: action.FolderID})
+		return err
+
+	case KindDeleteFolder:
+		// the folder comes back empty, the next sync fills it again
+		_, err := q.CreateFolder(ctx, db.CreateFolderParams{AccountID: action.AccountID, Name: action.Folder})
+		return err
+	}
+
+	// a mail that wasn't sent has nothing to take back
+	return nil
+}
+
+// apply runs the local change and records its actions in one transaction, so one never happens
+// without the other. the replayer of the account is woken up after.
+func apply(ctx context.Context, dbClient *db.Client, accountID int64, change func(q *db.Queries) error) error {
+	tx, err := dbClient.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err = change(dbClient.WithTx(tx)); err != nil {
+		return err
+	}
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit: %w", err)
+	}
+
+	wake(accountID)
+	events.Publish(events.ActionsChanged{AccountID: accountID})
+	return nil
+}
+
+func create(ctx context.Context, q *db.Queries, action db.CreatePendingActionParams) (db.PendingAction, error) {
+	now := time.Now()
+	action.NextAttemptAt = now
+	action.CreatedAt = now
+	return q.CreatePendingAction(ctx, action)
+}
+
+func updateFlag(ctx context.Context, q *db.Queries, email db.Email, flag imap.Flag, value bool) error {
+	isRead, isStarred := email.IsRead, email.IsStarred
+	switch flag {
+	case imap.FlagSeen:
+		isRead = value
+	case imap.FlagFlagged:
+		isStarred = value
+	}
+
+	return q.UpdateEmailFlags(ctx, db.UpdateEmailFlagsParams{
+		IsRead:    isRead,
+		IsStarred: isStarred,
+		IsDraft:   email.IsDraft,
+		ID:        email.ID,
+	})
+}
+
+func updateFolderFlag(ctx context.Context, q *db.Queries, folderID, uid int64, isRead, isStarred, isDraft bool, flag imap.Flag, value bool) error {
+	switch flag {
+	case imap.FlagSeen:
+		isRead = value
+	case imap.FlagFlagged:
+		isStarred = value
+	}
+
+	_, err := q.UpdateFolderEmailFlags(ctx, db.UpdateFolderEmailFlagsParams{
+		IsRead:    isRead,
+		IsStarred: isStarred,
+		IsDraft:   isDraft,
+		FolderID:  folderID,
+		Uid:       uid,
+	})
+	return err
+}
+
+// deleteIfOrphan deletes the email once it is in no folder any more
+func deleteIfOrphan(ctx context.Context, q *db.Queries, emailID int64) error {
+	count, err := q.CountEmailFolders(ctx, emailID)
+	if err != nil || count > 0 {
+		return err
+	}
+	return q.DeleteEmail(ctx, emailID)
+}
+
+func checkFree(ctx context.Context, q *db.Queries, accountID int64, name string) error {
+	if strings.TrimSpace(name) == "" {
+		return errors.New("the name is empty")
+	}
+
+	_, err := q.GetFolderByName(ctx, db.GetFolderByNameParams{Name: name, AccountID: accountID})
+	if err == nil {
+		return fmt.Errorf("there already is a folder %s", name)
+	} else if !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,352 @@
+package actions
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	goimap "github.com/emersion/go-imap/v2"
+	"github.com/rexxDigital/clmail/internal/db"
+	"github.com/rexxDigital/clmail/internal/imap"
+	"github.com/rexxDigital/clmail/internal/services/events"
+	"github.com/rexxDigital/clmail/internal/smtp"
+	"log"
+	"net"
+	"net/mail"
+	"net/textproto"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// how often the queue is looked at when nothing woke the replayer, that's also how soon it
+	// notices the server is back
+	replayTick = 30 * time.Second
+	// an action that failed this often is left for the user to retry or discard
+	maxAttempts = 6
+	// the wait after the first failure, it doubles with every one after
+	retryBackoff    = 30 * time.Second
+	maxRetryBackoff = 30 * time.Minute
+)
+
+// errMaybeSent is a send that was handed to the smtp server without hearing back, only the user
+// can tell whether to send it again
+var errMaybeSent = errors.New("the mail may have been sent already, retry to send it again")
+
+var (
+	replayers      = make(map[int64]*Replayer)
+	replayersMutex sync.Mutex
+)
+
+// Replayer pushes the pending actions of one account to the server, oldest first
+type Replayer struct {
+	account  db.Account
+	password string
+	dbClient *db.Client
+	// syncNow is told about folders that got mail from a move, so the real copy shows up soon
+	syncNow func(folder string)
+	wake    chan struct{}
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+func NewReplayer(account db.Account, password string, dbClient *db.Client, syncNow func(folder string)) *Replayer {
+	return &Replayer{
+		account:  account,
+		password: password,
+		dbClient: dbClient,
+		syncNow:  syncNow,
+		wake:     make(chan struct{}, 1),
+	}
+}
+
+func (r *Replayer) Start() {
+	r.ctx, r.cancel = context.WithCancel(context.Background())
+
+	replayersMutex.Lock()
+	replayers[r.account.ID] = r
+	replayersMutex.Unlock()
+
+	r.wg.Add(1)
+	go r.run()
+}
+
+func (r *Replayer) Close() {
+	replayersMutex.Lock()
+	if replayers[r.account.ID] == r {
+		delete(replayers, r.account.ID)
+	}
+	replayersMutex.Unlock()
+
+	if r.cancel != nil {
+		r.cancel()
+	}
+	r.wg.Wait()
+}
+
+// Wake makes the replayer look at the queue right away
+func (r *Replayer) Wake() {
+	select {
+	case r.wake <- struct{}{}:
+	default:
+	}
+}
+
+// wake wakes the replayer of the account, if it runs. one that doesn't gets to the queue when
+// it is started.
+func wake(accountID int64) {
+	replayersMutex.Lock()
+	replayer, ok := replayers[accountID]
+	replayersMutex.Unlock()
+
+	if ok {
+		replayer.Wake()
+	}
+}
+
+func (r *Replayer) run() {
+	defer r.wg.Done()
+
+	ticker := time.NewTicker(replayTick)
+	defer ticker.Stop()
+
+	for {
+		r.replayDue()
+
+		select {
+		case <-r.ctx.Done():
+			return
+		case <-ticker.C:
+		case <-r.wake:
+		}
+	}
+}
+
+// replayDue replays the actions in the order they were taken. one that has to wait holds back
+// everything after it, a later one may depend on it, a move on the folder created before or a
+// flag on where the message was moved to. one left for the user only holds back the later ones
+// on the same message or folder, the rest of the queue goes on without it.
+func (r *Replayer) replayDue() {
+	pending, err := r.dbClient.ListAccountPendingActions(r.ctx, r.account.ID)
+	if err != nil {
+		log.Printf("[ACTIONS::Replayer] Failed to get pending actions: %v", err)
+		return
+	}
+
+	blocked := make(map[string]bool)
+	for _, action := range pending {
+		if r.ctx.Err() != nil {
+			return
+		}
+
+		holds, needs := touches(action)
+		if action.Status != StatusPending || slices.ContainsFunc(needs, func(key string) bool { return blocked[key] }) {
+			for _, key := range holds {
+				blocked[key] = true
+			}
+			continue
+		}
+		if action.NextAttemptAt.After(time.Now()) {
+			return
+		}
+
+		err := r.replay(&action)
+		if offline(err) {
+			// nothing else gets through either, and being offline isn't the actions fault
+			r.record(action, action.Status, action.Attempts, err, action.NextAttemptAt)
+			return
+		}
+		r.finish(action, err)
+		if err != nil {
+			return
+		}
+	}
+}
+
+// touches is what an action holds back when it is stuck, and what it needs from the ones before it.
+// a message action holds its message and needs the folders it works in, a folder action holds and
+// needs its folders.
+func touches(action db.PendingAction) (holds []string, needs []string) {
+	if action.EmailID != 0 {
+		holds = append(holds, fmt.Sprintf("email:%d", action.EmailID))
+	}
+	var folders []string
+	if action.Folder != "" {
+		folders = append(folders, "folder:"+action.Folder)
+	}
+	if action.Kind == KindMove || action.Kind == KindRenameFolder {
+		folders = append(folders, "folder:"+action.Target)
+	}
+
+	needs = append(slices.Clone(holds), folders...)
+	if action.EmailID == 0 {
+		holds = append(holds, folders...)
+	}
+	return holds, needs
+}
+
+func (r *Replayer) replay(action *db.PendingAction) error {
+	client, err := imap.NewSyncClient(r.account, r.password, r.dbClient)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	switch action.Kind {
+	case KindFlag:
+		return client.StoreFlag(r.target(*action), goimap.Flag(action.Target), action.Value)
+
+	case KindMove:
+		if err = client.Move(r.target(*action), action.Target); err != nil {
+			return err
+		}
+		r.syncNow(action.Target)
+		return nil
+
+	case KindDelete:
+		if err = client.Expunge(r.target(*action)); err != nil {
+			return err
+		}
+		return deleteIfOrphan(r.ctx, r.dbClient.Queries, action.EmailID)
+
+	case KindSend:
+		// recorded before it goes out, a crash or a failed update after the server took it must
+		// not send it a second time
+		if err = r.dbClient.SetPendingActionKind(r.ctx, db.SetPendingActionKindParams{Kind: KindSending, ID: action.ID}); err != nil {
+			return err
+		}
+		if err = smtp.Send(&r.account, r.password, strings.Split(action.Target, "\n"), action.Payload); err != nil {
+			// it didn't go out, it can be sent again
+			if kindErr := r.dbClient.SetPendingActionKind(r.ctx, db.SetPendingActionKindParams{Kind: KindSend, ID: action.ID}); kindErr != nil {
+				log.Printf("[ACTIONS::Replayer] Failed to mark %s as unsent: %v", action.Summary, kindErr)
+				action.Kind = KindSending
+			}
+			return err
+		}
+		events.Publish(events.SendResult{AccountID: r.account.ID, MessageID: header(action.Payload, "Message-ID")})
+
+		if err = r.dbClient.SetPendingActionKind(r.ctx, db.SetPendingActionKindParams{Kind: KindAppend, ID: action.ID}); err != nil {
+			return err
+		}
+		action.Kind = KindAppend
+		return r.saveSent(client, *action)
+
+	case KindSending:
+		return errMaybeSent
+
+	case KindAppend:
+		return r.saveSent(client, *action)
+
+	case KindCreateFolder:
+		return client.CreateFolder(action.Folder)
+
+	case KindRenameFolder:
+		return client.RenameFolder(action.Folder, action.Target)
+
+	case KindDeleteFolder:
+		if err = client.DeleteFolder(action.Folder); err != nil {
+			return err
+		}
+		_, err = r.dbClient.DeleteOrphanEmails(r.ctx, r.account.ID)
+		return err
+	}
+
+	return fmt.Errorf("unknown action %s", action.Kind)
+}
+
+func (r *Replayer) saveSent(client imap.SyncClient, action db.PendingAction) error {
+	date, err := mail.ParseDate(header(action.Payload, "Date"))
+	if err != nil {
+		date = action.CreatedAt
+	}
+	return client.SaveSent(string(action.Payload), date)
+}
+
+// target is where the message of the action was when it was taken
+func (r *Replayer) target(action db.PendingAction) imap.Target {
+	target := imap.Target{
+		Folder:      action.Folder,
+		UID:         uint32(action.Uid),
+		UIDValidity: uint32(action.UidValidity),
+	}
+
+	email, err := r.dbClient.GetEmail(r.ctx, action.EmailID)
+	if err == nil {
+		target.MessageID = email.MessageID
+	} else if !errors.Is(err, sql.ErrNoRows) {
+		log.Printf("[ACTIONS::Replayer] Failed to get email %d: %v", action.EmailID, err)
+	}
+	return target
+}
+
+// finish takes the action off the queue when it went through, otherwise it is retried later or
+// left for the user
+func (r *Replayer) finish(action db.PendingAction, err error) {
+	if err == nil {
+		if err = r.dbClient.DeletePendingAction(r.ctx, action.ID); err != nil {
+			log.Printf("[ACTIONS::Replayer] Failed to delete action: %v", err)
+		}
+		events.Publish(events.ActionsChanged{AccountID: r.account.ID})
+		return
+	}
+
+	log.Printf("[ACTIONS::Replayer] Failed to replay %s of %s: %v", action.Kind, action.Summary, err)
+
+	attempts := action.Attempts + 1
+	status := StatusPending
+	switch {
+	case imap.IsConflict(err), errors.Is(err, errMaybeSent):
+		status = StatusConflict
+	case permanent(err), attempts >= maxAttempts:
+		status = StatusFailed
+	}
+
+	if status != StatusPending && action.Kind == KindSend {
+		events.Publish(events.SendResult{AccountID: r.account.ID, MessageID: header(action.Payload, "Message-ID"), Err: err})
+	}
+
+	backoff := min(retryBackoff<<(attempts-1), maxRetryBackoff)
+	r.record(action, status, attempts, err, time.Now().Add(backoff))
+}
+
+func (r *Replayer) record(action db.PendingAction, status string, attempts int64, err error, next time.Time) {
+	updateErr := r.dbClient.UpdatePendingAction(r.ctx, db.UpdatePendingActionParams{
+		Status:        status,
+		Attempts:      attempts,
+		LastError:     err.Error(),
+		NextAttemptAt: next,
+		ID:            action.ID,
+	})
+	if updateErr != nil {
+		log.Printf("[ACTIONS::Replayer] Failed to update action: %v", updateErr)
+	}
+	events.Publish(events.ActionsChanged{AccountID: r.account.ID})
+}
+
+// offline reports whether the server couldn't be reached at all
+func offline(err error) bool {
+	var netErr *net.OpError
+	return errors.Is(err, imap.ErrOffline) || errors.As(err, &netErr)
+}
+
+// permanent reports whether the smtp server refused the mail for good, a 5xx won't turn into a
+// yes by asking again
+func permanent(err error) bool {
+	var smtpErr *textproto.Error
+	return errors.As(err, &smtpErr) && smtpErr.Code >= 500
+}
+
+func header(message []byte, name string) string {
+	reader := textproto.NewReader(bufio.NewReader(bytes.NewReader(message)))
+	headers, err := reader.ReadMIMEHeader()
+	if err != nil && len(headers) == 0 {
+		return ""
+	}
+	return headers.Get(name)
+}
@@ -8,12 +8,15 @@ import (
 	"github.com/rexxDigital/clmail/internal/accounts"
 	"github.com/rexxDigital/clmail/internal/db"
 	"github.com/rexxDigital/clmail/internal/imap"
+	"github.com/rexxDigital/clmail/internal/services/actions"
 	"github.com/rexxDigital/clmail/internal/services/sync"
 )
 
 type EmailService interface {
 	InitializeAccount(account db.Account) error
 	InitializeAllAccounts() error
+	ReloadAccount(account db.Account) error
+	RemoveAccount(accountID int64)
 	Close()
 	GetAllClients() map[int64]*EmailClient
 	HasAccount(accountID int64) bool
@@ -28,6 +31,7 @@ type emailService struct {
 type EmailClient struct {
 	IdleClient imap.IdleClient
 	SyncClient sync.Syncer
+	Replayer   *actions.Replayer
 	Account    db.Account
 }
 
@@ -43,7 +47,7 @@ func (es *emailService) InitializeAccount(account db.Account) error {
 		return nil
 	}
 
-	password, err := accounts.GetPassword(account.Email)
+	password, err := accounts.PasswordFor(account)
 	if err != nil {
 		return fmt.Errorf("failed to get password for %s: %w", account.Email, err)
 	}
@@ -56,12 +60,26 @@ func (es *emailService) InitializeAccount(account db.Account) error {
 	syncClient := sync.NewSyncService(account, password, es.dbClient)
 	syncClient.Start()
 
-	go idleClient.Idle("INBOX")
+	pushFolders, err := accounts.PushFolders(account.ID, es.dbClient)
+	if err != nil {
+		log.Printf("Failed to get push folders for %s: %v", account.Email, err)
+	}
+	// a push makes the syncer fetch the folder right away, while push works the poll leaves it alone
+	idleClient.Push(pushFolders, imap.PushHandler{
+		Changed: syncClient.SyncNow,
+		Active:  syncClient.SetPushed,
+	})
+
 	go syncClient.InitSync()
 
+	// whatever was done while the account wasn't running goes out now
+	replayer := actions.NewReplayer(account, password, es.dbClient, syncClient.SyncNow)
+	replayer.Start()
+
 	es.clients[account.ID] = &EmailClient{
 		IdleClient: idleClient,
 		SyncClient: syncClient,
+		Replayer:   replayer,
 		Account:    account,
 	}
 
@@ -89,21 +107,48 @@ func (es *emailService) InitializeAllAccounts() error {
 	return nil
 }
 
+// ReloadAccount restarts the clients of an account so edited settings or a new password are picked up
+func (es *emailService) ReloadAccount(account db.Account) error {
+	es.RemoveAccount(account.ID)
+	return es.InitializeAccount(account)
+}
+
+// RemoveAccount stops the idle and sync clients of the account and closes its connections
+func (es *emailService) RemoveAccount(accountID int64) {
+	client, exists := es.clients[accountID]
+	if !exists {
+		return
+	}
+
+	closeClient(accountID, client)
+	delete(es.clients, accountID)
+}
+
 func (es *emailService) Close() {
 	for accountID, client := range es.clients {
-		if client.IdleClient != nil {
-			if err := client.IdleClient.Close(); err != nil {
-				log.Printf("Failed to close idle client for account %d: %v", accountID, err)
-			}
-		}
-		if client.SyncClient != nil {
-			client.SyncClient.Close()
-		}
+		closeClient(accountID, client)
 	}
 
 	es.clients = make(map[int64]*EmailClient)
 }
 
+func closeClient(accountID int64, client *EmailClient) {
+	if client.IdleClient != nil {
+		if err := client.IdleClient.Close(); err != nil {
+			log.Printf("Failed to close idle client for account %d: %v", accountID, err)
+		}
+	}
+	if client.Replayer != nil {
+		client.Replayer.Close()
+	}
+	if client.SyncClient != nil {
+		client.SyncClient.Close()
+	}
+
+	// after the syncer stopped, so nothing borrows a connection from the closed pool
+	imap.ClosePool(accountID)
+}
+
 func (es *emailService) GetAllClients() map[int64]*EmailClient {
 	result := make(map[int64]*EmailClient)
 	for id, client := range es.clients {
@@ -0,0 +1,195 @@
+package events
+
+import (
+	"sync"
+)
+
+// the events the sync layer and the action queue publish. ids are 0 when the publisher doesn't know them.
+type (
+	// NewMail is published when messages were stored for a folder
+	NewMail struct {
+		AccountID int64
+		FolderID  int64
+	}
+
+	// BodyFetched is published when the body of a stored message arrived
+	BodyFetched struct {
+		AccountID int64
+		EmailID   int64
+	}
+
+	// FlagsChanged is published when the server told us flags changed in a folder
+	FlagsChanged struct {
+		AccountID int64
+		FolderID  int64
+	}
+
+	// Expunged is published when messages were removed from a folder on the server
+	Expunged struct {
+		AccountID int64
+		FolderID  int64
+	}
+
+	// FoldersChanged is published when the folder list of an account changed
+	FoldersChanged struct {
+		AccountID int64
+	}
+
+	// ActionsChanged is published when the queue of actions waiting for the server changed
+	ActionsChanged struct {
+		AccountID int64
+	}
+
+	// SendResult is published when a mail was sent or failed to send
+	SendResult struct {
+		AccountID int64
+		MessageID string
+		Err       error
+	}
+)
+
+// Coalescer is implemented by events where only the latest one matters, a queued event with the
+// same key is replaced instead of queueing another one
+type Coalescer interface {
+	CoalesceKey() any
+}
+
+// maxQueued is how many events a subscriber that doesn't keep up holds before dropping the oldest
+const maxQueued = 1000
+
+// Bus hands every published event to every subscriber. publishing never blocks, so the sync and
+// imap goroutines can't be held up by a busy tui.
+type Bus struct {
+	mu          sync.Mutex
+	subscribers []*Subscription
+}
+
+type Subscription struct {
+	bus    *Bus
+	mu     sync.Mutex
+	queue  []any
+	notify chan struct{}
+	// closed wakes a Next that is still waiting when the subscription is closed
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+// the bus everything in the process shares
+var defaultBus = NewBus()
+
+func NewBus() *Bus {
+	return &Bus{}
+}
+
+func Publish(event any) {
+	defaultBus.Publish(event)
+}
+
+func Subscribe() *Subscription {
+	return defaultBus.Subscribe()
+}
+
+func (b *Bus) Publish(event any) {
+	b.mu.Lock()
+	subscribers := b.subscribers
+	b.mu.Unlock()
+
+	for _, subscription := range subscribers {
+		subscription.push(event)
+	}
+}
+
+func (b *Bus) Subscribe() *Subscription {
+	subscription := &Subscription{bus: b, notify: make(chan struct{}, 1), closed: make(chan struct{})}
+
+	b.mu.Lock()
+	b.subscribers = append(append([]*Subscription(nil), b.subscribers...), subscription)
+	b.mu.Unlock()
+
+	return subscription
+}
+
+// Next blocks until there are events and returns all of them, oldest first. it returns nil once
+// the subscription is closed.
+func (s *Subscription) Next() []any {
+	for {
+		select {
+		case <-s.notify:
+		case <-s.closed:
+			return nil
+		}
+
+		s.mu.Lock()
+		queued := s.queue
+		s.queue = nil
+		s.mu.Unlock()
+
+		// a notify can outlive the events an earlier Next already took
+		if len(queued) > 0 {
+			return queued
+		}
+	}
+}
+
+// Close stops the delivery of events to the subscription and wakes a waiting Next
+func (s *Subscription) Close() {
+	s.closeOnce.Do(func() { close(s.closed) })
+
+	s.bus.mu.Lock()
+	defer s.bus.mu.Unlock()
+
+	subscribers := make([]*Subscription, 0, len(s.bus.subscribers))
+	for _, subscription := range s.bus.subscribers {
+		if subscription != s {
+			subscribers = append(subscribers, subscription)
+		}
+	}
+	s.bus.subscribers = subscribers
+}
+
+func (s *Subscription) push(event any) {
+	s.mu.Lock()
+	if !s.replace(event) {
+		if len(s.queue) >= maxQueued {
+			s.queue = s.queue[1:]
+		}
+		s.queue = append(s.queue, event)
+	}
+	s.mu.Unlock()
+
+	select {
+	case s.notify <- struct{}{}:
+	default:
+	}
+}
+
+// replace swaps a queued event for the new one when they are the same kind of update, plain
+// duplicates like ten NewMail for one folder collapse as well
+func (s *Subscription) replace(event any) bool {
+	coalescer, ok := event.(Coalescer)
+
+	for i, queued := range s.queue {
+		if ok {
+			if other, isCoalescer := queued.(Coalescer); isCoalescer && other.CoalesceKey() == coalescer.CoalesceKey() {
+				s.queue[i] = event
+				return true
+			}
+			continue
+		}
+
+		if isPlain(event) && isPlain(queued) && queued == event {
+			return true
+		}
+	}
+
+	return false
+}
+
+// isPlain reports whether the event only holds ids, so comparing it with == can't panic
+func isPlain(event any) bool {
+	switch event.(type) {
+	case NewMail, BodyFetched, FlagsChanged, Expunged, FoldersChanged, ActionsChanged:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,111 @@
+package sync
+
+import (
+	"github.com/rexxDigital/clmail/internal/db"
+	"strings"
+	"sync"
+)
+
+// lower syncs first
+const (
+	// the folder on screen and anything asked for with sync now
+	priorityNow = iota
+	// INBOX and the special-use folders people actually look at
+	prioritySpecial
+	priorityNormal
+)
+
+type queuedFolder struct {
+	folder   db.Folder
+	priority int
+	seq      uint64
+}
+
+// folderQueue is the sync queue of one account. a folder is in it at most once and pushing never
+// blocks, unlike the channel it replaced, which blocked the scheduler whenever it was full.
+type folderQueue struct {
+	mu      sync.Mutex
+	folders []queuedFolder
+	seq     uint64
+	// wake gets a value when something was pushed, so the worker doesn't have to poll
+	wake chan struct{}
+}
+
+func newFolderQueue() *folderQueue {
+	return &folderQueue{wake: make(chan struct{}, 1)}
+}
+
+// push queues the folder, a folder that is already waiting keeps its place but can move up
+func (q *folderQueue) push(folder db.Folder, priority int) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for i := range q.folders {
+		if q.folders[i].folder.ID == folder.ID {
+			q.folders[i].priority = min(q.folders[i].priority, priority)
+			q.signal()
+			return
+		}
+	}
+
+	q.seq++
+	q.folders = append(q.folders, queuedFolder{folder: folder, priority: priority, seq: q.seq})
+	q.signal()
+}
+
+// raise moves a waiting folder up, it doesn't queue anything new
+func (q *folderQueue) raise(name string, priority int) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for i := range q.folders {
+		if q.folders[i].folder.Name == name {
+			q.folders[i].priority = min(q.folders[i].priority, priority)
+		}
+	}
+}
+
+// pop takes the folder with the best priority, the oldest one first when they are equal
+func (q *folderQueue) pop() (db.Folder, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if len(q.folders) == 0 {
+		return db.Folder{}, false
+	}
+
+	next := 0
+	for i, queued := range q.folders {
+		best := q.folders[next]
+		if queued.priority < best.priority || (queued.priority == best.priority && queued.seq < best.seq) {
+			next = i
+		}
+	}
+
+	folder := q.folders[next].folder
+	q.folders = append(q.folders[:next], q.folders[next+1:]...)
+	return folder, true
+}
+
+func (q *folderQueue) signal() {
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+// isSpecialFolder guesses the special-use folders from their name since we don't store the LIST
+// attributes. trash and junk are special too, but nobody waits for those.
+func isSpecialFolder(name string) bool {
+	if strings.EqualFold(name, "INBOX") {
+		return true
+	}
+
+	lower := strings.ToLower(name)
+	for _, special := range []string{"sent", "draft", "archive", "all mail", "starred", "flagged"} {
+		if strings.Contains(lower, special) {
+			return true
+		}
+	}
+	return false
+}
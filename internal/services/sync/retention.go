@@ -0,0 +1,143 @@
+package sync
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/rexxDigital/clmail/internal/db"
+)
+
+const (
+	// how often the stored bodies of an account are checked against its retention policy
+	retentionTick = time.Hour
+	// the size limit evicts this many bodies per query, oldest first
+	evictBatchSize = 200
+)
+
+// retentionLoop applies the retention policy once at start and then every retentionTick
+func (s *syncer) retentionLoop() {
+	defer s.wg.Done()
+
+	ticker := time.NewTicker(retentionTick)
+	defer ticker.Stop()
+
+	for {
+		if err := applyRetention(s.ctx, s.account, s.dbClient); err != nil {
+			log.Printf("Failed to apply retention for %s: %v", s.account.Email, err)
+		}
+
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// applyRetention drops the bodies and attachment contents the account doesn't want to keep,
+// first everything older than the retention days and then the oldest until the rest fits the
+// size limit. headers and starred mail always stay, evicted bodies are fetched again when their
+// thread is opened.
+func applyRetention(ctx context.Context, account db.Account, dbClient *db.Client) error {
+	if account.BodyRetentionDays <= 0 && account.BodyRetentionMb <= 0 {
+		return nil
+	}
+
+	var evicted int64
+
+	if account.BodyRetentionDays > 0 {
+		cutoff := time.Now().AddDate(0, 0, -int(account.BodyRetentionDays))
+
+		bodies, err := dbClient.EvictBodiesBefore(ctx, db.EvictBodiesBeforeParams{
+			AccountID:    account.ID,
+			ReceivedDate: cutoff,
+		})
+		if err != nil {
+			return fmt.Errorf("[SYNC::applyRetention] failed to evict old bodies: %w", err)
+		}
+		_, err = dbClient.EvictAttachmentsBefore(ctx, db.EvictAttachmentsBeforeParams{
+			AccountID:    account.ID,
+			ReceivedDate: cutoff,
+		})
+		if err != nil {
+			return fmt.Errorf("[SYNC::applyRetention] failed to evict old attachments: %w", err)
+		}
+		evicted += bodies
+	}
+
+	if account.BodyRetentionMb > 0 {
+		bodies, err := evictToLimit(ctx, account, dbClient)
+		if err != nil {
+			return err
+		}
+		evicted += bodies
+	}
+
+	if evicted == 0 {
+		return nil
+	}
+	log.Printf("[SYNC::applyRetention] Evicted %d bodies of %s", evicted, account.Email)
+
+	return dbClient.IncrementalVacuum(ctx)
+}
+
+// evictToLimit evicts the oldest bodies until the account is within its size limit
+func evictToLimit(ctx context.Context, account db.Account, dbClient *db.Client) (int64, error) {
+	storage, err := dbClient.GetAccountStorage(ctx, account.ID)
+	if err != nil {
+		return 0, fmt.Errorf("[SYNC::evictToLimit] failed to get storage: %w", err)
+	}
+
+	excess := storage.BodyBytes + storage.SourceBytes + storage.AttachmentBytes - account.BodyRetentionMb*1024*1024
+	var evicted int64
+
+	for excess > 0 && ctx.Err() == nil {
+		candidates, err := dbClient.ListEvictableBodies(ctx, db.ListEvictableBodiesParams{
+			AccountID: account.ID,
+			Limit:     evictBatchSize,
+		})
+		if err != nil {
+			return evicted, fmt.Errorf("[SYNC::evictToLimit] failed to list bodies: %w", err)
+		}
+		// what is left is starred, that stays even above the limit
+		if len(candidates) == 0 {
+			break
+		}
+
+		for _, candidate := range candidates {
+			if excess <= 0 {
+				break
+			}
+			if err := evictBody(ctx, candidate.ID, dbClient); err != nil {
+				return evicted, err
+			}
+			excess -= candidate.StoredBytes
+			evicted++
+		}
+	}
+
+	return evicted, nil
+}
+
+func evictBody(ctx context.Context, emailID int64, dbClient *db.Client) error {
+	tx, err := dbClient.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("[SYNC::evictBody] failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	queries := dbClient.WithTx(tx)
+	if err = queries.EvictBody(ctx, emailID); err != nil {
+		return fmt.Errorf("[SYNC::evictBody] failed to evict body: %w", err)
+	}
+	if err = queries.EvictAttachments(ctx, emailID); err != nil {
+		return fmt.Errorf("[SYNC::evictBody] failed to evict attachments: %w", err)
+	}
+
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("[SYNC::evictBody] failed to commit: %w", err)
+	}
+	return nil
+}
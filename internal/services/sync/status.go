@@ -0,0 +1,41 @@
+package sync
+
+import (
+	"maps"
+	"time"
+)
+
+type Status struct {
+	IsRunning bool
+	// Connected is false while the server can't be reached
+	Connected bool
+	// ActiveFolder is the folder being synced right now, empty between syncs
+	ActiveFolder string
+	// Fetched of Total messages of the current step of the active folder
+	Fetched int
+	Total   int
+	// LastSync is the last time any folder finished, FolderLastSync has it per folder name
+	LastSync       time.Time
+	FolderLastSync map[string]time.Time
+	LastError      string
+	LastErrorAt    time.Time
+}
+
+// StatusChanged is published on the event bus whenever the status of a syncer changed
+type StatusChanged struct {
+	AccountID int64
+	Status    Status
+}
+
+type statusKey int64
+
+// CoalesceKey keeps only the latest status per account in a subscribers queue
+func (e StatusChanged) CoalesceKey() any {
+	return statusKey(e.AccountID)
+}
+
+// copy so the reader never shares the folder map with the syncer
+func (s Status) copy() Status {
+	s.FolderLastSync = maps.Clone(s.FolderLastSync)
+	return s
+}
@@ -2,8 +2,11 @@ package sync
 
 import (
 	"context"
+	"database/sql"
+	"errors"
 	"github.com/rexxDigital/clmail/internal/db"
 	"github.com/rexxDigital/clmail/internal/imap"
+	"github.com/rexxDigital/clmail/internal/services/events"
 	"log"
 	"sync"
 	"time"
@@ -13,44 +16,84 @@ type Syncer interface {
 	Start()
 	Close()
 	InitSync()
+	// SyncNow queues the folder in front of everything else, an empty folder syncs the whole account
+	SyncNow(folder string)
+	// SetFocus tells the scheduler which folder is on screen, it goes first whenever it's due
+	SetFocus(folder string)
+	// FocusThread fetches the missing bodies of the open thread before any others
+	FocusThread(threadID int64)
+	// FetchSource downloads the raw message, or only its header, from the server right away
+	FetchSource(emailID int64, headerOnly bool) ([]byte, error)
+	// SetPushed tells the scheduler whether the folder gets push updates, those aren't polled
+	SetPushed(folder string, active bool)
 	GetStatus() Status
 }
 
-type Status struct {
-	IsRunning    bool
-	LastSync     time.Time
-	ActiveFolder string
-	//TODO: Add visuals to what folder is being synced in tui
-}
+// how often the scheduler looks for folders that are due, the interval itself is per account
+const scheduleTick = 30 * time.Second
 
 type syncer struct {
-	account   db.Account
-	password  string
-	dbClient  *db.Client
-	syncQueue chan string
-	isRunning bool
+	account  db.Account
+	password string
+	dbClient *db.Client
+	queue    *folderQueue
+	bodies   *imap.BodyFetcher
+
+	statusMutex sync.Mutex
+	status      Status
+
+	focusMutex sync.Mutex
+	focus      string
+
+	pushMutex sync.Mutex
+	pushed    map[string]bool
 
 	ctx    context.Context
 	cancel context.CancelFunc
 	wg     sync.WaitGroup
 }
 
+// NewSyncService creates the syncer of the account, every status change is published as StatusChanged
 func NewSyncService(account db.Account, password string, dbClient *db.Client) Syncer {
 	return &syncer{
-		account:   account,
-		password:  password,
-		dbClient:  dbClient,
-		syncQueue: make(chan string, 10),
+		account:  account,
+		password: password,
+		dbClient: dbClient,
+		queue:    newFolderQueue(),
+		bodies:   imap.NewBodyFetcher(account, password, dbClient),
+		pushed:   make(map[string]bool),
+		status:   Status{Connected: true, FolderLastSync: make(map[string]time.Time)},
 	}
 }
 
 func (s *syncer) Start() {
 	s.ctx, s.cancel = context.WithCancel(context.Background())
-	s.isRunning = true
 
-	s.wg.Add(2)
+	folders, err := s.dbClient.ListFolders(context.Background(), s.account.ID)
+	if err != nil {
+		log.Printf("Failed to get folders: %v", err)
+	}
+
+	s.updateStatus(func(status *Status) {
+		status.IsRunning = true
+		for _, folder := range folders {
+			if folder.LastSyncedAt.Valid {
+				status.FolderLastSync[folder.Name] = folder.LastSyncedAt.Time
+				if folder.LastSyncedAt.Time.After(status.LastSync) {
+					status.LastSync = folder.LastSyncedAt.Time
+				}
+			}
+		}
+	})
+
+	s.wg.Add(4)
 	go s.syncerWorker()
 	go s.syncerScheduler()
+	go s.retentionLoop()
+	go func() {
+		defer s.wg.Done()
+		s.bodies.Run(s.ctx)
+	}()
 }
 
 func (s *syncer) Close() {
@@ -60,34 +103,112 @@ func (s *syncer) Close() {
 
 	s.wg.Wait()
 
-	s.isRunning = false
+	s.updateStatus(func(status *Status) {
+		status.IsRunning = false
+		status.ActiveFolder = ""
+	})
 }
 
 func (s *syncer) InitSync() {
-	s.queueAllFolders()
+	// push only tells us about changes from now on, whatever came in before needs a sync
+	s.queueDueFolders(false)
+}
+
+func (s *syncer) SyncNow(folder string) {
+	folders, err := s.dbClient.ListFolders(context.Background(), s.account.ID)
+	if err != nil {
+		log.Printf("Failed to get folders: %v", err)
+		return
+	}
+
+	for _, dbFolder := range folders {
+		// local folders have nothing to sync with
+		if dbFolder.Local {
+			continue
+		}
+		if folder == "" {
+			s.queue.push(dbFolder, s.priority(dbFolder.Name))
+		} else if dbFolder.Name == folder {
+			s.queue.push(dbFolder, priorityNow)
+		}
+	}
+}
+
+func (s *syncer) SetFocus(folder string) {
+	s.focusMutex.Lock()
+	s.focus = folder
+	s.focusMutex.Unlock()
+
+	// already queued ones move to the front
+	s.queue.raise(folder, priorityNow)
+}
+
+func (s *syncer) SetPushed(folder string, active bool) {
+	s.pushMutex.Lock()
+	s.pushed[folder] = active
+	s.pushMutex.Unlock()
+
+	if active {
+		// catch up on what arrived while push wasn't there
+		s.SyncNow(folder)
+	}
+}
+
+func (s *syncer) isPushed(folder string) bool {
+	s.pushMutex.Lock()
+	defer s.pushMutex.Unlock()
+	return s.pushed[folder]
+}
+
+func (s *syncer) FocusThread(threadID int64) {
+	s.bodies.FocusThread(threadID)
+}
+
+func (s *syncer) FetchSource(emailID int64, headerOnly bool) ([]byte, error) {
+	return s.bodies.FetchSource(s.ctx, emailID, headerOnly)
 }
 
 func (s *syncer) GetStatus() Status {
-	return Status{}
+	s.statusMutex.Lock()
+	defer s.statusMutex.Unlock()
+	return s.status.copy()
+}
+
+// updateStatus changes the status and publishes the result
+func (s *syncer) updateStatus(update func(status *Status)) {
+	s.statusMutex.Lock()
+	update(&s.status)
+	status := s.status.copy()
+	s.statusMutex.Unlock()
+
+	events.Publish(StatusChanged{AccountID: s.account.ID, Status: status})
 }
 
 func (s *syncer) syncerWorker() {
 	defer s.wg.Done()
 
 	for {
-		select {
-		case <-s.ctx.Done():
+		folder, ok := s.queue.pop()
+		if !ok {
+			select {
+			case <-s.ctx.Done():
+				return
+			case <-s.queue.wake:
+				continue
+			}
+		}
+
+		if s.ctx.Err() != nil {
 			return
-		case folder := <-s.syncQueue:
-			s.syncFolder(folder)
 		}
+		s.syncFolder(folder)
 	}
 }
 
 func (s *syncer) syncerScheduler() {
 	defer s.wg.Done()
 
-	ticker := time.NewTicker(20 * time.Second)
+	ticker := time.NewTicker(scheduleTick)
 	defer ticker.Stop()
 
 	for {
@@ -95,25 +216,50 @@ func (s *syncer) syncerScheduler() {
 		case <-s.ctx.Done():
 			return
 		case <-ticker.C:
-			s.queueAllFolders()
+			s.queueDueFolders(true)
 		}
 	}
 }
 
-// queueAllFolders add all our folder names to the syncQueue channel
-func (s *syncer) queueAllFolders() {
+// queueDueFolders queues every folder that wasn't synced within the refresh interval of the account,
+// folders that get push are skipped when skipPushed is set
+func (s *syncer) queueDueFolders(skipPushed bool) {
 	folders, err := s.dbClient.ListFolders(context.Background(), s.account.ID)
 	if err != nil {
 		log.Printf("Failed to get folders: %v", err)
 		return
 	}
 
+	interval := time.Duration(max(s.account.RefreshIntervalMinutes, 1)) * time.Minute
+
 	for _, folder := range folders {
-		s.syncQueue <- folder.Name
+		if folder.Local {
+			continue
+		}
+		if folder.LastSyncedAt.Valid && time.Since(folder.LastSyncedAt.Time) < interval {
+			continue
+		}
+		if skipPushed && s.isPushed(folder.Name) {
+			continue
+		}
+		s.queue.push(folder, s.priority(folder.Name))
+	}
+}
+
+func (s *syncer) priority(folder string) int {
+	s.focusMutex.Lock()
+	defer s.focusMutex.Unlock()
+
+	if folder == s.focus {
+		return priorityNow
 	}
+	if isSpecialFolder(folder) {
+		return prioritySpecial
+	}
+	return priorityNormal
 }
 
-func (s *syncer) syncFolder(folder string) {
+func (s *syncer) syncFolder(folder db.Folder) {
 	client, err := imap.NewSyncClient(s.account, s.password, s.dbClient)
 	if err != nil {
 		log.Printf("Failed to create imap client: %v", err)
@@ -121,9 +267,46 @@ func (s *syncer) syncFolder(folder string) {
 	}
 	defer client.Close()
 
-	err = client.SyncFolder(folder)
+	s.updateStatus(func(status *Status) {
+		status.ActiveFolder = folder.Name
+		status.Fetched = 0
+		status.Total = 0
+	})
+
+	err = client.SyncFolder(folder.Name, func(done, total int) {
+		s.updateStatus(func(status *Status) {
+			status.Fetched = done
+			status.Total = total
+		})
+	})
 	if err != nil {
 		log.Printf("Failed to sync folder: %v", err)
+		s.updateStatus(func(status *Status) {
+			status.ActiveFolder = ""
+			status.Connected = !errors.Is(err, imap.ErrOffline)
+			status.LastError = err.Error()
+			status.LastErrorAt = time.Now()
+		})
 		return
 	}
+
+	// the headers are in, the bodies follow in the background
+	s.bodies.Wake()
+
+	now := time.Now()
+	err = s.dbClient.UpdateFolderLastSynced(context.Background(), db.UpdateFolderLastSyncedParams{
+		LastSyncedAt: sql.NullTime{Time: now, Valid: true},
+		ID:           folder.ID,
+	})
+	if err != nil {
+		log.Printf("Failed to store last sync of %s: %v", folder.Name, err)
+	}
+
+	s.updateStatus(func(status *Status) {
+		status.ActiveFolder = ""
+		status.Connected = true
+		status.LastError = ""
+		status.LastSync = now
+		status.FolderLastSync[folder.Name] = now
+	})
 }
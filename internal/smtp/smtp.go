@@ -1,20 +1,20 @@
 package smtp
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"github.com/emersion/go-sasl"
 	"github.com/rexxDigital/clmail/internal/db"
-	"github.com/rexxDigital/clmail/internal/imap"
+	"github.com/rexxDigital/clmail/internal/oauth"
 	"github.com/rexxDigital/clmail/types"
 	"net/smtp"
 	"strings"
 	"time"
 )
 
-func SendMail(mail types.Mail, account *db.Account, password string, dbClient *db.Client) error {
-	auth := smtp.PlainAuth("", account.SmtpUsername, password, account.SmtpServer)
-
-	receivers := append(mail.CC, mail.To)
-
+// Message builds the message as it is sent and stored in the sent folder
+func Message(mail types.Mail, account *db.Account) []byte {
 	var message strings.Builder
 
 	// necessary headers :)
@@ -53,28 +53,61 @@ func SendMail(mail types.Mail, account *db.Account, password string, dbClient *d
 	message.WriteString("\r\n")
 	message.WriteString(mail.Body)
 
-	err := smtp.SendMail(
-		fmt.Sprintf("%s:%d", account.SmtpServer, account.SmtpPort),
-		auth,
-		account.Email,
-		receivers,
-		[]byte(message.String()))
+	return []byte(message.String())
+}
 
+// Recipients are the addresses the message goes to
+func Recipients(mail types.Mail) []string {
+	return append(mail.CC, mail.To)
+}
+
+// Send hands the message to the smtp server of the account, storing it in the sent folder is up
+// to the caller
+func Send(account *db.Account, password string, recipients []string, message []byte) error {
+	auth, err := newAuth(account, password)
 	if err != nil {
 		return err
 	}
 
-	syncClient, err := imap.NewSyncClient(*account, password, dbClient)
-	if err != nil {
-		return err
+	return smtp.SendMail(
+		fmt.Sprintf("%s:%d", account.SmtpServer, account.SmtpPort),
+		auth,
+		account.Email,
+		recipients,
+		message)
+}
+
+func newAuth(account *db.Account, password string) (smtp.Auth, error) {
+	if !oauth.IsOAuth(account.SmtpAuthMethod) {
+		return smtp.PlainAuth("", account.SmtpUsername, password, account.SmtpServer), nil
 	}
 
-	err = syncClient.SaveSent(message.String(), mail.Date)
+	token, err := oauth.AccessToken(context.Background(), *account)
 	if err != nil {
-		return err
+		return nil, fmt.Errorf("[SMTP::newAuth] failed to get access token: %w", err)
 	}
 
-	syncClient.Close()
+	return &saslAuth{
+		client: oauth.NewSASLClient(account.SmtpAuthMethod, account.SmtpUsername, token, account.SmtpServer, int(account.SmtpPort)),
+	}, nil
+}
 
-	return nil
+// saslAuth lets net/smtp use a go-sasl client
+type saslAuth struct {
+	client sasl.Client
+}
+
+func (a *saslAuth) Start(server *smtp.ServerInfo) (string, []byte, error) {
+	// same rule as smtp.PlainAuth, never send a token over an unencrypted connection
+	if !server.TLS && server.Name != "localhost" && server.Name != "127.0.0.1" {
+		return "", nil, errors.New("unencrypted connection")
+	}
+	return a.client.Start()
+}
+
+func (a *saslAuth) Next(fromServer []byte, more bool) ([]byte, error) {
+	if !more {
+		return nil, nil
+	}
+	return a.client.Next(fromServer)
 }
@@ -0,0 +1,117 @@
+package tui
+
+import (
+	"fmt"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/rexxDigital/clmail/internal/db"
+	"strings"
+)
+
+// accountSelectedMsg is sent when an entry in the account switcher is picked
+type accountSelectedMsg struct {
+	account *db.Account
+	unified bool
+}
+
+// AccountSwitcher is the small popup listing the unified inbox and every account
+type AccountSwitcher struct {
+	accounts []db.Account
+	selected int // 0 is the unified inbox when there is more than one account
+}
+
+func NewAccountSwitcher(accounts []db.Account, current *db.Account) *AccountSwitcher {
+	switcher := &AccountSwitcher{accounts: accounts}
+
+	if current != nil {
+		for i, account := range accounts {
+			if account.ID == current.ID {
+				switcher.selected = i + switcher.offset()
+			}
+		}
+	}
+
+	return switcher
+}
+
+// offset is the number of entries above the first account
+func (m *AccountSwitcher) offset() int {
+	if len(m.accounts) > 1 {
+		return 1
+	}
+	return 0
+}
+
+func (m *AccountSwitcher) Init() tea.Cmd {
+	return nil
+}
+
+func (m *AccountSwitcher) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	keyMsg, ok := msg.(tea.KeyMsg)
+	if !ok {
+		return m, nil
+	}
+
+	switch keyMsg.String() {
+	case "j", "down":
+		if m.selected < len(m.accounts)+m.offset()-1 {
+			m.selected++
+		}
+	case "k", "up":
+		if m.selected > 0 {
+			m.selected--
+		}
+	case "esc", "a":
+		return m, func() tea.Msg {
+			return accountSelectedMsg{}
+		}
+	case "enter":
+		if m.selected < m.offset() {
+			return m, func() tea.Msg {
+				return accountSelectedMsg{unified: true}
+			}
+		}
+
+		account := m.accounts[m.selected-m.offset()]
+		return m, func() tea.Msg {
+			return accountSelectedMsg{account: &account}
+		}
+	}
+
+	return m, nil
+}
+
+func (m *AccountSwitcher) View() string {
+	var content strings.Builder
+
+	content.WriteString(lipgloss.NewStyle().Bold(true).Render("Switch account") + "\n\n")
+
+	var names []string
+	if m.offset() > 0 {
+		names = append(names, "Unified Inbox")
+	}
+	for _, account := range m.accounts {
+		names = append(names, fmt.Sprintf("%s <%s>", account.Name, account.Email))
+	}
+
+	for i, name := range names {
+		if i == m.selected {
+			content.WriteString(focusedStyle.Bold(true).Render("> "+name) + "\n")
+		} else {
+			content.WriteString("  " + name + "\n")
+		}
+	}
+
+	content.WriteString("\n" + blurredStyle.Render("enter: select • esc: close"))
+
+	return lipgloss.NewStyle().
+		Border(lipgloss.RoundedBorder()).
+		BorderForeground(highlightColor).
+		Padding(1, 2).
+		Render(content.String())
+}
+
+// accountBadge is the short account tag shown in front of threads in the unified inbox
+func accountBadge(account db.Account) string {
+	return lipgloss.NewStyle().Foreground(specialColor).Render("[" + truncateString(account.Name, 12) + "]")
+}
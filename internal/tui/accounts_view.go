@@ -0,0 +1,756 @@
+package tui
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/charmbracelet/bubbles/textinput"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/rexxDigital/clmail/internal/accounts"
+	"github.com/rexxDigital/clmail/internal/db"
+	"github.com/rexxDigital/clmail/internal/imap"
+	"github.com/rexxDigital/clmail/internal/oauth"
+	"github.com/rexxDigital/clmail/internal/secrets"
+	"github.com/rexxDigital/clmail/internal/services/email"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	accountsModeList = iota
+	accountsModeEdit
+	accountsModePassword
+	accountsModeDelete
+	accountsModeUnlock
+)
+
+// edit form field indexes, in the order they are shown
+const (
+	fieldName = iota
+	fieldDisplayName
+	fieldEmail
+	fieldImapServer
+	fieldImapPort
+	fieldImapUsername
+	fieldImapAuthMethod
+	fieldImapSecurity
+	fieldImapCaFile
+	fieldImapFingerprint
+	fieldSmtpServer
+	fieldSmtpPort
+	fieldSmtpUsername
+	fieldSmtpUseTls
+	fieldSmtpAuthMethod
+	fieldSecretStore
+	fieldPasswordCommand
+	fieldRefreshInterval
+	fieldBodySizeLimit
+	fieldBodyRetentionDays
+	fieldBodyRetentionMb
+	fieldPushFolders
+	fieldSignature
+	fieldIsDefault
+	fieldCount
+)
+
+type accountField struct {
+	label  string
+	input  textinput.Model
+	toggle bool // booleans are flipped with space instead of typed
+	value  bool
+}
+
+type accountsLoadedMsg struct {
+	accounts []db.Account
+	err      error
+}
+
+type accountSavedMsg struct {
+	err   error
+	retry tea.Cmd
+}
+
+type accountDeletedMsg struct {
+	err error
+}
+
+type passwordUpdatedMsg struct {
+	err   error
+	retry tea.Cmd
+}
+
+// AccountsView lists the configured accounts and lets you edit, re-authenticate or delete them
+type AccountsView struct {
+	width        int
+	height       int
+	dbClient     *db.Client
+	emailService services.EmailService
+
+	accounts []db.Account
+	selected int
+	mode     int
+
+	fields        []accountField
+	focusIndex    int
+	passwordInput textinput.Model
+
+	// when the secrets file is locked we ask for the passphrase and then retry what failed
+	passphraseInput textinput.Model
+	unlockRetry     tea.Cmd
+	unlockReturn    int
+
+	working  bool
+	errorMsg string
+	infoMsg  string
+}
+
+func NewAccountsView(width, height int, dbClient *db.Client, emailService services.EmailService) *AccountsView {
+	passwordInput := textinput.New()
+	passwordInput.Placeholder = "New password"
+	passwordInput.EchoMode = textinput.EchoPassword
+	passwordInput.Width = 30
+
+	passphraseInput := textinput.New()
+	passphraseInput.Placeholder = "Master passphrase"
+	passphraseInput.EchoMode = textinput.EchoPassword
+	passphraseInput.Width = 30
+
+	return &AccountsView{
+		width:           width,
+		height:          height,
+		dbClient:        dbClient,
+		emailService:    emailService,
+		passwordInput:   passwordInput,
+		passphraseInput: passphraseInput,
+	}
+}
+
+func (m *AccountsView) Init() tea.Cmd {
+	return m.loadAccounts
+}
+
+func (m *AccountsView) loadAccounts() tea.Msg {
+	accounts, err := m.dbClient.ListAccounts(context.Background())
+	return accountsLoadedMsg{accounts: accounts, err: err}
+}
+
+func (m *AccountsView) Update(message tea.Msg) (tea.Model, tea.Cmd) {
+	switch msg := message.(type) {
+	case tea.WindowSizeMsg:
+		m.width = msg.Width
+		m.height = msg.Height
+		return m, nil
+	case accountsLoadedMsg:
+		if msg.err != nil {
+			m.errorMsg = fmt.Sprintf("Failed to load accounts: %v", msg.err)
+			return m, nil
+		}
+		m.accounts = msg.accounts
+		m.selected = max(0, min(m.selected, len(m.accounts)-1))
+		return m, nil
+	case accountSavedMsg:
+		m.working = false
+		if errors.Is(msg.err, secrets.ErrLocked) {
+			return m, m.askPassphrase(msg.retry)
+		}
+		if msg.err != nil {
+			m.errorMsg = msg.err.Error()
+			return m, nil
+		}
+		m.mode = accountsModeList
+		m.infoMsg = "Account saved"
+		return m, m.loadAccounts
+	case passwordUpdatedMsg:
+		m.working = false
+		m.infoMsg = ""
+		if errors.Is(msg.err, secrets.ErrLocked) {
+			return m, m.askPassphrase(msg.retry)
+		}
+		if msg.err != nil {
+			m.errorMsg = msg.err.Error()
+			return m, nil
+		}
+		m.mode = accountsModeList
+		m.passwordInput.Reset()
+		m.infoMsg = "Credentials updated"
+		return m, nil
+	case accountDeletedMsg:
+		m.working = false
+		m.mode = accountsModeList
+		if msg.err != nil {
+			m.errorMsg = msg.err.Error()
+			return m, nil
+		}
+		m.infoMsg = "Account deleted"
+		return m, m.loadAccounts
+	case tea.KeyMsg:
+		if m.working {
+			return m, nil
+		}
+
+		switch m.mode {
+		case accountsModeList:
+			return m.updateList(msg)
+		case accountsModeEdit:
+			return m.updateEdit(msg)
+		case accountsModePassword:
+			return m.updatePassword(msg)
+		case accountsModeDelete:
+			return m.updateDelete(msg)
+		case accountsModeUnlock:
+			return m.updateUnlock(msg)
+		}
+	}
+
+	return m, nil
+}
+
+func (m *AccountsView) updateList(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	m.errorMsg = ""
+	m.infoMsg = ""
+
+	switch msg.String() {
+	case "ctrl+c":
+		return m, tea.Quit
+	case "q", "esc":
+		return m, func() tea.Msg {
+			if len(m.accounts) == 0 {
+				return SwitchViewMsg{ViewName: "setup"}
+			}
+			return SwitchViewMsg{ViewName: "home"}
+		}
+	case "up", "k":
+		if m.selected > 0 {
+			m.selected--
+		}
+	case "down", "j":
+		if m.selected < len(m.accounts)-1 {
+			m.selected++
+		}
+	case "n":
+		return m, func() tea.Msg {
+			return SwitchViewMsg{ViewName: "setup"}
+		}
+	case "enter", "e":
+		if account := m.selectedAccount(); account != nil {
+			pushFolders, err := accounts.PushFolders(account.ID, m.dbClient)
+			if err != nil {
+				m.errorMsg = err.Error()
+				return m, nil
+			}
+			m.fields = newAccountFields(*account, pushFolders)
+			m.focusIndex = 0
+			m.mode = accountsModeEdit
+			return m, m.focusField()
+		}
+	case "p":
+		if account := m.selectedAccount(); account != nil && oauth.IsOAuth(account.ImapAuthMethod) {
+			// oauth accounts have no password, sign in again instead
+			m.working = true
+			m.infoMsg = "Finish signing in in your browser..."
+			return m, m.reauthorize(*account)
+		}
+		if m.selectedAccount() != nil {
+			m.passwordInput.Reset()
+			m.mode = accountsModePassword
+			return m, m.passwordInput.Focus()
+		}
+	case "d":
+		if m.selectedAccount() != nil {
+			m.mode = accountsModeDelete
+		}
+	}
+
+	return m, nil
+}
+
+func (m *AccountsView) updateEdit(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	switch msg.String() {
+	case "ctrl+c":
+		return m, tea.Quit
+	case "esc":
+		m.errorMsg = ""
+		m.mode = accountsModeList
+		return m, nil
+	case "tab", "down", "enter":
+		m.focusIndex = (m.focusIndex + 1) % len(m.fields)
+		return m, m.focusField()
+	case "shift+tab", "up":
+		m.focusIndex = (m.focusIndex - 1 + len(m.fields)) % len(m.fields)
+		return m, m.focusField()
+	case "ctrl+s":
+		params, err := m.formData()
+		if err != nil {
+			m.errorMsg = err.Error()
+			return m, nil
+		}
+		m.errorMsg = ""
+		m.working = true
+		return m, m.saveAccount(params, m.pushFolders())
+	case " ":
+		if field := &m.fields[m.focusIndex]; field.toggle {
+			field.value = !field.value
+			return m, nil
+		}
+	}
+
+	var cmd tea.Cmd
+	if !m.fields[m.focusIndex].toggle {
+		m.fields[m.focusIndex].input, cmd = m.fields[m.focusIndex].input.Update(msg)
+	}
+	return m, cmd
+}
+
+func (m *AccountsView) updatePassword(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	switch msg.String() {
+	case "ctrl+c":
+		return m, tea.Quit
+	case "esc":
+		m.errorMsg = ""
+		m.mode = accountsModeList
+		return m, nil
+	case "enter":
+		if m.passwordInput.Value() == "" {
+			m.errorMsg = "Password can't be empty"
+			return m, nil
+		}
+		m.errorMsg = ""
+		m.working = true
+		return m, m.updateAccountPassword(*m.selectedAccount(), m.passwordInput.Value())
+	}
+
+	var cmd tea.Cmd
+	m.passwordInput, cmd = m.passwordInput.Update(msg)
+	return m, cmd
+}
+
+func (m *AccountsView) updateDelete(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	switch msg.String() {
+	case "y":
+		m.working = true
+		return m, m.deleteAccount(*m.selectedAccount())
+	default:
+		m.mode = accountsModeList
+	}
+
+	return m, nil
+}
+
+// askPassphrase switches to the passphrase prompt, retry runs again once the file is unlocked
+func (m *AccountsView) askPassphrase(retry tea.Cmd) tea.Cmd {
+	m.errorMsg = ""
+	m.unlockRetry = retry
+	m.unlockReturn = m.mode
+	m.mode = accountsModeUnlock
+	m.passphraseInput.Reset()
+	return m.passphraseInput.Focus()
+}
+
+func (m *AccountsView) updateUnlock(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	switch msg.String() {
+	case "ctrl+c":
+		return m, tea.Quit
+	case "esc":
+		m.errorMsg = ""
+		m.mode = m.unlockReturn
+		return m, nil
+	case "enter":
+		if err := secrets.UnlockFile(m.passphraseInput.Value()); err != nil {
+			m.errorMsg = err.Error()
+			return m, nil
+		}
+		m.errorMsg = ""
+		m.mode = m.unlockReturn
+		m.working = true
+		return m, m.unlockRetry
+	}
+
+	var cmd tea.Cmd
+	m.passphraseInput, cmd = m.passphraseInput.Update(msg)
+	return m, cmd
+}
+
+func (m *AccountsView) selectedAccount() *db.Account {
+	if m.selected < 0 || m.selected >= len(m.accounts) {
+		return nil
+	}
+	return &m.accounts[m.selected]
+}
+
+func (m *AccountsView) focusField() tea.Cmd {
+	var cmd tea.Cmd
+	for i := range m.fields {
+		if i == m.focusIndex && !m.fields[i].toggle {
+			cmd = m.fields[i].input.Focus()
+			m.fields[i].input.PromptStyle = focusedStyle
+			m.fields[i].input.TextStyle = focusedStyle
+			continue
+		}
+		m.fields[i].input.Blur()
+		m.fields[i].input.PromptStyle = noStyle
+		m.fields[i].input.TextStyle = noStyle
+	}
+	return cmd
+}
+
+func newAccountFields(account db.Account, pushFolders []string) []accountField {
+	fields := make([]accountField, fieldCount)
+
+	text := func(index int, label, value string) {
+		input := textinput.New()
+		input.Prompt = ""
+		input.Width = 40
+		input.SetValue(value)
+		fields[index] = accountField{label: label, input: input}
+	}
+	toggle := func(index int, label string, value bool) {
+		fields[index] = accountField{label: label, input: textinput.New(), toggle: true, value: value}
+	}
+
+	text(fieldName, "Name", account.Name)
+	text(fieldDisplayName, "Display name", account.DisplayName)
+	text(fieldEmail, "Email", account.Email)
+	text(fieldImapServer, "IMAP server", account.ImapServer)
+	text(fieldImapPort, "IMAP port", strconv.FormatInt(account.ImapPort, 10))
+	text(fieldImapUsername, "IMAP username", account.ImapUsername)
+	text(fieldImapAuthMethod, "IMAP auth method", account.ImapAuthMethod)
+	text(fieldImapSecurity, "IMAP security", account.ImapSecurity)
+	text(fieldImapCaFile, "IMAP CA file", account.ImapCaFile.String)
+	text(fieldImapFingerprint, "IMAP cert SHA256", account.ImapTlsFingerprint.String)
+	text(fieldSmtpServer, "SMTP server", account.SmtpServer)
+	text(fieldSmtpPort, "SMTP port", strconv.FormatInt(account.SmtpPort, 10))
+	text(fieldSmtpUsername, "SMTP username", account.SmtpUsername)
+	toggle(fieldSmtpUseTls, "SMTP use TLS", account.SmtpUseTls)
+	text(fieldSmtpAuthMethod, "SMTP auth method", account.SmtpAuthMethod)
+	text(fieldSecretStore, "Password storage", account.SecretStore)
+	text(fieldPasswordCommand, "Password command", account.PasswordCommand.String)
+	text(fieldRefreshInterval, "Refresh (minutes)", strconv.FormatInt(account.RefreshIntervalMinutes, 10))
+	text(fieldBodySizeLimit, "Body size limit (KB)", strconv.FormatInt(account.BodySizeLimitKb, 10))
+	text(fieldBodyRetentionDays, "Keep bodies (days)", strconv.FormatInt(account.BodyRetentionDays, 10))
+	text(fieldBodyRetentionMb, "Keep bodies (MB)", strconv.FormatInt(account.BodyRetentionMb, 10))
+	text(fieldPushFolders, "Push folders", strings.Join(pushFolders, ", "))
+	text(fieldSignature, "Signature", account.Signature.String)
+	toggle(fieldIsDefault, "Default account", account.IsDefault)
+
+	return fields
+}
+
+// formData validates the edit form and turns it into the update params
+func (m *AccountsView) formData() (db.UpdateAccountParams, error) {
+	value := func(index int) string {
+		return strings.TrimSpace(m.fields[index].input.Value())
+	}
+
+	for _, index := range []int{fieldName, fieldEmail, fieldImapServer, fieldImapUsername, fieldSmtpServer, fieldSmtpUsername} {
+		if value(index) == "" {
+			return db.UpdateAccountParams{}, fmt.Errorf("%s can't be empty", m.fields[index].label)
+		}
+	}
+
+	number := func(index int) (int64, error) {
+		n, err := strconv.ParseInt(value(index), 10, 64)
+		if err != nil || n <= 0 {
+			return 0, fmt.Errorf("%s must be a positive number", m.fields[index].label)
+		}
+		return n, nil
+	}
+
+	imapPort, err := number(fieldImapPort)
+	if err != nil {
+		return db.UpdateAccountParams{}, err
+	}
+	smtpPort, err := number(fieldSmtpPort)
+	if err != nil {
+		return db.UpdateAccountParams{}, err
+	}
+	refresh, err := number(fieldRefreshInterval)
+	if err != nil {
+		return db.UpdateAccountParams{}, err
+	}
+	// 0 turns the limit off
+	limit := func(index int) (int64, error) {
+		n, err := strconv.ParseInt(value(index), 10, 64)
+		if err != nil || n < 0 {
+			return 0, fmt.Errorf("%s must be 0 or a positive number", m.fields[index].label)
+		}
+		return n, nil
+	}
+
+	bodySizeLimit, err := limit(fieldBodySizeLimit)
+	if err != nil {
+		return db.UpdateAccountParams{}, err
+	}
+	retentionDays, err := limit(fieldBodyRetentionDays)
+	if err != nil {
+		return db.UpdateAccountParams{}, err
+	}
+	retentionMb, err := limit(fieldBodyRetentionMb)
+	if err != nil {
+		return db.UpdateAccountParams{}, err
+	}
+
+	displayName := value(fieldDisplayName)
+	if displayName == "" {
+		displayName = value(fieldName)
+	}
+
+	authMethod := func(index int) string {
+		if value(index) == "" {
+			return "plain"
+		}
+		return value(index)
+	}
+
+	optional := func(index int) sql.NullString {
+		return sql.NullString{String: value(index), Valid: value(index) != ""}
+	}
+
+	security := strings.ToLower(value(fieldImapSecurity))
+	if security == "" {
+		security = imap.SecurityTLS
+	}
+
+	if err := imap.ValidateSecurity(db.Account{
+		ImapServer:         value(fieldImapServer),
+		ImapSecurity:       security,
+		ImapTlsFingerprint: optional(fieldImapFingerprint),
+	}); err != nil {
+		return db.UpdateAccountParams{}, err
+	}
+
+	secretStore := strings.ToLower(value(fieldSecretStore))
+	if secretStore == "" {
+		secretStore = secrets.BackendKeyring
+	}
+
+	if _, err := secrets.For(secretStore, value(fieldPasswordCommand)); err != nil {
+		return db.UpdateAccountParams{}, err
+	}
+
+	return db.UpdateAccountParams{
+		ID:           m.selectedAccount().ID,
+		Name:         value(fieldName),
+		DisplayName:  displayName,
+		Email:        value(fieldEmail),
+		ImapServer:   value(fieldImapServer),
+		ImapPort:     imapPort,
+		ImapUsername: value(fieldImapUsername),
+		// kept in step with the security mode for anything still reading it
+		ImapUseSsl:             security == imap.SecurityTLS,
+		ImapAuthMethod:         authMethod(fieldImapAuthMethod),
+		ImapSecurity:           security,
+		ImapCaFile:             optional(fieldImapCaFile),
+		ImapTlsFingerprint:     optional(fieldImapFingerprint),
+		SmtpServer:             value(fieldSmtpServer),
+		SmtpPort:               smtpPort,
+		SmtpUsername:           value(fieldSmtpUsername),
+		SmtpUseTls:             m.fields[fieldSmtpUseTls].value,
+		SmtpAuthMethod:         authMethod(fieldSmtpAuthMethod),
+		SecretStore:            secretStore,
+		PasswordCommand:        optional(fieldPasswordCommand),
+		RefreshIntervalMinutes: refresh,
+		BodySizeLimitKb:        bodySizeLimit,
+		BodyRetentionDays:      retentionDays,
+		BodyRetentionMb:        retentionMb,
+		Signature:              optional(fieldSignature),
+		IsDefault:              m.fields[fieldIsDefault].value,
+	}, nil
+}
+
+// pushFolders splits the comma separated push folders of the form
+func (m *AccountsView) pushFolders() []string {
+	var folders []string
+	for _, folder := range strings.Split(m.fields[fieldPushFolders].input.Value(), ",") {
+		if folder = strings.TrimSpace(folder); folder != "" {
+			folders = append(folders, folder)
+		}
+	}
+	return folders
+}
+
+func (m *AccountsView) saveAccount(params db.UpdateAccountParams, pushFolders []string) tea.Cmd {
+	return func() tea.Msg {
+		account, err := accounts.UpdateAccount(params, m.dbClient)
+		if err != nil {
+			return accountSavedMsg{err: err, retry: m.saveAccount(params, pushFolders)}
+		}
+
+		if err = accounts.SetPushFolders(account.ID, pushFolders, m.dbClient); err != nil {
+			return accountSavedMsg{err: fmt.Errorf("saved, but failed to set push folders: %w", err)}
+		}
+
+		// restart the clients so the new settings are used
+		if err = m.emailService.ReloadAccount(account); err != nil {
+			return accountSavedMsg{err: fmt.Errorf("saved, but failed to reconnect: %w", err)}
+		}
+
+		return accountSavedMsg{}
+	}
+}
+
+func (m *AccountsView) updateAccountPassword(account db.Account, password string) tea.Cmd {
+	return func() tea.Msg {
+		if err := accounts.UpdatePassword(account, password, m.dbClient); err != nil {
+			return passwordUpdatedMsg{err: err, retry: m.updateAccountPassword(account, password)}
+		}
+
+		if err := m.emailService.ReloadAccount(account); err != nil {
+			return passwordUpdatedMsg{err: fmt.Errorf("password updated, but failed to reconnect: %w", err)}
+		}
+
+		return passwordUpdatedMsg{}
+	}
+}
+
+func (m *AccountsView) reauthorize(account db.Account) tea.Cmd {
+	return func() tea.Msg {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+		defer cancel()
+
+		if err := accounts.ReauthorizeOAuth(ctx, account, m.dbClient); err != nil {
+			return passwordUpdatedMsg{err: err}
+		}
+
+		if err := m.emailService.ReloadAccount(account); err != nil {
+			return passwordUpdatedMsg{err: fmt.Errorf("signed in, but failed to reconnect: %w", err)}
+		}
+
+		return passwordUpdatedMsg{}
+	}
+}
+
+func (m *AccountsView) deleteAccount(account db.Account) tea.Cmd {
+	return func() tea.Msg {
+		// stop syncing before the rows disappear underneath the clients
+		m.emailService.RemoveAccount(account.ID)
+
+		return accountDeletedMsg{err: accounts.DeleteAccount(account, m.dbClient)}
+	}
+}
+
+func (m *AccountsView) View() string {
+	titleStyle := lipgloss.NewStyle().Bold(true).Foreground(highlightColor).MarginBottom(1)
+	footerStyle := lipgloss.NewStyle().Foreground(subtleColor).Faint(true)
+
+	var content string
+	var help string
+
+	switch m.mode {
+	case accountsModeEdit:
+		content = m.renderEdit()
+		help = "tab/↑/↓: move • space: toggle • ctrl+s: save • esc: cancel"
+	case accountsModePassword:
+		content = fmt.Sprintf("New password for %s\n\n%s", m.selectedAccount().Email, m.passwordInput.View())
+		help = "enter: test login and save • esc: cancel"
+	case accountsModeUnlock:
+		content = fmt.Sprintf("The secrets file is locked, enter the master passphrase\n\n%s", m.passphraseInput.View())
+		help = "enter: unlock • esc: cancel"
+	default:
+		content = m.renderList()
+		help = "j/k: navigate • enter/e: edit • p: password/sign in • d: delete • n: new account • esc: back"
+	}
+
+	if m.mode == accountsModeDelete {
+		account := m.selectedAccount()
+		content += "\n\n" + errorStyle.Render(fmt.Sprintf("Delete %s and all of its mail? (y/n)", account.Email))
+	}
+
+	if m.working {
+		content += "\n\n" + blurredStyle.Render("Working...")
+	}
+	if m.errorMsg != "" {
+		content += "\n\n" + errorStyle.Render(m.errorMsg)
+	}
+	if m.infoMsg != "" {
+		content += "\n\n" + focusedStyle.Render(m.infoMsg)
+	}
+
+	return lipgloss.NewStyle().Padding(1, 2).Width(m.width).Height(m.height).Render(
+		lipgloss.JoinVertical(
+			lipgloss.Left,
+			titleStyle.Render("Accounts"),
+			content,
+			"",
+			footerStyle.Render(help),
+		),
+	)
+}
+
+func (m *AccountsView) renderList() string {
+	if len(m.accounts) == 0 {
+		return "No accounts configured, press n to add one."
+	}
+
+	var list strings.Builder
+	for i, account := range m.accounts {
+		name := fmt.Sprintf("%s <%s>", account.Name, account.Email)
+		if account.IsDefault {
+			name += " (default)"
+		}
+
+		line := fmt.Sprintf("%-50s %s", name, m.syncStatus(account))
+		if i == m.selected {
+			list.WriteString(focusedStyle.Bold(true).Render("> "+line) + "\n")
+		} else {
+			list.WriteString("  " + line + "\n")
+		}
+	}
+
+	return list.String()
+}
+
+func (m *AccountsView) syncStatus(account db.Account) string {
+	client, ok := m.emailService.GetClient(account.ID)
+	if !ok || client.SyncClient == nil {
+		return blurredStyle.Render("○ not connected")
+	}
+
+	status := client.SyncClient.GetStatus()
+	if !status.Connected {
+		return errorStyle.Render("● offline since " + status.LastErrorAt.Format("2006-01-02 15:04"))
+	}
+
+	text := "● connected"
+	if status.ActiveFolder != "" {
+		text += ", syncing " + status.ActiveFolder
+		if status.Total > 0 {
+			text += fmt.Sprintf(" %d/%d", status.Fetched, status.Total)
+		}
+	}
+	if !status.LastSync.IsZero() {
+		text += ", last sync " + status.LastSync.Format("2006-01-02 15:04")
+	}
+	if status.LastError != "" {
+		text += errorStyle.Render(", last sync failed: " + status.LastError)
+	}
+
+	return lipgloss.NewStyle().Foreground(specialColor).Render(text)
+}
+
+func (m *AccountsView) renderEdit() string {
+	var form strings.Builder
+	for i, field := range m.fields {
+		label := fmt.Sprintf("%-18s", field.label)
+		if i == m.focusIndex {
+			label = focusedStyle.Render(label)
+		}
+
+		value := field.input.View()
+		if field.toggle {
+			value = "[ ]"
+			if field.value {
+				value = "[x]"
+			}
+			if i == m.focusIndex {
+				value = focusedStyle.Render(value)
+			}
+		}
+
+		form.WriteString(label + " " + value + "\n")
+	}
+
+	return form.String()
+}
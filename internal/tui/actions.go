@@ -0,0 +1,26 @@
+package tui
+
+import (
+	"context"
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// actionDoneMsg is the outcome of a change to mail or folders. it only tells whether it made it
+// into the queue, the server gets it whenever it can be reached.
+type actionDoneMsg struct {
+	notice string
+	err    error
+}
+
+func (msg actionDoneMsg) String() string {
+	if msg.err != nil {
+		return "Failed: " + msg.err.Error()
+	}
+	return msg.notice
+}
+
+func runAction(notice string, action func(ctx context.Context) error) tea.Cmd {
+	return func() tea.Msg {
+		return actionDoneMsg{notice: notice, err: action(context.Background())}
+	}
+}
@@ -5,6 +5,7 @@ import (
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/rexxDigital/clmail/internal/db"
 	"github.com/rexxDigital/clmail/internal/services/email"
+	"github.com/rexxDigital/clmail/internal/services/events"
 	"github.com/rexxDigital/clmail/types"
 	"log"
 )
@@ -12,11 +13,15 @@ import (
 type SwitchViewMsg struct {
 	ViewName string
 	Account  *db.Account
+	Folder   *db.Folder
 	Mail     *types.Mail
 }
 
 type accountExists bool
 
+// eventsMsg has everything published on the event bus since the last one, oldest first
+type eventsMsg []any
+
 type BaseModel struct {
 	currentView  tea.Model
 	width        int
@@ -24,6 +29,7 @@ type BaseModel struct {
 	hasAccount   bool
 	dbClient     *db.Client
 	emailService services.EmailService
+	events       *events.Subscription
 }
 
 func NewBaseModel(dbClient *db.Client) *BaseModel {
@@ -33,6 +39,7 @@ func NewBaseModel(dbClient *db.Client) *BaseModel {
 		height:       0,
 		dbClient:     dbClient,
 		emailService: services.NewEmailService(dbClient),
+		events:       events.Subscribe(),
 	}
 }
 
@@ -42,7 +49,17 @@ func (m *BaseModel) HandleWindowSizeMsg(msg tea.WindowSizeMsg) {
 }
 
 func (m *BaseModel) Init() tea.Cmd {
-	return m.checkConfig
+	return tea.Batch(m.checkConfig, m.waitForEvents)
+}
+
+// waitForEvents forwards the next batch of events, it is started again after every message
+func (m *BaseModel) waitForEvents() tea.Msg {
+	queued := m.events.Next()
+	if queued == nil {
+		// closed, nothing to wait for anymore
+		return nil
+	}
+	return eventsMsg(queued)
 }
 
 func (m *BaseModel) Update(message tea.Msg) (tea.Model, tea.Cmd) {
@@ -51,14 +68,30 @@ func (m *BaseModel) Update(message tea.Msg) (tea.Model, tea.Cmd) {
 	switch msg := message.(type) {
 	case tea.WindowSizeMsg:
 		m.HandleWindowSizeMsg(msg)
+	case eventsMsg:
+		_, cmd = m.currentView.Update(msg)
+		return m, tea.Batch(cmd, m.waitForEvents)
 	case SwitchViewMsg:
 		switch msg.ViewName {
 		case "home":
-			m.currentView = NewHomeView(m.width, m.height, m.dbClient)
-			return m, m.currentView.Init()
+			m.currentView = NewHomeView(m.width, m.height, m.dbClient, m.emailService)
+			// picks up accounts added in the setup view, running ones are left alone
+			return m, tea.Batch(m.currentView.Init(), m.initializeAccounts)
 		case "setup":
 			m.currentView = NewSetupView(m.width, m.height, m.dbClient)
 			return m, m.currentView.Init()
+		case "accounts":
+			m.currentView = NewAccountsView(m.width, m.height, m.dbClient, m.emailService)
+			return m, m.currentView.Init()
+		case "storage":
+			m.currentView = NewStorageView(m.width, m.height, m.dbClient)
+			return m, m.currentView.Init()
+		case "queue":
+			m.currentView = NewQueueView(m.width, m.height, m.dbClient)
+			return m, m.currentView.Init()
+		case "search":
+			m.currentView = NewSearchView(m.width, m.height, msg.Account, msg.Folder, m.dbClient)
+			return m, m.currentView.Init()
 		case "send":
 			mail := &types.Mail{}
 			if msg.Mail != nil {
@@ -70,7 +103,7 @@ func (m *BaseModel) Update(message tea.Msg) (tea.Model, tea.Cmd) {
 	case accountExists:
 		m.hasAccount = bool(msg)
 		if m.hasAccount {
-			m.currentView = NewHomeView(m.width, m.height, m.dbClient)
+			m.currentView = NewHomeView(m.width, m.height, m.dbClient, m.emailService)
 			return m, m.currentView.Init()
 		} else {
 			m.currentView = NewSetupView(m.width, m.height, m.dbClient)
@@ -91,6 +124,7 @@ func (m *BaseModel) View() string {
 }
 
 func (m *BaseModel) Close() {
+	m.events.Close()
 	if m.emailService != nil {
 		m.emailService.Close()
 	}
@@ -114,3 +148,10 @@ func (m *BaseModel) checkConfig() tea.Msg {
 
 	return accountExists(hasAccount)
 }
+
+func (m *BaseModel) initializeAccounts() tea.Msg {
+	if err := m.emailService.InitializeAllAccounts(); err != nil {
+		log.Printf("Failed to initialize accounts: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,82 @@
+package tui
+
+import (
+	"context"
+	"fmt"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/rexxDigital/clmail/internal/archive"
+	"github.com/rexxDigital/clmail/internal/config"
+	"github.com/rexxDigital/clmail/internal/db"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+type exportedMsg struct {
+	path     string
+	exported int
+	rebuilt  int
+	err      error
+}
+
+func (msg exportedMsg) String() string {
+	if msg.err != nil {
+		return "Export failed: " + msg.err.Error()
+	}
+	notice := fmt.Sprintf("Exported %d messages to %s", msg.exported, msg.path)
+	if msg.rebuilt > 0 {
+		notice += fmt.Sprintf(" (%d rebuilt without their source)", msg.rebuilt)
+	}
+	return notice
+}
+
+// exportMbox writes the emails listed by ids to a new mbox in the exports directory, the tui
+// always exports mbox. `clmail export` has the other formats and scopes.
+func exportMbox(dbClient *db.Client, name string, ids func(ctx context.Context) ([]int64, error)) tea.Cmd {
+	return func() tea.Msg {
+		ctx := context.Background()
+
+		dir, err := exportsDir()
+		if err != nil {
+			return exportedMsg{err: err}
+		}
+
+		emailIDs, err := ids(ctx)
+		if err != nil {
+			return exportedMsg{err: err}
+		}
+
+		fileName := archive.FileName(fmt.Sprintf("%s-%s", name, time.Now().Format("20060102-150405")), archive.FormatMbox)
+		path := filepath.Join(dir, fileName)
+
+		rebuilt, err := archive.Export(ctx, dbClient, emailIDs, archive.FormatMbox, path, nil)
+		return exportedMsg{path: path, exported: len(emailIDs), rebuilt: len(rebuilt), err: err}
+	}
+}
+
+// exportsDir is where the tui writes exports, it is created when missing
+func exportsDir() (string, error) {
+	dataDir, err := config.DataDir()
+	if err != nil {
+		return "", err
+	}
+	dir := filepath.Join(dataDir, "exports")
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return "", err
+	}
+	return dir, nil
+}
+
+// messagesDir is where the tui saves single messages as .eml. they are a copy of what the server
+// and the database have, so they go in the cache, created when missing.
+func messagesDir() (string, error) {
+	cacheDir, err := config.CacheDir()
+	if err != nil {
+		return "", err
+	}
+	dir := filepath.Join(cacheDir, "messages")
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return "", err
+	}
+	return dir, nil
+}
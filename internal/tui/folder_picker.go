@@ -0,0 +1,83 @@
+package tui
+
+import (
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/rexxDigital/clmail/internal/db"
+	"strings"
+)
+
+// popupClosedMsg is sent when a popup on top of the home view is done
+type popupClosedMsg struct{}
+
+func closePopup() tea.Msg {
+	return popupClosedMsg{}
+}
+
+// FolderPicker is the popup that picks the folder mail is moved to
+type FolderPicker struct {
+	title    string
+	folders  []db.Folder
+	selected int
+	pick     func(folder db.Folder) tea.Cmd
+}
+
+func NewFolderPicker(title string, folders []db.Folder, pick func(folder db.Folder) tea.Cmd) *FolderPicker {
+	return &FolderPicker{title: title, folders: sortFolders(folders), pick: pick}
+}
+
+func (m *FolderPicker) Init() tea.Cmd {
+	return nil
+}
+
+func (m *FolderPicker) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	keyMsg, ok := msg.(tea.KeyMsg)
+	if !ok {
+		return m, nil
+	}
+
+	switch keyMsg.String() {
+	case "j", "down":
+		if m.selected < len(m.folders)-1 {
+			m.selected++
+		}
+	case "k", "up":
+		if m.selected > 0 {
+			m.selected--
+		}
+	case "esc":
+		return m, closePopup
+	case "enter":
+		if len(m.folders) == 0 {
+			return m, closePopup
+		}
+		return m, tea.Batch(closePopup, m.pick(m.folders[m.selected]))
+	}
+
+	return m, nil
+}
+
+func (m *FolderPicker) View() string {
+	var content strings.Builder
+
+	content.WriteString(lipgloss.NewStyle().Bold(true).Render(m.title) + "\n\n")
+
+	if len(m.folders) == 0 {
+		content.WriteString("  No other folders\n")
+	}
+	for i, folder := range m.folders {
+		if i == m.selected {
+			content.WriteString(focusedStyle.Bold(true).Render("> "+folder.Name) + "\n")
+		} else {
+			content.WriteString("  " + folder.Name + "\n")
+		}
+	}
+
+	content.WriteString("\n" + blurredStyle.Render("enter: select • esc: close"))
+
+	return lipgloss.NewStyle().
+		Border(lipgloss.RoundedBorder()).
+		BorderForeground(highlightColor).
+		Padding(1, 2).
+		Render(content.String())
+}
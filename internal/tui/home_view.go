@@ -1,16 +1,23 @@
 package tui
 
 import (
+	"cmp"
 	"context"
-	"database/sql/driver"
 	"fmt"
+	"github.com/charmbracelet/bubbles/spinner"
 	"github.com/charmbracelet/bubbles/viewport"
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/lipgloss"
+	"github.com/rexxDigital/clmail/internal/archive"
 	"github.com/rexxDigital/clmail/internal/db"
+	"github.com/rexxDigital/clmail/internal/search"
+	"github.com/rexxDigital/clmail/internal/services/actions"
+	"github.com/rexxDigital/clmail/internal/services/email"
+	"github.com/rexxDigital/clmail/internal/services/events"
+	"github.com/rexxDigital/clmail/internal/services/sync"
 	"github.com/rexxDigital/clmail/types"
+	overlay "github.com/rmhubbert/bubbletea-overlay"
 	"log"
-	"maps"
 	"slices"
 	"strings"
 	"time"
@@ -18,31 +25,77 @@ import (
 
 type HomeView struct {
 	dbClient          *db.Client
+	emailService      services.EmailService
 	accounts          []db.Account
 	currentAccount    *db.Account
 	threads           []db.GetThreadsInFolderRow
+	threadsComplete   bool // every thread of the selected entry is loaded
 	selectedThread    []db.Email
 	selectedFolder    int
 	selectedThreadInt int
 	selectedEmail     int
-	folders           map[int]db.Folder
-	activePanel       int // 0: folders, 1: email list, 2: email content
-	width             int
-	height            int
-	loading           bool
-	threadsViewport   viewport.Model
-	contentViewport   viewport.Model
+	folderEntries     []folderEntry
+	expandedAccount   int64
+	savedSearchUnread map[int64]int64
+	// popup is the account switcher, folder picker or prompt on top of the view
+	popup           tea.Model
+	activePanel     int // 0: folders, 1: email list, 2: email content
+	width           int
+	height          int
+	loading         bool
+	threadsViewport viewport.Model
+	contentViewport viewport.Model
+
+	syncStatus map[int64]sync.Status
+	spinner    spinner.Model
+	spinning   bool
+
+	// notice replaces the key help in the status bar until the next key
+	notice string
+
+	// contentMode is whether the open email shows as the message, its full header or its source
+	contentMode int
+	source      messageSource
+
+	// actions waiting for the server, and the ones that failed or ran into a conflict
+	queuedActions int64
+	stuckActions  int64
 }
 
+const (
+	// threads are loaded a page at a time, the next one once the selection is threadPrefetch
+	// threads away from the end of what is loaded
+	threadPageSize = 50
+	threadPrefetch = 10
+)
+
 const (
 	FolderPanel = iota
 	EmailListPanel
 	ContentPanel
 )
 
-type tickMsg struct{}
+// the folder panel is a flat list of these, grouped per account
+const (
+	entryUnifiedInbox = iota
+	entryAccount
+	entryFolder
+	entrySavedSearch
+)
+
+type folderEntry struct {
+	kind        int
+	account     db.Account
+	folder      db.Folder
+	savedSearch db.SavedSearch
+}
+
+func (e folderEntry) sameAs(other folderEntry) bool {
+	return e.kind == other.kind && e.account.ID == other.account.ID &&
+		e.folder.ID == other.folder.ID && e.savedSearch.ID == other.savedSearch.ID
+}
 
-func NewHomeView(width, height int, dbClient *db.Client) *HomeView {
+func NewHomeView(width, height int, dbClient *db.Client, emailService services.EmailService) *HomeView {
 	homeView := &HomeView{
 		loading:           true,
 		selectedFolder:    0,
@@ -51,16 +104,27 @@ func NewHomeView(width, height int, dbClient *db.Client) *HomeView {
 		width:             width,
 		height:            height,
 		dbClient:          dbClient,
-		folders:           make(map[int]db.Folder),
+		emailService:      emailService,
+		savedSearchUnread: make(map[int64]int64),
+		syncStatus:        make(map[int64]sync.Status),
+		spinner:           spinner.New(spinner.WithSpinner(spinner.MiniDot), spinner.WithStyle(focusedStyle)),
 	}
 
 	homeView.threadsViewport = viewport.New(0, 0)
 	homeView.contentViewport = viewport.New(0, 0)
 
+	// status changes only arrive from now on, start with what the syncers have
+	for id, client := range emailService.GetAllClients() {
+		if client.SyncClient != nil {
+			homeView.syncStatus[id] = client.SyncClient.GetStatus()
+		}
+	}
+
 	// load initial data from db
 	homeView.loadAccounts()
 	homeView.loadFolders()
-	homeView.loadThreads()
+	homeView.selectFirstFolderOf(homeView.currentAccount)
+	homeView.loadQueueCounts()
 
 	return homeView
 
@@ -86,7 +150,7 @@ func (m *HomeView) HandleWindowSizeMsg(msg tea.WindowSizeMsg) {
 }
 
 func (m *HomeView) Init() tea.Cmd {
-	return m.tickDatabase()
+	return m.startSpinner()
 }
 
 func (m *HomeView) Update(message tea.Msg) (tea.Model, tea.Cmd) {
@@ -94,12 +158,47 @@ func (m *HomeView) Update(message tea.Msg) (tea.Model, tea.Cmd) {
 	var cmds []tea.Cmd
 
 	switch msg := message.(type) {
-	case tickMsg:
-		return m, m.tickDatabase()
+	case eventsMsg:
+		m.handleEvents(msg)
+		return m, m.startSpinner()
+	case spinner.TickMsg:
+		if !m.syncing() {
+			m.spinning = false
+			return m, nil
+		}
+		m.spinner, cmd = m.spinner.Update(msg)
+		return m, cmd
 	case tea.WindowSizeMsg:
 		m.HandleWindowSizeMsg(msg)
 		return m, nil
+	case exportedMsg:
+		m.notice = msg.String()
+		return m, nil
+	case actionDoneMsg:
+		m.notice = msg.String()
+		return m, nil
+	case sourceLoadedMsg:
+		m.handleSourceLoaded(msg)
+		return m, nil
+	case popupClosedMsg:
+		m.popup = nil
+		return m, nil
+	case accountSelectedMsg:
+		m.popup = nil
+		if msg.unified {
+			m.selectUnifiedInbox()
+		} else if msg.account != nil {
+			m.SelectAccount(msg.account)
+		}
+		return m, nil
 	case tea.KeyMsg:
+		if m.popup != nil {
+			_, cmd = m.popup.Update(msg)
+			return m, cmd
+		}
+
+		m.notice = ""
+
 		switch msg.String() {
 		case "q", "esc", "ctrl+c":
 			return m, tea.Quit
@@ -112,8 +211,7 @@ func (m *HomeView) Update(message tea.Msg) (tea.Model, tea.Cmd) {
 			switch m.activePanel {
 			case FolderPanel:
 				if m.selectedFolder > 0 {
-					m.selectedFolder--
-					m.SelectFolder(m.selectedFolder)
+					m.SelectFolder(m.selectedFolder - 1)
 					if len(m.threads) > 0 {
 						m.selectedEmail = 0
 						m.loadThreadEmails(m.threads[m.selectedThreadInt].ID)
@@ -143,9 +241,8 @@ func (m *HomeView) Update(message tea.Msg) (tea.Model, tea.Cmd) {
 		case "down", "j":
 			switch m.activePanel {
 			case FolderPanel:
-				if m.selectedFolder < len(m.folders)-1 {
-					m.selectedFolder++
-					m.SelectFolder(m.selectedFolder)
+				if m.selectedFolder < len(m.folderEntries)-1 {
+					m.SelectFolder(m.selectedFolder + 1)
 					if len(m.threads) > 0 {
 						m.selectedEmail = 0
 						m.loadThreadEmails(m.threads[m.selectedThreadInt].ID)
@@ -154,6 +251,7 @@ func (m *HomeView) Update(message tea.Msg) (tea.Model, tea.Cmd) {
 			case EmailListPanel:
 				if m.selectedThreadInt < len(m.threads)-1 {
 					m.selectedThreadInt++
+					m.loadMoreThreads()
 					if len(m.threads) > 0 {
 						m.selectedEmail = 0
 						m.loadThreadEmails(m.threads[m.selectedThreadInt].ID)
@@ -177,8 +275,70 @@ func (m *HomeView) Update(message tea.Msg) (tea.Model, tea.Cmd) {
 				return SwitchViewMsg{ViewName: "send", Account: m.currentAccount, Mail: nil}
 			}
 		case "r":
+			if len(m.selectedThread) == 0 {
+				return m, nil
+			}
+			// reply from the account the mail was delivered to, which matters in the unified inbox
+			account := m.accountByID(m.selectedThread[m.selectedEmail].AccountID)
+			mail := m.GetSelectedMail()
 			return m, func() tea.Msg {
-				return SwitchViewMsg{ViewName: "send", Account: m.currentAccount, Mail: m.GetSelectedMail()}
+				return SwitchViewMsg{ViewName: "send", Account: account, Mail: mail}
+			}
+		case "R":
+			m.syncNow()
+		case "A":
+			return m, func() tea.Msg {
+				return SwitchViewMsg{ViewName: "accounts"}
+			}
+		case "S":
+			return m, func() tea.Msg {
+				return SwitchViewMsg{ViewName: "storage"}
+			}
+		case "E":
+			return m, m.export()
+		case "H":
+			return m, m.toggleSource()
+		case "w":
+			return m, m.saveSource()
+		case "Q":
+			return m, func() tea.Msg {
+				return SwitchViewMsg{ViewName: "queue"}
+			}
+		case "u":
+			return m, m.toggleRead()
+		case "f":
+			return m, m.toggleStarred()
+		case "m":
+			m.moveMail()
+		case "d":
+			if m.activePanel == FolderPanel {
+				m.deleteFolder()
+			} else {
+				return m, m.deleteMail()
+			}
+		case "n":
+			if m.activePanel == FolderPanel {
+				m.newFolder()
+			}
+		case "e":
+			if m.activePanel == FolderPanel {
+				m.renameFolder()
+			}
+		case "a":
+			if len(m.accounts) > 0 {
+				m.popup = NewAccountSwitcher(m.accounts, m.currentAccount)
+			}
+		case "x":
+			if entry := m.selectedEntry(); entry != nil && entry.kind == entrySavedSearch && m.activePanel == FolderPanel {
+				m.deleteSavedSearch(entry.savedSearch.ID)
+			}
+		case "/":
+			entry := m.selectedEntry()
+			return m, func() tea.Msg {
+				if entry == nil || entry.kind != entryFolder {
+					return SwitchViewMsg{ViewName: "search", Account: m.currentAccount}
+				}
+				return SwitchViewMsg{ViewName: "search", Account: m.currentAccount, Folder: &entry.folder}
 			}
 		default:
 			// pass other keys to active viewport for scrolling
@@ -197,6 +357,23 @@ func (m *HomeView) Update(message tea.Msg) (tea.Model, tea.Cmd) {
 }
 
 func (m *HomeView) View() string {
+	if m.popup != nil {
+		return overlay.New(m.popup, homeBackground{m}, overlay.Center, overlay.Center, 0, 0).View()
+	}
+
+	return m.render()
+}
+
+// homeBackground lets the overlay draw the home view behind the popup
+type homeBackground struct {
+	*HomeView
+}
+
+func (b homeBackground) View() string {
+	return b.render()
+}
+
+func (m *HomeView) render() string {
 	// get width for the different parts
 	folderWidth := min(25, m.width/5)
 	emailListWidth := min(60, m.width/3)
@@ -209,12 +386,21 @@ func (m *HomeView) View() string {
 		Bold(true)
 
 	header := ""
-	if m.currentAccount != nil {
+	if entry := m.selectedEntry(); entry != nil && entry.kind == entryUnifiedInbox {
+		header = fmt.Sprintf("📧 CLMAIL - Unified Inbox (%d accounts)", len(m.accounts))
+	} else if m.currentAccount != nil {
 		header = fmt.Sprintf("📧 CLMAIL - %s (%s)", m.currentAccount.Name, m.currentAccount.Email)
 	} else {
 		header = "📧 CLMAIL - No Account Selected"
 	}
 
+	if indicator := m.syncIndicator(); indicator != "" {
+		header += "  " + indicator
+	}
+	if indicator := m.queueIndicator(); indicator != "" {
+		header += "  " + indicator
+	}
+
 	headerView := headerStyle.Width(m.width).Render(header)
 
 	// pretty status bar :)
@@ -226,19 +412,17 @@ func (m *HomeView) View() string {
 	status := ""
 	if m.loading {
 		status = "⏳ Loading..."
+	} else if m.notice != "" {
+		status = m.notice
 	} else if m.currentAccount != nil {
 		unreadCount := 0
 		folderCount := 0
 		for _, thread := range m.threads {
-			unread, err := thread.FolderUnreadCount.Value()
-			if err != nil {
-				continue
-			}
 			folderCount += int(thread.FolderCount)
-			unreadCount += convertToInt(unread)
+			unreadCount += int(thread.FolderUnreadCount)
 		}
 		// TODO: change to threads instead of mails, and fix reading/unread in mail
-		status = fmt.Sprintf("📊 %d threads • %d unread • h/l: panels • j/k: navigate • s: compose • r: reply • q: quit",
+		status = fmt.Sprintf("📊 %d threads • %d unread • h/l: panels • j/k: navigate • s: compose • r: reply • u: read • f: star • m: move • d: delete • n/e: new/rename folder • H: headers/source • w: save .eml • R: sync now • a: switch account • A: manage accounts • S: storage • E: export • Q: queue • /: search • x: delete saved search • q: quit",
 			folderCount, unreadCount)
 	} else {
 		status = "ℹ️ No accounts configured. Press Esc to go back and add an account."
@@ -277,15 +461,44 @@ func (m *HomeView) View() string {
 	folderContent := strings.Builder{}
 	folderContent.WriteString(lipgloss.NewStyle().Bold(true).Render("Folders") + "\n\n")
 
-	keys := slices.Sorted(maps.Keys(m.folders))
+	for i, entry := range m.folderEntries {
+		var name string
+		switch entry.kind {
+		case entryUnifiedInbox:
+			name = "Unified Inbox"
+		case entryAccount:
+			// account headers break the list up into one tree per account
+			if i > 0 {
+				folderContent.WriteString("\n")
+			}
+			marker := "▸ "
+			if entry.account.ID == m.expandedAccount {
+				marker = "▾ "
+			}
+			name = marker + entry.account.Name
+		case entryFolder:
+			name = entry.folder.Name
+		case entrySavedSearch:
+			name = "🔍 " + entry.savedSearch.Name
+			if unread := m.savedSearchUnread[entry.savedSearch.ID]; unread > 0 {
+				name = fmt.Sprintf("%s (%d)", name, unread)
+			}
+		}
+
+		if entry.kind == entryFolder || entry.kind == entrySavedSearch {
+			if len(m.accounts) > 1 {
+				name = "  " + name
+			}
+		}
 
-	for i, folder := range keys {
 		if i == m.selectedFolder && m.activePanel == FolderPanel {
-			folderContent.WriteString(lipgloss.NewStyle().Foreground(highlightColor).Bold(true).Render("> "+m.folders[folder].Name) + "\n")
+			folderContent.WriteString(lipgloss.NewStyle().Foreground(highlightColor).Bold(true).Render("> "+name) + "\n")
 		} else if i == m.selectedFolder {
-			folderContent.WriteString(lipgloss.NewStyle().Foreground(specialColor).Bold(true).Render("> "+m.folders[folder].Name) + "\n")
+			folderContent.WriteString(lipgloss.NewStyle().Foreground(specialColor).Bold(true).Render("> "+name) + "\n")
+		} else if entry.kind == entryAccount || entry.kind == entryUnifiedInbox {
+			folderContent.WriteString(lipgloss.NewStyle().Bold(true).Render("  "+name) + "\n")
 		} else {
-			folderContent.WriteString("  " + m.folders[folder].Name + "\n")
+			folderContent.WriteString("  " + name + "\n")
 		}
 	}
 
@@ -334,16 +547,187 @@ func (m *HomeView) View() string {
 	))
 }
 
-func (m *HomeView) tickDatabase() tea.Cmd {
-	return tea.Tick(5*time.Second, func(t time.Time) tea.Msg {
+// shownAccounts are the accounts whose mail is on screen, all of them in the unified inbox
+func (m *HomeView) shownAccounts() []db.Account {
+	if entry := m.selectedEntry(); entry != nil && entry.kind == entryUnifiedInbox {
+		return m.accounts
+	}
+	if m.currentAccount != nil {
+		return []db.Account{*m.currentAccount}
+	}
+	return nil
+}
+
+func (m *HomeView) syncing() bool {
+	for _, status := range m.syncStatus {
+		if status.ActiveFolder != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// startSpinner starts the spinner ticking when a sync started, it stops by itself once all are done
+func (m *HomeView) startSpinner() tea.Cmd {
+	if m.spinning || !m.syncing() {
+		return nil
+	}
+	m.spinning = true
+	return m.spinner.Tick
+}
+
+// syncIndicator renders the sync state of the shown accounts for the header
+func (m *HomeView) syncIndicator() string {
+	var offline, syncing, failed []string
+	var lastSync time.Time
+
+	for _, account := range m.shownAccounts() {
+		status, ok := m.syncStatus[account.ID]
+		if !ok || !status.IsRunning {
+			continue
+		}
+
+		switch {
+		case !status.Connected:
+			offline = append(offline, account.Name)
+		case status.ActiveFolder != "":
+			progress := status.ActiveFolder
+			if status.Total > 0 {
+				progress += fmt.Sprintf(" %d/%d", status.Fetched, status.Total)
+			}
+			syncing = append(syncing, progress)
+		case status.LastError != "":
+			failed = append(failed, account.Name)
+		}
+
+		if status.LastSync.After(lastSync) {
+			lastSync = status.LastSync
+		}
+	}
+
+	var parts []string
+	if len(offline) > 0 {
+		badge := "offline"
+		if len(m.shownAccounts()) > 1 {
+			badge += ": " + strings.Join(offline, ", ")
+		}
+		parts = append(parts, offlineBadge.Render(badge))
+	}
+	if len(syncing) > 0 {
+		parts = append(parts, m.spinner.View()+" syncing "+strings.Join(syncing, ", "))
+	}
+	if len(failed) > 0 {
+		parts = append(parts, errorStyle.Render("⚠ sync failed"))
+	}
+	if len(parts) == 0 && !lastSync.IsZero() {
+		parts = append(parts, "✓ synced "+lastSync.Format("15:04"))
+	}
+
+	return strings.Join(parts, "  ")
+}
+
+// handleEvents reloads whatever the events touched, once per batch no matter how many came in
+func (m *HomeView) handleEvents(msg eventsMsg) {
+	var reloadThreads, reloadThread, reloadFolders, reloadCounts bool
+
+	for _, event := range msg {
+		switch e := event.(type) {
+		case sync.StatusChanged:
+			m.syncStatus[e.AccountID] = e.Status
+		case events.NewMail:
+			reloadThreads = reloadThreads || m.showsFolder(e.AccountID, e.FolderID)
+			reloadCounts = reloadCounts || m.hasSavedSearches(e.AccountID)
+		case events.FlagsChanged:
+			reloadThreads = reloadThreads || m.showsFolder(e.AccountID, e.FolderID)
+			reloadThread = true
+			reloadCounts = reloadCounts || m.hasSavedSearches(e.AccountID)
+		case events.Expunged:
+			reloadThreads = reloadThreads || m.showsFolder(e.AccountID, e.FolderID)
+			reloadCounts = reloadCounts || m.hasSavedSearches(e.AccountID)
+		case events.BodyFetched:
+			reloadThread = reloadThread || slices.ContainsFunc(m.selectedThread, func(email db.Email) bool {
+				return email.ID == e.EmailID
+			})
+		case events.FoldersChanged:
+			reloadFolders = true
+		case events.ActionsChanged:
+			m.loadQueueCounts()
+		case events.SendResult:
+			// the copy in the sent folder shows up once it was stored
+			reloadThreads = reloadThreads || (e.Err == nil && m.showsFolder(e.AccountID, 0))
+		}
+	}
+
+	if reloadFolders {
+		// counts the saved searches again as well
+		m.reloadAccounts()
+		// the selected folder may be gone or renamed
+		reloadThreads = true
+		reloadCounts = false
+	}
+	if reloadThreads {
 		m.loadThreads()
-		return tickMsg{}
+	}
+	if reloadCounts {
+		// saved searches match mail of every folder, not just the one on screen
+		m.loadSavedSearchCounts()
+	}
+	if reloadThread && len(m.selectedThread) > 0 {
+		m.loadThreadEmails(m.selectedThread[0].ThreadID)
+	}
+}
+
+// hasSavedSearches reports whether the account has a saved search in the sidebar
+func (m *HomeView) hasSavedSearches(accountID int64) bool {
+	return slices.ContainsFunc(m.folderEntries, func(entry folderEntry) bool {
+		return entry.kind == entrySavedSearch && entry.account.ID == accountID
 	})
 }
 
+// showsFolder reports whether mail of the folder could be on screen, a folder id of 0 matches
+// any folder of the account
+func (m *HomeView) showsFolder(accountID, folderID int64) bool {
+	entry := m.selectedEntry()
+	if entry == nil {
+		return false
+	}
+
+	switch entry.kind {
+	case entryUnifiedInbox:
+		return true
+	case entryFolder:
+		return entry.account.ID == accountID && (folderID == 0 || entry.folder.ID == folderID)
+	default:
+		return entry.account.ID == accountID
+	}
+}
+
+// reloadAccounts picks up added accounts and folders without moving away from the current one
+func (m *HomeView) reloadAccounts() {
+	var currentID int64
+	if m.currentAccount != nil {
+		currentID = m.currentAccount.ID
+	}
+	expanded := m.expandedAccount
+
+	m.loadAccounts()
+	if account := m.accountByID(currentID); account != nil {
+		m.currentAccount = account
+		m.expandedAccount = expanded
+	}
+	m.loadFolders()
+}
+
 func (m *HomeView) updateThreadsViewport() {
-	emailListContent := m.buildThreadsContent()
+	emailListContent, selectedTop, selectedBottom := m.buildThreadsContent()
 	m.threadsViewport.SetContent(emailListContent)
+
+	// keep the selected thread on screen, the list is longer than the panel once a few pages are in
+	if selectedTop < m.threadsViewport.YOffset {
+		m.threadsViewport.SetYOffset(selectedTop)
+	} else if selectedBottom > m.threadsViewport.YOffset+m.threadsViewport.Height {
+		m.threadsViewport.SetYOffset(selectedBottom - m.threadsViewport.Height)
+	}
 }
 
 func (m *HomeView) updateContentViewport() {
@@ -351,16 +735,16 @@ func (m *HomeView) updateContentViewport() {
 	m.contentViewport.SetContent(contentData)
 }
 
-func (m *HomeView) buildThreadsContent() string {
+// buildThreadsContent renders the thread list and returns the lines the selected thread is on
+func (m *HomeView) buildThreadsContent() (string, int, int) {
 	emailListContent := strings.Builder{}
 	emailListContent.WriteString(lipgloss.NewStyle().Bold(true).Render("Threads") + "\n\n")
 
+	var selectedTop, selectedBottom int
 	for i, thread := range m.threads {
-		unreadCount := 0
-		folderCount := 0
-		unread, _ := thread.FolderUnreadCount.Value()
-		folderCount += int(thread.FolderCount)
-		unreadCount += convertToInt(unread)
+		if i == m.selectedThreadInt {
+			selectedTop = strings.Count(emailListContent.String(), "\n")
+		}
 
 		sender := thread.LatestFolderSender
 
@@ -370,6 +754,13 @@ func (m *HomeView) buildThreadsContent() string {
 			truncateString(thread.Subject, m.threadsViewport.Width-6),
 			thread.LatestMessageDate.Format("2006-01-02 15:04"))
 
+		// threads from several accounts are mixed in the unified inbox, so show whose they are
+		if entry := m.selectedEntry(); entry != nil && entry.kind == entryUnifiedInbox {
+			if account := m.accountByID(thread.AccountID); account != nil {
+				emailItem = accountBadge(*account) + " " + emailItem
+			}
+		}
+
 		if i == m.selectedThreadInt && m.activePanel == EmailListPanel {
 			emailListContent.WriteString(lipgloss.NewStyle().Foreground(highlightColor).Bold(true).BorderBottom(true).BorderStyle(lipgloss.MarkdownBorder()).Width(m.threadsViewport.Width).Render("> "+emailItem) + "\n\n")
 		} else if i == m.selectedThreadInt {
@@ -377,9 +768,17 @@ func (m *HomeView) buildThreadsContent() string {
 		} else {
 			emailListContent.WriteString(lipgloss.NewStyle().Foreground(subtleColor).Bold(true).BorderBottom(true).BorderStyle(lipgloss.MarkdownBorder()).Width(m.threadsViewport.Width).Render(emailItem) + "\n\n")
 		}
+
+		if i == m.selectedThreadInt {
+			selectedBottom = strings.Count(emailListContent.String(), "\n")
+		}
 	}
 
-	return emailListContent.String()
+	if !m.threadsComplete && len(m.threads) > 0 {
+		emailListContent.WriteString(lipgloss.NewStyle().Foreground(subtleColor).Render("More below...") + "\n")
+	}
+
+	return emailListContent.String(), selectedTop, selectedBottom
 }
 
 func (m *HomeView) buildContentData() string {
@@ -388,11 +787,17 @@ func (m *HomeView) buildContentData() string {
 	}
 
 	email := m.selectedThread[m.selectedEmail]
+	if m.contentMode != showBody && m.source.emailID == email.ID {
+		return m.buildSourceContent(email)
+	}
 
 	var bodyText string
-	if !email.BodyText.Valid {
+	switch {
+	case !email.BodyText.Valid:
+		bodyText = "Loading body..."
+	case email.BodyText.String == "":
 		bodyText = "No body text available"
-	} else {
+	default:
 		bodyText = email.BodyText.String
 	}
 
@@ -447,44 +852,169 @@ func (m *HomeView) loadAccounts() {
 	m.accounts = accounts
 	if len(accounts) > 0 {
 		m.currentAccount = &accounts[0]
+		for i := range accounts {
+			if accounts[i].IsDefault {
+				m.currentAccount = &accounts[i]
+				break
+			}
+		}
+		m.expandedAccount = m.currentAccount.ID
 	}
 	m.loading = false
 }
 
+// loadFolders builds the folder panel: the unified inbox when there is more than one account,
+// then a tree per account where only the expanded account shows its folders and saved searches.
 func (m *HomeView) loadFolders() {
-	folders, err := m.dbClient.ListFolders(context.Background(), m.accounts[0].ID)
-	if err != nil {
-		return
+	var selected *folderEntry
+	if entry := m.selectedEntry(); entry != nil {
+		e := *entry
+		selected = &e
 	}
 
-	for _, folder := range folders {
+	var entries []folderEntry
+
+	if len(m.accounts) > 1 {
+		entries = append(entries, folderEntry{kind: entryUnifiedInbox})
+	}
+
+	for _, account := range m.accounts {
+		if len(m.accounts) > 1 {
+			entries = append(entries, folderEntry{kind: entryAccount, account: account})
+		}
+
+		if account.ID != m.expandedAccount {
+			continue
+		}
+
+		folders, err := m.dbClient.ListFolders(context.Background(), account.ID)
+		if err != nil {
+			log.Printf("Failed to get folders for %s: %v", account.Email, err)
+			continue
+		}
+
+		for _, folder := range sortFolders(folders) {
+			entries = append(entries, folderEntry{kind: entryFolder, account: account, folder: folder})
+		}
+
+		savedSearches, err := m.dbClient.ListSavedSearches(context.Background(), account.ID)
+		if err != nil {
+			log.Printf("Failed to get saved searches for %s: %v", account.Email, err)
+			continue
+		}
+
+		for _, savedSearch := range savedSearches {
+			entries = append(entries, folderEntry{kind: entrySavedSearch, account: account, savedSearch: savedSearch})
+		}
+	}
+
+	m.folderEntries = entries
+
+	// keep the cursor on the same entry when the list changes around it
+	if selected != nil {
+		for i, entry := range entries {
+			if entry.sameAs(*selected) {
+				m.selectedFolder = i
+				break
+			}
+		}
+	}
+	m.selectedFolder = max(0, min(m.selectedFolder, len(entries)-1))
+
+	m.loadSavedSearchCounts()
+}
+
+// sortFolders puts the well known folders on top in the order people expect, the rest alphabetically
+func sortFolders(folders []db.Folder) []db.Folder {
+	rank := func(folder db.Folder) int {
 		f := strings.ToLower(folder.Name)
 		switch {
 		case strings.Contains(f, "inbox"):
-			m.folders[0] = folder
+			return 0
 		case strings.Contains(f, "drafts"):
-			m.folders[1] = folder
+			return 1
 		case strings.Contains(f, "sent"):
-			m.folders[2] = folder
+			return 2
 		case strings.Contains(f, "junk"), strings.Contains(f, "spam"):
-			m.folders[3] = folder
+			return 3
 		case strings.Contains(f, "trash"), strings.Contains(f, "deleted"):
-			m.folders[4] = folder
-		default:
-			i := len(m.folders)
-			m.folders[i] = folder
+			return 4
+		}
+		return 5
+	}
+
+	sorted := slices.Clone(folders)
+	slices.SortStableFunc(sorted, func(a, b db.Folder) int {
+		if rank(a) != rank(b) {
+			return rank(a) - rank(b)
+		}
+		return strings.Compare(a.Name, b.Name)
+	})
+
+	return sorted
+}
+
+func (m *HomeView) loadSavedSearchCounts() {
+	counts := make(map[int64]int64)
+	for _, entry := range m.folderEntries {
+		if entry.kind != entrySavedSearch {
+			continue
+		}
+
+		query, err := search.Parse(entry.savedSearch.Query)
+		if err != nil {
+			continue
 		}
+
+		unread, err := search.CountUnread(context.Background(), m.dbClient, entry.account.ID, query)
+		if err != nil {
+			log.Printf("Failed to count unread for saved search %s: %v", entry.savedSearch.Name, err)
+			continue
+		}
+		counts[entry.savedSearch.ID] = unread
+	}
+
+	m.savedSearchUnread = counts
+}
+
+func (m *HomeView) deleteSavedSearch(id int64) {
+	if err := m.dbClient.DeleteSavedSearch(context.Background(), id); err != nil {
+		log.Printf("Failed to delete saved search: %v", err)
+		return
+	}
+
+	m.loadFolders()
+	m.SelectFolder(m.selectedFolder)
+}
+
+// selectedEntry returns the folder panel entry under the cursor
+func (m *HomeView) selectedEntry() *folderEntry {
+	if m.selectedFolder < 0 || m.selectedFolder >= len(m.folderEntries) {
+		return nil
 	}
+	return &m.folderEntries[m.selectedFolder]
 }
 
+func (m *HomeView) accountByID(id int64) *db.Account {
+	for i := range m.accounts {
+		if m.accounts[i].ID == id {
+			return &m.accounts[i]
+		}
+	}
+	return nil
+}
+
+// loadThreads loads the threads of the selected entry from the start again. as many are loaded as
+// were before, so a reload after new mail doesn't take the selection away.
 func (m *HomeView) loadThreads() {
-	threads, err := m.dbClient.GetThreadsInFolder(context.Background(), db.GetThreadsInFolderParams{
-		FolderID:  m.folders[m.selectedFolder].ID,
-		AccountID: m.currentAccount.ID,
-		// TODO: Pagination
-		Limit: 10,
-	})
+	entry := m.selectedEntry()
+	if entry == nil {
+		m.loading = false
+		return
+	}
 
+	count := max(int64(len(m.threads)), threadPageSize)
+	threads, err := m.threadPage(*entry, db.FirstPage, count)
 	if err != nil {
 		m.loading = false
 		log.Printf("Failed to get threads: %v", err)
@@ -492,10 +1022,89 @@ func (m *HomeView) loadThreads() {
 	}
 
 	m.threads = threads
+	m.threadsComplete = int64(len(threads)) < count
+	m.selectedThreadInt = min(m.selectedThreadInt, max(len(threads)-1, 0))
 	m.loading = false
 	m.updateThreadsViewport()
 }
 
+// loadMoreThreads adds the next page once the selection gets close to the end of the list
+func (m *HomeView) loadMoreThreads() {
+	entry := m.selectedEntry()
+	if entry == nil || m.threadsComplete || len(m.threads) == 0 || m.selectedThreadInt < len(m.threads)-threadPrefetch {
+		return
+	}
+
+	threads, err := m.threadPage(*entry, db.CursorAfter(m.threads[len(m.threads)-1]), threadPageSize)
+	if err != nil {
+		log.Printf("Failed to get more threads: %v", err)
+		return
+	}
+
+	m.threads = append(m.threads, threads...)
+	m.threadsComplete = len(threads) < threadPageSize
+}
+
+func (m *HomeView) threadPage(entry folderEntry, cursor db.ThreadCursor, limit int64) ([]db.GetThreadsInFolderRow, error) {
+	switch entry.kind {
+	case entryUnifiedInbox:
+		return m.unifiedInboxThreads(cursor, limit)
+	case entryAccount:
+		// an account header shows that accounts inbox
+		return m.inboxThreads(entry.account, cursor, limit)
+	case entryFolder:
+		return m.dbClient.ThreadsPage(context.Background(), entry.folder.ID, cursor, limit)
+	case entrySavedSearch:
+		return m.savedSearchThreads(entry.account, entry.savedSearch, cursor, limit)
+	}
+	return nil, nil
+}
+
+func (m *HomeView) inboxThreads(account db.Account, cursor db.ThreadCursor, limit int64) ([]db.GetThreadsInFolderRow, error) {
+	inbox, err := m.dbClient.GetFolderByName(context.Background(), db.GetFolderByNameParams{
+		Name:      "INBOX",
+		AccountID: account.ID,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("no inbox for %s: %w", account.Email, err)
+	}
+
+	return m.dbClient.ThreadsPage(context.Background(), inbox.ID, cursor, limit)
+}
+
+// unifiedInboxThreads merges the newest inbox threads of every account. thread ids are unique
+// across accounts, so the same cursor pages through all of them.
+func (m *HomeView) unifiedInboxThreads(cursor db.ThreadCursor, limit int64) ([]db.GetThreadsInFolderRow, error) {
+	var threads []db.GetThreadsInFolderRow
+
+	for _, account := range m.accounts {
+		accountThreads, err := m.inboxThreads(account, cursor, limit)
+		if err != nil {
+			log.Printf("Failed to get inbox threads for %s: %v", account.Email, err)
+			continue
+		}
+		threads = append(threads, accountThreads...)
+	}
+
+	slices.SortStableFunc(threads, func(a, b db.GetThreadsInFolderRow) int {
+		if order := b.FolderLatestDate.Compare(a.FolderLatestDate); order != 0 {
+			return order
+		}
+		return cmp.Compare(b.ID, a.ID)
+	})
+
+	return threads[:min(len(threads), int(limit))], nil
+}
+
+func (m *HomeView) savedSearchThreads(account db.Account, savedSearch db.SavedSearch, cursor db.ThreadCursor, limit int64) ([]db.GetThreadsInFolderRow, error) {
+	query, err := search.Parse(savedSearch.Query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse saved search %s: %w", savedSearch.Name, err)
+	}
+
+	return search.Threads(context.Background(), m.dbClient, account.ID, query, cursor, limit)
+}
+
 func (m *HomeView) loadThreadEmails(threadID int64) {
 	emails, err := m.dbClient.ListEmailsByThread(context.Background(), threadID)
 	if err != nil {
@@ -504,27 +1113,417 @@ func (m *HomeView) loadThreadEmails(threadID int64) {
 	}
 	m.selectedThread = emails
 	m.updateContentViewport()
+
+	// a body that isn't there yet is fetched right away instead of whenever its turn comes
+	for _, email := range emails {
+		if email.BodyText.Valid {
+			continue
+		}
+		if client, ok := m.emailService.GetClient(email.AccountID); ok && client.SyncClient != nil {
+			client.SyncClient.FocusThread(threadID)
+		}
+		break
+	}
 }
 
 func (m *HomeView) SelectFolder(folderID int) {
 	m.selectedFolder = folderID
+	m.threads = nil
+	m.threadsViewport.SetYOffset(0)
 	m.selectedThreadInt = 0
 	m.selectedEmail = 0
 	m.selectedThread = nil
+
+	// moving into another accounts tree switches to that account
+	if entry := m.selectedEntry(); entry != nil && entry.kind != entryUnifiedInbox {
+		if account := m.accountByID(entry.account.ID); account != nil && account.ID != m.expandedAccount {
+			m.currentAccount = account
+			m.expandedAccount = account.ID
+			m.loadFolders()
+		}
+	}
+
+	m.focusSync()
 	m.loadThreads()
 }
 
+// focusSync tells the syncer of the account which folder is on screen so it syncs that one first
+func (m *HomeView) focusSync() {
+	entry := m.selectedEntry()
+	if entry == nil {
+		return
+	}
+
+	for accountID, folder := range m.syncTargets(*entry) {
+		if client, ok := m.emailService.GetClient(accountID); ok && client.SyncClient != nil {
+			client.SyncClient.SetFocus(folder)
+		}
+	}
+}
+
+// syncNow syncs the folder under the cursor right away, or the whole account on an account header
+func (m *HomeView) syncNow() {
+	entry := m.selectedEntry()
+	if entry == nil {
+		return
+	}
+
+	targets := m.syncTargets(*entry)
+	if entry.kind == entryAccount || entry.kind == entrySavedSearch {
+		targets = map[int64]string{entry.account.ID: ""}
+	}
+
+	for accountID, folder := range targets {
+		if client, ok := m.emailService.GetClient(accountID); ok && client.SyncClient != nil {
+			client.SyncClient.SyncNow(folder)
+		}
+	}
+}
+
+// export writes the open thread, or the folder under the cursor in the folder panel, to an mbox
+func (m *HomeView) export() tea.Cmd {
+	if m.activePanel == FolderPanel {
+		entry := m.selectedEntry()
+		if entry == nil || entry.kind != entryFolder {
+			m.notice = "Only folders can be exported from here, use clmail export for whole accounts"
+			return nil
+		}
+		folder := entry.folder
+		return exportMbox(m.dbClient, folder.Name, func(ctx context.Context) ([]int64, error) {
+			return m.dbClient.ListFolderEmailIDs(ctx, folder.ID)
+		})
+	}
+
+	if len(m.threads) == 0 {
+		return nil
+	}
+	threadID := m.threads[m.selectedThreadInt].ID
+	return exportMbox(m.dbClient, fmt.Sprintf("thread-%d", threadID), func(ctx context.Context) ([]int64, error) {
+		return archive.ThreadEmailIDs(ctx, m.dbClient, threadID)
+	})
+}
+
+// actionEmails are the emails a key acts on: the open one in the content panel, the whole thread
+// in the thread list
+func (m *HomeView) actionEmails() []db.Email {
+	switch m.activePanel {
+	case ContentPanel:
+		if len(m.selectedThread) > 0 {
+			return []db.Email{m.selectedThread[m.selectedEmail]}
+		}
+	case EmailListPanel:
+		return m.selectedThread
+	}
+	return nil
+}
+
+// actionFolder is the folder the mail on screen is in, the inbox in the unified inbox and on an
+// account header
+func (m *HomeView) actionFolder(accountID int64) (db.Folder, error) {
+	entry := m.selectedEntry()
+	if entry == nil {
+		return db.Folder{}, fmt.Errorf("no folder selected")
+	}
+
+	switch entry.kind {
+	case entryFolder:
+		return entry.folder, nil
+	case entryUnifiedInbox, entryAccount:
+		return m.dbClient.GetFolderByName(context.Background(), db.GetFolderByNameParams{
+			Name:      "INBOX",
+			AccountID: accountID,
+		})
+	}
+	return db.Folder{}, fmt.Errorf("open the folder to move or delete its mail")
+}
+
+// emailsIn keeps the emails that are in the folder, a thread can have mail in several
+func (m *HomeView) emailsIn(emails []db.Email, folder db.Folder) []db.Email {
+	var in []db.Email
+	for _, email := range emails {
+		inFolder, err := m.dbClient.IsEmailInFolder(context.Background(), db.IsEmailInFolderParams{
+			EmailID:  email.ID,
+			FolderID: folder.ID,
+		})
+		if err != nil {
+			log.Printf("Failed to look up folder of email: %v", err)
+			continue
+		}
+		if inFolder {
+			in = append(in, email)
+		}
+	}
+	return in
+}
+
+// toggleRead marks the mail read, or unread when all of it is read already
+func (m *HomeView) toggleRead() tea.Cmd {
+	emails := m.actionEmails()
+	if len(emails) == 0 {
+		return nil
+	}
+
+	read := slices.ContainsFunc(emails, func(email db.Email) bool { return !email.IsRead })
+	notice := "Marked as read"
+	if !read {
+		notice = "Marked as unread"
+	}
+
+	return runAction(notice, func(ctx context.Context) error {
+		for _, email := range emails {
+			if email.IsRead == read {
+				continue
+			}
+			if err := actions.SetRead(ctx, m.dbClient, email, read); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// toggleStarred stars the open mail, in the thread list the newest of the thread. a thread with
+// starred mail loses all of its stars.
+func (m *HomeView) toggleStarred() tea.Cmd {
+	emails := m.actionEmails()
+	if len(emails) == 0 {
+		return nil
+	}
+
+	starred := !slices.ContainsFunc(emails, func(email db.Email) bool { return email.IsStarred })
+	if starred {
+		emails = emails[len(emails)-1:]
+	}
+	notice := "Starred"
+	if !starred {
+		notice = "Star removed"
+	}
+
+	return runAction(notice, func(ctx context.Context) error {
+		for _, email := range emails {
+			if email.IsStarred == starred {
+				continue
+			}
+			if err := actions.SetStarred(ctx, m.dbClient, email, starred); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// moveMail opens the folder picker for the mail under the cursor
+func (m *HomeView) moveMail() {
+	emails := m.actionEmails()
+	if len(emails) == 0 {
+		return
+	}
+
+	from, err := m.actionFolder(emails[0].AccountID)
+	if err != nil {
+		m.notice = err.Error()
+		return
+	}
+	if emails = m.emailsIn(emails, from); len(emails) == 0 {
+		m.notice = "None of it is in " + from.Name
+		return
+	}
+
+	folders, err := m.dbClient.ListFolders(context.Background(), from.AccountID)
+	if err != nil {
+		m.notice = "Failed to get folders: " + err.Error()
+		return
+	}
+	// mail can't move between local folders and the server
+	folders = slices.DeleteFunc(folders, func(folder db.Folder) bool {
+		return folder.ID == from.ID || folder.Local != from.Local
+	})
+
+	m.popup = NewFolderPicker("Move to", folders, func(to db.Folder) tea.Cmd {
+		return runAction(fmt.Sprintf("Moved %d messages to %s", len(emails), to.Name), func(ctx context.Context) error {
+			for _, email := range emails {
+				if err := actions.Move(ctx, m.dbClient, email, from, to); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	})
+}
+
+// deleteMail moves the mail under the cursor to the trash, mail that is in the trash already is
+// deleted for good after asking
+func (m *HomeView) deleteMail() tea.Cmd {
+	emails := m.actionEmails()
+	if len(emails) == 0 {
+		return nil
+	}
+
+	folder, err := m.actionFolder(emails[0].AccountID)
+	if err != nil {
+		m.notice = err.Error()
+		return nil
+	}
+	if emails = m.emailsIn(emails, folder); len(emails) == 0 {
+		m.notice = "None of it is in " + folder.Name
+		return nil
+	}
+
+	deleteAll := runAction(fmt.Sprintf("Deleted %d messages", len(emails)), func(ctx context.Context) error {
+		for _, email := range emails {
+			if err := actions.Delete(ctx, m.dbClient, email, folder); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+
+	folders, err := m.dbClient.ListFolders(context.Background(), folder.AccountID)
+	if err != nil {
+		m.notice = "Failed to get folders: " + err.Error()
+		return nil
+	}
+	if trash, ok := actions.TrashFolder(folders); ok && trash.ID != folder.ID && !folder.Local {
+		return deleteAll
+	}
+
+	m.popup = NewConfirmPrompt(fmt.Sprintf("Delete %d messages for good?", len(emails)), func() tea.Cmd {
+		return deleteAll
+	})
+	return nil
+}
+
+func (m *HomeView) newFolder() {
+	if m.currentAccount == nil {
+		return
+	}
+
+	account := *m.currentAccount
+	m.popup = NewInputPrompt("New folder in "+account.Name, "", func(name string) tea.Cmd {
+		return runAction("Created "+name, func(ctx context.Context) error {
+			return actions.CreateFolder(ctx, m.dbClient, account, name)
+		})
+	})
+}
+
+func (m *HomeView) renameFolder() {
+	entry := m.selectedEntry()
+	if entry == nil || entry.kind != entryFolder {
+		return
+	}
+
+	folder := entry.folder
+	m.popup = NewInputPrompt("Rename "+folder.Name, folder.Name, func(name string) tea.Cmd {
+		if name == folder.Name {
+			return nil
+		}
+		return runAction("Renamed "+folder.Name+" to "+name, func(ctx context.Context) error {
+			return actions.RenameFolder(ctx, m.dbClient, folder, name)
+		})
+	})
+}
+
+func (m *HomeView) deleteFolder() {
+	entry := m.selectedEntry()
+	if entry == nil || entry.kind != entryFolder {
+		return
+	}
+
+	folder := entry.folder
+	m.popup = NewConfirmPrompt("Delete "+folder.Name+" with all of its mail?", func() tea.Cmd {
+		return runAction("Deleted "+folder.Name, func(ctx context.Context) error {
+			return actions.DeleteFolder(ctx, m.dbClient, folder)
+		})
+	})
+}
+
+func (m *HomeView) loadQueueCounts() {
+	counts, err := m.dbClient.CountPendingActions(context.Background())
+	if err != nil {
+		log.Printf("Failed to count pending actions: %v", err)
+		return
+	}
+
+	m.queuedActions, m.stuckActions = 0, 0
+	for _, count := range counts {
+		if count.Status == actions.StatusPending {
+			m.queuedActions += count.Count
+		} else {
+			m.stuckActions += count.Count
+		}
+	}
+}
+
+// queueIndicator tells how much is waiting for the server for the header
+func (m *HomeView) queueIndicator() string {
+	var parts []string
+	if m.queuedActions > 0 {
+		parts = append(parts, fmt.Sprintf("⇅ %d queued", m.queuedActions))
+	}
+	if m.stuckActions > 0 {
+		parts = append(parts, errorStyle.Render(fmt.Sprintf("⚠ %d actions failed (Q)", m.stuckActions)))
+	}
+	return strings.Join(parts, "  ")
+}
+
+// syncTargets maps the accounts shown by the entry to the folder the entry shows of them
+func (m *HomeView) syncTargets(entry folderEntry) map[int64]string {
+	targets := make(map[int64]string)
+
+	switch entry.kind {
+	case entryUnifiedInbox:
+		for _, account := range m.accounts {
+			targets[account.ID] = "INBOX"
+		}
+	case entryAccount:
+		targets[entry.account.ID] = "INBOX"
+	case entryFolder:
+		targets[entry.folder.AccountID] = entry.folder.Name
+	}
+
+	return targets
+}
+
+// SelectAccount switches to the account and puts the cursor on its first folder
 func (m *HomeView) SelectAccount(account *db.Account) {
-	m.currentAccount = account
+	m.currentAccount = m.accountByID(account.ID)
+	m.expandedAccount = account.ID
+	m.loadFolders()
+	m.selectFirstFolderOf(m.currentAccount)
+}
+
+func (m *HomeView) selectFirstFolderOf(account *db.Account) {
+	for i, entry := range m.folderEntries {
+		if account != nil && entry.kind == entryFolder && entry.account.ID == account.ID {
+			m.SelectFolder(i)
+			return
+		}
+	}
+	m.SelectFolder(0)
+}
+
+func (m *HomeView) selectUnifiedInbox() {
+	for i, entry := range m.folderEntries {
+		if entry.kind == entryUnifiedInbox {
+			m.SelectFolder(i)
+			return
+		}
+	}
 }
 
 func (m *HomeView) GetSelectedMail() *types.Mail {
 	mail := m.selectedThread[m.selectedEmail]
+
+	from := m.currentAccount.Email
+	if account := m.accountByID(mail.AccountID); account != nil {
+		from = account.Email
+	}
+
 	return &types.Mail{
 		MessageID:  mail.MessageID,
 		References: mail.ReferenceID.String,
 		To:         mail.FromAddress,
-		From:       m.currentAccount.Email,
+		From:       from,
 		Subject:    mail.Subject,
 		Body:       mail.BodyText.String,
 		CC:         nil,
@@ -557,18 +1556,3 @@ func truncateString(s string, maxLen int) string {
 
 	return s[:maxLen-3] + "..."
 }
-
-func convertToInt(value driver.Value) int {
-	switch v := value.(type) {
-	case int64:
-		return int(v)
-	case float64:
-		return int(v) // Convert float64 to int
-	case int:
-		return v
-	case nil:
-		return 0
-	default:
-		return 0
-	}
-}
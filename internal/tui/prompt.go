@@ -0,0 +1,90 @@
+package tui
+
+import (
+	"github.com/charmbracelet/bubbles/cursor"
+	"github.com/charmbracelet/bubbles/textinput"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"strings"
+)
+
+// Prompt is the popup asking for a name, or for a yes before something that can't be taken back
+type Prompt struct {
+	title   string
+	input   textinput.Model
+	confirm bool
+	submit  func(value string) tea.Cmd
+}
+
+// NewInputPrompt asks for a line of text, value is what the input starts with
+func NewInputPrompt(title, value string, submit func(value string) tea.Cmd) *Prompt {
+	input := textinput.New()
+	input.CharLimit = 200
+	input.Width = 40
+	input.SetValue(value)
+	input.Focus()
+	// only keys reach a popup, a blinking cursor would never get its ticks
+	input.Cursor.SetMode(cursor.CursorStatic)
+
+	return &Prompt{title: title, input: input, submit: submit}
+}
+
+// NewConfirmPrompt asks for y or n
+func NewConfirmPrompt(title string, submit func() tea.Cmd) *Prompt {
+	return &Prompt{title: title, confirm: true, submit: func(string) tea.Cmd { return submit() }}
+}
+
+func (m *Prompt) Init() tea.Cmd {
+	return nil
+}
+
+func (m *Prompt) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	keyMsg, ok := msg.(tea.KeyMsg)
+	if !ok {
+		return m, nil
+	}
+
+	if m.confirm {
+		switch keyMsg.String() {
+		case "y", "Y":
+			return m, tea.Batch(closePopup, m.submit(""))
+		case "n", "N", "esc":
+			return m, closePopup
+		}
+		return m, nil
+	}
+
+	switch keyMsg.String() {
+	case "esc":
+		return m, closePopup
+	case "enter":
+		value := strings.TrimSpace(m.input.Value())
+		if value == "" {
+			return m, nil
+		}
+		return m, tea.Batch(closePopup, m.submit(value))
+	}
+
+	var cmd tea.Cmd
+	m.input, cmd = m.input.Update(msg)
+	return m, cmd
+}
+
+func (m *Prompt) View() string {
+	var content strings.Builder
+
+	content.WriteString(lipgloss.NewStyle().Bold(true).Render(m.title) + "\n\n")
+
+	if m.confirm {
+		content.WriteString(blurredStyle.Render("y: yes • n: no"))
+	} else {
+		content.WriteString(m.input.View() + "\n\n")
+		content.WriteString(blurredStyle.Render("enter: ok • esc: cancel"))
+	}
+
+	return lipgloss.NewStyle().
+		Border(lipgloss.RoundedBorder()).
+		BorderForeground(highlightColor).
+		Padding(1, 2).
+		Render(content.String())
+}
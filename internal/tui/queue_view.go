@@ -0,0 +1,256 @@
+package tui
+
+import (
+	"context"
+	"fmt"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/rexxDigital/clmail/internal/db"
+	"github.com/rexxDigital/clmail/internal/services/actions"
+	"github.com/rexxDigital/clmail/internal/services/events"
+	"strings"
+)
+
+type queueLoadedMsg struct {
+	actions  []db.PendingAction
+	accounts map[int64]string
+	err      error
+}
+
+// QueueView lists what is waiting to be replayed to the server, actions that failed or ran into
+// a conflict can be retried, dropped, or dropped and undone locally
+type QueueView struct {
+	dbClient *db.Client
+	actions  []db.PendingAction
+	accounts map[int64]string
+	selected int
+	loading  bool
+	errorMsg string
+	infoMsg  string
+	width    int
+	height   int
+}
+
+func NewQueueView(width, height int, dbClient *db.Client) *QueueView {
+	return &QueueView{
+		dbClient: dbClient,
+		loading:  true,
+		width:    width,
+		height:   height,
+	}
+}
+
+func (m *QueueView) Init() tea.Cmd {
+	return m.load
+}
+
+func (m *QueueView) load() tea.Msg {
+	ctx := context.Background()
+
+	pending, err := m.dbClient.ListPendingActions(ctx)
+	if err != nil {
+		return queueLoadedMsg{err: fmt.Errorf("failed to get pending actions: %w", err)}
+	}
+
+	accounts, err := m.dbClient.ListAccounts(ctx)
+	if err != nil {
+		return queueLoadedMsg{err: fmt.Errorf("failed to get accounts: %w", err)}
+	}
+
+	names := make(map[int64]string, len(accounts))
+	for _, account := range accounts {
+		names[account.ID] = account.Email
+	}
+
+	return queueLoadedMsg{actions: pending, accounts: names}
+}
+
+func (m *QueueView) Update(message tea.Msg) (tea.Model, tea.Cmd) {
+	switch msg := message.(type) {
+	case tea.WindowSizeMsg:
+		m.width = msg.Width
+		m.height = msg.Height
+		return m, nil
+	case eventsMsg:
+		for _, event := range msg {
+			if _, ok := event.(events.ActionsChanged); ok {
+				return m, m.load
+			}
+		}
+		return m, nil
+	case queueLoadedMsg:
+		m.loading = false
+		if msg.err != nil {
+			m.errorMsg = msg.err.Error()
+			return m, nil
+		}
+		m.actions = msg.actions
+		m.accounts = msg.accounts
+		m.selected = max(min(m.selected, len(m.actions)-1), 0)
+		return m, nil
+	case actionDoneMsg:
+		if msg.err != nil {
+			m.errorMsg = msg.err.Error()
+			return m, nil
+		}
+		m.errorMsg = ""
+		m.infoMsg = msg.notice
+		return m, m.load
+	case tea.KeyMsg:
+		switch msg.String() {
+		case "ctrl+c":
+			return m, tea.Quit
+		case "esc", "q":
+			return m, func() tea.Msg {
+				return SwitchViewMsg{ViewName: "home"}
+			}
+		case "j", "down":
+			if m.selected < len(m.actions)-1 {
+				m.selected++
+			}
+		case "k", "up":
+			if m.selected > 0 {
+				m.selected--
+			}
+		case "r":
+			if action, ok := m.selectedAction(); ok {
+				return m, runAction("Retrying "+describeAction(action), func(ctx context.Context) error {
+					return actions.Retry(ctx, m.dbClient, action)
+				})
+			}
+		case "d":
+			if action, ok := m.selectedAction(); ok {
+				return m, runAction("Dropped "+describeAction(action), func(ctx context.Context) error {
+					return actions.Discard(ctx, m.dbClient, action, false)
+				})
+			}
+		case "u":
+			if action, ok := m.selectedAction(); ok {
+				return m, runAction("Undid "+describeAction(action), func(ctx context.Context) error {
+					return actions.Discard(ctx, m.dbClient, action, true)
+				})
+			}
+		}
+	}
+
+	return m, nil
+}
+
+func (m *QueueView) selectedAction() (db.PendingAction, bool) {
+	if len(m.actions) == 0 {
+		return db.PendingAction{}, false
+	}
+	return m.actions[m.selected], true
+}
+
+func (m *QueueView) View() string {
+	headerStyle := lipgloss.NewStyle().
+		Background(backgroundColor).
+		Foreground(subtleColor).
+		Padding(0, 1).
+		Bold(true)
+	headerView := headerStyle.Width(m.width).Render("📧 CLMAIL - Queue")
+
+	contentStyle := lipgloss.NewStyle().
+		Border(lipgloss.RoundedBorder()).
+		BorderForeground(subtleColor).
+		Padding(0, 1).
+		Height(m.height - 4).
+		Width(m.width - 2)
+
+	statusStyle := lipgloss.NewStyle().
+		Background(backgroundColor).
+		Foreground(highlightColor).
+		Padding(0, 1)
+
+	keys := "r: retry • d: drop • u: drop and undo • esc: back"
+	var status string
+	switch {
+	case m.errorMsg != "":
+		status = errorStyle.Render("⚠️ " + m.errorMsg)
+	case m.infoMsg != "":
+		status = "✅ " + m.infoMsg + " • " + keys
+	default:
+		status = keys
+	}
+
+	return lipgloss.JoinVertical(
+		lipgloss.Left,
+		headerView,
+		contentStyle.Render(m.content()),
+		statusStyle.Width(m.width).Render(status),
+	)
+}
+
+func (m *QueueView) content() string {
+	if m.loading {
+		return "Loading..."
+	}
+	if len(m.actions) == 0 {
+		return "Nothing is waiting for the server"
+	}
+
+	var b strings.Builder
+
+	// one action takes three lines
+	visible := max((m.height-6)/3, 1)
+	start := max(min(m.selected-visible/2, len(m.actions)-visible), 0)
+	end := min(start+visible, len(m.actions))
+
+	for i := start; i < end; i++ {
+		action := m.actions[i]
+
+		line := fmt.Sprintf("%s  %s", describeAction(action), blurredStyle.Render(m.accounts[action.AccountID]))
+		if i == m.selected {
+			b.WriteString(focusedStyle.Bold(true).Render("> ") + line + "\n")
+		} else {
+			b.WriteString("  " + line + "\n")
+		}
+
+		state := fmt.Sprintf("queued %s", action.CreatedAt.Local().Format("Jan 2 15:04"))
+		switch action.Status {
+		case actions.StatusFailed:
+			state = errorStyle.Render(fmt.Sprintf("failed after %d attempts", action.Attempts))
+		case actions.StatusConflict:
+			state = errorStyle.Render("conflict with the server")
+		default:
+			if action.Attempts > 0 {
+				state += fmt.Sprintf(", %d attempts", action.Attempts)
+			}
+		}
+		if action.LastError != "" {
+			state += blurredStyle.Render(" • " + action.LastError)
+		}
+		b.WriteString("    " + state + "\n\n")
+	}
+
+	return b.String()
+}
+
+func describeAction(action db.PendingAction) string {
+	switch action.Kind {
+	case actions.KindFlag:
+		verb := "Mark"
+		if !action.Value {
+			verb = "Unmark"
+		}
+		return fmt.Sprintf("%s %s %q", verb, strings.TrimPrefix(action.Target, "\\"), action.Summary)
+	case actions.KindMove:
+		return fmt.Sprintf("Move %q from %s to %s", action.Summary, action.Folder, action.Target)
+	case actions.KindDelete:
+		return fmt.Sprintf("Delete %q from %s", action.Summary, action.Folder)
+	case actions.KindSend:
+		return fmt.Sprintf("Send %q", action.Summary)
+	case actions.KindSending:
+		return fmt.Sprintf("Send %q, maybe sent already", action.Summary)
+	case actions.KindAppend:
+		return fmt.Sprintf("Save %q to sent", action.Summary)
+	case actions.KindCreateFolder:
+		return "Create folder " + action.Folder
+	case actions.KindRenameFolder:
+		return fmt.Sprintf("Rename folder %s to %s", action.Folder, action.Target)
+	case actions.KindDeleteFolder:
+		return "Delete folder " + action.Folder
+	}
+	return action.Kind
+}
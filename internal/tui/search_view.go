@@ -0,0 +1,556 @@
+package tui
+
+import (
+	"context"
+	"fmt"
+	"github.com/charmbracelet/bubbles/textinput"
+	"github.com/charmbracelet/bubbles/viewport"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/rexxDigital/clmail/internal/accounts"
+	"github.com/rexxDigital/clmail/internal/db"
+	"github.com/rexxDigital/clmail/internal/search"
+	"strings"
+)
+
+const searchResultLimit = 200
+
+// where a search runs besides the local index
+const (
+	scopeLocal = iota
+	scopeServerFolder
+	scopeServerAll
+	scopeCount
+)
+
+type SearchView struct {
+	dbClient        *db.Client
+	account         *db.Account
+	folder          *db.Folder
+	scope           int
+	input           textinput.Model
+	nameInput       textinput.Model
+	naming          bool
+	results         []search.Result
+	selectedResult  int
+	inputFocused    bool
+	searching       bool
+	searchingServer bool
+	errorMsg        string
+	infoMsg         string
+	resultsViewport viewport.Model
+	contentViewport viewport.Model
+	width           int
+	height          int
+}
+
+type searchResultsMsg struct {
+	results []search.Result
+	err     error
+}
+
+type serverSearchResultsMsg struct {
+	results []search.Result
+	err     error
+}
+
+type searchSavedMsg struct {
+	name string
+	err  error
+}
+
+func NewSearchView(width, height int, account *db.Account, folder *db.Folder, dbClient *db.Client) *SearchView {
+	input := textinput.New()
+	input.Placeholder = `from:bob has:attachment after:30d "quarterly report"`
+	input.Prompt = "/ "
+	input.PromptStyle = focusedStyle
+	input.Focus()
+
+	nameInput := textinput.New()
+	nameInput.Placeholder = "Name for this search, e.g. unread from boss"
+	nameInput.Prompt = "Save as: "
+	nameInput.PromptStyle = focusedStyle
+
+	searchView := &SearchView{
+		dbClient:        dbClient,
+		account:         account,
+		folder:          folder,
+		input:           input,
+		nameInput:       nameInput,
+		inputFocused:    true,
+		resultsViewport: viewport.New(0, 0),
+		contentViewport: viewport.New(0, 0),
+		width:           width,
+		height:          height,
+	}
+
+	searchView.resize()
+
+	return searchView
+}
+
+func (m *SearchView) Init() tea.Cmd {
+	return textinput.Blink
+}
+
+func (m *SearchView) HandleWindowSizeMsg(msg tea.WindowSizeMsg) {
+	m.width = msg.Width
+	m.height = msg.Height
+	m.resize()
+}
+
+func (m *SearchView) resize() {
+	resultsWidth := min(60, m.width/3)
+	contentWidth := m.width - resultsWidth - 4
+
+	// header, search input, status bar and borders
+	viewportHeight := m.height - 8
+
+	m.input.Width = m.width - 6
+	m.nameInput.Width = m.width - 16
+	m.resultsViewport.Width = resultsWidth - 4
+	m.resultsViewport.Height = viewportHeight
+	m.contentViewport.Width = contentWidth - 4
+	m.contentViewport.Height = viewportHeight
+
+	m.updateResultsViewport()
+	m.updateContentViewport()
+}
+
+func (m *SearchView) Update(message tea.Msg) (tea.Model, tea.Cmd) {
+	var cmd tea.Cmd
+
+	switch msg := message.(type) {
+	case tea.WindowSizeMsg:
+		m.HandleWindowSizeMsg(msg)
+		return m, nil
+	case searchResultsMsg:
+		m.searching = false
+		if msg.err != nil {
+			m.errorMsg = msg.err.Error()
+			return m, nil
+		}
+		m.errorMsg = ""
+		m.infoMsg = ""
+		m.results = msg.results
+		m.selectedResult = 0
+		m.resultsViewport.GotoTop()
+		m.updateResultsViewport()
+		m.updateContentViewport()
+		if len(m.results) > 0 {
+			m.inputFocused = false
+			m.input.Blur()
+		}
+		if m.scope != scopeLocal {
+			m.searchingServer = true
+			return m, m.runServerSearch(m.input.Value())
+		}
+		return m, nil
+	case serverSearchResultsMsg:
+		m.searchingServer = false
+		if msg.err != nil {
+			m.errorMsg = msg.err.Error()
+			return m, nil
+		}
+		m.results = search.Merge(m.results, msg.results)
+		m.updateResultsViewport()
+		m.updateContentViewport()
+		return m, nil
+	case exportedMsg:
+		if msg.err != nil {
+			m.errorMsg = msg.String()
+			return m, nil
+		}
+		m.errorMsg = ""
+		m.infoMsg = msg.String()
+		return m, nil
+	case searchSavedMsg:
+		if msg.err != nil {
+			m.errorMsg = msg.err.Error()
+			return m, nil
+		}
+		m.errorMsg = ""
+		m.infoMsg = fmt.Sprintf("Saved search %q, it shows up under your folders", msg.name)
+		return m, nil
+	case tea.KeyMsg:
+		if m.naming {
+			return m, m.updateNaming(msg)
+		}
+
+		switch msg.String() {
+		case "ctrl+c":
+			return m, tea.Quit
+		case "ctrl+s":
+			if strings.TrimSpace(m.input.Value()) == "" {
+				m.errorMsg = "nothing to save, type a search first"
+				return m, nil
+			}
+			if _, err := search.Parse(m.input.Value()); err != nil {
+				m.errorMsg = err.Error()
+				return m, nil
+			}
+			m.naming = true
+			m.input.Blur()
+			return m, m.nameInput.Focus()
+		case "ctrl+e":
+			query, err := search.Parse(m.input.Value())
+			if err != nil {
+				m.errorMsg = err.Error()
+				return m, nil
+			}
+			if m.account == nil || query.IsEmpty() {
+				m.errorMsg = "nothing to export, search an account first"
+				return m, nil
+			}
+			accountID := m.account.ID
+			return m, exportMbox(m.dbClient, "search", func(ctx context.Context) ([]int64, error) {
+				return search.EmailIDs(ctx, m.dbClient, accountID, query)
+			})
+		case "esc":
+			return m, func() tea.Msg {
+				return SwitchViewMsg{ViewName: "home"}
+			}
+		case "ctrl+r":
+			m.scope = (m.scope + 1) % scopeCount
+			if m.scope == scopeServerFolder && m.folder == nil {
+				m.scope = scopeServerAll
+			}
+			return m, nil
+		case "tab", "shift+tab":
+			m.inputFocused = !m.inputFocused
+			if m.inputFocused {
+				return m, m.input.Focus()
+			}
+			m.input.Blur()
+			return m, nil
+		case "enter":
+			if m.inputFocused {
+				m.searching = true
+				return m, m.runSearch(m.input.Value())
+			}
+		}
+
+		if m.inputFocused {
+			m.input, cmd = m.input.Update(msg)
+			return m, cmd
+		}
+
+		switch msg.String() {
+		case "/":
+			m.inputFocused = true
+			return m, m.input.Focus()
+		case "up", "k":
+			if m.selectedResult > 0 {
+				m.selectedResult--
+				m.updateResultsViewport()
+				m.updateContentViewport()
+			}
+		case "down", "j":
+			if m.selectedResult < len(m.results)-1 {
+				m.selectedResult++
+				m.updateResultsViewport()
+				m.updateContentViewport()
+			}
+		default:
+			// let the content viewport handle scrolling keys like pgup/pgdown
+			m.contentViewport, cmd = m.contentViewport.Update(msg)
+			return m, cmd
+		}
+		return m, nil
+	}
+
+	if m.inputFocused {
+		m.input, cmd = m.input.Update(message)
+	}
+
+	return m, cmd
+}
+
+func (m *SearchView) View() string {
+	resultsWidth := min(60, m.width/3)
+	contentWidth := m.width - resultsWidth - 4
+	availableHeight := m.height - 6
+
+	headerStyle := lipgloss.NewStyle().
+		Background(backgroundColor).
+		Foreground(subtleColor).
+		Padding(0, 1).
+		Bold(true)
+
+	header := "📧 CLMAIL - Search"
+	if m.account != nil {
+		header = fmt.Sprintf("📧 CLMAIL - Search %s", m.account.Email)
+	}
+	headerView := headerStyle.Width(m.width).Render(header)
+
+	inputStyle := lipgloss.NewStyle().
+		Border(lipgloss.RoundedBorder()).
+		BorderForeground(subtleColor).
+		Width(m.width - 2)
+	if m.inputFocused {
+		inputStyle = inputStyle.BorderForeground(highlightColor)
+	}
+	inputView := inputStyle.Render(m.input.View())
+	if m.naming {
+		inputView = inputStyle.Render(m.nameInput.View())
+	}
+
+	resultsStyle := lipgloss.NewStyle().
+		Border(lipgloss.RoundedBorder()).
+		BorderForeground(subtleColor).
+		Height(availableHeight - 3).
+		Width(resultsWidth)
+	if !m.inputFocused {
+		resultsStyle = resultsStyle.BorderForeground(highlightColor)
+	}
+
+	contentStyle := lipgloss.NewStyle().
+		Border(lipgloss.RoundedBorder()).
+		BorderForeground(subtleColor).
+		Height(availableHeight - 3).
+		Width(contentWidth)
+
+	mainView := lipgloss.JoinHorizontal(
+		lipgloss.Top,
+		resultsStyle.Render(m.resultsViewport.View()),
+		contentStyle.Render(m.contentViewport.View()),
+	)
+
+	statusStyle := lipgloss.NewStyle().
+		Background(backgroundColor).
+		Foreground(highlightColor).
+		Padding(0, 1)
+
+	var status string
+	switch {
+	case m.searching:
+		status = "⏳ Searching..."
+	case m.searchingServer:
+		status = fmt.Sprintf("⏳ %d local results • searching %s...", len(m.results), m.scopeName())
+	case m.naming:
+		status = "💾 enter: save • esc: cancel"
+	case m.errorMsg != "":
+		status = errorStyle.Render("✗ " + m.errorMsg)
+	case m.infoMsg != "":
+		status = "✓ " + m.infoMsg
+	default:
+		status = fmt.Sprintf("🔍 %d results in %s • enter: search • ctrl+r: scope • ctrl+s: save • ctrl+e: export • tab: results/input • j/k: navigate • esc: back • "+
+			"from: to: subject: has:attachment is:unread is:starred before: after: folder: -negate",
+			len(m.results), m.scopeName())
+	}
+	statusView := statusStyle.Width(m.width).Render(status)
+
+	return lipgloss.JoinVertical(
+		lipgloss.Left,
+		headerView,
+		inputView,
+		mainView,
+		statusView,
+	)
+}
+
+func (m *SearchView) runSearch(input string) tea.Cmd {
+	return func() tea.Msg {
+		if m.account == nil {
+			return searchResultsMsg{err: fmt.Errorf("no account selected")}
+		}
+
+		query, err := search.Parse(input)
+		if err != nil {
+			return searchResultsMsg{err: err}
+		}
+
+		if query.IsEmpty() {
+			return searchResultsMsg{}
+		}
+
+		results, err := search.Search(context.Background(), m.dbClient, search.Params{
+			AccountID: m.account.ID,
+			Query:     query,
+			Limit:     searchResultLimit,
+		})
+
+		return searchResultsMsg{results: results, err: err}
+	}
+}
+
+// updateNaming handles keys while the user types a name for the saved search
+func (m *SearchView) updateNaming(msg tea.KeyMsg) tea.Cmd {
+	switch msg.String() {
+	case "esc":
+		m.naming = false
+		m.nameInput.Blur()
+		m.nameInput.SetValue("")
+		m.inputFocused = true
+		return m.input.Focus()
+	case "enter":
+		name := strings.TrimSpace(m.nameInput.Value())
+		if name == "" {
+			return nil
+		}
+		m.naming = false
+		m.nameInput.Blur()
+		m.nameInput.SetValue("")
+		m.inputFocused = true
+		return tea.Batch(m.input.Focus(), m.saveSearch(name, m.input.Value()))
+	}
+
+	var cmd tea.Cmd
+	m.nameInput, cmd = m.nameInput.Update(msg)
+	return cmd
+}
+
+func (m *SearchView) saveSearch(name, query string) tea.Cmd {
+	return func() tea.Msg {
+		if m.account == nil {
+			return searchSavedMsg{err: fmt.Errorf("no account selected")}
+		}
+
+		_, err := m.dbClient.CreateSavedSearch(context.Background(), db.CreateSavedSearchParams{
+			AccountID: m.account.ID,
+			Name:      name,
+			Query:     query,
+		})
+		if err != nil {
+			return searchSavedMsg{err: fmt.Errorf("failed to save search: %w", err)}
+		}
+
+		return searchSavedMsg{name: name}
+	}
+}
+
+// runServerSearch repeats the search on the imap server for mail we have not downloaded yet
+func (m *SearchView) runServerSearch(input string) tea.Cmd {
+	return func() tea.Msg {
+		query, err := search.Parse(input)
+		if err != nil || query.IsEmpty() {
+			return serverSearchResultsMsg{err: err}
+		}
+
+		password, err := accounts.PasswordFor(*m.account)
+		if err != nil {
+			return serverSearchResultsMsg{err: fmt.Errorf("failed to get password: %w", err)}
+		}
+
+		params := search.ServerParams{
+			Account:  *m.account,
+			Password: password,
+			Query:    query,
+		}
+		if m.scope == scopeServerFolder {
+			params.Folders = []db.Folder{*m.folder}
+		}
+
+		results, err := search.SearchServer(context.Background(), m.dbClient, params)
+
+		return serverSearchResultsMsg{results: results, err: err}
+	}
+}
+
+func (m *SearchView) scopeName() string {
+	switch m.scope {
+	case scopeServerFolder:
+		return "local + server " + m.folder.Name
+	case scopeServerAll:
+		return "local + server all folders"
+	}
+	return "local"
+}
+
+func (m *SearchView) updateResultsViewport() {
+	content := strings.Builder{}
+	content.WriteString(lipgloss.NewStyle().Bold(true).Render("Results") + "\n\n")
+
+	if len(m.results) == 0 {
+		content.WriteString(blurredStyle.Render("Nothing found yet"))
+	}
+
+	for i, result := range m.results {
+		from := result.FromAddress
+		if result.FromName.Valid {
+			from = result.FromName.String
+		}
+
+		item := fmt.Sprintf("%s  %s\n%s\n%s",
+			truncateString(from, 24),
+			blurredStyle.Render(result.FolderName),
+			renderHighlights(result.HighlightedSubject),
+			result.ReceivedDate.Format("2006-01-02 15:04"))
+
+		if snippet := strings.Join(strings.Fields(result.Snippet), " "); snippet != "" {
+			item += "\n" + renderHighlights(snippet)
+		}
+
+		style := lipgloss.NewStyle().
+			Bold(true).
+			BorderBottom(true).
+			BorderStyle(lipgloss.MarkdownBorder()).
+			Width(m.resultsViewport.Width)
+
+		if i == m.selectedResult {
+			content.WriteString(style.Foreground(specialColor).Render("> "+item) + "\n\n")
+		} else {
+			content.WriteString(style.Foreground(subtleColor).Render(item) + "\n\n")
+		}
+	}
+
+	m.resultsViewport.SetContent(content.String())
+}
+
+func (m *SearchView) updateContentViewport() {
+	if len(m.results) == 0 {
+		m.contentViewport.SetContent("No email selected")
+		return
+	}
+
+	email := m.results[m.selectedResult]
+
+	bodyText := "No body text available"
+	if email.BodyText.Valid {
+		bodyText = email.BodyText.String
+	}
+
+	headerLines := []string{
+		fmt.Sprintf("From: %s", email.FromAddress),
+		fmt.Sprintf("To: %s", email.ToAddresses),
+		fmt.Sprintf("Date: %s", email.ReceivedDate.Format("2006-01-02 15:04")),
+		fmt.Sprintf("Folder: %s", email.FolderName),
+	}
+
+	content := strings.Builder{}
+	content.WriteString(lipgloss.NewStyle().Bold(true).Foreground(highlightColor).Render(email.Subject))
+	content.WriteString("\n\n")
+	content.WriteString(lipgloss.NewStyle().Foreground(subtleColor).Render(strings.Join(headerLines, "\n")))
+	content.WriteString("\n\n")
+	content.WriteString(strings.Repeat("─", min(m.contentViewport.Width-6, 50)))
+	content.WriteString("\n\n")
+	content.WriteString(bodyText)
+
+	m.contentViewport.SetContent(content.String())
+	m.contentViewport.GotoTop()
+}
+
+// renderHighlights swaps the search highlight markers for colored text
+func renderHighlights(s string) string {
+	highlightStyle := lipgloss.NewStyle().Foreground(highlightColor).Underline(true)
+
+	var out strings.Builder
+	for {
+		start := strings.Index(s, search.HighlightStart)
+		if start == -1 {
+			break
+		}
+		end := strings.Index(s[start:], search.HighlightEnd)
+		if end == -1 {
+			break
+		}
+		end += start
+
+		out.WriteString(s[:start])
+		out.WriteString(highlightStyle.Render(s[start+len(search.HighlightStart) : end]))
+		s = s[end+len(search.HighlightEnd):]
+	}
+	out.WriteString(strings.ReplaceAll(strings.ReplaceAll(s, search.HighlightStart, ""), search.HighlightEnd, ""))
+
+	return out.String()
+}
@@ -1,13 +1,13 @@
 package tui
 
 import (
+	"context"
 	"fmt"
 	"github.com/charmbracelet/bubbles/textarea"
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/lipgloss"
-	"github.com/rexxDigital/clmail/internal/accounts"
 	"github.com/rexxDigital/clmail/internal/db"
-	"github.com/rexxDigital/clmail/internal/smtp"
+	"github.com/rexxDigital/clmail/internal/services/actions"
 	"github.com/rexxDigital/clmail/types"
 	"math/rand"
 	"strings"
@@ -331,6 +331,7 @@ func (m *SendView) View() string {
 
 }
 
+// sendMail queues the mail, it goes out in the background as soon as the server can be reached
 func (m *SendView) sendMail() tea.Cmd {
 	return func() tea.Msg {
 		if err := m.validateForm(); err != nil {
@@ -340,8 +341,6 @@ func (m *SendView) sendMail() tea.Cmd {
 			}
 		}
 
-		password, _ := accounts.GetPassword(m.account.Email)
-
 		domain := strings.Split(m.account.Email, "@")
 		if len(domain) != 2 {
 			domain[1] = "localhost"
@@ -354,7 +353,7 @@ func (m *SendView) sendMail() tea.Cmd {
 
 		referenceList := m.createReferenceList()
 
-		err := smtp.SendMail(types.Mail{
+		err := actions.Send(context.Background(), m.dbClient, *m.account, types.Mail{
 			MessageID:  messageID,
 			References: referenceList,
 			To:         m.toArea.Value(),
@@ -363,7 +362,7 @@ func (m *SendView) sendMail() tea.Cmd {
 			Body:       m.bodyArea.Value(),
 			Date:       date,
 			InReplyTo:  m.InReplyTo,
-		}, m.account, password, m.dbClient)
+		})
 		if err != nil {
 			return mailSendMsg{
 				success: false,
@@ -3,7 +3,9 @@ package tui
 // huge thanks to the examples at bubble teas GitHub!
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"github.com/charmbracelet/bubbles/cursor"
 	"github.com/charmbracelet/bubbles/help"
@@ -13,9 +15,14 @@ import (
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/lipgloss"
 	"github.com/rexxDigital/clmail/internal/accounts"
+	"github.com/rexxDigital/clmail/internal/autoconfig"
 	"github.com/rexxDigital/clmail/internal/db"
+	"github.com/rexxDigital/clmail/internal/imap"
+	"github.com/rexxDigital/clmail/internal/secrets"
+	"log"
 	"strconv"
 	"strings"
+	"time"
 )
 
 type keyMap struct {
@@ -70,6 +77,12 @@ type accountCreatedMsg struct {
 	err     error
 }
 
+type autoconfigMsg struct {
+	email  string
+	config *autoconfig.Config
+	err    error
+}
+
 type SetupView struct {
 	inputs     []textinput.Model
 	paginator  paginator.Model
@@ -90,11 +103,21 @@ type SetupView struct {
 	height     int
 	errorMsg   string
 	dbClient   *db.Client
+
+	discoverer    *autoconfig.Discoverer
+	discovering   bool
+	discoveredFor string
+	discovered    *autoconfig.Config
+	oauthRunning  bool
+
+	// the encrypted secrets file has to be unlocked before the first password goes in
+	unlocking  bool
+	passphrase textinput.Model
 }
 
 func NewSetupView(width, height int, dbClient *db.Client) *SetupView {
 	// Create text inputs
-	inputs := make([]textinput.Model, 6)
+	inputs := make([]textinput.Model, 9)
 
 	// Email
 	inputs[0] = textinput.New()
@@ -128,6 +151,26 @@ func NewSetupView(width, height int, dbClient *db.Client) *SetupView {
 	inputs[5].Placeholder = "SMTP Port"
 	inputs[5].Width = 30
 
+	// how to connect to the imap server, left empty it's guessed from the port
+	inputs[6] = textinput.New()
+	inputs[6].Placeholder = "IMAP Security (tls, starttls, none)"
+	inputs[6].Width = 30
+
+	// where the password is kept, the keyring when left empty
+	inputs[7] = textinput.New()
+	inputs[7].Placeholder = "Password storage (keyring, command, file)"
+	inputs[7].Width = 30
+
+	// only used with the command storage, e.g. pass show mail/work
+	inputs[8] = textinput.New()
+	inputs[8].Placeholder = "Password command"
+	inputs[8].Width = 30
+
+	passphrase := textinput.New()
+	passphrase.Placeholder = "Master passphrase"
+	passphrase.EchoMode = textinput.EchoPassword
+	passphrase.Width = 30
+
 	p := paginator.New()
 	p.Type = paginator.Dots
 	p.PerPage = 2
@@ -149,6 +192,8 @@ func NewSetupView(width, height int, dbClient *db.Client) *SetupView {
 		width:      width,
 		height:     height,
 		dbClient:   dbClient,
+		discoverer: autoconfig.NewDiscoverer(),
+		passphrase: passphrase,
 	}
 }
 
@@ -162,6 +207,10 @@ func (m *SetupView) Update(message tea.Msg) (tea.Model, tea.Cmd) {
 		m.HandleWindowSizeMsg(msg)
 		return m, nil
 	case tea.KeyMsg:
+		if m.unlocking {
+			return m, m.updatePassphrase(msg)
+		}
+
 		switch {
 		case key.Matches(msg, m.keys.Quit):
 			return m, tea.Quit
@@ -183,6 +232,7 @@ func (m *SetupView) Update(message tea.Msg) (tea.Model, tea.Cmd) {
 					m.paginator.NextPage()
 					// Focus the first input on the new page
 					m.focusIndex = (currentPage + 1) * inputsPerPage
+
 				} else if currentPage == m.paginator.TotalPages-1 && m.focusIndex == len(m.inputs) {
 					// This is the final submit button on the last page
 					return m, m.createAccount()
@@ -201,6 +251,11 @@ func (m *SetupView) Update(message tea.Msg) (tea.Model, tea.Cmd) {
 					}
 				}
 
+				// we know the email once the first page is done, look up the servers while they type
+				if currentPage == 0 {
+					cmds = append(cmds, m.discover())
+				}
+
 				return m, tea.Batch(cmds...)
 			}
 
@@ -241,6 +296,8 @@ func (m *SetupView) Update(message tea.Msg) (tea.Model, tea.Cmd) {
 
 			return m, tea.Batch(cmds...)
 
+		case msg.String() == "ctrl+o":
+			return m, m.createOAuthAccount()
 		case key.Matches(msg, m.keys.Help):
 			m.showHelp = !m.showHelp
 			// This is the crucial part - set the help mode based on showHelp
@@ -252,11 +309,30 @@ func (m *SetupView) Update(message tea.Msg) (tea.Model, tea.Cmd) {
 
 			return m, nil
 		}
+	case autoconfigMsg:
+		// ignore lookups for an email they changed in the meantime
+		if msg.email != m.discoveredFor {
+			return m, nil
+		}
+		m.discovering = false
+		if msg.err != nil {
+			log.Printf("Failed to discover mail servers for %s: %v", msg.email, msg.err)
+			return m, nil
+		}
+		m.discovered = msg.config
+		m.prefillServers(msg.config)
+		return m, nil
 	case accountCreatedMsg:
+		m.oauthRunning = false
 		if msg.success {
 			return m, func() tea.Msg {
 				return SwitchViewMsg{ViewName: "home"}
 			}
+		} else if errors.Is(msg.err, secrets.ErrLocked) {
+			m.unlocking = true
+			m.errorMsg = ""
+			m.passphrase.SetValue("")
+			return m, m.passphrase.Focus()
 		} else {
 			m.errorMsg = msg.err.Error()
 			return m, nil
@@ -301,6 +377,12 @@ func (m *SetupView) View() string {
 		}
 	}
 
+	if m.unlocking {
+		form = "Enter the master passphrase of the secrets file.\n" +
+			blurredStyle.Render("If there is no file yet it will be created with this passphrase.") + "\n\n" +
+			m.passphrase.View() + "\n"
+	}
+
 	currentPage := m.paginator.Page
 	isLastPage := currentPage == m.paginator.TotalPages-1
 	inputsPerPage := m.paginator.PerPage
@@ -324,6 +406,18 @@ func (m *SetupView) View() string {
 	}
 	fmt.Fprintf(&btn, "\n\n%s\n\n", *button)
 
+	if m.oauthRunning {
+		form += "\n" + blurredStyle.Render("Finish signing in in your browser...") + "\n"
+	} else if currentPage == 0 {
+		form += "\n" + blurredStyle.Render("ctrl+o: sign in with OAuth instead of a password") + "\n"
+	}
+
+	if m.discovering {
+		form += "\n" + blurredStyle.Render("Looking up server settings...") + "\n"
+	} else if m.discovered != nil && currentPage > 0 {
+		form += "\n" + blurredStyle.Render("Server settings found via "+m.discovered.Source+", change them if needed") + "\n"
+	}
+
 	var errorMsgBuilder strings.Builder
 
 	if m.errorMsg != "" {
@@ -356,25 +450,141 @@ func (m *SetupView) HandleWindowSizeMsg(msg tea.WindowSizeMsg) {
 	m.height = msg.Height
 }
 
+func (m *SetupView) discover() tea.Cmd {
+	email := strings.TrimSpace(m.inputs[0].Value())
+	if email == "" || email == m.discoveredFor {
+		return nil
+	}
+
+	m.discoveredFor = email
+	m.discovered = nil
+	m.discovering = true
+
+	return func() tea.Msg {
+		ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+		defer cancel()
+
+		config, err := m.discoverer.Discover(ctx, email)
+		return autoconfigMsg{email: email, config: config, err: err}
+	}
+}
+
+// prefillServers fills the server inputs with what autoconfig found, anything typed already wins
+func (m *SetupView) prefillServers(config *autoconfig.Config) {
+	values := map[int]string{
+		2: config.Imap.Hostname,
+		3: strconv.FormatInt(config.Imap.Port, 10),
+		4: config.Smtp.Hostname,
+		5: strconv.FormatInt(config.Smtp.Port, 10),
+		6: securityFromSocketType(config.Imap.SocketType),
+	}
+
+	for i, value := range values {
+		if m.inputs[i].Value() == "" {
+			m.inputs[i].SetValue(value)
+		}
+	}
+}
+
+// discoveredUsername returns the username autoconfig found when the server wasn't changed by hand
+func (m *SetupView) discoveredUsername(server autoconfig.Server, host string) string {
+	if m.discovered == nil || server.Username == "" || !strings.EqualFold(server.Hostname, host) {
+		return strings.Split(m.inputs[0].Value(), "@")[0]
+	}
+	return server.Username
+}
+
+func securityFromSocketType(socketType string) string {
+	switch socketType {
+	case autoconfig.SocketSTARTTLS:
+		return imap.SecurityStartTLS
+	case autoconfig.SocketPlain:
+		return imap.SecurityNone
+	}
+	return imap.SecurityTLS
+}
+
+// imapSecurity is what they typed, otherwise 143 means starttls and anything else implicit tls
+func (m *SetupView) imapSecurity(port int64) string {
+	if security := strings.ToLower(strings.TrimSpace(m.inputs[6].Value())); security != "" {
+		return security
+	}
+	if port == 143 {
+		return imap.SecurityStartTLS
+	}
+	return imap.SecurityTLS
+}
+
 func (m *SetupView) getFormData() *db.CreateAccountParams {
+	imapPort := parsePort(m.inputs[3].Value(), 993)
+	security := m.imapSecurity(imapPort)
+
+	var imapServer, smtpServer autoconfig.Server
+	if m.discovered != nil {
+		imapServer = m.discovered.Imap
+		smtpServer = m.discovered.Smtp
+	}
+
+	command := strings.TrimSpace(m.inputs[8].Value())
+	passwordCommand := sql.NullString{String: command, Valid: command != ""}
+
 	return &db.CreateAccountParams{
 		Name:                   m.inputs[0].Value(),
 		DisplayName:            m.inputs[0].Value(),
 		Email:                  m.inputs[0].Value(),
 		ImapServer:             m.inputs[2].Value(),
-		ImapPort:               parsePort(m.inputs[3].Value(), 993),
-		ImapUsername:           strings.Split(m.inputs[0].Value(), "@")[0],
-		ImapUseSsl:             true,
+		ImapPort:               imapPort,
+		ImapUsername:           m.discoveredUsername(imapServer, m.inputs[2].Value()),
+		ImapUseSsl:             security == imap.SecurityTLS,
 		ImapAuthMethod:         "plain",
+		ImapSecurity:           security,
 		SmtpServer:             m.inputs[4].Value(),
 		SmtpPort:               parsePort(m.inputs[5].Value(), 587),
-		SmtpUsername:           strings.Split(m.inputs[0].Value(), "@")[0],
+		SmtpUsername:           m.discoveredUsername(smtpServer, m.inputs[4].Value()),
 		SmtpUseTls:             true,
 		SmtpAuthMethod:         "plain",
+		SecretStore:            m.secretStore(),
+		PasswordCommand:        passwordCommand,
 		RefreshIntervalMinutes: 5,
-		Signature:              sql.NullString{},
-		IsDefault:              true,
+		BodySizeLimitKb:        1024,
+		// bodies are kept until a policy is set in the account settings
+		BodyRetentionDays: 0,
+		BodyRetentionMb:   0,
+		Signature:         sql.NullString{},
+		IsDefault:         true,
+	}
+}
+
+// updatePassphrase handles the master passphrase prompt, enter unlocks the file and tries again
+func (m *SetupView) updatePassphrase(msg tea.KeyMsg) tea.Cmd {
+	switch msg.String() {
+	case "esc":
+		m.unlocking = false
+		m.passphrase.Blur()
+		m.errorMsg = "The secrets file is locked, pick another password storage"
+		return nil
+	case "enter":
+		if err := secrets.UnlockFile(m.passphrase.Value()); err != nil {
+			m.errorMsg = err.Error()
+			return nil
+		}
+		m.unlocking = false
+		m.passphrase.Blur()
+		m.errorMsg = ""
+		return m.createAccount()
 	}
+
+	var cmd tea.Cmd
+	m.passphrase, cmd = m.passphrase.Update(msg)
+	return cmd
+}
+
+// secretStore is the storage they typed, the keyring when left empty
+func (m *SetupView) secretStore() string {
+	if store := strings.ToLower(strings.TrimSpace(m.inputs[7].Value())); store != "" {
+		return store
+	}
+	return secrets.BackendKeyring
 }
 
 func (m *SetupView) updateInputs(msg tea.Msg) tea.Cmd {
@@ -401,6 +611,46 @@ func (m *SetupView) createAccount() tea.Cmd {
 	}
 }
 
+// createOAuthAccount signs in through the browser instead of asking for a password
+func (m *SetupView) createOAuthAccount() tea.Cmd {
+	if m.oauthRunning {
+		return nil
+	}
+
+	if strings.TrimSpace(m.inputs[0].Value()) == "" {
+		m.errorMsg = "Enter your email first"
+		return nil
+	}
+
+	if m.discovered != nil {
+		m.prefillServers(m.discovered)
+	}
+	if m.inputs[2].Value() == "" || m.inputs[4].Value() == "" {
+		if m.discovering {
+			m.errorMsg = "Still looking up the server settings, try again in a moment"
+		} else {
+			m.errorMsg = "Enter the IMAP and SMTP servers first"
+		}
+		return tea.Batch(m.discover())
+	}
+
+	m.errorMsg = ""
+	m.oauthRunning = true
+
+	params := *m.getFormData()
+	return func() tea.Msg {
+		// give them a few minutes to get through the consent screens
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+		defer cancel()
+
+		if err := accounts.CreateOAuthAccount(ctx, params, m.dbClient); err != nil {
+			return accountCreatedMsg{success: false, err: err}
+		}
+
+		return accountCreatedMsg{success: true}
+	}
+}
+
 func parsePort(port string, defaultPort int64) int64 {
 	if port == "" {
 		return defaultPort
@@ -0,0 +1,228 @@
+package tui
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/rexxDigital/clmail/internal/archive"
+	"github.com/rexxDigital/clmail/internal/db"
+	"github.com/rexxDigital/clmail/internal/services/sync"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode"
+)
+
+// what the content panel shows of the open email
+const (
+	showBody = iota
+	showHeaders
+	showSource
+)
+
+// more of a source than this isn't put on screen, saving it gets all of it
+const maxSourceView = 512 * 1024
+
+// messageSource is the header and source of the email the content panel shows them for
+type messageSource struct {
+	emailID int64
+	header  []byte
+	raw     []byte
+	err     error
+}
+
+type sourceLoadedMsg struct {
+	emailID    int64
+	headerOnly bool
+	raw        []byte
+	err        error
+}
+
+// toggleSource goes from the message to its full header, to its source and back
+func (m *HomeView) toggleSource() tea.Cmd {
+	if len(m.selectedThread) == 0 {
+		return nil
+	}
+
+	email := m.selectedThread[m.selectedEmail]
+	if m.source.emailID != email.ID {
+		m.source = messageSource{emailID: email.ID}
+		m.contentMode = showBody
+	}
+	m.source.err = nil
+	m.contentMode = (m.contentMode + 1) % 3
+
+	m.contentViewport.SetYOffset(0)
+	m.updateContentViewport()
+
+	switch {
+	case m.contentMode == showHeaders && m.source.header == nil:
+		return m.loadSource(email, true)
+	case m.contentMode == showSource && m.source.raw == nil:
+		return m.loadSource(email, false)
+	}
+	return nil
+}
+
+// loadSource reads the header or source from the database, the server is only asked for what
+// was never stored
+func (m *HomeView) loadSource(email db.Email, headerOnly bool) tea.Cmd {
+	syncer := m.syncerOf(email.AccountID)
+
+	return func() tea.Msg {
+		ctx := context.Background()
+
+		var raw []byte
+		var err error
+		if headerOnly {
+			raw, err = m.dbClient.Header(ctx, email.ID)
+		} else {
+			raw, err = m.dbClient.Source(ctx, email.ID)
+		}
+		if errors.Is(err, sql.ErrNoRows) {
+			raw, err = fetchSource(syncer, email, headerOnly)
+		}
+
+		return sourceLoadedMsg{emailID: email.ID, headerOnly: headerOnly, raw: raw, err: err}
+	}
+}
+
+func (m *HomeView) handleSourceLoaded(msg sourceLoadedMsg) {
+	if msg.emailID != m.source.emailID {
+		return
+	}
+
+	switch {
+	case msg.err != nil:
+		m.source.err = msg.err
+	case msg.headerOnly:
+		m.source.header = msg.raw
+	default:
+		m.source.raw = msg.raw
+		if m.source.header == nil {
+			m.source.header = db.SplitHeader(msg.raw)
+		}
+	}
+	m.updateContentViewport()
+}
+
+// saveSource writes the open email to an .eml in the messages directory of the cache. without the source and
+// the server it is rebuilt from what we stored, like an export does.
+func (m *HomeView) saveSource() tea.Cmd {
+	if len(m.selectedThread) == 0 {
+		return nil
+	}
+
+	email := m.selectedThread[m.selectedEmail]
+	syncer := m.syncerOf(email.AccountID)
+
+	return func() tea.Msg {
+		ctx := context.Background()
+
+		raw, err := m.dbClient.Source(ctx, email.ID)
+		if errors.Is(err, sql.ErrNoRows) {
+			raw, err = fetchSource(syncer, email, false)
+		}
+
+		var rebuiltBecause error
+		if err != nil {
+			msg, _, rebuildErr := archive.ToMessage(ctx, m.dbClient, email, nil)
+			if rebuildErr != nil {
+				return actionDoneMsg{err: err}
+			}
+			raw, rebuiltBecause = msg.Raw, err
+		}
+
+		dir, err := messagesDir()
+		if err != nil {
+			return actionDoneMsg{err: err}
+		}
+
+		subject := email.Subject
+		if subject == "" {
+			subject = "message"
+		}
+		name := archive.FileName(fmt.Sprintf("%s-%d", truncateString(subject, 60), email.ID), archive.FormatMaildir) + ".eml"
+		path := filepath.Join(dir, name)
+		if err = os.WriteFile(path, raw, 0o600); err != nil {
+			return actionDoneMsg{err: err}
+		}
+
+		notice := "Saved to " + path
+		if rebuiltBecause != nil {
+			notice += " (rebuilt without its source: " + rebuiltBecause.Error() + ")"
+		}
+		return actionDoneMsg{notice: notice}
+	}
+}
+
+// syncerOf is looked up here, the commands run outside of Update
+func (m *HomeView) syncerOf(accountID int64) sync.Syncer {
+	if client, ok := m.emailService.GetClient(accountID); ok {
+		return client.SyncClient
+	}
+	return nil
+}
+
+func fetchSource(syncer sync.Syncer, email db.Email, headerOnly bool) ([]byte, error) {
+	if syncer == nil {
+		return nil, errors.New("the message was never downloaded whole and its account isn't running")
+	}
+	return syncer.FetchSource(email.ID, headerOnly)
+}
+
+func (m *HomeView) buildSourceContent(email db.Email) string {
+	content := strings.Builder{}
+
+	content.WriteString(lipgloss.NewStyle().
+		Bold(true).
+		Foreground(highlightColor).
+		Render(email.Subject))
+	content.WriteString("\n\n")
+
+	title := "Full headers • H: source • w: save .eml"
+	raw := m.source.header
+	if m.contentMode == showSource {
+		title = "Source • H: back to the message • w: save .eml"
+		raw = m.source.raw
+	}
+	content.WriteString(lipgloss.NewStyle().Foreground(subtleColor).Render(title))
+	content.WriteString("\n\n")
+
+	content.WriteString(strings.Repeat("─", min(m.contentViewport.Width-6, 50)))
+	content.WriteString("\n\n")
+
+	switch {
+	case m.source.err != nil:
+		content.WriteString(errorStyle.Render("Failed to get it: " + m.source.err.Error()))
+	case raw == nil:
+		content.WriteString("Loading...")
+	case len(raw) > maxSourceView:
+		content.WriteString(printable(raw[:maxSourceView]))
+		content.WriteString(lipgloss.NewStyle().
+			Foreground(subtleColor).
+			Italic(true).
+			Render(fmt.Sprintf("\n\n%s more, save it with w to see the rest", formatBytes(int64(len(raw)-maxSourceView)))))
+	default:
+		content.WriteString(printable(raw))
+	}
+
+	return content.String()
+}
+
+// printable keeps a raw message from messing with the terminal: escape sequences and carriage
+// returns are dropped, broken utf-8 (8bit mail in another charset) becomes '?'
+func printable(raw []byte) string {
+	return strings.Map(func(r rune) rune {
+		if r == '\n' || r == '\t' {
+			return r
+		}
+		if unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, strings.ToValidUTF8(string(raw), "?"))
+}
@@ -0,0 +1,227 @@
+package tui
+
+import (
+	"context"
+	"fmt"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/rexxDigital/clmail/internal/db"
+	"strings"
+)
+
+type accountStorage struct {
+	account db.Account
+	storage db.GetAccountStorageRow
+}
+
+type storageLoadedMsg struct {
+	accounts []accountStorage
+	database db.DatabaseStorage
+	err      error
+}
+
+type vacuumDoneMsg struct {
+	err error
+}
+
+// StorageView shows what every account keeps in the local database and compacts it on request
+type StorageView struct {
+	dbClient  *db.Client
+	accounts  []accountStorage
+	database  db.DatabaseStorage
+	loading   bool
+	vacuuming bool
+	errorMsg  string
+	infoMsg   string
+	width     int
+	height    int
+}
+
+func NewStorageView(width, height int, dbClient *db.Client) *StorageView {
+	return &StorageView{
+		dbClient: dbClient,
+		loading:  true,
+		width:    width,
+		height:   height,
+	}
+}
+
+func (m *StorageView) Init() tea.Cmd {
+	return m.load
+}
+
+func (m *StorageView) load() tea.Msg {
+	ctx := context.Background()
+
+	accounts, err := m.dbClient.ListAccounts(ctx)
+	if err != nil {
+		return storageLoadedMsg{err: fmt.Errorf("failed to get accounts: %w", err)}
+	}
+
+	loaded := make([]accountStorage, 0, len(accounts))
+	for _, account := range accounts {
+		storage, err := m.dbClient.GetAccountStorage(ctx, account.ID)
+		if err != nil {
+			return storageLoadedMsg{err: fmt.Errorf("failed to get storage of %s: %w", account.Email, err)}
+		}
+		loaded = append(loaded, accountStorage{account: account, storage: storage})
+	}
+
+	database, err := m.dbClient.DatabaseStorage(ctx)
+	if err != nil {
+		return storageLoadedMsg{err: err}
+	}
+
+	return storageLoadedMsg{accounts: loaded, database: database}
+}
+
+func (m *StorageView) vacuum() tea.Msg {
+	return vacuumDoneMsg{err: m.dbClient.Vacuum(context.Background())}
+}
+
+func (m *StorageView) Update(message tea.Msg) (tea.Model, tea.Cmd) {
+	switch msg := message.(type) {
+	case tea.WindowSizeMsg:
+		m.width = msg.Width
+		m.height = msg.Height
+		return m, nil
+	case storageLoadedMsg:
+		m.loading = false
+		if msg.err != nil {
+			m.errorMsg = msg.err.Error()
+			return m, nil
+		}
+		m.accounts = msg.accounts
+		m.database = msg.database
+		return m, nil
+	case vacuumDoneMsg:
+		m.vacuuming = false
+		if msg.err != nil {
+			m.errorMsg = msg.err.Error()
+			return m, nil
+		}
+		m.errorMsg = ""
+		m.infoMsg = "Database compacted"
+		m.loading = true
+		return m, m.load
+	case tea.KeyMsg:
+		switch msg.String() {
+		case "ctrl+c":
+			return m, tea.Quit
+		case "esc", "q":
+			return m, func() tea.Msg {
+				return SwitchViewMsg{ViewName: "home"}
+			}
+		case "v":
+			// nothing else can touch the database while it runs, don't start a second one
+			if m.vacuuming {
+				return m, nil
+			}
+			m.vacuuming = true
+			m.infoMsg = ""
+			return m, m.vacuum
+		}
+	}
+
+	return m, nil
+}
+
+func (m *StorageView) View() string {
+	headerStyle := lipgloss.NewStyle().
+		Background(backgroundColor).
+		Foreground(subtleColor).
+		Padding(0, 1).
+		Bold(true)
+	headerView := headerStyle.Width(m.width).Render("📧 CLMAIL - Storage")
+
+	contentStyle := lipgloss.NewStyle().
+		Border(lipgloss.RoundedBorder()).
+		BorderForeground(subtleColor).
+		Padding(0, 1).
+		Height(m.height - 4).
+		Width(m.width - 2)
+
+	statusStyle := lipgloss.NewStyle().
+		Background(backgroundColor).
+		Foreground(highlightColor).
+		Padding(0, 1)
+
+	var status string
+	switch {
+	case m.vacuuming:
+		status = "⏳ Compacting the database, this can take a while..."
+	case m.errorMsg != "":
+		status = errorStyle.Render("⚠️ " + m.errorMsg)
+	case m.infoMsg != "":
+		status = "✅ " + m.infoMsg + " • v: compact • esc: back"
+	default:
+		status = "v: compact database • esc: back"
+	}
+
+	return lipgloss.JoinVertical(
+		lipgloss.Left,
+		headerView,
+		contentStyle.Render(m.content()),
+		statusStyle.Width(m.width).Render(status),
+	)
+}
+
+func (m *StorageView) content() string {
+	if m.loading {
+		return "Loading..."
+	}
+
+	var b strings.Builder
+
+	for _, entry := range m.accounts {
+		storage := entry.storage
+
+		b.WriteString(focusedStyle.Render(entry.account.Email))
+		b.WriteString("\n")
+		fmt.Fprintf(&b, "  Messages     %d\n", storage.Messages)
+		fmt.Fprintf(&b, "  Headers      %s\n", formatBytes(storage.HeaderBytes))
+		fmt.Fprintf(&b, "  Bodies       %s in %d messages, %d evicted\n", formatBytes(storage.BodyBytes), storage.Bodies, storage.Evicted)
+		fmt.Fprintf(&b, "  Sources      %s compressed\n", formatBytes(storage.SourceBytes))
+		fmt.Fprintf(&b, "  Attachments  %s\n", formatBytes(storage.AttachmentBytes))
+		fmt.Fprintf(&b, "  Retention    %s\n\n", retentionPolicy(entry.account))
+	}
+
+	b.WriteString(focusedStyle.Render("Database"))
+	b.WriteString("\n")
+	fmt.Fprintf(&b, "  File         %s\n", formatBytes(m.database.FileBytes))
+	fmt.Fprintf(&b, "  Free         %s\n", formatBytes(m.database.FreeBytes))
+	if !m.database.IncrementalVacuum {
+		b.WriteString(blurredStyle.Render("  The file only shrinks after evicting once it has been compacted (v)"))
+		b.WriteString("\n")
+	}
+
+	return b.String()
+}
+
+func retentionPolicy(account db.Account) string {
+	var policy []string
+	if account.BodyRetentionDays > 0 {
+		policy = append(policy, fmt.Sprintf("bodies for %d days", account.BodyRetentionDays))
+	}
+	if account.BodyRetentionMb > 0 {
+		policy = append(policy, fmt.Sprintf("at most %d MB of bodies", account.BodyRetentionMb))
+	}
+	if len(policy) == 0 {
+		return "keep everything"
+	}
+	return "keep " + strings.Join(policy, ", ") + ", starred mail always"
+}
+
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+
+	div, exp := int64(unit), 0
+	for v := n / unit; v >= unit; v /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGT"[exp])
+}
@@ -15,6 +15,7 @@ var (
 	blurredStyle    = lipgloss.NewStyle().Foreground(subtleColor)
 	errorStyle      = lipgloss.NewStyle().Foreground(errorColor)
 	noStyle         = lipgloss.NewStyle()
+	offlineBadge    = lipgloss.NewStyle().Foreground(lipgloss.Color("#FFFFFF")).Background(errorColor).Bold(true).Padding(0, 1)
 
 	focusedSubmitButton = focusedStyle.Render("[ Submit ]")
 	blurredSubmitButton = fmt.Sprintf("[ %s ]", blurredStyle.Render("Submit"))